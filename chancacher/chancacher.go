@@ -57,6 +57,10 @@ type ChanCacher struct {
 	cacheIsDone    bool
 	cacheCommitted bool
 
+	wal       *wal
+	walNotify chan struct{}
+	lgr       Logger
+
 	fileLock *flock.Flock
 }
 
@@ -220,7 +224,11 @@ func (c *ChanCacher) run() {
 				select {
 				case c.Out <- v:
 				case <-c.cachePaused:
-					c.cacheValue(v)
+					if err := c.cacheValue(v); err != nil {
+						// the backing store is failing, hold the value until there is room for it
+						c.logError("failed to cache value, waiting on the output buffer", err)
+						c.Out <- v
+					}
 				}
 			}
 		}
@@ -308,9 +316,21 @@ func (c *ChanCacher) cacheHandler() {
 	}
 }
 
-func (c *ChanCacher) cacheValue(v interface{}) {
+// cacheValue writes a value to the backing store, an error means the value was not stored
+func (c *ChanCacher) cacheValue(v interface{}) error {
 	if v == nil {
-		return
+		return nil
+	}
+	if c.wal != nil {
+		// the log enforces its own limits through eviction
+		if err := c.wal.Append(v); err != nil {
+			return err
+		}
+		select {
+		case c.walNotify <- struct{}{}:
+		default:
+		}
+		return nil
 	}
 	for c.maxSize != 0 && c.Size() >= c.maxSize {
 		time.Sleep(100 * time.Millisecond)
@@ -323,10 +343,14 @@ func (c *ChanCacher) cacheValue(v interface{}) {
 		// TODO: log
 	}
 	c.cacheModified = true
+	return nil
 }
 
 // Return if the cache has outstanding data not written to the output channel.
 func (c *ChanCacher) CacheHasData() bool {
+	if c.wal != nil {
+		return c.wal.HasData()
+	}
 	return c.cacheModified || c.cacheReading
 }
 
@@ -409,15 +433,21 @@ func (c *ChanCacher) Commit() {
 			readerStopped = true
 		case v := <-c.Out:
 			if v != nil {
-				c.cacheValue(v)
+				if err := c.cacheValue(v); err != nil {
+					c.logError("failed to commit value to cache, value lost", err)
+				}
 			}
 		}
 	}
 
-	c.cacheR.Sync()
-	c.cacheW.Sync()
-	c.cacheR.Close()
-	c.cacheW.Close()
+	if c.wal != nil {
+		c.wal.Close()
+	} else {
+		c.cacheR.Sync()
+		c.cacheW.Sync()
+		c.cacheR.Close()
+		c.cacheW.Close()
+	}
 
 	c.cacheCommitted = true
 }
@@ -433,8 +463,13 @@ func (c *ChanCacher) finishCache() {
 }
 
 // Returns the number of bytes committed to disk. This does not include data in
-// the in-memory buffer.
+// the in-memory buffer.  It is safe to call while the cache is running.
 func (c *ChanCacher) Size() int {
+	if c.wal != nil {
+		return int(c.wal.Size())
+	}
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
 	return c.cacheR.Count() + c.cacheW.Count()
 }
 
@@ -1,10 +1,15 @@
 package chancacher
 
-import "os"
+import (
+	"os"
+	"sync/atomic"
+)
 
+// fileCounter tracks the number of unread bytes in a file, the count may be read while
+// another routine is reading or writing the file
 type fileCounter struct {
 	*os.File
-	count int
+	count int64 // atomic
 }
 
 func NewFileCounter(f *os.File) (*fileCounter, error) {
@@ -14,18 +19,19 @@ func NewFileCounter(f *os.File) (*fileCounter, error) {
 	}
 	return &fileCounter{
 		File:  f,
-		count: int(fi.Size()),
+		count: fi.Size(),
 	}, nil
 }
 
 func (f *fileCounter) Write(b []byte) (n int, err error) {
-	f.count += len(b)
-	return f.File.Write(b)
+	n, err = f.File.Write(b)
+	atomic.AddInt64(&f.count, int64(n))
+	return
 }
 
 func (f *fileCounter) Read(b []byte) (n int, err error) {
 	n, err = f.File.Read(b)
-	f.count -= n
+	atomic.AddInt64(&f.count, -int64(n))
 	return
 }
 
@@ -33,5 +39,5 @@ func (f *fileCounter) Count() int {
 	if f == nil || f.File == nil {
 		return 0
 	}
-	return f.count
+	return int(atomic.LoadInt64(&f.count))
 }
@@ -0,0 +1,661 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/rfc5424"
+)
+
+const (
+	walMagic         = "GWAL"
+	walVersion       = 1
+	walHeaderSize    = 16 // magic, version, padding, creation timestamp
+	walRecHeaderSize = 8  // payload length, crc32c of the payload
+	walSegmentExt    = ".wal"
+	walCursorFile    = "cursor"
+	walCursorSize    = 20 // segment id, offset, crc32c
+
+	// maximum size of a single record, anything larger is treated as corruption
+	walMaxRecordSize = 256 * 1024 * 1024
+
+	DefaultSegmentSize  = 16 * 1024 * 1024
+	DefaultSyncInterval = time.Second
+)
+
+var (
+	ErrWALClosed         = errors.New("write ahead log is closed")
+	ErrInvalidSegment    = errors.New("invalid write ahead log segment")
+	ErrInvalidSyncPolicy = errors.New("invalid sync policy")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// SyncPolicy controls when the write ahead log forces data to stable storage.
+type SyncPolicy int
+
+const (
+	SyncInterval SyncPolicy = iota // fsync periodically, data written since the last sync may be lost on a power failure
+	SyncAlways                     // fsync after every write
+	SyncNever                      // leave it up to the OS
+)
+
+// ParseSyncPolicy converts a config value into a SyncPolicy, an empty value is SyncInterval.
+func ParseSyncPolicy(v string) (SyncPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case ``, `interval`:
+		return SyncInterval, nil
+	case `always`:
+		return SyncAlways, nil
+	case `never`, `none`:
+		return SyncNever, nil
+	}
+	return SyncInterval, fmt.Errorf("%w %q", ErrInvalidSyncPolicy, v)
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncInterval:
+		return `interval`
+	case SyncAlways:
+		return `always`
+	case SyncNever:
+		return `never`
+	}
+	return `unknown`
+}
+
+// WALConfig controls the behavior of a write ahead log backed ChanCacher.
+type WALConfig struct {
+	SegmentSize  int64         // roll to a new segment after this many bytes, zero uses DefaultSegmentSize
+	MaxSize      int64         // evict the oldest segments once the log exceeds this many bytes, zero is unbounded
+	MaxAge       time.Duration // evict segments that were last written longer ago than this, zero disables
+	Sync         SyncPolicy
+	SyncInterval time.Duration // zero uses DefaultSyncInterval
+	Logger       Logger        // optional, receives failures of the log
+}
+
+// Logger receives errors from the cache that cannot be returned to the caller.
+type Logger interface {
+	Error(string, ...rfc5424.SDParam) error
+}
+
+// WALStats reports the state of a write ahead log.
+type WALStats struct {
+	Segments        int
+	Size            int64  // bytes of unconsumed data on disk
+	EvictedSegments uint64 // segments dropped due to size or age limits
+	EvictedBytes    uint64
+	Corrupted       uint64 // records or segment tails that failed validation and were skipped
+}
+
+type walSegment struct {
+	id      uint64
+	path    string
+	size    int64
+	created time.Time
+	mtime   time.Time // last write
+}
+
+// wal is a segmented, checksummed log of gob encoded values.  Records are appended
+// to the newest segment and consumed from the oldest, once every record in a segment
+// has been acknowledged the segment is removed.  The acknowledged position is kept in
+// a cursor file so that a restart resumes where the consumer left off; anything read but
+// not acknowledged before a crash will be delivered again.
+type wal struct {
+	mtx  sync.Mutex
+	dir  string
+	cfg  WALConfig
+	segs []*walSegment // oldest first, the last segment is the one being written
+	wf   *os.File
+	rf   *os.File // open handle on segs[0]
+	roff int64    // read offset into segs[0]
+	aoff int64    // acknowledged offset into segs[0]
+
+	dirty      bool // data written since the last sync
+	ackDirty   bool // cursor moved since it was last persisted
+	lastSync   time.Time
+	closed     bool
+	evictSegs  uint64
+	evictBytes uint64
+	corrupt    uint64
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%016x%s", id, walSegmentExt)
+}
+
+// openWAL opens or creates a write ahead log in dir.  Torn writes at the tail of the
+// newest segment are truncated away and a fresh segment is started for new writes.
+func openWAL(dir string, cfg WALConfig) (w *wal, err error) {
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.MaxSize > 0 && cfg.SegmentSize > cfg.MaxSize/2 {
+		// keep segments small enough that eviction has some granularity
+		if cfg.SegmentSize = cfg.MaxSize / 2; cfg.SegmentSize < walHeaderSize+walRecHeaderSize {
+			cfg.SegmentSize = walHeaderSize + walRecHeaderSize
+		}
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	w = &wal{
+		dir:      dir,
+		cfg:      cfg,
+		lastSync: time.Now(),
+	}
+	if w.segs, err = listSegments(dir); err != nil {
+		return nil, err
+	}
+	if err = w.loadCursor(); err != nil {
+		return nil, err
+	}
+	if l := len(w.segs); l > 0 {
+		if err = w.repairTail(w.segs[l-1]); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.newSegment(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// listSegments returns the segments in a directory ordered oldest to newest
+func listSegments(dir string) (segs []*walSegment, err error) {
+	var ents []os.DirEntry
+	if ents, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	for _, ent := range ents {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		fi, err := ent.Info()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, &walSegment{
+			id:    id,
+			path:  filepath.Join(dir, name),
+			size:  fi.Size(),
+			mtime: fi.ModTime(),
+		})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].id < segs[j].id })
+	return
+}
+
+// loadCursor restores the acknowledged position, segments entirely before the cursor
+// were consumed but not yet removed when we went down.
+func (w *wal) loadCursor() error {
+	id, off, ok, err := readCursor(w.dir)
+	if err != nil {
+		if err == ErrInvalidSegment {
+			// a bad cursor just means replaying more than we need to
+			w.corrupt++
+			err = nil
+		}
+		return err
+	} else if !ok {
+		return nil
+	}
+	for len(w.segs) > 0 && w.segs[0].id < id {
+		if err := os.Remove(w.segs[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.segs = w.segs[1:]
+	}
+	if len(w.segs) > 0 && w.segs[0].id == id && off > walHeaderSize && off <= w.segs[0].size {
+		w.roff, w.aoff = off, off
+	}
+	return nil
+}
+
+// readCursor reads the acknowledged position from a log directory without modifying anything
+func readCursor(dir string) (id uint64, off int64, ok bool, err error) {
+	var buff []byte
+	if buff, err = os.ReadFile(filepath.Join(dir, walCursorFile)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if len(buff) != walCursorSize || crc32.Checksum(buff[:16], crcTable) != binary.LittleEndian.Uint32(buff[16:]) {
+		err = ErrInvalidSegment
+		return
+	}
+	id = binary.LittleEndian.Uint64(buff)
+	off = int64(binary.LittleEndian.Uint64(buff[8:]))
+	ok = true
+	return
+}
+
+// repairTail walks the records in a segment and truncates anything after the last valid record
+func (w *wal) repairTail(seg *walSegment) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = checkSegmentHeader(f); err != nil {
+		// header never made it to disk, nothing to salvage
+		w.corrupt++
+		return f.Truncate(0)
+	}
+	off := int64(walHeaderSize)
+	for off < seg.size {
+		n, err := readRecord(f, off, seg.size, nil)
+		if err != nil {
+			w.corrupt++
+			break
+		}
+		off += n
+	}
+	if off != seg.size {
+		if err = f.Truncate(off); err != nil {
+			return err
+		}
+		seg.size = off
+	}
+	return nil
+}
+
+func checkSegmentHeader(f *os.File) error {
+	var hdr [walHeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return ErrInvalidSegment
+	}
+	if string(hdr[:4]) != walMagic || hdr[4] != walVersion {
+		return ErrInvalidSegment
+	}
+	return nil
+}
+
+// readRecord validates the record at off and returns its total length.
+// If v is not nil the payload is decoded into it.
+func readRecord(f *os.File, off, size int64, v *interface{}) (int64, error) {
+	var hdr [walRecHeaderSize]byte
+	if off+walRecHeaderSize > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return 0, err
+	}
+	l := int64(binary.LittleEndian.Uint32(hdr[:]))
+	if l == 0 || l > walMaxRecordSize || off+walRecHeaderSize+l > size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, l)
+	if _, err := f.ReadAt(payload, off+walRecHeaderSize); err != nil {
+		return 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return 0, ErrInvalidSegment
+	}
+	if v != nil {
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(v); err != nil {
+			// the record is intact so the caller can move past it
+			return walRecHeaderSize + l, err
+		}
+	}
+	return walRecHeaderSize + l, nil
+}
+
+// newSegment closes out the current write segment and starts a new one, caller must hold the lock
+func (w *wal) newSegment() (err error) {
+	var id uint64
+	if l := len(w.segs); l > 0 {
+		id = w.segs[l-1].id + 1
+	}
+	if w.wf != nil {
+		if w.cfg.Sync != SyncNever {
+			w.wf.Sync()
+		}
+		w.wf.Close()
+		w.wf = nil
+	}
+	now := time.Now()
+	seg := &walSegment{
+		id:      id,
+		path:    filepath.Join(w.dir, segmentName(id)),
+		created: now,
+		mtime:   now,
+	}
+	var f *os.File
+	if f, err = os.OpenFile(seg.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640); err != nil {
+		return
+	}
+	var hdr [walHeaderSize]byte
+	copy(hdr[:], walMagic)
+	hdr[4] = walVersion
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(seg.created.UnixNano()))
+	if _, err = f.Write(hdr[:]); err != nil {
+		f.Close()
+		os.Remove(seg.path)
+		return
+	}
+	seg.size = walHeaderSize
+	w.wf = f
+	w.segs = append(w.segs, seg)
+	return
+}
+
+// Append encodes and writes a value to the log
+func (w *wal) Append(v interface{}) error {
+	var bb bytes.Buffer
+	bb.Write(make([]byte, walRecHeaderSize))
+	if err := gob.NewEncoder(&bb).Encode(&v); err != nil {
+		return err
+	}
+	rec := bb.Bytes()
+	if len(rec)-walRecHeaderSize > walMaxRecordSize {
+		return fmt.Errorf("record of %d bytes exceeds maximum size", len(rec))
+	}
+	binary.LittleEndian.PutUint32(rec, uint32(len(rec)-walRecHeaderSize))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(rec[walRecHeaderSize:], crcTable))
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return ErrWALClosed
+	}
+	seg := w.segs[len(w.segs)-1]
+	if seg.size > walHeaderSize && (seg.size+int64(len(rec)) > w.cfg.SegmentSize || w.segmentExpiring(seg)) {
+		if err := w.newSegment(); err != nil {
+			return err
+		}
+		seg = w.segs[len(w.segs)-1]
+	}
+	n, err := w.wf.Write(rec)
+	seg.size += int64(n)
+	seg.mtime = time.Now()
+	if err != nil {
+		return err
+	}
+	w.dirty = true
+	if w.cfg.Sync == SyncAlways || (w.cfg.Sync == SyncInterval && time.Since(w.lastSync) >= w.cfg.SyncInterval) {
+		w.sync()
+	}
+	if w.cfg.MaxSize > 0 {
+		w.evictSize()
+	}
+	return nil
+}
+
+// Read returns the next unread value in the log, ok is false when the reader has caught up
+// with the writer.  Values must be acknowledged with Ack before the next call to Read.
+func (w *wal) Read() (v interface{}, ok bool, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for !w.closed {
+		head := w.segs[0]
+		if w.rf == nil {
+			if w.rf, err = os.Open(head.path); err != nil {
+				return
+			}
+			if err = checkSegmentHeader(w.rf); err != nil {
+				w.corrupt++
+				w.roff = head.size
+			} else if w.roff < walHeaderSize {
+				w.roff = walHeaderSize
+			}
+			w.aoff = w.roff
+		}
+		if w.roff+walRecHeaderSize > head.size {
+			if len(w.segs) == 1 {
+				return // caught up
+			}
+			w.dropHead()
+			continue
+		}
+		var n int64
+		if n, err = readRecord(w.rf, w.roff, head.size, &v); err != nil {
+			w.corrupt++
+			if n == 0 {
+				// the framing is gone so we cannot find the next record, skip the rest of the segment
+				n = head.size - w.roff
+			}
+			w.roff += n
+			w.aoff = w.roff
+			w.ackDirty = true
+			if len(w.segs) == 1 {
+				// the write segment is damaged, start a new one
+				if err = w.newSegment(); err != nil {
+					return
+				}
+			}
+			err = nil
+			continue
+		}
+		w.roff += n
+		ok = v != nil
+		if !ok {
+			w.aoff = w.roff
+			continue
+		}
+		return
+	}
+	err = ErrWALClosed
+	return
+}
+
+// Ack marks every value returned by Read as consumed
+func (w *wal) Ack() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed || w.aoff == w.roff {
+		return
+	}
+	w.aoff = w.roff
+	w.ackDirty = true
+	if w.cfg.Sync == SyncAlways {
+		w.writeCursor()
+	}
+}
+
+// dropHead removes the oldest segment, caller must hold the lock
+func (w *wal) dropHead() {
+	if w.rf != nil {
+		w.rf.Close()
+		w.rf = nil
+	}
+	os.Remove(w.segs[0].path)
+	w.segs = w.segs[1:]
+	w.roff, w.aoff = 0, 0
+	w.ackDirty = true
+}
+
+// evict drops the oldest segment due to a size or age limit, caller must hold the lock
+func (w *wal) evict() {
+	if len(w.segs) == 1 {
+		if w.segs[0].size <= walHeaderSize {
+			return
+		}
+		if err := w.newSegment(); err != nil {
+			return
+		}
+	}
+	sz := w.segs[0].size - w.aoff
+	if w.aoff == 0 {
+		sz -= walHeaderSize
+	}
+	w.evictSegs++
+	w.evictBytes += uint64(sz)
+	w.dropHead()
+}
+
+func (w *wal) evictSize() {
+	for w.size() > w.cfg.MaxSize && w.hasData() {
+		w.evict()
+	}
+}
+
+// segmentExpiring returns true if a segment should not take any more writes so that
+// age based eviction does not have to wait on a segment that keeps getting fresh data
+func (w *wal) segmentExpiring(seg *walSegment) bool {
+	return w.cfg.MaxAge > 0 && time.Since(seg.created) > w.cfg.MaxAge/2
+}
+
+func (w *wal) evictAge() {
+	cutoff := time.Now().Add(-w.cfg.MaxAge)
+	for w.hasData() && w.segs[0].mtime.Before(cutoff) {
+		w.evict()
+	}
+}
+
+// maintain performs periodic syncs and age based eviction
+func (w *wal) maintain() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return
+	}
+	if w.cfg.MaxAge > 0 {
+		w.evictAge()
+	}
+	if w.cfg.Sync != SyncNever && time.Since(w.lastSync) >= w.cfg.SyncInterval {
+		w.sync()
+	}
+}
+
+// sync flushes data and the cursor to disk, caller must hold the lock
+func (w *wal) sync() {
+	if w.dirty {
+		w.wf.Sync()
+		w.dirty = false
+	}
+	w.writeCursor()
+	w.lastSync = time.Now()
+}
+
+func (w *wal) writeCursor() {
+	if !w.ackDirty {
+		return
+	}
+	var buff [walCursorSize]byte
+	binary.LittleEndian.PutUint64(buff[:], w.segs[0].id)
+	binary.LittleEndian.PutUint64(buff[8:], uint64(w.aoff))
+	binary.LittleEndian.PutUint32(buff[16:], crc32.Checksum(buff[:16], crcTable))
+	pth := filepath.Join(w.dir, walCursorFile)
+	tmp := pth + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(buff[:])
+	if err == nil && w.cfg.Sync != SyncNever {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp, pth) != nil {
+		os.Remove(tmp)
+		return
+	}
+	w.ackDirty = false
+}
+
+// size returns the number of bytes not yet acknowledged, caller must hold the lock
+func (w *wal) size() (sz int64) {
+	for _, s := range w.segs {
+		sz += s.size - walHeaderSize
+	}
+	if w.aoff > walHeaderSize {
+		sz -= w.aoff - walHeaderSize
+	}
+	return
+}
+
+// hasData returns true if there are unacknowledged records, caller must hold the lock
+func (w *wal) hasData() bool {
+	return w.size() > 0
+}
+
+// Size returns the number of bytes in the log that have not been acknowledged
+func (w *wal) Size() int64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.size()
+}
+
+func (w *wal) HasData() bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.hasData()
+}
+
+func (w *wal) Stats() WALStats {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return WALStats{
+		Segments:        len(w.segs),
+		Size:            w.size(),
+		EvictedSegments: w.evictSegs,
+		EvictedBytes:    w.evictBytes,
+		Corrupted:       w.corrupt,
+	}
+}
+
+// Close syncs and closes the log, unacknowledged data remains on disk to be replayed
+func (w *wal) Close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.ackDirty = true
+	w.sync()
+	if w.rf != nil {
+		w.rf.Close()
+		w.rf = nil
+	}
+	err := w.wf.Close()
+	if !w.hasData() {
+		// everything was consumed, clean up entirely so that segment numbering
+		// starting over cannot be confused by a stale cursor
+		for _, s := range w.segs {
+			os.Remove(s.path)
+		}
+		os.Remove(filepath.Join(w.dir, walCursorFile))
+		return err
+	}
+	// clean up segments that never got any data
+	for _, s := range w.segs[1:] {
+		if s.size <= walHeaderSize {
+			os.Remove(s.path)
+		}
+	}
+	return err
+}
@@ -0,0 +1,365 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crewjam/rfc5424"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func walAppend(t *testing.T, w *wal, start, cnt int) {
+	for i := start; i < start+cnt; i++ {
+		if err := w.Append(&ChanCacheTester{V: i, Data: "hello world"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walExpect reads and acknowledges values from the log, ensuring they are in order
+func walExpect(t *testing.T, w *wal, start, cnt int) {
+	for i := start; i < start+cnt; i++ {
+		v, ok, err := w.Read()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("log ran out at %d", i)
+		} else if ct, ok := v.(*ChanCacheTester); !ok || ct.V != i {
+			t.Fatalf("bad value %v != %d", v, i)
+		}
+		w.Ack()
+	}
+}
+
+func walEmpty(t *testing.T, w *wal) {
+	if v, ok, err := w.Read(); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatalf("got unexpected value %v", v)
+	}
+}
+
+func TestWALReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, WALConfig{SegmentSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walAppend(t, w, 0, 100)
+	if s := w.Stats(); s.Segments < 10 {
+		t.Fatalf("log did not roll segments: %+v", s)
+	}
+	walExpect(t, w, 0, 50)
+	walEmptyAfter := w.Stats().Segments
+	walExpect(t, w, 50, 50)
+	walEmpty(t, w)
+	if s := w.Stats(); s.Segments != 1 || s.Size != 0 || s.Segments >= walEmptyAfter {
+		t.Fatalf("consumed segments were not removed: %+v", s)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if segs, err := listSegments(dir); err != nil {
+		t.Fatal(err)
+	} else if len(segs) != 0 {
+		t.Fatalf("empty log left %d segments", len(segs))
+	}
+}
+
+func TestWALCursor(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, WALConfig{SegmentSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walAppend(t, w, 0, 20)
+	walExpect(t, w, 0, 7)
+	// read but never acknowledged, it must come back
+	if _, ok, err := w.Read(); err != nil || !ok {
+		t.Fatal("failed to read", err)
+	}
+	w.Close()
+
+	if w, err = openWAL(dir, WALConfig{SegmentSize: 512}); err != nil {
+		t.Fatal(err)
+	}
+	walExpect(t, w, 7, 13)
+	walEmpty(t, w)
+	w.Close()
+}
+
+func TestWALTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, WALConfig{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walAppend(t, w, 0, 10)
+	pth := w.segs[len(w.segs)-1].path
+	w.Close()
+
+	// simulate a crash halfway through a record
+	fout, err := os.OpenFile(pth, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, 5, 6})
+	fout.Close()
+
+	if w, err = openWAL(dir, WALConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Stats().Corrupted != 1 {
+		t.Fatal("torn write not detected")
+	}
+	walExpect(t, w, 0, 10)
+	walEmpty(t, w)
+	walAppend(t, w, 10, 1)
+	walExpect(t, w, 10, 1)
+	w.Close()
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, WALConfig{SegmentSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walAppend(t, w, 0, 40)
+	first := w.segs[0]
+	w.Close()
+
+	// count the records in the first segment then damage the last one
+	f, err := os.OpenFile(first.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cnt int
+	var last int64
+	for off := int64(walHeaderSize); off < first.size; cnt++ {
+		n, err := readRecord(f, off, first.size, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = off
+		off += n
+	}
+	if _, err = f.WriteAt([]byte{0xff, 0xff}, last+walRecHeaderSize+4); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if w, err = openWAL(dir, WALConfig{SegmentSize: 512}); err != nil {
+		t.Fatal(err)
+	}
+	walExpect(t, w, 0, cnt-1)
+	// the damaged record is skipped and we pick up at the next segment
+	walExpect(t, w, cnt, 40-cnt)
+	walEmpty(t, w)
+	if w.Stats().Corrupted != 1 {
+		t.Fatal("corruption not counted")
+	}
+	w.Close()
+}
+
+func TestWALEvictSize(t *testing.T) {
+	w, err := openWAL(t.TempDir(), WALConfig{MaxSize: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	walAppend(t, w, 0, 200)
+	s := w.Stats()
+	if s.Size > 2048 {
+		t.Fatalf("log exceeded max size: %+v", s)
+	} else if s.EvictedSegments == 0 || s.EvictedBytes == 0 {
+		t.Fatalf("nothing evicted: %+v", s)
+	}
+	// we should get the newest values, in order, ending with the last one written
+	var last int
+	for {
+		v, ok, err := w.Read()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			break
+		}
+		w.Ack()
+		if last = v.(*ChanCacheTester).V; last == 0 {
+			t.Fatal("oldest value was not evicted")
+		}
+	}
+	if last != 199 {
+		t.Fatalf("lost newest data, last value %d", last)
+	}
+}
+
+func TestWALEvictAge(t *testing.T) {
+	w, err := openWAL(t.TempDir(), WALConfig{MaxAge: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	walAppend(t, w, 0, 10)
+	w.maintain()
+	if !w.HasData() {
+		t.Fatal("evicted young data")
+	}
+	time.Sleep(100 * time.Millisecond)
+	walAppend(t, w, 10, 5)
+	w.maintain()
+	walExpect(t, w, 10, 5)
+	walEmpty(t, w)
+	if s := w.Stats(); s.EvictedSegments != 1 {
+		t.Fatalf("old segment not evicted: %+v", s)
+	}
+}
+
+func TestWALCacher(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewChanCacherWAL(2, dir, WALConfig{SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewChanCacherWAL(2, dir, WALConfig{}); err == nil {
+		t.Fatal("opened a locked cache")
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case c.In <- &ChanCacheTester{V: i}:
+		case <-time.After(DEFAULT_TIMEOUT):
+			t.Fatal("channel write should not block")
+		}
+	}
+	if c.Size() == 0 || !c.CacheHasData() {
+		t.Fatal("nothing went to the log")
+	}
+	close(c.In)
+	c.Commit()
+	if _, ok := <-c.Out; ok {
+		t.Fatal("output not closed")
+	}
+
+	if c, err = NewChanCacherWAL(2, dir, WALConfig{SegmentSize: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		select {
+		case v := <-c.Out:
+			results[v.(*ChanCacheTester).V]++
+		case <-time.After(5 * DEFAULT_TIMEOUT):
+			t.Fatalf("channel blocked after %d reads", i)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if results[i] != 1 {
+			t.Fatalf("bad count for %d: %d", i, results[i])
+		}
+	}
+	close(c.In)
+	c.Commit()
+	if st, ok := c.WALStats(); !ok || st.Size != 0 {
+		t.Fatalf("bad stats after draining: %+v", st)
+	}
+}
+
+// walUnregistered is never registered with gob so it cannot be written to the log
+type walUnregistered struct {
+	V int
+}
+
+type testErrLogger struct {
+	sync.Mutex
+	msgs []string
+}
+
+func (l *testErrLogger) Error(msg string, _ ...rfc5424.SDParam) error {
+	l.Lock()
+	l.msgs = append(l.msgs, msg)
+	l.Unlock()
+	return nil
+}
+
+func TestWALCacherAppendFailure(t *testing.T) {
+	lgr := &testErrLogger{}
+	c, err := NewChanCacherWAL(1, t.TempDir(), WALConfig{Logger: lgr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.In <- &walUnregistered{V: i}
+		}
+		close(c.In)
+	}()
+	// values that cannot be cached must still come out in order
+	for i := 0; i < 10; i++ {
+		select {
+		case v := <-c.Out:
+			if wu, ok := v.(*walUnregistered); !ok || wu.V != i {
+				t.Fatalf("bad value %v != %d", v, i)
+			}
+		case <-time.After(5 * DEFAULT_TIMEOUT):
+			t.Fatalf("channel blocked after %d reads", i)
+		}
+	}
+	c.Commit()
+	lgr.Lock()
+	defer lgr.Unlock()
+	if len(lgr.msgs) == 0 {
+		t.Fatal("append failures were not logged")
+	}
+}
+
+func TestWALImportLegacy(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cache_a", "cache_b"} {
+		if bts, err := os.ReadFile(filepath.Join("old-entries-cache", name)); err != nil {
+			t.Fatal(err)
+		} else if err = os.WriteFile(filepath.Join(dir, name), bts, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewChanCacherWAL(2, dir, WALConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case v := <-c.Out:
+			if _, ok := v.(*entry.Entry); !ok {
+				t.Fatalf("bad value %T", v)
+			}
+		case <-time.After(5 * DEFAULT_TIMEOUT):
+			t.Fatalf("channel blocked after %d reads", i)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache_a")); !os.IsNotExist(err) {
+		t.Fatal("legacy cache not removed")
+	}
+	close(c.In)
+	c.Commit()
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for k, v := range map[string]SyncPolicy{``: SyncInterval, `Always`: SyncAlways, `never`: SyncNever, `interval`: SyncInterval} {
+		if p, err := ParseSyncPolicy(k); err != nil || p != v {
+			t.Fatalf("bad policy for %q: %v %v", k, p, err)
+		}
+	}
+	if _, err := ParseSyncPolicy(`sometimes`); err == nil {
+		t.Fatal("accepted bad policy")
+	}
+}
@@ -0,0 +1,186 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const walMaintenanceInterval = time.Second
+
+// NewChanCacherWAL creates a ChanCacher that is backed by a segmented write ahead log
+// rather than a pair of gob files.  Every record is checksummed so a crash or torn write
+// only costs the damaged records, consumed segments are removed as the output channel
+// drains, and the log can be bounded by size and age, evicting the oldest data first.
+//
+// The channel API is identical to NewChanCacher.  If cachePath holds cache files
+// written by NewChanCacher they are imported into the log and removed.
+func NewChanCacherWAL(maxDepth int, cachePath string, cfg WALConfig) (*ChanCacher, error) {
+	if cachePath == "" {
+		return nil, ErrInvalidCachePath
+	}
+	if fi, err := os.Stat(cachePath); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("Cache Path %q is not a directory: %w", cachePath, ErrInvalidCachePath)
+	}
+	if maxDepth == -1 || maxDepth > MaxDepth {
+		maxDepth = MaxDepth
+	}
+	c := &ChanCacher{
+		In:          make(chan interface{}),
+		Out:         make(chan interface{}, maxDepth),
+		cachePath:   cachePath,
+		cache:       true,
+		cachePaused: make(chan bool),
+		cacheDone:   make(chan bool),
+		cacheAck:    make(chan bool),
+		walNotify:   make(chan struct{}, 1),
+		lgr:         cfg.Logger,
+	}
+	close(c.cachePaused)
+
+	if err := os.MkdirAll(c.cachePath, 0750); err != nil {
+		return nil, err
+	}
+	c.fileLock = flock.New(filepath.Join(c.cachePath, "lock"))
+	if locked, err := c.fileLock.TryLock(); err != nil {
+		return nil, err
+	} else if !locked {
+		return nil, fmt.Errorf("could not get file lock!")
+	}
+
+	var err error
+	if c.wal, err = openWAL(c.cachePath, cfg); err != nil {
+		c.fileLock.Unlock()
+		return nil, err
+	}
+	if err = c.importLegacy(); err != nil {
+		c.wal.Close()
+		c.fileLock.Unlock()
+		return nil, err
+	}
+
+	go c.walHandler()
+	go c.run()
+	return c, nil
+}
+
+// importLegacy pulls in any data left behind by a gob backed cache in the same directory
+func (c *ChanCacher) importLegacy() error {
+	detritus, err := filepath.Glob(filepath.Join(c.cachePath, "merge*"))
+	if err != nil {
+		return err
+	}
+	for _, v := range detritus {
+		os.Remove(v)
+	}
+	for _, name := range []string{"cache_a", "cache_b"} {
+		pth := filepath.Join(c.cachePath, name)
+		f, err := os.Open(pth)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		dec := gob.NewDecoder(f)
+		for {
+			var v interface{}
+			if err = dec.Decode(&v); err != nil {
+				break
+			}
+			if v == nil {
+				continue
+			}
+			if err = c.wal.Append(v); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+		if err != io.EOF {
+			// take what we could get, the remainder is unrecoverable
+			c.wal.mtx.Lock()
+			c.wal.corrupt++
+			c.wal.mtx.Unlock()
+		}
+		if err = os.Remove(pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walHandler feeds the output channel from the log, acknowledging each value once it
+// has been handed off.  A value read but not delivered when the cache is shut down is
+// left in the log and will be replayed.
+func (c *ChanCacher) walHandler() {
+	defer close(c.cacheAck)
+	tkr := time.NewTicker(walMaintenanceInterval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-c.cacheDone:
+			return
+		case <-tkr.C:
+			c.wal.maintain()
+		default:
+		}
+
+		v, ok, err := c.wal.Read()
+		if err != nil && err != ErrWALClosed {
+			c.logError("failed to read from write ahead log", err)
+		}
+		if ok {
+			select {
+			case c.Out <- v:
+				c.wal.Ack()
+			case <-c.cacheDone:
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-c.cacheDone:
+			return
+		case <-c.walNotify:
+		case <-tkr.C:
+			c.wal.maintain()
+		}
+	}
+}
+
+// logError reports failures of the backing store, they would otherwise go unnoticed
+// as the cache sits between the caller and the destination.
+func (c *ChanCacher) logError(msg string, err error) {
+	if c.lgr != nil {
+		c.lgr.Error(msg, log.KV("path", c.cachePath), log.KVErr(err))
+	}
+}
+
+// WALStats returns the state of the write ahead log, ok is false if the ChanCacher
+// is not backed by a write ahead log.
+func (c *ChanCacher) WALStats() (s WALStats, ok bool) {
+	if c.wal == nil {
+		return
+	}
+	return c.wal.Stats(), true
+}
@@ -0,0 +1,306 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+const gobMaxUintSize = 9 // count byte plus up to 8 bytes of value
+
+var (
+	ErrCacheCorrupted = errors.New("cache data is corrupted")
+)
+
+// WalkStats describes the data visited by Walk.
+type WalkStats struct {
+	Files   int   // cache files and log segments visited
+	Bytes   int64 // bytes of cache data examined
+	Values  int   // values decoded
+	Damaged int   // damaged regions encountered
+	Skipped int64 // bytes skipped over while salvaging damaged regions
+}
+
+// IsCacheDir returns true if dir contains ChanCacher data, either gob cache files or
+// write ahead log segments.
+func IsCacheDir(dir string) bool {
+	for _, name := range []string{"cache_a", "cache_b"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+	segs, err := listSegments(dir)
+	return err == nil && len(segs) > 0
+}
+
+// InUse returns true if a running ChanCacher holds the lock on a cache directory.
+func InUse(dir string) (bool, error) {
+	pth := filepath.Join(dir, "lock")
+	if _, err := os.Stat(pth); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	fl := flock.New(pth)
+	locked, err := fl.TryRLock()
+	if err != nil {
+		return false, err
+	} else if locked {
+		fl.Unlock()
+		return false, nil
+	}
+	return true, nil
+}
+
+// Walk calls fn with every value stored in a cache directory, in the order that a
+// ChanCacher would replay them.  The directory is only read, the cache lock is not
+// taken and nothing is modified, so Walk can be pointed at the cache of a dead ingester.
+//
+// A damaged cache normally ends the walk with ErrCacheCorrupted.  With salvage set
+// damaged regions are skipped and the walk resumes at the next readable record, the
+// amount of data lost is reported in the returned WalkStats.  If fn returns an error
+// the walk stops and that error is returned.
+func Walk(dir string, salvage bool, fn func(v interface{}) error) (st WalkStats, err error) {
+	for _, name := range []string{"cache_a", "cache_b"} {
+		pth := filepath.Join(dir, name)
+		if fi, lerr := os.Stat(pth); lerr != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if err = walkGob(pth, salvage, fn, &st); err != nil {
+			return
+		}
+	}
+	var segs []*walSegment
+	if segs, err = listSegments(dir); err != nil {
+		return
+	}
+	err = walkWAL(dir, segs, salvage, fn, &st)
+	return
+}
+
+// walkGob reads a gob stream written by a ChanCacher.  A single value may span several
+// gob messages, so the decoder reads straight from the file through a reader that tracks
+// our position.  When a value fails to decode we scan forward for the next message that
+// looks like the start of a value and decodes cleanly; the decoder keeps the type
+// definitions it has already seen, which is what allows values in the middle of the
+// stream to be decoded on their own.
+func walkGob(pth string, salvage bool, fn func(v interface{}) error, st *WalkStats) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	st.Files++
+	st.Bytes += size
+
+	rdr := &offsetReader{f: f, size: size}
+	dec := gob.NewDecoder(rdr)
+	decode := func(off int64) (v interface{}, err error) {
+		defer func() {
+			// garbage can send the decoder places it does not expect to go
+			if r := recover(); r != nil {
+				err = fmt.Errorf("gob decoder panic: %v", r)
+			}
+		}()
+		rdr.off = off
+		err = dec.Decode(&v)
+		return
+	}
+
+	for off := int64(0); off < size; {
+		v, err := decode(off)
+		if err == nil {
+			off = rdr.off
+			if v == nil {
+				continue
+			}
+			st.Values++
+			if err = fn(v); err != nil {
+				return err
+			}
+			continue
+		}
+		st.Damaged++
+		if !salvage {
+			return fmt.Errorf("%w: %s offset %d", ErrCacheCorrupted, pth, off)
+		}
+		next := size
+		for cand := off + 1; cand < size; cand++ {
+			// only hand the decoder things that are framed like a value, random
+			// garbage can claim enormous message sizes
+			if id, ok := gobFrameAt(f, cand, size); !ok || id <= 0 {
+				continue
+			}
+			if v, err = decode(cand); err == nil && v != nil {
+				next = cand
+				break
+			}
+		}
+		st.Skipped += next - off
+		off = next
+	}
+	return nil
+}
+
+// offsetReader reads from a file at a position we control.  It implements io.ByteReader so
+// the gob decoder does not wrap it in a buffer and read past the end of the current value.
+type offsetReader struct {
+	f    *os.File
+	off  int64
+	size int64
+}
+
+func (r *offsetReader) Read(b []byte) (n int, err error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if rem := r.size - r.off; int64(len(b)) > rem {
+		b = b[:rem]
+	}
+	n, err = r.f.ReadAt(b, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+func (r *offsetReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// gobFrameAt checks that a gob message frame starting at off fits in the file and returns its type id
+func gobFrameAt(f *os.File, off, size int64) (id int64, ok bool) {
+	var hdr [2 * gobMaxUintSize]byte
+	n, _ := f.ReadAt(hdr[:], off)
+	cnt, l, ok := gobUint(hdr[:n])
+	if !ok || cnt == 0 || off+int64(l)+int64(cnt) > size {
+		return 0, false
+	}
+	u, _, ok := gobUint(hdr[l:n])
+	if !ok {
+		return 0, false
+	}
+	if u&1 != 0 {
+		id = ^int64(u >> 1)
+	} else {
+		id = int64(u >> 1)
+	}
+	return id, id != 0
+}
+
+// gobUint decodes an unsigned integer in the gob wire format
+func gobUint(b []byte) (v uint64, n int, ok bool) {
+	if len(b) == 0 {
+		return
+	}
+	if b[0] < 0x80 {
+		return uint64(b[0]), 1, true
+	}
+	cnt := -int(int8(b[0]))
+	if cnt > 8 || len(b) < cnt+1 {
+		return
+	}
+	for i := 1; i <= cnt; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, cnt + 1, true
+}
+
+// walkWAL reads log segments starting at the acknowledged position
+func walkWAL(dir string, segs []*walSegment, salvage bool, fn func(v interface{}) error, st *WalkStats) error {
+	cid, coff, cok, err := readCursor(dir)
+	if err != nil {
+		if err != ErrInvalidSegment {
+			return err
+		}
+		// a bad cursor means a replay would start from the top, so do we
+		st.Damaged++
+	}
+	for _, seg := range segs {
+		if cok && seg.id < cid {
+			continue
+		}
+		start := int64(walHeaderSize)
+		if cok && seg.id == cid && coff > start {
+			start = coff
+		}
+		if err := walkSegment(seg, start, salvage, fn, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkSegment(seg *walSegment, start int64, salvage bool, fn func(v interface{}) error, st *WalkStats) error {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st.Files++
+	if seg.size > start {
+		st.Bytes += seg.size - start
+	}
+	if err = checkSegmentHeader(f); err != nil {
+		st.Damaged++
+		if !salvage {
+			return fmt.Errorf("%w: %s", ErrCacheCorrupted, seg.path)
+		}
+		// records carry their own checksums so just go looking for them
+		start = walHeaderSize
+	}
+	for off := start; off < seg.size; {
+		var v interface{}
+		n, err := readRecord(f, off, seg.size, &v)
+		if err != nil {
+			st.Damaged++
+			if !salvage {
+				return fmt.Errorf("%w: %s offset %d", ErrCacheCorrupted, seg.path, off)
+			}
+			if n == 0 {
+				// the framing is gone, scan for the next record with a good checksum
+				for n = 1; off+n < seg.size; n++ {
+					if _, err := readRecord(f, off+n, seg.size, nil); err == nil {
+						break
+					}
+				}
+			}
+			st.Skipped += n
+			off += n
+			continue
+		}
+		off += n
+		if v == nil {
+			continue
+		}
+		st.Values++
+		if err = fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func copyOldEntries(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"cache_a", "cache_b", "lock"} {
+		if bts, err := os.ReadFile(filepath.Join("old-entries-cache", name)); err != nil {
+			t.Fatal(err)
+		} else if err = os.WriteFile(filepath.Join(dir, name), bts, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func walkEntries(dir string, salvage bool) (ents []*entry.Entry, st WalkStats, err error) {
+	st, err = Walk(dir, salvage, func(v interface{}) error {
+		if ent, ok := v.(*entry.Entry); ok {
+			ents = append(ents, ent)
+		}
+		return nil
+	})
+	return
+}
+
+func TestWalkOldEntries(t *testing.T) {
+	dir := copyOldEntries(t)
+	if !IsCacheDir(dir) {
+		t.Fatal("fixture is not a cache directory")
+	}
+	ents, st, err := walkEntries(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 100 || st.Values != 100 || st.Damaged != 0 || st.Files != 2 {
+		t.Fatalf("bad walk: %d entries %+v", len(ents), st)
+	}
+	for i, ent := range ents {
+		if string(ent.Data) == `` {
+			t.Fatalf("entry %d is empty", i)
+		}
+	}
+}
+
+func TestWalkSalvageGob(t *testing.T) {
+	dir := copyOldEntries(t)
+	pth := filepath.Join(dir, "cache_b")
+	bts, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stomp on a chunk in the middle of the stream
+	for i := len(bts) / 2; i < len(bts)/2+24; i++ {
+		bts[i] = 0xff
+	}
+	if err = os.WriteFile(pth, bts, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = walkEntries(dir, false); !errors.Is(err, ErrCacheCorrupted) {
+		t.Fatalf("corruption not detected: %v", err)
+	}
+	ents, st, err := walkEntries(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Damaged == 0 || st.Skipped == 0 {
+		t.Fatalf("damage not reported: %+v", st)
+	}
+	if len(ents) < 90 || len(ents) >= 100 {
+		t.Fatalf("salvaged %d entries", len(ents))
+	}
+	// we must have picked up after the damaged region
+	if string(ents[len(ents)-1].Data) != `99` {
+		t.Fatalf("did not recover the tail, last entry %q", ents[len(ents)-1].Data)
+	}
+}
+
+func TestWalkWAL(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, WALConfig{SegmentSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	walAppend(t, w, 0, 40)
+	walExpect(t, w, 0, 5)
+	w.Close()
+
+	var vals []int
+	fn := func(v interface{}) error {
+		vals = append(vals, v.(*ChanCacheTester).V)
+		return nil
+	}
+	if !IsCacheDir(dir) {
+		t.Fatal("log is not a cache directory")
+	}
+	st, err := Walk(dir, false, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 35 || vals[0] != 5 || vals[34] != 39 || st.Values != 35 {
+		t.Fatalf("bad walk %v %+v", vals, st)
+	}
+
+	// damage a record in the last segment
+	segs, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := segs[len(segs)-1]
+	f, err := os.OpenFile(last.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{0xde, 0xad, 0xbe, 0xef}, walHeaderSize+2)
+	f.Close()
+
+	vals = nil
+	if _, err = Walk(dir, false, fn); !errors.Is(err, ErrCacheCorrupted) {
+		t.Fatalf("corruption not detected: %v", err)
+	}
+	vals = nil
+	if st, err = Walk(dir, true, fn); err != nil {
+		t.Fatal(err)
+	}
+	if st.Damaged != 1 || len(vals) != 34 || vals[len(vals)-1] != 39 {
+		t.Fatalf("bad salvage %v %+v", vals, st)
+	}
+}
+
+func TestInUse(t *testing.T) {
+	dir := t.TempDir()
+	if inUse, err := InUse(dir); err != nil || inUse {
+		t.Fatal("empty directory in use", err)
+	}
+	c, err := NewChanCacher(2, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inUse, err := InUse(dir); err != nil || !inUse {
+		t.Fatal("running cache not in use", err)
+	}
+	close(c.In)
+	c.Commit()
+	for range c.Out {
+	}
+	if inUse, err := InUse(dir); err != nil || inUse {
+		t.Fatal("committed cache in use", err)
+	}
+}
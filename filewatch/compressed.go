@@ -0,0 +1,265 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type compression int
+
+const (
+	compressNone compression = iota
+	compressGzip
+	compressBzip2
+	compressZstd
+	compressXz
+)
+
+const compressMagicSize = 6
+
+var (
+	ErrSeekWhence = errors.New("compressed files can only seek from the start of the stream")
+
+	compressionTypes = []struct {
+		c     compression
+		ext   string
+		magic []byte
+	}{
+		{c: compressGzip, ext: `.gz`, magic: []byte{0x1f, 0x8b}},
+		{c: compressBzip2, ext: `.bz2`, magic: []byte{'B', 'Z', 'h'}},
+		{c: compressZstd, ext: `.zst`, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{c: compressXz, ext: `.xz`, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	}
+)
+
+// fileCompression identifies compressed files by their magic bytes.  Files that are too
+// short to carry a header fall back to the extension, which lets us pick up a compressed
+// file that is still being written by logrotate.
+func fileCompression(f *os.File) compression {
+	hdr := make([]byte, compressMagicSize)
+	n, _ := f.ReadAt(hdr, 0)
+	hdr = hdr[:n]
+	for _, ct := range compressionTypes {
+		if !bytes.HasPrefix(hdr, ct.magic) {
+			continue
+		}
+		// the bzip2 magic is plain ASCII, make sure the block size is there too
+		if ct.c == compressBzip2 && (len(hdr) < 4 || hdr[3] < '1' || hdr[3] > '9') {
+			continue
+		}
+		return ct.c
+	}
+	ext := strings.ToLower(filepath.Ext(f.Name()))
+	for _, ct := range compressionTypes {
+		if ext == ct.ext && bytes.HasPrefix(ct.magic, hdr) {
+			return ct.c
+		}
+	}
+	return compressNone
+}
+
+// isCompressedFile opens the file at p and checks if it is compressed, errors are treated as uncompressed
+func isCompressedFile(p string) bool {
+	fin, err := openDeletableFile(p)
+	if err != nil {
+		return false
+	}
+	defer fin.Close()
+	return fileCompression(fin) != compressNone
+}
+
+// trimCompressionExt removes a known compression extension from a file name
+func trimCompressionExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, ct := range compressionTypes {
+		if ext == ct.ext {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// decompressReader presents a compressed file as its decompressed stream.  Offsets, and
+// therefore follower states, are positions in the decompressed stream.
+//
+// A compressed file may still be growing when we find it, so a stream that ends early is
+// treated as the end of the available data.  When the file grows the stream is decompressed
+// from the top again and everything up to the current position is discarded.
+type decompressReader struct {
+	f      *os.File
+	c      compression
+	rdr    io.Reader
+	pos    int64 // position in the decompressed stream handed to the caller
+	rpos   int64 // position of the decompressor, always <= pos
+	failed int64 // size of the file when the stream last ended, -1 if the stream is open
+}
+
+func newDecompressReader(f *os.File, c compression) *decompressReader {
+	return &decompressReader{
+		f:      f,
+		c:      c,
+		failed: -1,
+	}
+}
+
+func (d *decompressReader) open() (err error) {
+	// the section reader uses ReadAt, so the file offset is never touched
+	src := bufio.NewReader(io.NewSectionReader(d.f, 0, math.MaxInt64))
+	switch d.c {
+	case compressGzip:
+		d.rdr, err = gzip.NewReader(src)
+	case compressBzip2:
+		d.rdr = bzip2.NewReader(src)
+	case compressZstd:
+		d.rdr, err = zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+	case compressXz:
+		d.rdr, err = xz.NewReader(src)
+	default:
+		err = errors.New("unknown compression type")
+	}
+	if err != nil {
+		d.rdr = nil
+		return
+	}
+	d.rpos = 0
+	d.failed = -1
+	return
+}
+
+func (d *decompressReader) release() {
+	if d.rdr == nil {
+		return
+	}
+	switch t := d.rdr.(type) {
+	case *gzip.Reader:
+		t.Close()
+	case *zstd.Decoder:
+		t.Close()
+	}
+	d.rdr = nil
+}
+
+// end records that the stream stopped, we will only try again once the file changes size
+func (d *decompressReader) end() {
+	d.release()
+	if fi, err := d.f.Stat(); err == nil {
+		d.failed = fi.Size()
+	} else {
+		d.failed = 0
+	}
+}
+
+func (d *decompressReader) Read(b []byte) (n int, err error) {
+	if d.rdr == nil {
+		if d.failed >= 0 {
+			var fi os.FileInfo
+			if fi, err = d.f.Stat(); err != nil {
+				return
+			} else if fi.Size() == d.failed {
+				return 0, io.EOF
+			}
+		}
+		if err = d.open(); err != nil {
+			d.end()
+			return 0, io.EOF
+		}
+	}
+	if d.rpos < d.pos {
+		var m int64
+		m, err = io.CopyN(io.Discard, d.rdr, d.pos-d.rpos)
+		d.rpos += m
+		if err != nil {
+			d.end()
+			return 0, io.EOF
+		}
+	}
+	n, err = d.rdr.Read(b)
+	d.rpos += int64(n)
+	d.pos = d.rpos
+	if err != nil {
+		d.end()
+		if n > 0 {
+			err = nil
+		} else {
+			err = io.EOF
+		}
+	}
+	return
+}
+
+func (d *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return d.pos, ErrSeekWhence
+	} else if offset < 0 {
+		return d.pos, errors.New("invalid offset")
+	}
+	if offset < d.rpos {
+		// can't go backwards, start over
+		d.release()
+		d.rpos = 0
+		d.failed = -1
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decompressReader) Name() string {
+	return d.f.Name()
+}
+
+func (d *decompressReader) Close() error {
+	d.release()
+	return d.f.Close()
+}
+
+// readCompressedHead decompresses up to n bytes from the start of a compressed file,
+// complete is set when the entire stream was shorter than n bytes.
+func readCompressedHead(p string, n int) (head []byte, complete bool, err error) {
+	var fin *os.File
+	if fin, err = openDeletableFile(p); err != nil {
+		return
+	}
+	defer fin.Close()
+	d := newDecompressReader(fin, fileCompression(fin))
+	if err = d.open(); err != nil {
+		// not enough of the file to even get a header
+		return nil, false, nil
+	}
+	defer d.release()
+	head = make([]byte, n)
+	var m int
+	m, err = io.ReadFull(d.rdr, head)
+	head = head[:m]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the decompressor says io.EOF only at the clean end of a stream, check that we
+		// got there rather than running off the end of a partially written file
+		complete = true
+		err = nil
+		var b [1]byte
+		if _, lerr := d.rdr.Read(b[:]); lerr != io.EOF {
+			complete = false
+		}
+	} else if err != nil {
+		err = nil
+	}
+	return
+}
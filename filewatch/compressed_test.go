@@ -0,0 +1,215 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func testLines(start, cnt int) (b []byte) {
+	for i := start; i < start+cnt; i++ {
+		b = append(b, fmt.Sprintf("line %d %s\n", i, randomString(32))...)
+	}
+	return
+}
+
+func compressBytes(t *testing.T, ext string, data []byte) []byte {
+	var bb bytes.Buffer
+	var wtr io.WriteCloser
+	var err error
+	switch ext {
+	case `.gz`:
+		wtr = gzip.NewWriter(&bb)
+	case `.zst`:
+		wtr, err = zstd.NewWriter(&bb)
+	case `.xz`:
+		wtr, err = xz.NewWriter(&bb)
+	default:
+		t.Fatalf("unknown extension %q", ext)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = wtr.Write(data); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func openLiner(t *testing.T, pth string, start int64) *LineReader {
+	fin, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnr, err := NewLineReader(ReaderConfig{
+		Fin:        fin,
+		MaxLineLen: defaultMaxLine,
+		StartIndex: start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lnr
+}
+
+func readAllLines(t *testing.T, lnr *LineReader) (lines []string) {
+	for {
+		ln, ok, _, err := lnr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		lines = append(lines, string(ln))
+	}
+}
+
+func TestCompressedReaders(t *testing.T) {
+	data := testLines(0, 1000)
+	expect := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	for _, ext := range []string{`.gz`, `.zst`, `.xz`} {
+		pth := filepath.Join(t.TempDir(), "test.log.1"+ext)
+		if err := os.WriteFile(pth, compressBytes(t, ext, data), 0640); err != nil {
+			t.Fatal(err)
+		}
+		lnr := openLiner(t, pth, 0)
+		if lines := readAllLines(t, lnr); len(lines) != len(expect) {
+			t.Fatalf("%s: got %d lines", ext, len(lines))
+		} else if lines[999] != string(expect[999]) {
+			t.Fatalf("%s: bad line %q", ext, lines[999])
+		}
+		if lnr.Index() != int64(len(data)) {
+			t.Fatalf("%s: index %d is not in the decompressed stream", ext, lnr.Index())
+		}
+		lnr.Close()
+
+		// resume halfway through the decompressed stream
+		off := int64(bytes.Index(data, expect[500]))
+		lnr = openLiner(t, pth, off)
+		if lines := readAllLines(t, lnr); len(lines) != 500 || lines[0] != string(expect[500]) {
+			t.Fatalf("%s: bad resume, got %d lines", ext, len(lines))
+		}
+		lnr.Close()
+	}
+}
+
+func TestCompressedGrowing(t *testing.T) {
+	data := testLines(0, 2000)
+	cdata := compressBytes(t, `.gz`, data)
+	pth := filepath.Join(t.TempDir(), "test.log.1.gz")
+	if err := os.WriteFile(pth, cdata[:len(cdata)/2], 0640); err != nil {
+		t.Fatal(err)
+	}
+	lnr := openLiner(t, pth, 0)
+	defer lnr.Close()
+	first := readAllLines(t, lnr)
+	if len(first) == 0 || len(first) >= 2000 {
+		t.Fatalf("read %d lines from half a file", len(first))
+	}
+	fout, err := os.OpenFile(pth, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.Write(cdata[len(cdata)/2:])
+	fout.Close()
+
+	lines := append(first, readAllLines(t, lnr)...)
+	if len(lines) != 2000 {
+		t.Fatalf("got %d lines after the file was finished", len(lines))
+	}
+	for i, ln := range lines {
+		if !bytes.HasPrefix([]byte(ln), []byte(fmt.Sprintf("line %d ", i))) {
+			t.Fatalf("line %d is out of order: %q", i, ln)
+		}
+	}
+}
+
+func TestCompressedRotation(t *testing.T) {
+	dir := t.TempDir()
+	rt := newRotationTracker()
+	plain := filepath.Join(dir, "foo.log")
+	data := testLines(0, 100)
+	if err := os.WriteFile(plain, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	var plainState int64
+	var plainLH trackingLH
+	syncFollower(t, FollowerConfig{BaseName: baseName, FilePath: plain, State: &plainState, Handler: &plainLH, rot: rt})
+	if len(plainLH.mp) != 100 {
+		t.Fatalf("consumed %d lines from the plain file", len(plainLH.mp))
+	}
+
+	// rotated and compressed with a few more lines that were written before rotation
+	rotated := filepath.Join(dir, "foo.log.1.gz")
+	if err := os.WriteFile(rotated, compressBytes(t, `.gz`, append(data, testLines(100, 10)...)), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var rotState int64
+	var rotLH trackingLH
+	syncFollower(t, FollowerConfig{BaseName: baseName, FilePath: rotated, State: &rotState, Handler: &rotLH, rot: rt})
+	if len(rotLH.mp) != 10 {
+		t.Fatalf("rotated file handed %d lines, expected 10", len(rotLH.mp))
+	}
+	for k := range rotLH.mp {
+		if _, ok := plainLH.mp[k]; ok {
+			t.Fatalf("line %q ingested twice", k)
+		}
+	}
+
+	// a compressed file with different contents must be read in full
+	other := filepath.Join(dir, "foo.log.2.gz")
+	if err := os.WriteFile(other, compressBytes(t, `.gz`, testLines(0, 50)), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var otherState int64
+	var otherLH trackingLH
+	syncFollower(t, FollowerConfig{BaseName: baseName, FilePath: other, State: &otherState, Handler: &otherLH, rot: rt})
+	if len(otherLH.mp) != 50 {
+		t.Fatalf("unrelated compressed file handed %d lines", len(otherLH.mp))
+	}
+}
+
+func syncFollower(t *testing.T, fcfg FollowerConfig) {
+	fl, err := NewFollower(fcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if err = fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotationBase(t *testing.T) {
+	for k, v := range map[string]string{
+		`foo.log`:              `foo.log`,
+		`foo.log.1`:            `foo.log`,
+		`foo.log.2.gz`:         `foo.log`,
+		`foo.log-20240101.zst`: `foo.log`,
+		`syslog.1.bz2`:         `syslog`,
+		`1.log.xz`:             `1.log`,
+		`messages`:             `messages`,
+	} {
+		if r := rotationBase(k); r != v {
+			t.Fatalf("rotationBase(%q) = %q, expected %q", k, r, v)
+		}
+	}
+}
@@ -69,6 +69,7 @@ type FilterManager struct {
 	stateFout       *os.File
 	maxFilesWatched int
 	logger          ingest.IngestLogger
+	rotations       *rotationTracker
 }
 
 func NewFilterManager(stateFile string) (*FilterManager, error) {
@@ -88,6 +89,7 @@ func NewFilterManager(stateFile string) (*FilterManager, error) {
 		states:    states,
 		followers: map[FileName]*follower{},
 		logger:    ingest.NoLogger(),
+		rotations: newRotationTracker(),
 	}, nil
 }
 
@@ -440,6 +442,7 @@ func (f *FilterManager) NewFollower(fpath string) (bool, error) {
 // the caller MUST hold the lock
 func (f *FilterManager) addFollower(fcfg FollowerConfig) error {
 	f.expungeOldFiles()
+	fcfg.rot = f.rotations
 	stid := FileName{
 		BaseName: fcfg.BaseName,
 		FilePath: fcfg.FilePath,
@@ -566,6 +569,7 @@ func (f *FilterManager) checkState(wf watchedFile) (si *int64, hasWork bool, err
 	//get base dir
 	fname := filepath.Base(wf.pth)
 	fdir := filepath.Dir(wf.pth)
+	compressed := isCompressedFile(wf.pth)
 	//swing through all filters and for each follower that matches, check if the file has work to be done
 	for _, v := range f.filters {
 		//check base directory and pattern match
@@ -579,8 +583,10 @@ func (f *FilterManager) checkState(wf watchedFile) (si *int64, hasWork bool, err
 				hasWork = true
 				si = f.addSeekInfo(v.bname, wf.pth)
 			}
-		} else if *si < wf.size {
+		} else if !compressed && *si < wf.size {
 			//we have a state, check if there is new data
+			//states on compressed files are offsets in the decompressed stream and can't be
+			//compared to the file size, the regular follower will pick up anything remaining
 			hasWork = true
 		}
 	}
@@ -711,6 +717,7 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 // catchupFollower is a linear operation to get outstanding files up to date.
 func (f *FilterManager) catchupFollower(fcfg FollowerConfig, qc chan os.Signal) (bool, error) {
 	f.logger.Info("performing initial catch-up preprocessing for file", log.KV("file", fcfg.FilePath))
+	fcfg.rot = f.rotations
 	if fl, err := NewFollower(fcfg); err != nil {
 		return false, err
 	} else if quit, err := fl.Sync(qc); err != nil || quit {
@@ -880,7 +887,8 @@ func cleanStates(states map[FileName]*int64) error {
 				v = new(int64)
 			}
 			//if file shrank, we have to assume this was a truncation, so remove the state
-			if fi.Size() < *v {
+			//compressed files are tracked by the decompressed offset which is almost always larger
+			if fi.Size() < *v && !isCompressedFile(k.FilePath) {
 				*v = 0 //reset the size
 			}
 		}
@@ -51,6 +51,7 @@ type FileId struct {
 type FollowerEngineConfig struct {
 	Engine     int
 	EngineArgs string
+	Multiline  MultilineConfig
 }
 
 type FollowerConfig struct {
@@ -60,6 +61,8 @@ type FollowerConfig struct {
 	State    *int64
 	FilterID int
 	Handler  handler
+
+	rot *rotationTracker
 }
 
 type follower struct {
@@ -76,6 +79,12 @@ type follower struct {
 	wg       *sync.WaitGroup
 	lh       handler
 	lastAct  time.Time
+
+	fin        *os.File
+	compressed bool
+	rot        *rotationTracker
+	rotPending bool // compressed file that has not been checked against the rotation tracker
+	headLen    int
 }
 
 func NewFollower(cfg FollowerConfig) (*follower, error) {
@@ -102,6 +111,7 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 		StartIndex: *cfg.State,
 		Engine:     cfg.Engine,
 		EngineArgs: cfg.EngineArgs,
+		Multiline:  cfg.Multiline,
 	}
 	lnr, err := NewReader(rdrCfg)
 	if err != nil {
@@ -116,7 +126,7 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 	}
 
 	//open the file for reading and get
-	return &follower{
+	fl := &follower{
 		filterId: cfg.FilterID,
 		id:       id,
 		lnr:      lnr,
@@ -129,8 +139,19 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 			FilePath: cfg.FilePath,
 			BaseName: cfg.BaseName,
 		},
-		lastAct: time.Now(),
-	}, nil
+		lastAct:    time.Now(),
+		fin:        fin,
+		compressed: fileCompression(fin) != compressNone,
+		rot:        cfg.rot,
+	}
+	if fl.compressed {
+		fl.rotPending = cfg.rot != nil && *cfg.State == 0
+	} else if cfg.rot != nil {
+		head := fl.readHead()
+		fl.headLen = len(head)
+		cfg.rot.track(id, fl.FileName, head, cfg.State)
+	}
+	return fl, nil
 }
 
 func (f *follower) FilterId() int {
@@ -181,6 +202,12 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 	if f.abortCh != nil || f.running != 0 {
 		return false, ErrAlreadyStarted
 	}
+	if f.rotPending {
+		if ready, err := f.resolveRotation(); err != nil || !ready {
+			return false, err
+		}
+	}
+	defer f.updateHead()
 	for {
 		ln, ok, sawEOF, err := f.lnr.ReadEntry()
 		if err != nil {
@@ -208,7 +235,8 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 		f.lastAct = now
 		// This makes sure we don't read forever, in case the writer is really fast
 		// and the connection to the indexer isn't.
-		if f.lnr.Index() >= size {
+		// Compressed files are indexed by the decompressed stream so the size means nothing.
+		if !f.compressed && f.lnr.Index() >= size {
 			return false, nil
 		}
 		select {
@@ -270,9 +298,14 @@ func (f *follower) Close() error {
 	if err := f.fsn.Close(); err != nil {
 		f.err = err
 	}
+	if !f.compressed {
+		f.updateHead()
+		f.rot.retire(f.id)
+	}
 	if err := f.lnr.Close(); err != nil {
 		f.err = err
 	}
+	f.fin = nil
 	return f.err
 }
 
@@ -295,12 +328,17 @@ func (f *follower) IdleDuration() time.Duration {
 // and make sure the file wasn't truncated
 func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 	var hit bool
+	if f.rotPending {
+		if ready, err := f.resolveRotation(); err != nil || !ready {
+			return err
+		}
+	}
 	for {
 		ln, ok, sawEOF, err := f.lnr.ReadEntry()
 		if err != nil {
 			return err
 		}
-		if sawEOF && writeEvent {
+		if sawEOF && writeEvent && !f.compressed {
 			// We got an EOF on the file after a write
 			fi, err := os.Stat(f.FilePath)
 			if err != nil {
@@ -343,10 +381,23 @@ func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 	}
 	if hit {
 		f.lastAct = time.Now()
+		f.updateHead()
 	}
 	return nil
 }
 
+// updateHead refreshes what the rotation tracker knows about the start of a plain file
+// that was too short to fill the head the last time we looked
+func (f *follower) updateHead() {
+	if f.rot == nil || f.compressed || f.headLen >= rotationHeadSize {
+		return
+	}
+	if head := f.readHead(); len(head) > f.headLen {
+		f.headLen = len(head)
+		f.rot.update(f.id, head)
+	}
+}
+
 func (f *follower) routine() {
 	defer f.wg.Done()
 	defer func(r *int32) {
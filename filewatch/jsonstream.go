@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// JSONReader splits a stream of JSON objects or arrays into records without relying on
+// newlines, so concatenated ({...}{...}) and pretty printed objects both come out whole.
+// Anything between top level values that is not an object or array is skipped.
+type JSONReader struct {
+	baseReader
+	brdr  *bufio.Reader
+	buff  []byte // bytes read but not yet handed out, buff[0] is at idx
+	scan  int    // position in buff the scanner has reached
+	start int    // start of the current value in buff, -1 if we are between values
+	depth int
+	inStr bool
+	esc   bool
+}
+
+func NewJSONReader(cfg ReaderConfig) (*JSONReader, error) {
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.f),
+		start:      -1,
+	}, nil
+}
+
+func (jr *JSONReader) SeekFile(offset int64) error {
+	if err := jr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	jr.brdr.Reset(jr.f)
+	jr.reset()
+	jr.buff = nil
+	return nil
+}
+
+func (jr *JSONReader) reset() {
+	jr.scan = 0
+	jr.start = -1
+	jr.depth = 0
+	jr.inStr = false
+	jr.esc = false
+}
+
+// consume drops n bytes from the front of the buffer and moves the index past them
+func (jr *JSONReader) consume(n int) {
+	jr.buff = jr.buff[n:]
+	jr.idx += int64(n)
+	jr.reset()
+}
+
+// next scans the buffered data for a complete top level value
+func (jr *JSONReader) next() (ln []byte, ok bool) {
+	for ; jr.scan < len(jr.buff); jr.scan++ {
+		c := jr.buff[jr.scan]
+		if jr.start < 0 {
+			if c == '{' || c == '[' {
+				jr.start = jr.scan
+				jr.depth = 1
+			}
+			continue
+		}
+		if jr.inStr {
+			if jr.esc {
+				jr.esc = false
+			} else if c == '\\' {
+				jr.esc = true
+			} else if c == '"' {
+				jr.inStr = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			jr.inStr = true
+		case '{', '[':
+			jr.depth++
+		case '}', ']':
+			if jr.depth--; jr.depth == 0 {
+				end := jr.scan + 1
+				ln = append([]byte(nil), jr.buff[jr.start:end]...)
+				jr.consume(end)
+				return ln, true
+			}
+		}
+	}
+	if jr.start < 0 && len(jr.buff) > 0 {
+		// nothing but filler, no need to hang onto it
+		jr.consume(len(jr.buff))
+	}
+	return
+}
+
+func (jr *JSONReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	b := make([]byte, 8*1024)
+	for {
+		if ln, ok = jr.next(); ok {
+			return
+		} else if jr.start >= 0 && len(jr.buff)-jr.start > jr.maxLine {
+			// this is never going to close, hand back what we have rather than grow forever
+			ln, ok = jr.takeAll(), true
+			return
+		}
+		n, lerr := jr.brdr.Read(b)
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		}
+		if lerr == io.EOF {
+			wasEOF = true
+		}
+		if n == 0 {
+			return
+		}
+		jr.buff = append(jr.buff, b[:n]...)
+	}
+}
+
+func (jr *JSONReader) takeAll() (ln []byte) {
+	ln = bytes.TrimSpace(jr.buff)
+	ln = append([]byte(nil), ln...)
+	jr.consume(len(jr.buff))
+	return
+}
+
+// ReadRemaining hands back a value that was never closed, the writer went quiet or the file is going away
+func (jr *JSONReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = jr.ReadEntry(); err != nil || ok {
+		return
+	} else if jr.start >= 0 {
+		ln = jr.takeAll()
+	}
+	return
+}
@@ -0,0 +1,109 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const jsonStream = `{"a":1}{"b":"}{ not a brace \" still a string"}
+[1,2,{"c":[3]}]  garbage
+{
+	"pretty": {
+		"nested": true
+	}
+}`
+
+func openJSON(t *testing.T, pth string, start int64) *JSONReader {
+	fin, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr, err := NewJSONReader(ReaderConfig{
+		Fin:        fin,
+		MaxLineLen: defaultMaxLine,
+		StartIndex: start,
+		Engine:     JSONEngine,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jr.Close() })
+	return jr
+}
+
+func TestJSONReader(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "stream.json")
+	if err := os.WriteFile(pth, []byte(jsonStream), 0640); err != nil {
+		t.Fatal(err)
+	}
+	jr := openJSON(t, pth, 0)
+	recs := readRecords(t, jr)
+	if len(recs) != 4 {
+		t.Fatalf("got %d records: %q", len(recs), recs)
+	}
+	for _, r := range recs {
+		if !json.Valid([]byte(r)) {
+			t.Fatalf("invalid record %q", r)
+		}
+	}
+	if recs[1] != `{"b":"}{ not a brace \" still a string"}` {
+		t.Fatalf("string contents broke the scanner: %q", recs[1])
+	}
+	if jr.Index() != int64(len(jsonStream)) {
+		t.Fatalf("index %d did not reach the end", jr.Index())
+	}
+
+	// resume after the second record
+	jr = openJSON(t, pth, int64(strings.Index(jsonStream, "\n[")))
+	if recs2 := readRecords(t, jr); len(recs2) != 2 || recs2[0] != `[1,2,{"c":[3]}]` {
+		t.Fatalf("bad resume %q", recs2)
+	}
+}
+
+func TestJSONReaderPartial(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "stream.json")
+	half := strings.Index(jsonStream, `"nested"`)
+	if err := os.WriteFile(pth, []byte(jsonStream[:half]), 0640); err != nil {
+		t.Fatal(err)
+	}
+	jr := openJSON(t, pth, 0)
+	if recs := readRecords(t, jr); len(recs) != 3 {
+		t.Fatalf("got %d records", len(recs))
+	}
+	held := jr.Index()
+	fout, err := os.OpenFile(pth, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.WriteString(jsonStream[half:])
+	fout.Close()
+	if recs := readRecords(t, jr); len(recs) != 1 || !json.Valid([]byte(recs[0])) {
+		t.Fatalf("bad completed record %q", recs)
+	} else if held >= jr.Index() {
+		t.Fatal("index did not move")
+	}
+
+	// an object that never closes comes out on ReadRemaining
+	fout, err = os.OpenFile(pth, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fout.WriteString(`{"truncated":`)
+	fout.Close()
+	if ln, err := jr.ReadRemaining(); err != nil {
+		t.Fatal(err)
+	} else if string(ln) != `{"truncated":` {
+		t.Fatalf("bad remaining %q", ln)
+	}
+}
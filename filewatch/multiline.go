@@ -0,0 +1,206 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultMultilineMaxLines = 500
+	defaultMultilineTimeout  = 2 * time.Second
+)
+
+var (
+	ErrMultilineNoPattern = errors.New("multiline engine requires a start or continuation pattern")
+)
+
+// MultilineConfig controls how the multiline engine groups lines into records.
+// A line begins a new record when it matches StartPattern, or when a ContinuationPattern
+// is given and the line does not match it; every other line is appended to the current
+// record.  A record is finished when the next record begins, when it reaches MaxLines,
+// or when no new data has shown up for Timeout.
+type MultilineConfig struct {
+	StartPattern        string
+	ContinuationPattern string
+	MaxLines            int
+	Timeout             time.Duration
+}
+
+// Validate checks that the patterns compile and fills in defaults
+func (mc *MultilineConfig) Validate() error {
+	if mc.StartPattern == `` && mc.ContinuationPattern == `` {
+		return ErrMultilineNoPattern
+	}
+	if mc.StartPattern != `` {
+		if _, err := regexp.Compile(mc.StartPattern); err != nil {
+			return err
+		}
+	}
+	if mc.ContinuationPattern != `` {
+		if _, err := regexp.Compile(mc.ContinuationPattern); err != nil {
+			return err
+		}
+	}
+	if mc.MaxLines < 0 {
+		return errors.New("multiline max lines is invalid")
+	} else if mc.MaxLines == 0 {
+		mc.MaxLines = defaultMultilineMaxLines
+	}
+	if mc.Timeout < 0 {
+		return errors.New("multiline timeout is invalid")
+	} else if mc.Timeout == 0 {
+		mc.Timeout = defaultMultilineTimeout
+	}
+	return nil
+}
+
+// MultilineReader groups lines into records, such as a log message followed by a stack trace.
+// The index only moves past complete records, so a record that is still being assembled when
+// the follower stops is read again from the top when it resumes.
+type MultilineReader struct {
+	baseReader
+	cfg      MultilineConfig
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	brdr     *bufio.Reader
+	ridx     int64  // how far we have read
+	partial  []byte // line that has not seen its newline yet
+	rec      []byte // record being assembled
+	recLines int
+	lastRead time.Time
+}
+
+func NewMultilineReader(cfg ReaderConfig) (*MultilineReader, error) {
+	mc := cfg.Multiline
+	if err := mc.Validate(); err != nil {
+		return nil, err
+	}
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MultilineReader{
+		baseReader: br,
+		cfg:        mc,
+		brdr:       bufio.NewReader(br.f),
+		ridx:       cfg.StartIndex,
+		lastRead:   time.Now(),
+	}
+	if mc.StartPattern != `` {
+		mr.start = regexp.MustCompile(mc.StartPattern)
+	}
+	if mc.ContinuationPattern != `` {
+		mr.cont = regexp.MustCompile(mc.ContinuationPattern)
+	}
+	return mr, nil
+}
+
+func (mr *MultilineReader) SeekFile(offset int64) error {
+	if err := mr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	mr.brdr.Reset(mr.f)
+	mr.ridx = offset
+	mr.partial = nil
+	mr.rec = nil
+	mr.recLines = 0
+	return nil
+}
+
+// isStart decides if a line begins a new record
+func (mr *MultilineReader) isStart(ln []byte) bool {
+	if mr.start != nil && mr.start.Match(ln) {
+		return true
+	} else if mr.cont != nil {
+		return !mr.cont.Match(ln)
+	}
+	return false
+}
+
+// flush hands back the current record, the index moves to end so it must point at the
+// first byte that is not part of the record
+func (mr *MultilineReader) flush(end int64) (rec []byte) {
+	rec = mr.rec
+	mr.rec = nil
+	mr.recLines = 0
+	mr.idx = end
+	return
+}
+
+func (mr *MultilineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		b, lerr := mr.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		}
+		if len(b) > 0 {
+			mr.ridx += int64(len(b))
+			mr.lastRead = time.Now()
+		}
+		if lerr == io.EOF {
+			wasEOF = true
+			mr.partial = append(mr.partial, b...)
+			// nothing new for a while, whatever we have is all there is going to be
+			if len(mr.rec) > 0 && len(mr.partial) == 0 && time.Since(mr.lastRead) > mr.cfg.Timeout {
+				ln, ok = mr.flush(mr.ridx), true
+			}
+			return
+		}
+		lineStart := mr.ridx - int64(len(mr.partial)+len(b))
+		line := bytes.TrimRight(append(mr.partial, b...), "\r\n")
+		mr.partial = nil
+
+		if len(line) == 0 {
+			// blank lines never start a record
+			if len(mr.rec) == 0 {
+				mr.idx = mr.ridx
+				continue
+			}
+		} else if len(mr.rec) > 0 && mr.isStart(line) {
+			ln, ok = mr.flush(lineStart), true
+			mr.appendLine(line)
+			return
+		}
+		mr.appendLine(line)
+		if mr.recLines >= mr.cfg.MaxLines || len(mr.rec) >= mr.maxLine {
+			ln, ok = mr.flush(mr.ridx), true
+			return
+		}
+	}
+}
+
+func (mr *MultilineReader) appendLine(line []byte) {
+	if mr.recLines > 0 {
+		mr.rec = append(mr.rec, '\n')
+	}
+	mr.rec = append(mr.rec, line...)
+	mr.recLines++
+}
+
+func (mr *MultilineReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = mr.ReadEntry(); err != nil || ok {
+		return
+	}
+	if len(mr.partial) > 0 {
+		mr.appendLine(bytes.TrimRight(mr.partial, "\r\n"))
+		mr.partial = nil
+	}
+	if len(mr.rec) > 0 {
+		ln = mr.flush(mr.ridx)
+	}
+	return
+}
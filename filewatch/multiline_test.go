@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const stackTraceLog = `2024-01-02 10:00:00 INFO starting up
+2024-01-02 10:00:01 ERROR request failed
+java.lang.IllegalStateException: bad things
+	at com.example.Foo.bar(Foo.java:10)
+	at com.example.Foo.main(Foo.java:5)
+
+Caused by: java.io.IOException: worse things
+	at com.example.Baz.qux(Baz.java:99)
+	... 2 more
+2024-01-02 10:00:02 INFO recovered
+2024-01-02 10:00:03 ERROR again
+	at com.example.Foo.bar(Foo.java:10)
+`
+
+func newMultiline(t *testing.T, data string, start int64, mc MultilineConfig) *MultilineReader {
+	pth := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(pth, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+	fin, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewMultilineReader(ReaderConfig{
+		Fin:        fin,
+		MaxLineLen: defaultMaxLine,
+		StartIndex: start,
+		Engine:     MultilineEngine,
+		Multiline:  mc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mr.Close() })
+	return mr
+}
+
+func readRecords(t *testing.T, rdr Reader) (recs []string) {
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		recs = append(recs, string(ln))
+	}
+}
+
+func TestMultilineStartPattern(t *testing.T) {
+	mr := newMultiline(t, stackTraceLog, 0, MultilineConfig{StartPattern: `^\d{4}-\d{2}-\d{2} `})
+	recs := readRecords(t, mr)
+	// the last record has nothing after it, so it is held until the timeout
+	if len(recs) != 3 {
+		t.Fatalf("got %d records: %q", len(recs), recs)
+	}
+	if !strings.HasPrefix(recs[1], "2024-01-02 10:00:01 ERROR") || !strings.HasSuffix(recs[1], "... 2 more") || strings.Count(recs[1], "\n") != 7 {
+		t.Fatalf("bad stack trace record %q", recs[1])
+	}
+	// the index must sit at the start of the record we are holding
+	if off := int64(strings.Index(stackTraceLog, "2024-01-02 10:00:03")); mr.Index() != off {
+		t.Fatalf("index %d should be %d", mr.Index(), off)
+	}
+	ln, err := mr.ReadRemaining()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(ln) != "2024-01-02 10:00:03 ERROR again\n\tat com.example.Foo.bar(Foo.java:10)" {
+		t.Fatalf("bad final record %q", ln)
+	} else if mr.Index() != int64(len(stackTraceLog)) {
+		t.Fatalf("index %d did not reach the end", mr.Index())
+	}
+
+	// resuming from a saved state must produce the same records
+	off := int64(strings.Index(stackTraceLog, "2024-01-02 10:00:01"))
+	mr = newMultiline(t, stackTraceLog, off, MultilineConfig{StartPattern: `^\d{4}-\d{2}-\d{2} `})
+	if recs2 := readRecords(t, mr); len(recs2) != 2 || recs2[0] != recs[1] {
+		t.Fatalf("bad resume: %q", recs2)
+	}
+}
+
+func TestMultilineContinuationPattern(t *testing.T) {
+	mr := newMultiline(t, stackTraceLog, 0, MultilineConfig{ContinuationPattern: `^(\s|\.\.\.|Caused by:|java\.)`})
+	recs := readRecords(t, mr)
+	if len(recs) != 3 || recs[0] != "2024-01-02 10:00:00 INFO starting up" || strings.Count(recs[1], "\n") != 7 {
+		t.Fatalf("bad records %q", recs)
+	}
+}
+
+func TestMultilineMaxLines(t *testing.T) {
+	mr := newMultiline(t, stackTraceLog, 0, MultilineConfig{StartPattern: `^\d{4}-`, MaxLines: 3})
+	recs := readRecords(t, mr)
+	if len(recs) != 5 {
+		t.Fatalf("got %d records: %q", len(recs), recs)
+	}
+	for _, r := range recs {
+		if strings.Count(r, "\n") > 2 {
+			t.Fatalf("record exceeded max lines: %q", r)
+		}
+	}
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	mr := newMultiline(t, stackTraceLog, 0, MultilineConfig{StartPattern: `^\d{4}-`, Timeout: 20 * time.Millisecond})
+	if recs := readRecords(t, mr); len(recs) != 3 {
+		t.Fatalf("got %d records", len(recs))
+	}
+	time.Sleep(50 * time.Millisecond)
+	ln, ok, eof, err := mr.ReadEntry()
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok || !eof || !strings.HasPrefix(string(ln), "2024-01-02 10:00:03") {
+		t.Fatalf("held record was not flushed: %v %q", ok, ln)
+	} else if mr.Index() != int64(len(stackTraceLog)) {
+		t.Fatalf("index %d did not reach the end", mr.Index())
+	}
+}
+
+func TestMultilineConfig(t *testing.T) {
+	var mc MultilineConfig
+	if err := mc.Validate(); err != ErrMultilineNoPattern {
+		t.Fatal("accepted config without patterns")
+	}
+	mc.StartPattern = `[`
+	if err := mc.Validate(); err == nil {
+		t.Fatal("accepted bad pattern")
+	}
+	mc.StartPattern = `^\S`
+	if err := mc.Validate(); err != nil {
+		t.Fatal(err)
+	} else if mc.MaxLines != defaultMultilineMaxLines || mc.Timeout != defaultMultilineTimeout {
+		t.Fatalf("defaults not applied: %+v", mc)
+	}
+}
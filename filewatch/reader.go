@@ -10,6 +10,7 @@ package filewatch
 
 import (
 	"errors"
+	"io"
 	"os"
 )
 
@@ -20,8 +21,10 @@ const (
 )
 
 const (
-	LineEngine  int = 0
-	RegexEngine int = 1
+	LineEngine      int = 0
+	RegexEngine     int = 1
+	JSONEngine      int = 3
+	MultilineEngine int = 4
 )
 
 type Reader interface {
@@ -38,29 +41,42 @@ type ReaderConfig struct {
 	StartIndex int64
 	Engine     int
 	EngineArgs string
+	Multiline  MultilineConfig
+}
+
+// fileReader is the source a reader pulls from, either the file itself or its decompressed stream
+type fileReader interface {
+	io.ReadSeekCloser
+	Name() string
 }
 
 type baseReader struct {
-	f       *os.File
+	f       fileReader
 	idx     int64
 	maxLine int
 }
 
 func newBaseReader(f *os.File, maxLine int, startIdx int64) (br baseReader, err error) {
 	var n int64
+	var fr fileReader = f
+	if f != nil {
+		if c := fileCompression(f); c != compressNone {
+			fr = newDecompressReader(f, c)
+		}
+	}
 	if f == nil {
 		err = errors.New("Reader is nil")
 	} else if maxLine < 0 {
 		err = errors.New("maxline is invalid")
 	} else if startIdx < 0 {
 		err = errors.New("Invalid start index")
-	} else if n, err = f.Seek(startIdx, 0); err != nil {
+	} else if n, err = fr.Seek(startIdx, 0); err != nil {
 		return
 	} else if n != startIdx {
 		err = errors.New("Failed to seek")
 	}
 	if err == nil {
-		br.f = f
+		br.f = fr
 		br.idx = startIdx
 		br.maxLine = maxLine
 	}
@@ -23,6 +23,10 @@ func NewReader(cfg ReaderConfig) (Reader, error) {
 		return NewRegexReader(cfg)
 	case LineEngine: //default/empty is line reader
 		return NewLineReader(cfg)
+	case JSONEngine:
+		return NewJSONReader(cfg)
+	case MultilineEngine:
+		return NewMultilineReader(cfg)
 	}
 	return nil, errors.New("Unknown engine")
 }
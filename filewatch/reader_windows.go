@@ -30,6 +30,8 @@ const (
 )
 
 var (
+	ErrCompressedLineEngine = errors.New("compressed files require the regex engine on windows")
+
 	magicEvtxHeader = [8]byte{0x45, 0x6c, 0x66, 0x46, 0x69, 0x6c, 0x65, 0x00}
 )
 
@@ -44,9 +46,17 @@ func NewReader(cfg ReaderConfig) (Reader, error) {
 		if isEvtxFile(cfg.Fin) {
 			return NewEvtxReader(cfg)
 		}
+		//the windows line reader reopens the file on every read, it can't carry a decompressor
+		if cfg.Fin != nil && fileCompression(cfg.Fin) != compressNone {
+			return nil, ErrCompressedLineEngine
+		}
 		return NewLineReader(cfg)
 	case EvtxEngine:
 		return NewEvtxReader(cfg)
+	case JSONEngine:
+		return NewJSONReader(cfg)
+	case MultilineEngine:
+		return NewMultilineReader(cfg)
 	}
 	return nil, errors.New("Unknown engine")
 }
@@ -0,0 +1,198 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rotationHeadSize is how much of the start of a file we keep to recognize it after compression
+	rotationHeadSize = 1024
+	// maxRetiredFiles is how many files that are no longer followed we remember
+	maxRetiredFiles = 256
+)
+
+// rotationTracker remembers the start of the plain files we follow and how far we got in them.
+// When a compressed file appears (foo.log.1.gz) we can check whether it is a rotated copy of
+// a file we already consumed (foo.log) and pick up where we left off rather than ingesting
+// the same data twice.  Retired files are only remembered in memory, so a file that is rotated
+// AND compressed while the ingester is down will be ingested again.
+type rotationTracker struct {
+	mtx     sync.Mutex
+	files   map[FileId]*rotationSource
+	retired []FileId
+}
+
+type rotationSource struct {
+	FileName
+	head   []byte
+	state  *int64
+	active bool
+}
+
+func newRotationTracker() *rotationTracker {
+	return &rotationTracker{
+		files: map[FileId]*rotationSource{},
+	}
+}
+
+// track registers a plain file that a follower is reading
+func (rt *rotationTracker) track(id FileId, fn FileName, head []byte, state *int64) {
+	if rt == nil {
+		return
+	}
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	rt.files[id] = &rotationSource{
+		FileName: fn,
+		head:     head,
+		state:    state,
+		active:   true,
+	}
+}
+
+// update refreshes the start of a file that was too short to fill the head when first tracked
+func (rt *rotationTracker) update(id FileId, head []byte) {
+	if rt == nil {
+		return
+	}
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	if rs, ok := rt.files[id]; ok {
+		rs.head = head
+	}
+}
+
+// retire marks a file as no longer followed, the state is copied as the pointer may be reused
+func (rt *rotationTracker) retire(id FileId) {
+	if rt == nil {
+		return
+	}
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	rs, ok := rt.files[id]
+	if !ok || !rs.active {
+		return
+	}
+	st := new(int64)
+	if rs.state != nil {
+		*st = *rs.state
+	}
+	rs.state = st
+	rs.active = false
+	rt.retired = append(rt.retired, id)
+	for len(rt.retired) > maxRetiredFiles {
+		if old, ok := rt.files[rt.retired[0]]; ok && !old.active {
+			delete(rt.files, rt.retired[0])
+		}
+		rt.retired = rt.retired[1:]
+	}
+}
+
+// match looks for a consumed plain file that the compressed file at fpath was rotated from.
+// ready is false when the compressed file is still being written and we don't have enough of
+// it to tell yet.
+func (rt *rotationTracker) match(fn FileName, id FileId, head []byte, complete, stale bool) (offset int64, ok, ready bool) {
+	ready = true
+	if rt == nil {
+		return
+	}
+	rt.mtx.Lock()
+	defer rt.mtx.Unlock()
+	dir := filepath.Dir(fn.FilePath)
+	base := rotationBase(filepath.Base(fn.FilePath))
+	var best *rotationSource
+	for k, rs := range rt.files {
+		if k == id || rs.BaseName != fn.BaseName || len(rs.head) == 0 || rs.state == nil {
+			continue
+		} else if filepath.Dir(rs.FilePath) != dir || rotationBase(filepath.Base(rs.FilePath)) != base {
+			continue
+		}
+		if len(head) < len(rs.head) {
+			if !bytes.HasPrefix(rs.head, head) {
+				continue
+			} else if !complete && !stale {
+				// might be this one, wait for more of the file
+				ready = false
+				continue
+			} else if len(head) == 0 {
+				continue
+			}
+		} else if !bytes.HasPrefix(head, rs.head) {
+			continue
+		}
+		if best == nil || len(rs.head) > len(best.head) || (len(rs.head) == len(best.head) && *rs.state > *best.state) {
+			best = rs
+		}
+	}
+	if best != nil {
+		offset, ok, ready = *best.state, true, true
+	}
+	return
+}
+
+// rotationBase strips compression extensions and rotation suffixes from a file name,
+// so foo.log, foo.log.1, foo.log.2.gz, and foo.log-20240101.gz all come back as foo.log
+func rotationBase(name string) string {
+	name = trimCompressionExt(name)
+	for {
+		idx := strings.LastIndexAny(name, ".-_")
+		if idx <= 0 || idx == len(name)-1 || !isDigits(name[idx+1:]) {
+			return name
+		}
+		name = name[:idx]
+	}
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveRotation is called before a compressed follower reads anything, if the file is a
+// rotated copy of something we already consumed the state is moved forward to match.
+// It returns false if the file is still being written and we should try again later.
+func (f *follower) resolveRotation() (bool, error) {
+	head, complete, err := readCompressedHead(f.FilePath, rotationHeadSize)
+	if err != nil {
+		return false, err
+	}
+	stale := time.Since(f.lastFileModTime()) > maxIdleDataTime
+	offset, ok, ready := f.rot.match(f.FileName, f.id, head, complete, stale)
+	if !ready {
+		return false, nil
+	}
+	f.rotPending = false
+	if ok && offset > 0 {
+		if err := f.lnr.SeekFile(offset); err != nil {
+			return false, err
+		}
+		*f.state = offset
+	}
+	return true, nil
+}
+
+// readHead grabs the start of a plain file for the rotation tracker
+func (f *follower) readHead() []byte {
+	if f.fin == nil {
+		return nil
+	}
+	head := make([]byte, rotationHeadSize)
+	n, _ := f.fin.ReadAt(head, 0)
+	return head[:n]
+}
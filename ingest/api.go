@@ -29,10 +29,13 @@ const (
 
 const (
 	configurationBlockSize          uint32          = 1
+	extConfigurationBlockSize       uint32          = 3           //legacy compression, extended compression, level
 	maxStreamConfigurationBlockSize uint32          = 1024 * 1024 //just a sanity check
 	maxIngestStateSize              uint32          = 1024 * 1024
 	CompressNone                    CompressionType = 0
 	CompressSnappy                  CompressionType = 0x10
+	CompressZstd                    CompressionType = 0x20
+	CompressLZ4                     CompressionType = 0x30
 )
 
 var (
@@ -66,14 +69,28 @@ type Logger interface {
 }
 
 // StreamConfiguration is a structure that can be sent back and
+// forth between the ingester and indexer to configure the stream.
+//
+// Extended compression types (zstd, lz4) are carried after the legacy compression byte,
+// which is always set to a type that older indexers understand.  An older indexer only
+// looks at the first byte and echoes it back, so the ingester falls back to snappy.
 type StreamConfiguration struct {
-	Compression CompressionType
+	Compression      CompressionType
+	CompressionLevel uint8 //compression specific level, zero means the default
+}
+
+func (c StreamConfiguration) blockSize() uint32 {
+	if c.Compression.extended() {
+		return extConfigurationBlockSize
+	}
+	return configurationBlockSize
 }
 
 func (c StreamConfiguration) Write(wtr io.Writer) (err error) {
 	var n int
-	buff := make([]byte, configurationBlockSize+4)
-	binary.LittleEndian.PutUint32(buff, configurationBlockSize)
+	bsz := c.blockSize()
+	buff := make([]byte, bsz+4)
+	binary.LittleEndian.PutUint32(buff, bsz)
 	if err = c.encode(buff[4:]); err != nil {
 		return
 	}
@@ -117,7 +134,15 @@ func (c StreamConfiguration) encode(buff []byte) (err error) {
 		err = ErrInvalidBuffer
 		return
 	}
-	buff[0] = byte(c.Compression)
+	buff[0] = byte(c.Compression.legacy())
+	if c.Compression.extended() {
+		if uint32(len(buff)) < extConfigurationBlockSize {
+			err = ErrInvalidBuffer
+			return
+		}
+		buff[1] = byte(c.Compression)
+		buff[2] = c.CompressionLevel
+	}
 	return
 }
 
@@ -127,6 +152,11 @@ func (c *StreamConfiguration) decode(buff []byte) (err error) {
 		return
 	}
 	c.Compression = CompressionType(buff[0])
+	c.CompressionLevel = 0
+	if uint32(len(buff)) >= extConfigurationBlockSize && buff[1] != 0 {
+		c.Compression = CompressionType(buff[1])
+		c.CompressionLevel = buff[2]
+	}
 
 	err = c.validate()
 	return
@@ -144,18 +174,51 @@ func (ct CompressionType) validate() (err error) {
 	switch ct {
 	case CompressNone:
 	case CompressSnappy:
+	case CompressZstd:
+	case CompressLZ4:
 	default:
 		err = fmt.Errorf("Unknown compression id %x", ct)
 	}
 	return
 }
 
+// extended returns true if the compression type is not understood by older indexers
+func (ct CompressionType) extended() bool {
+	return ct == CompressZstd || ct == CompressLZ4
+}
+
+// legacy returns the compression type to advertise to indexers that do not support extended compression
+func (ct CompressionType) legacy() CompressionType {
+	if ct.extended() {
+		return CompressSnappy
+	}
+	return ct
+}
+
+func (ct CompressionType) String() string {
+	switch ct {
+	case CompressNone:
+		return `none`
+	case CompressSnappy:
+		return `snappy`
+	case CompressZstd:
+		return `zstd`
+	case CompressLZ4:
+		return `lz4`
+	}
+	return fmt.Sprintf("unknown(%x)", uint8(ct))
+}
+
 func ParseCompression(v string) (ct CompressionType, err error) {
 	switch strings.ToLower(strings.TrimSpace(v)) {
 	case ``:
 	case `none`:
 	case `snappy`:
 		ct = CompressSnappy
+	case `zstd`, `zstandard`:
+		ct = CompressZstd
+	case `lz4`:
+		ct = CompressLZ4
 	default:
 		err = fmt.Errorf("Unknown compression type %q", v)
 	}
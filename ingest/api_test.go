@@ -100,3 +100,76 @@ func TestIngestState(t *testing.T) {
 		t.Fatalf("ReadWrite failure: %+v != %+v\n", x, y)
 	}
 }
+
+func TestExtendedStreamConfiguration(t *testing.T) {
+	for _, ct := range []CompressionType{CompressZstd, CompressLZ4} {
+		bb := bytes.NewBuffer(make([]byte, 0, 64))
+		x := StreamConfiguration{
+			Compression:      ct,
+			CompressionLevel: 7,
+		}
+		var y StreamConfiguration
+		if err := x.Write(bb); err != nil {
+			t.Fatal(err)
+		}
+		if err := y.Read(bb); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(x, y) {
+			t.Fatalf("ReadWrite failure: %+v != %+v\n", x, y)
+		}
+	}
+}
+
+func TestStreamConfigurationFallback(t *testing.T) {
+	bb := bytes.NewBuffer(make([]byte, 0, 64))
+	x := StreamConfiguration{
+		Compression: CompressZstd,
+	}
+	if err := x.Write(bb); err != nil {
+		t.Fatal(err)
+	}
+
+	// an older indexer reads the block size, looks at the first byte and echoes back a standard block
+	var bsz uint32
+	if err := binary.Read(bb, binary.LittleEndian, &bsz); err != nil {
+		t.Fatal(err)
+	}
+	buff := make([]byte, bsz)
+	if _, err := bb.Read(buff); err != nil {
+		t.Fatal(err)
+	}
+	if CompressionType(buff[0]) != CompressSnappy {
+		t.Fatalf("legacy compression is not snappy: %x", buff[0])
+	}
+	resp := make([]byte, 4+configurationBlockSize)
+	binary.LittleEndian.PutUint32(resp, configurationBlockSize)
+	resp[4] = buff[0]
+
+	var y StreamConfiguration
+	if err := y.Read(bytes.NewBuffer(resp)); err != nil {
+		t.Fatal(err)
+	} else if y.Compression != CompressSnappy {
+		t.Fatalf("did not fall back to snappy: %v", y.Compression)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := map[string]CompressionType{
+		``:       CompressNone,
+		`none`:   CompressNone,
+		`Snappy`: CompressSnappy,
+		`zstd`:   CompressZstd,
+		` LZ4 `:  CompressLZ4,
+	}
+	for v, ct := range tests {
+		if r, err := ParseCompression(v); err != nil {
+			t.Fatal(err)
+		} else if r != ct {
+			t.Fatalf("%q parsed to %v, expected %v", v, r, ct)
+		}
+	}
+	if _, err := ParseCompression(`gzip`); err == nil {
+		t.Fatal("failed to catch bad compression type")
+	}
+}
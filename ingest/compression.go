@@ -0,0 +1,65 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type flushWriter interface {
+	io.Writer
+	flusher
+}
+
+// newCompressionWriter builds a streaming compressor for the stream configuration.
+// Every compressor must be able to flush a complete block so that the remote side
+// can decode everything we have written without waiting for more data.
+func newCompressionWriter(c StreamConfiguration, wtr io.Writer) (fw flushWriter, err error) {
+	switch c.Compression {
+	case CompressSnappy:
+		fw = snappy.NewWriter(wtr)
+	case CompressZstd:
+		opts := []zstd.EOption{
+			zstd.WithEncoderConcurrency(1),
+		}
+		if c.CompressionLevel != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(int(c.CompressionLevel))))
+		}
+		fw, err = zstd.NewWriter(wtr, opts...)
+	case CompressLZ4:
+		lw := lz4.NewWriter(wtr)
+		if err = lw.Apply(lz4.ConcurrencyOption(1)); err == nil {
+			fw = lw
+		}
+	default:
+		err = fmt.Errorf("Unknown compression id %x", c.Compression)
+	}
+	return
+}
+
+// newCompressionReader builds a streaming decompressor for the compression type.
+func newCompressionReader(ct CompressionType, rdr io.Reader) (r io.Reader, err error) {
+	switch ct {
+	case CompressSnappy:
+		r = snappy.NewReader(rdr)
+	case CompressZstd:
+		// a single decoder goroutine decodes synchronously, handing back each block as it lands
+		r, err = zstd.NewReader(rdr, zstd.WithDecoderConcurrency(1))
+	case CompressLZ4:
+		r = lz4.NewReader(rdr)
+	default:
+		err = fmt.Errorf("Unknown compression id %x", ct)
+	}
+	return
+}
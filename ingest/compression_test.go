@@ -0,0 +1,71 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompressionStreaming(t *testing.T) {
+	cfgs := []StreamConfiguration{
+		{Compression: CompressSnappy},
+		{Compression: CompressZstd},
+		{Compression: CompressZstd, CompressionLevel: 19},
+		{Compression: CompressLZ4},
+	}
+	for _, c := range cfgs {
+		cli, srv := net.Pipe()
+		wtr, err := newCompressionWriter(c, cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr, err := newCompressionReader(c.Compression, srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every flush must hand the remote side a complete block, the stream stays open
+		for i := 0; i < 8; i++ {
+			msg := bytes.Repeat([]byte{byte('a' + i)}, 100*(i+1))
+			errC := make(chan error, 1)
+			go func() {
+				if _, err := wtr.Write(msg); err != nil {
+					errC <- err
+				} else {
+					errC <- wtr.Flush()
+				}
+			}()
+			buff := make([]byte, len(msg))
+			srv.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.ReadFull(rdr, buff); err != nil {
+				t.Fatalf("%v failed to read flushed block %d: %v", c.Compression, i, err)
+			} else if !bytes.Equal(buff, msg) {
+				t.Fatalf("%v bad block %d", c.Compression, i)
+			}
+			if err := <-errC; err != nil {
+				t.Fatal(err)
+			}
+		}
+		cli.Close()
+		srv.Close()
+	}
+}
+
+func TestCompressionInvalid(t *testing.T) {
+	if _, err := newCompressionWriter(StreamConfiguration{Compression: 0xff}, io.Discard); err == nil {
+		t.Fatal("failed to catch bad compression writer")
+	}
+	if _, err := newCompressionReader(0xff, bytes.NewBuffer(nil)); err == nil {
+		t.Fatal("failed to catch bad compression reader")
+	}
+}
@@ -46,10 +46,13 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/gobwas/glob"
 	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/chancacher"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/log/rotate"
 	"github.com/gravwell/gravwell/v3/timegrinder"
@@ -83,6 +86,9 @@ const (
 	CACHE_MODE_DEFAULT  = "always"
 	CACHE_DEPTH_DEFAULT = 128
 	CACHE_SIZE_DEFAULT  = 1000
+
+	CACHE_BACKEND_GOB = "gob"
+	CACHE_BACKEND_WAL = "wal"
 )
 
 var (
@@ -116,14 +122,23 @@ type IngestConfig struct {
 	Cache_Mode                 string   `json:",omitempty"`
 	Ingest_Cache_Path          string   `json:",omitempty"`
 	Max_Ingest_Cache           int      `json:",omitempty"`
+	Cache_Backend              string   `json:",omitempty"` // gob or wal, defaults to gob
+	Cache_Sync                 string   `json:",omitempty"` // wal fsync policy: interval, always, or never
+	Max_Cache_Age              string   `json:",omitempty"` // wal evicts cached data older than this duration
 	Log_Source_Override        string   `json:",omitempty"` // override log messages only
 	Label                      string   `json:",omitempty"` //arbitrary label that can be attached to an ingester
 	Disable_Multithreading     bool     //basically set GOMAXPROCS(1)
 	Stats_Sample_Interval      string   `json:",omitempty"` // if set to > 0 duration then we periodically throw stats
+	Replicate_Targets          bool     `json:",omitempty"` // send every entry to Replication_Factor targets instead of just one
+	Replication_Factor         int      `json:",omitempty"` // number of targets that receive each entry, zero means all of them
+	Tag_Route                  []string `json:",omitempty"` // <target>=<tag>[,<tag>...] routes tags or tag globs to specific targets
+	Metrics_Listen_Address     string   `json:",omitempty"` // serve Prometheus metrics at /metrics on this host:port
 }
 
 type IngestStreamConfig struct {
-	Enable_Compression bool `json:",omitempty"`
+	Enable_Compression bool   `json:",omitempty"`
+	Compression_Type   string `json:",omitempty"` // snappy, zstd, or lz4; defaults to snappy when Enable-Compression is set
+	Compression_Level  int    `json:",omitempty"` // zstd compression level, zero uses the default
 }
 
 type TimeFormat struct {
@@ -264,6 +279,32 @@ func (ic *IngestConfig) Verify() error {
 		ic.Cache_Depth = CACHE_DEPTH_DEFAULT
 	}
 	// there are no defaults for the cache_size.
+	switch strings.ToLower(strings.TrimSpace(ic.Cache_Backend)) {
+	case "", CACHE_BACKEND_GOB:
+		if ic.Cache_Sync != `` || ic.Max_Cache_Age != `` {
+			return errors.New("Cache-Sync and Max-Cache-Age require Cache-Backend=wal")
+		}
+	case CACHE_BACKEND_WAL:
+		if _, err := chancacher.ParseSyncPolicy(ic.Cache_Sync); err != nil {
+			return fmt.Errorf("invalid Cache-Sync %w", err)
+		}
+		if ic.Max_Cache_Age != `` {
+			if dur, err := time.ParseDuration(ic.Max_Cache_Age); err != nil || dur < 0 {
+				return fmt.Errorf("invalid Max-Cache-Age %q", ic.Max_Cache_Age)
+			}
+		}
+	default:
+		return errors.New("Cache-Backend must be [gob,wal]")
+	}
+
+	if ic.Replication_Factor < 0 {
+		return errors.New("Replication-Factor cannot be negative")
+	} else if ic.Replication_Factor > 0 && !ic.Replicate_Targets {
+		return errors.New("Replication-Factor requires Replicate-Targets")
+	}
+	if err := ic.checkQueueCache(); err != nil {
+		return err
+	}
 
 	//if Stats_Sample_Interval is populated, check that we can parse as a duration
 	if ic.Stats_Sample_Interval != `` {
@@ -272,6 +313,12 @@ func (ic *IngestConfig) Verify() error {
 		}
 	}
 
+	if ic.Metrics_Listen_Address != `` {
+		if _, _, err := net.SplitHostPort(ic.Metrics_Listen_Address); err != nil {
+			return fmt.Errorf("invalid Metrics-Listen-Address %s %w", ic.Metrics_Listen_Address, err)
+		}
+	}
+
 	return nil
 }
 
@@ -296,6 +343,94 @@ func (ic *IngestConfig) Targets() ([]string, error) {
 	return conns, nil
 }
 
+// TagRoutes returns the tags routed to each target, keyed on the connection strings returned by Targets.
+// Tag-Route values take the form <target>=<tag>[,<tag>...] where the target is written as it
+// appears in the backend target lists and tags may be globs, e.g.:
+//
+//	Tag-Route="10.0.0.1:4023=windows*,sysmon"
+func (ic *IngestConfig) TagRoutes() (map[string][]string, error) {
+	if len(ic.Tag_Route) == 0 {
+		return nil, nil
+	}
+	routes := make(map[string][]string, len(ic.Tag_Route))
+	for _, v := range ic.Tag_Route {
+		idx := strings.LastIndex(v, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid Tag-Route %q, expected <target>=<tag>[,<tag>...]", v)
+		}
+		conn, err := ic.routeTarget(strings.TrimSpace(v[:idx]))
+		if err != nil {
+			return nil, err
+		}
+		var tags []string
+		for _, t := range strings.Split(v[idx+1:], ",") {
+			if t = strings.TrimSpace(t); t == `` {
+				continue
+			} else if _, err = glob.Compile(t); err != nil {
+				return nil, fmt.Errorf("invalid Tag-Route tag %q %w", t, err)
+			}
+			tags = append(tags, t)
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("Tag-Route %q does not specify any tags", v)
+		}
+		routes[conn] = append(routes[conn], tags...)
+	}
+	return routes, nil
+}
+
+// checkQueueCache validates the tag routes and ensures that a cache path is available when entries
+// are queued separately for each destination or route group.  Those queues spill to disk so that a
+// slow indexer does not hold up the others; without a cache there is nowhere for the overflow to go.
+func (ic *IngestConfig) checkQueueCache() error {
+	routes, err := ic.TagRoutes()
+	if err != nil {
+		return err
+	} else if ic.Ingest_Cache_Path != `` {
+		return nil
+	} else if ic.Replicate_Targets {
+		return errors.New("Replicate-Targets requires an Ingest-Cache-Path")
+	} else if len(routes) == 0 {
+		return nil
+	}
+	conns, err := ic.Targets()
+	if err != nil {
+		return err
+	}
+	groups := map[string]bool{}
+	for _, conn := range conns {
+		tags := append([]string{}, routes[conn]...)
+		sort.Strings(tags)
+		groups[strings.Join(tags, ",")] = true
+	}
+	if len(groups) > 1 {
+		return errors.New("Tag-Route with more than one group of targets requires an Ingest-Cache-Path")
+	}
+	return nil
+}
+
+// routeTarget finds the connection string of the backend target a tag route refers to
+func (ic *IngestConfig) routeTarget(tgt string) (string, error) {
+	for _, v := range ic.Cleartext_Backend_Target {
+		conn := "tcp://" + AppendDefaultPort(v, DefaultCleartextPort)
+		if tgt == conn || AppendDefaultPort(tgt, DefaultCleartextPort) == AppendDefaultPort(v, DefaultCleartextPort) {
+			return conn, nil
+		}
+	}
+	for _, v := range ic.Encrypted_Backend_Target {
+		conn := "tls://" + AppendDefaultPort(v, DefaultTLSPort)
+		if tgt == conn || AppendDefaultPort(tgt, DefaultTLSPort) == AppendDefaultPort(v, DefaultTLSPort) {
+			return conn, nil
+		}
+	}
+	for _, v := range ic.Pipe_Backend_Target {
+		if conn := "pipe://" + v; tgt == conn || tgt == v {
+			return conn, nil
+		}
+	}
+	return ``, fmt.Errorf("Tag-Route target %q is not a configured backend target", tgt)
+}
+
 // InsecureSkipTLSVerification returns true if the Insecure-Skip-TLS-Verify
 // config parameter was set.
 func (ic *IngestConfig) InsecureSkipTLSVerification() bool {
@@ -457,6 +592,18 @@ func (ic *IngestConfig) StatsSampleInterval() (dur time.Duration) {
 	return
 }
 
+// MaxCacheAge returns the age limit for a wal cache backend, zero means no limit
+func (ic *IngestConfig) MaxCacheAge() (dur time.Duration) {
+	if ic == nil || ic.Max_Cache_Age == `` {
+		return
+	}
+	var err error
+	if dur, err = time.ParseDuration(ic.Max_Cache_Age); err != nil {
+		dur = 0
+	}
+	return
+}
+
 func writeFull(w io.Writer, b []byte) error {
 	var written int
 	for written < len(b) {
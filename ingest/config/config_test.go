@@ -10,6 +10,7 @@ package config
 
 import (
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -53,3 +54,101 @@ func TestParseSourceIntegter(t *testing.T) {
 		}
 	}
 }
+
+func TestMetricsListenAddress(t *testing.T) {
+	for _, v := range []string{``, `:9100`, `127.0.0.1:9100`, `[::1]:9100`} {
+		ic := IngestConfig{
+			Ingest_Secret:            `secret`,
+			Cleartext_Backend_Target: []string{`127.0.0.1`},
+			Metrics_Listen_Address:   v,
+		}
+		if err := ic.Verify(); err != nil {
+			t.Fatalf("%q rejected: %v", v, err)
+		}
+	}
+	for _, v := range []string{`9100`, `localhost`, `::1:9100`} {
+		ic := IngestConfig{
+			Ingest_Secret:            `secret`,
+			Cleartext_Backend_Target: []string{`127.0.0.1`},
+			Metrics_Listen_Address:   v,
+		}
+		if err := ic.Verify(); err == nil {
+			t.Fatalf("%q accepted", v)
+		}
+	}
+}
+
+func TestTagRoutes(t *testing.T) {
+	ic := IngestConfig{
+		Ingest_Secret:            `secret`,
+		Cleartext_Backend_Target: []string{`10.0.0.1`, `10.0.0.2:5000`},
+		Encrypted_Backend_Target: []string{`10.0.0.3`},
+		Pipe_Backend_Target:      []string{`/opt/gravwell/comms/pipe`},
+		Tag_Route: []string{
+			`10.0.0.1=windows*, sysmon`,
+			`10.0.0.1:4023=winlog`,
+			`tcp://10.0.0.2:5000=netflow`,
+			`10.0.0.3=syslog`,
+			`/opt/gravwell/comms/pipe=pipe*`,
+		},
+	}
+	if err := ic.Verify(); err == nil {
+		t.Fatal("routes to several groups accepted without a cache path")
+	}
+	ic.Ingest_Cache_Path = t.TempDir()
+	if err := ic.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := ic.TagRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string][]string{
+		`tcp://10.0.0.1:4023`:             {`windows*`, `sysmon`, `winlog`},
+		`tcp://10.0.0.2:5000`:             {`netflow`},
+		`tls://10.0.0.3:4024`:             {`syslog`},
+		`pipe:///opt/gravwell/comms/pipe`: {`pipe*`},
+	}
+	if len(routes) != len(exp) {
+		t.Fatalf("bad routes %v", routes)
+	}
+	for k, v := range exp {
+		if strings.Join(routes[k], ",") != strings.Join(v, ",") {
+			t.Fatalf("bad routes for %s: %v != %v", k, routes[k], v)
+		}
+	}
+
+	for _, v := range []string{`10.0.0.9=foo`, `10.0.0.1=`, `=foo`, `10.0.0.1`, `10.0.0.2=foo`, `10.0.0.1=[foo`} {
+		ic.Tag_Route = []string{v}
+		if err := ic.Verify(); err == nil {
+			t.Fatalf("%q accepted", v)
+		}
+	}
+}
+
+func TestQueueCacheRequired(t *testing.T) {
+	ic := IngestConfig{
+		Ingest_Secret:            `secret`,
+		Cleartext_Backend_Target: []string{`10.0.0.1`, `10.0.0.2`},
+		Replicate_Targets:        true,
+	}
+	if err := ic.Verify(); err == nil {
+		t.Fatal("replication accepted without a cache path")
+	}
+	ic.Replicate_Targets = false
+
+	// every target shares the same routes, so there is a single queue
+	ic.Tag_Route = []string{`10.0.0.1=syslog,windows`, `10.0.0.2=windows`, `10.0.0.2=syslog`}
+	if err := ic.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	ic.Tag_Route = []string{`10.0.0.1=syslog`}
+	if err := ic.Verify(); err == nil {
+		t.Fatal("routes to several groups accepted without a cache path")
+	}
+	ic.Ingest_Cache_Path = t.TempDir()
+	ic.Replicate_Targets = true
+	if err := ic.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
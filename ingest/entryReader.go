@@ -13,7 +13,6 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -21,7 +20,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest/entry"
-	"github.com/klauspost/compress/snappy"
+	"github.com/gravwell/gravwell/v3/ingest/internal/faults"
 )
 
 const (
@@ -91,6 +90,7 @@ type EntryReader struct {
 	igStateMtx     *sync.Mutex
 	igState        IngesterState           // the most recent state message received
 	stateCallbacks []IngesterStateCallback // functions to be called when an IngesterState message is received
+	dropFilter     func(*entry.Entry) bool // fault injection for the test harnesses, see ingest/internal/faults
 }
 
 func NewEntryReader(conn net.Conn) (*EntryReader, error) {
@@ -111,7 +111,7 @@ func NewEntryReaderEx(cfg EntryReaderWriterConfig) (*EntryReader, error) {
 		return nil, err
 	}
 	//buffer big enough store entire entry header + EntryID
-	return &EntryReader{
+	er := &EntryReader{
 		conn:       cfg.Conn,
 		bIO:        bufio.NewReaderSize(cfg.Conn, cfg.BufferSize),
 		bAckWriter: bufio.NewWriterSize(cfg.Conn, ackEncodeSize*cfg.OutstandingEntryCount),
@@ -123,7 +123,11 @@ func NewEntryReaderEx(cfg EntryReaderWriterConfig) (*EntryReader, error) {
 		timeout:    cfg.Timeout,
 		tagMan:     cfg.TagMan,
 		igStateMtx: &sync.Mutex{},
-	}, nil
+	}
+	if fd, ok := cfg.Conn.(faults.EntryDropper); ok {
+		er.dropFilter = fd.DropEntry
+	}
+	return er, nil
 }
 
 // SetTagManager gives a handle on the instantiator's tag management system.
@@ -186,26 +190,30 @@ func (er *EntryReader) ConfigureStream() (err error) {
 
 	//we are in good shape, configure the stream
 	if req.Compression != CompressNone {
-		err = er.startCompression(req.Compression)
+		err = er.startCompression(req)
 	}
 	return
 }
 
 // startCompression gets the entryReader/Writer ready to work with a compressed connection
 // caller MUST HOLD THE LOCK
-func (ew *EntryReader) startCompression(ct CompressionType) (err error) {
-	switch ct {
-	case CompressNone: //do nothing
-	case CompressSnappy:
-		//get a writer rolling
-		wtr := snappy.NewWriter(ew.conn)
-		ew.flshr = wtr
-		ew.bAckWriter.Reset(wtr)
-		//get a reader rolling
-		ew.bIO.Reset(snappy.NewReader(ew.conn))
-	default:
-		err = fmt.Errorf("Unknown compression id %x", ct)
+func (ew *EntryReader) startCompression(c StreamConfiguration) (err error) {
+	if c.Compression == CompressNone {
+		return //do nothing
+	}
+	var wtr flushWriter
+	var rdr io.Reader
+	//get a writer rolling
+	if wtr, err = newCompressionWriter(c, ew.conn); err != nil {
+		return
+	}
+	//get a reader rolling
+	if rdr, err = newCompressionReader(c.Compression, ew.conn); err != nil {
+		return
 	}
+	ew.flshr = wtr
+	ew.bAckWriter.Reset(wtr)
+	ew.bIO.Reset(rdr)
 	return
 }
 
@@ -288,7 +296,22 @@ func isTimeout(err error) bool {
 	return false
 }
 
-func (er *EntryReader) read() (*entry.Entry, error) {
+func (er *EntryReader) read() (ent *entry.Entry, err error) {
+	for {
+		var id entrySendID
+		if ent, id, err = er.readEntry(); err != nil {
+			return nil, err
+		} else if er.dropFilter != nil && er.dropFilter(ent) {
+			continue
+		}
+		if err = er.throwAck(id); err != nil {
+			return nil, err
+		}
+		return
+	}
+}
+
+func (er *EntryReader) readEntry() (*entry.Entry, entrySendID, error) {
 	var (
 		err    error
 		sz     uint32
@@ -298,20 +321,17 @@ func (er *EntryReader) read() (*entry.Entry, error) {
 	ent := &entry.Entry{}
 
 	if err = er.fillHeader(ent, &id, &sz, &hasEvs); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	ent.Data = make([]byte, sz)
 	if _, err = io.ReadFull(er.bIO, ent.Data); err != nil {
-		return nil, err
+		return nil, 0, err
 	} else if hasEvs {
 		if err = ent.ReadEVs(er.bIO); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
-	if err = er.throwAck(id); err != nil {
-		return nil, err
-	}
-	return ent, nil
+	return ent, id, nil
 }
 
 // we just eat bytes until we hit the magic number,  this is a rudimentary
@@ -478,7 +498,7 @@ func (er *EntryReader) IngestOK(ok bool) (err error) {
 		if ok {
 			ac.val = 1
 		}
-		er.ackChan <- ac
+		err = er.setupAck(ac)
 	default:
 		err = errors.New("Unknown message when looking for IngestOK, exiting")
 	}
@@ -510,7 +530,7 @@ func (er *EntryReader) SetupConnection() (err error) {
 				// very, very weird
 				return err
 			}
-			if err := er.forceAck(); err != nil {
+			if err := er.setupAck(ackCommand{cmd: FORCE_ACK_MAGIC}); err != nil {
 				return err
 			}
 		case ID_MAGIC:
@@ -576,7 +596,9 @@ func (er *EntryReader) SetupConnection() (err error) {
 			}
 
 			// respond
-			er.ackChan <- ackCommand{cmd: CONFIRM_ID_MAGIC, val: uint64(0)}
+			if err = er.setupAck(ackCommand{cmd: CONFIRM_ID_MAGIC, val: uint64(0)}); err != nil {
+				return
+			}
 
 			// set id values
 			er.igName = string(name)
@@ -599,7 +621,9 @@ func (er *EntryReader) SetupConnection() (err error) {
 				return errFailedFullRead
 			}
 			er.igAPIVersion = binary.LittleEndian.Uint16(er.buff[0:2])
-			er.ackChan <- ackCommand{cmd: CONFIRM_API_VER_MAGIC, val: uint64(0)}
+			if err = er.setupAck(ackCommand{cmd: CONFIRM_API_VER_MAGIC, val: uint64(0)}); err != nil {
+				return
+			}
 		default:
 			// any other command means the ingester is no longer interested in identifying itself, so we're done
 			return
@@ -767,6 +791,26 @@ func (er *EntryReader) forceAck() error {
 	return nil
 }
 
+// setupAck answers a connection setup command, the answer goes through the ack routine
+// if it is running and is written directly otherwise.  Readers may be started after the
+// stream is configured so the ack routine never shares the writer with ConfigureStream.
+func (er *EntryReader) setupAck(ac ackCommand) error {
+	er.mtx.Lock()
+	defer er.mtx.Unlock()
+	if er.started {
+		er.ackChan <- ac
+		return nil
+	}
+	buff := make([]byte, 32)
+	off, _, err := ac.encode(buff)
+	if err != nil {
+		return err
+	} else if err = er.writeAll(buff[:off]); err != nil {
+		return err
+	}
+	return er.bAckWriter.Flush()
+}
+
 func (er *EntryReader) writeAll(b []byte) error {
 	var written int
 	for written < len(b) {
@@ -13,12 +13,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest/entry"
-	"github.com/klauspost/compress/snappy"
 )
 
 const (
@@ -484,7 +484,7 @@ func (ew *EntryWriter) ConfigureStream(c StreamConfiguration) (err error) {
 
 	//we are in good shape, configure the stream
 	if resp.Compression != CompressNone {
-		if err = ew.startCompression(resp.Compression); err != nil {
+		if err = ew.startCompression(resp); err != nil {
 			err = fmt.Errorf("failed to startCompression %w", err)
 			return
 		}
@@ -539,19 +539,23 @@ func (ew *EntryWriter) SendIngesterState(state IngesterState) (err error) {
 
 // startCompression gets the entryReader/Writer ready to work with a compressed connection
 // caller MUST HOLD THE LOCK
-func (ew *EntryWriter) startCompression(ct CompressionType) (err error) {
-	switch ct {
-	case CompressNone: //do nothing
-	case CompressSnappy:
-		//get a reader rolling
-		ew.bAckReader.Reset(snappy.NewReader(ew.conn))
-		//get a writer rolling
-		wtr := snappy.NewWriter(ew.conn)
-		ew.flshr = wtr
-		ew.bIO.Reset(wtr)
-	default:
-		err = fmt.Errorf("Unknown compression id %x", ct)
+func (ew *EntryWriter) startCompression(c StreamConfiguration) (err error) {
+	if c.Compression == CompressNone {
+		return //do nothing
+	}
+	var wtr flushWriter
+	var rdr io.Reader
+	//get a reader rolling
+	if rdr, err = newCompressionReader(c.Compression, ew.conn); err != nil {
+		return
+	}
+	//get a writer rolling
+	if wtr, err = newCompressionWriter(c, ew.conn); err != nil {
+		return
 	}
+	ew.bAckReader.Reset(rdr)
+	ew.flshr = wtr
+	ew.bIO.Reset(wtr)
 	return
 }
 
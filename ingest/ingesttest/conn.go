@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingesttest
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// conn wraps an ingester connection so that acks can be slowed down once the stream is hot
+type conn struct {
+	net.Conn
+	srv    *Server
+	er     *ingest.EntryReader
+	hot    int32
+	tenant string
+	tags   []string
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.hot) != 0 {
+		if d := c.srv.getAckDelay(); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// DropEntry lets the server discard entries without acknowledging them, see DropEntries
+func (c *conn) DropEntry(e *entry.Entry) bool {
+	return c.srv.dropFilter(e)
+}
+
+// ingester caller must hold the server lock
+func (c *conn) ingester() Ingester {
+	name, version, id := c.er.GetIngesterInfo()
+	return Ingester{
+		Name:       name,
+		Version:    version,
+		UUID:       id,
+		Tenant:     c.tenant,
+		APIVersion: c.er.GetIngesterAPIVersion(),
+		Remote:     c.RemoteAddr().String(),
+		Tags:       c.tags,
+		State:      c.er.GetIngesterState(),
+	}
+}
+
+func (s *Server) handle(c *conn) {
+	defer s.wg.Done()
+	defer c.Close()
+	defer s.unregister(c)
+	if err := s.handshake(c); err != nil {
+		return
+	}
+	er, err := ingest.NewEntryReaderEx(ingest.EntryReaderWriterConfig{
+		Conn:                  c,
+		OutstandingEntryCount: ingest.MAX_UNCONFIRMED_COUNT,
+		BufferSize:            ingest.READ_BUFFER_SIZE,
+		Timeout:               s.cfg.Timeout,
+		TagMan:                s,
+	})
+	if err != nil {
+		return
+	}
+	defer er.Close()
+	if err = er.SetupConnection(); err != nil {
+		return
+	} else if er.GetIngesterAPIVersion() >= ingest.MINIMUM_INGEST_OK_VERSION {
+		if err = er.IngestOK(true); err != nil {
+			return
+		}
+	}
+	//the ack routine shares the writer with the stream configuration, so it only starts once the stream is configured
+	if err = er.ConfigureStream(); err != nil {
+		return
+	} else if err = er.Start(); err != nil {
+		return
+	}
+	c.er = er
+	if !s.setHot(c) {
+		return
+	}
+	//get out of the hot set before the reader shuts down so Throttle never sees a closed reader
+	defer s.unregister(c)
+	atomic.StoreInt32(&c.hot, 1)
+
+	for {
+		ent, err := er.Read()
+		if err != nil {
+			return
+		}
+		if s.add(ent) {
+			return
+		}
+	}
+}
+
+// handshake performs the indexer side of authentication and tag negotiation
+func (s *Server) handshake(c *conn) (err error) {
+	if err = c.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return
+	}
+	chal, err := ingest.NewChallenge(s.hash)
+	if err != nil {
+		return
+	} else if err = chal.Write(c); err != nil {
+		return
+	}
+	var resp ingest.ChallengeResponse
+	if err = resp.Read(c); err != nil {
+		return
+	}
+	state := ingest.StateResponse{ID: ingest.STATE_AUTHENTICATED}
+	if ingest.VerifyResponse(s.hash, chal, resp) != nil {
+		state.ID = ingest.STATE_NOT_AUTHENTICATED
+		state.Write(c)
+		return ErrAuthFailed
+	} else if err = state.Write(c); err != nil {
+		return
+	}
+
+	var req ingest.TagRequest
+	if err = req.Read(c); err != nil {
+		return
+	}
+	tresp := ingest.TagResponse{
+		Tags: make(map[string]entry.EntryTag, len(req.Tags)),
+	}
+	for _, tag := range req.Tags {
+		if ingest.CheckTag(tag) != nil {
+			// a count of zero tells the ingester that negotiation failed
+			tresp = ingest.TagResponse{}
+			tresp.Write(c)
+			return ErrTagNegotation
+		}
+	}
+	s.mtx.Lock()
+	for _, tag := range req.Tags {
+		tresp.Tags[tag] = s.getAndPopulate(tag)
+	}
+	s.mtx.Unlock()
+	tresp.Count = uint32(len(tresp.Tags))
+	if err = tresp.Write(c); err != nil {
+		return
+	}
+	if err = state.Read(c); err != nil {
+		return
+	} else if state.ID != ingest.STATE_HOT {
+		return ErrNotHot
+	}
+	c.tenant = resp.Tenant
+	c.tags = req.Tags
+	return c.SetDeadline(time.Time{})
+}
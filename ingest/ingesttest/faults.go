@@ -0,0 +1,124 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingesttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// DropEntries discards the next n entries without acknowledging them.  The ingester
+// holds on to unacknowledged entries and sends them again after it reconnects, so pair
+// this with Disconnect to see them redelivered.
+func (s *Server) DropEntries(n int) {
+	s.mtx.Lock()
+	s.drop = n
+	s.mtx.Unlock()
+}
+
+// Dropped returns the number of entries discarded by DropEntries
+func (s *Server) Dropped() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.dropped
+}
+
+func (s *Server) dropFilter(*entry.Entry) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.drop <= 0 {
+		return false
+	}
+	s.drop--
+	s.dropped++
+	return true
+}
+
+// SetAckDelay holds every write back to the ingesters for d, zero turns the delay off
+func (s *Server) SetAckDelay(d time.Duration) {
+	atomic.StoreInt64(&s.ackDelay, int64(d))
+}
+
+// getAckDelay is called from the ack writers, it stays off the lock so that a slow
+// writer can never hold up Throttle
+func (s *Server) getAckDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.ackDelay))
+}
+
+// Throttle sends a throttle command to every connected ingester asking it to back off for d
+func (s *Server) Throttle(d time.Duration) (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for c, hot := range s.conns {
+		if !hot {
+			continue
+		}
+		if lerr := c.er.SendThrottle(d); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return
+}
+
+// DisconnectAfter cuts the connection that delivers the nth entry from now, zero cancels
+func (s *Server) DisconnectAfter(n int) {
+	s.mtx.Lock()
+	s.disconnect = n
+	s.mtx.Unlock()
+}
+
+// Disconnect immediately drops every connected ingester, listeners stay up so ingesters
+// are free to reconnect
+func (s *Server) Disconnect() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// selfSignedConfig generates a throwaway certificate for the TLS listeners
+func selfSignedConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"ingesttest"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,378 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package ingesttest provides an in-process stand-in for a Gravwell indexer so that
+// ingesters can be tested end-to-end without a licensed indexer.
+//
+// A Server speaks the ingest protocol over TCP, TLS, and unix pipe listeners, performs
+// the auth and tag negotiation, and keeps every entry it receives in memory.  Tests can
+// point an IngestMuxer at the targets returned by the Listen functions and then inspect
+// what arrived.  Faults such as dropped entries, slow acks, throttle commands, and
+// disconnects can be injected while the muxer is running.
+//
+// Like a real indexer the server provides at-least-once delivery, an entry that was
+// read but whose ack never made it back to the ingester may show up more than once.
+package ingesttest
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultTimeout   = time.Minute
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrNoSecret      = errors.New("ingest secret is required")
+	ErrClosed        = errors.New("server is closed")
+	ErrAuthFailed    = errors.New("ingester failed authentication")
+	ErrTagNegotation = errors.New("ingester requested an invalid tag")
+	ErrNotHot        = errors.New("ingester did not go hot")
+	ErrWaitTimeout   = errors.New("timed out waiting for entries")
+)
+
+// Config controls how a Server authenticates ingesters.
+type Config struct {
+	Secret    string        // shared ingest secret, required
+	TLSConfig *tls.Config   // used by ListenTLS, a self-signed certificate is generated if nil
+	Timeout   time.Duration // idle timeout on ingester connections, defaults to one minute
+}
+
+// Ingester describes an ingester connected to the server.
+type Ingester struct {
+	Name       string
+	Version    string
+	UUID       string
+	Tenant     string
+	APIVersion uint16
+	Remote     string
+	Tags       []string
+	State      ingest.IngesterState // most recent state message, if the ingester sent one
+}
+
+// Server is a fake indexer, all methods are safe to call from multiple goroutines.
+type Server struct {
+	ackDelay int64 // time.Duration, accessed atomically so keep it 64bit aligned
+	cfg      Config
+	hash     ingest.AuthHash
+	mtx      sync.Mutex
+	wg       sync.WaitGroup
+	closed   bool
+	lsts     []net.Listener
+	pipes    []string
+	conns    map[*conn]bool // every open connection, true once it is hot
+	tags     map[string]entry.EntryTag
+	tagNames []string
+	ents     []*entry.Entry
+	notify   chan struct{} // closed and replaced every time entries arrive
+
+	// fault injection
+	drop       int
+	dropped    int
+	disconnect int
+}
+
+// NewServer creates a server with no listeners.
+func NewServer(cfg Config) (*Server, error) {
+	if cfg.Secret == `` {
+		return nil, ErrNoSecret
+	} else if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	hash, err := ingest.GenAuthHash(cfg.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLSConfig == nil {
+		if cfg.TLSConfig, err = selfSignedConfig(); err != nil {
+			return nil, err
+		}
+	}
+	return &Server{
+		cfg:      cfg,
+		hash:     hash,
+		conns:    map[*conn]bool{},
+		tags:     map[string]entry.EntryTag{entry.DefaultTagName: entry.DefaultTagId},
+		tagNames: []string{entry.DefaultTagName},
+		notify:   make(chan struct{}),
+	}, nil
+}
+
+// ListenTCP starts accepting cleartext connections on addr and returns a muxer target for it.
+// Use a port of zero to have one picked for you.
+func (s *Server) ListenTCP(addr string) (string, error) {
+	lst, err := net.Listen("tcp", addr)
+	if err != nil {
+		return ``, err
+	}
+	if err = s.serve(lst); err != nil {
+		return ``, err
+	}
+	return `tcp://` + lst.Addr().String(), nil
+}
+
+// ListenTLS starts accepting TLS connections on addr and returns a muxer target for it.
+func (s *Server) ListenTLS(addr string) (string, error) {
+	lst, err := tls.Listen("tcp", addr, s.cfg.TLSConfig)
+	if err != nil {
+		return ``, err
+	}
+	if err = s.serve(lst); err != nil {
+		return ``, err
+	}
+	return `tls://` + lst.Addr().String(), nil
+}
+
+// ListenPipe starts accepting connections on a unix socket at pth and returns a muxer target for it.
+// The socket is removed when the server is closed.
+func (s *Server) ListenPipe(pth string) (string, error) {
+	lst, err := net.Listen("unix", pth)
+	if err != nil {
+		return ``, err
+	}
+	if err = s.serve(lst); err != nil {
+		return ``, err
+	}
+	s.mtx.Lock()
+	s.pipes = append(s.pipes, pth)
+	s.mtx.Unlock()
+	return `pipe://` + pth, nil
+}
+
+func (s *Server) serve(lst net.Listener) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		lst.Close()
+		return ErrClosed
+	}
+	s.lsts = append(s.lsts, lst)
+	s.wg.Add(1)
+	go s.acceptRoutine(lst)
+	return nil
+}
+
+func (s *Server) acceptRoutine(lst net.Listener) {
+	defer s.wg.Done()
+	for {
+		c, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			c.Close()
+			return
+		}
+		ic := &conn{Conn: c, srv: s}
+		s.conns[ic] = false
+		s.wg.Add(1)
+		s.mtx.Unlock()
+		go s.handle(ic)
+	}
+}
+
+// Close stops all listeners and drops every connected ingester.  Received entries
+// remain available.
+func (s *Server) Close() (err error) {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	for _, lst := range s.lsts {
+		if lerr := lst.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	pipes := s.pipes
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	for _, p := range pipes {
+		os.Remove(p)
+	}
+	return
+}
+
+// Entries returns a copy of everything received so far in the order it arrived
+func (s *Server) Entries() []*entry.Entry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*entry.Entry(nil), s.ents...)
+}
+
+// Count returns the number of entries received so far
+func (s *Server) Count() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.ents)
+}
+
+// TagEntries returns every entry received with the named tag
+func (s *Server) TagEntries(tag string) []*entry.Entry {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tg, ok := s.tags[tag]
+	if !ok {
+		return nil
+	}
+	var r []*entry.Entry
+	for _, ent := range s.ents {
+		if ent.Tag == tg {
+			r = append(r, ent)
+		}
+	}
+	return r
+}
+
+// Find returns every entry for which fn returns true
+func (s *Server) Find(fn func(*entry.Entry) bool) []*entry.Entry {
+	var r []*entry.Entry
+	for _, ent := range s.Entries() {
+		if fn(ent) {
+			r = append(r, ent)
+		}
+	}
+	return r
+}
+
+// WaitForEntries blocks until at least n entries have been received or the timeout expires
+func (s *Server) WaitForEntries(n int, to time.Duration) error {
+	tmr := time.NewTimer(to)
+	defer tmr.Stop()
+	for {
+		s.mtx.Lock()
+		cnt, ch := len(s.ents), s.notify
+		s.mtx.Unlock()
+		if cnt >= n {
+			return nil
+		}
+		select {
+		case <-ch:
+		case <-tmr.C:
+			return ErrWaitTimeout
+		}
+	}
+}
+
+// Reset throws away all received entries, negotiated tags are kept
+func (s *Server) Reset() {
+	s.mtx.Lock()
+	s.ents = nil
+	s.mtx.Unlock()
+}
+
+// TagName resolves a tag on a received entry back to its name
+func (s *Server) TagName(tg entry.EntryTag) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if int(tg) >= len(s.tagNames) {
+		return ``, false
+	}
+	return s.tagNames[tg], true
+}
+
+// Tag returns the value the server assigned to a tag name
+func (s *Server) Tag(name string) (tg entry.EntryTag, ok bool) {
+	s.mtx.Lock()
+	tg, ok = s.tags[name]
+	s.mtx.Unlock()
+	return
+}
+
+// Connections returns the number of ingesters currently connected
+func (s *Server) Connections() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var cnt int
+	for _, hot := range s.conns {
+		if hot {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Ingesters describes every ingester currently connected
+func (s *Server) Ingesters() (r []Ingester) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for c, hot := range s.conns {
+		if hot {
+			r = append(r, c.ingester())
+		}
+	}
+	return
+}
+
+// GetAndPopulate hands out tag values to ingesters, it satisfies ingest.TagManager
+func (s *Server) GetAndPopulate(name string) (tg entry.EntryTag, err error) {
+	if err = ingest.CheckTag(name); err != nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.getAndPopulate(name), nil
+}
+
+// getAndPopulate caller must hold the lock
+func (s *Server) getAndPopulate(name string) entry.EntryTag {
+	tg, ok := s.tags[name]
+	if !ok {
+		tg = entry.EntryTag(len(s.tagNames))
+		s.tags[name] = tg
+		s.tagNames = append(s.tagNames, name)
+	}
+	return tg
+}
+
+// add stores an entry and reports if the connection that delivered it should be cut
+func (s *Server) add(ent *entry.Entry) (disconnect bool) {
+	s.mtx.Lock()
+	s.ents = append(s.ents, ent)
+	close(s.notify)
+	s.notify = make(chan struct{})
+	if s.disconnect > 0 {
+		s.disconnect--
+		disconnect = s.disconnect == 0
+	}
+	s.mtx.Unlock()
+	return
+}
+
+// setHot marks a connection as ready for entries, false means the server is going away
+func (s *Server) setHot(c *conn) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = true
+	return true
+}
+
+func (s *Server) unregister(c *conn) {
+	s.mtx.Lock()
+	delete(s.conns, c)
+	s.mtx.Unlock()
+}
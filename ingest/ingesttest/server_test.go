@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingesttest
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	testSecret  = `testsecret`
+	testTag     = `testing`
+	testTimeout = 5 * time.Second
+	// the muxer backs off before reconnecting, give it plenty of room
+	reconnectTimeout = 30 * time.Second
+)
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(Config{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newTestMuxer(t *testing.T, secret string, targets ...string) *ingest.IngestMuxer {
+	im, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations:    targets,
+		Tags:            []string{testTag},
+		Auth:            secret,
+		IngesterName:    `ingesttest`,
+		IngesterVersion: `1.2.3`,
+		IngesterUUID:    `e4a1c6c2-5b5e-4e8e-9d0e-6f0b7a1f3c11`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = im.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { im.Close() })
+	return im
+}
+
+func writeEntries(t *testing.T, im *ingest.IngestMuxer, start, cnt int) {
+	tg, err := im.GetTag(testTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := start; i < start+cnt; i++ {
+		if err = im.Write(entry.Now(), tg, []byte(fmt.Sprintf("entry %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkEntries makes sure every entry in [0, cnt) showed up at least once under the test tag
+func checkEntries(t *testing.T, s *Server, cnt int) {
+	seen := map[string]bool{}
+	for _, ent := range s.TagEntries(testTag) {
+		seen[string(ent.Data)] = true
+	}
+	for i := 0; i < cnt; i++ {
+		if !seen[fmt.Sprintf("entry %d", i)] {
+			t.Fatalf("missing entry %d", i)
+		}
+	}
+}
+
+func roundTrip(t *testing.T, s *Server, target string) {
+	im := newTestMuxer(t, testSecret, target)
+	if err := im.WaitForHot(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, im, 0, 100)
+	if err := im.Sync(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForEntries(100, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, s, 100)
+
+	tg, ok := s.Tag(testTag)
+	if !ok {
+		t.Fatal("tag was not negotiated")
+	} else if name, ok := s.TagName(tg); !ok || name != testTag {
+		t.Fatalf("bad tag name %q", name)
+	}
+	igs := s.Ingesters()
+	if len(igs) != 1 {
+		t.Fatalf("bad ingester count %d", len(igs))
+	} else if igs[0].Name != `ingesttest` || igs[0].Version != `1.2.3` || igs[0].UUID == `` {
+		t.Fatalf("bad ingester info %+v", igs[0])
+	}
+}
+
+func TestTCP(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, s, tgt)
+}
+
+func TestTLS(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTLS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, s, tgt)
+}
+
+func TestPipe(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenPipe(filepath.Join(t.TempDir(), "ingest.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, s, tgt)
+}
+
+func TestBadSecret(t *testing.T) {
+	if _, err := NewServer(Config{}); err != ErrNoSecret {
+		t.Fatalf("bad error %v", err)
+	}
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := newTestMuxer(t, `wrongsecret`, tgt)
+	if err := im.WaitForHot(time.Second); err == nil {
+		t.Fatal("muxer went hot with a bad secret")
+	} else if s.Connections() != 0 {
+		t.Fatal("ingester connected with a bad secret")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := newTestMuxer(t, testSecret, tgt)
+	if err := im.WaitForHot(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, im, 0, 10)
+	if err := s.WaitForEntries(10, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	r := s.Find(func(ent *entry.Entry) bool { return string(ent.Data) == `entry 7` })
+	if len(r) != 1 {
+		t.Fatalf("bad find result %d", len(r))
+	} else if len(s.TagEntries(`nothere`)) != 0 {
+		t.Fatal("got entries for a tag that was never used")
+	}
+	s.Reset()
+	if s.Count() != 0 {
+		t.Fatal("reset did not clear entries")
+	} else if err := s.WaitForEntries(1, 100*time.Millisecond); err != ErrWaitTimeout {
+		t.Fatalf("bad wait result %v", err)
+	}
+}
+
+func TestDropRedelivery(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := newTestMuxer(t, testSecret, tgt)
+	if err := im.WaitForHot(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	s.DropEntries(10)
+	writeEntries(t, im, 0, 20)
+	if err := s.WaitForEntries(10, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	//give the stragglers a moment, nothing past the survivors should show up
+	time.Sleep(100 * time.Millisecond)
+	if s.Dropped() != 10 || s.Count() != 10 {
+		t.Fatalf("bad drop counts %d %d", s.Dropped(), s.Count())
+	}
+	s.Disconnect()
+	if err := s.WaitForEntries(20, reconnectTimeout); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, s, 20)
+}
+
+func TestDisconnectAfter(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := newTestMuxer(t, testSecret, tgt)
+	if err := im.WaitForHot(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	s.DisconnectAfter(50)
+	writeEntries(t, im, 0, 100)
+	if err := s.WaitForEntries(100, reconnectTimeout); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, s, 100)
+}
+
+func TestSlowAcksAndThrottle(t *testing.T) {
+	s := newTestServer(t)
+	tgt, err := s.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := newTestMuxer(t, testSecret, tgt)
+	if err := im.WaitForHot(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	s.SetAckDelay(10 * time.Millisecond)
+	if err := s.Throttle(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, im, 0, 50)
+	if err := im.Sync(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitForEntries(50, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, s, 50)
+}
@@ -0,0 +1,22 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package faults holds the hooks the ingest test harnesses use to inject faults
+// into the protocol without exposing them on the public API.
+package faults
+
+import (
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// EntryDropper may be implemented by the connection handed to an EntryReader.  Entries
+// for which DropEntry returns true are discarded without being acknowledged, so the
+// writer will eventually send them again.
+type EntryDropper interface {
+	DropEntry(*entry.Entry) bool
+}
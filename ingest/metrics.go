@@ -0,0 +1,124 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// MuxerStats is a point in time snapshot of the muxer's health, it is intended for
+// exporting to monitoring systems.  Entry and byte counts are cumulative since the
+// muxer was created.
+type MuxerStats struct {
+	Connections int           // total number of configured indexer connections
+	Hot         int           // connections that are currently hot
+	Dead        int           // connections that are currently dead
+	CacheDepth  int           // entries and blocks waiting in the in-memory buffers
+	CacheBytes  int           // bytes held in the on-disk cache and replication queues
+	Entries     uint64        // entries handed to the muxer
+	Bytes       uint64        // bytes of entry data handed to the muxer
+	Uptime      time.Duration // time since the muxer was started
+}
+
+// TagStats holds the cumulative number of entries and bytes written under a single tag
+type TagStats struct {
+	Tag     string
+	Entries uint64
+	Bytes   uint64
+}
+
+type tagCounter struct {
+	entries uint64
+	bytes   uint64
+}
+
+// tagStats tracks entry counts by tag, the write paths hit it on every entry so it
+// stays off the muxer lock
+type tagStats struct {
+	mp sync.Map // entry.EntryTag -> *tagCounter
+}
+
+func (ts *tagStats) add(tg entry.EntryTag, sz int) {
+	v, ok := ts.mp.Load(tg)
+	if !ok {
+		v, _ = ts.mp.LoadOrStore(tg, &tagCounter{})
+	}
+	tc := v.(*tagCounter)
+	atomic.AddUint64(&tc.entries, 1)
+	atomic.AddUint64(&tc.bytes, uint64(sz))
+}
+
+// tagSize is an entry's tag and size captured before the entry is handed to the
+// writers, which may rewrite the tag once it is queued
+type tagSize struct {
+	tag entry.EntryTag
+	sz  int
+}
+
+func batchTagSizes(b []*entry.Entry) (r []tagSize) {
+	r = make([]tagSize, len(b))
+	for i := range b {
+		r[i] = tagSize{tag: b[i].Tag, sz: len(b[i].Data)}
+	}
+	return
+}
+
+func (ts *tagStats) addBatch(r []tagSize) {
+	for _, v := range r {
+		ts.add(v.tag, v.sz)
+	}
+}
+
+func (ts *tagStats) each(fn func(entry.EntryTag, uint64, uint64)) {
+	ts.mp.Range(func(k, v interface{}) bool {
+		tc := v.(*tagCounter)
+		fn(k.(entry.EntryTag), atomic.LoadUint64(&tc.entries), atomic.LoadUint64(&tc.bytes))
+		return true
+	})
+}
+
+// Stats returns a snapshot of the muxer's connection, cache, and throughput counters
+func (im *IngestMuxer) Stats() (s MuxerStats, err error) {
+	im.mtx.RLock()
+	defer im.mtx.RUnlock()
+	if im.state != running {
+		err = ErrNotRunning
+		return
+	}
+	s.Connections = len(im.dests)
+	s.Hot = int(atomic.LoadInt32(&im.connHot))
+	s.Dead = int(atomic.LoadInt32(&im.connDead))
+	s.CacheDepth = im.cache.BufferSize() + im.bcache.BufferSize()
+	s.CacheBytes = im.cachedBytes()
+	s.Uptime = time.Since(im.start)
+	im.tagStats.each(func(_ entry.EntryTag, ents, bts uint64) {
+		s.Entries += ents
+		s.Bytes += bts
+	})
+	return
+}
+
+// TagStats returns the cumulative entry and byte counts for every tag that has been
+// written to, sorted by tag name
+func (im *IngestMuxer) TagStats() (r []TagStats) {
+	im.tagStats.each(func(tg entry.EntryTag, ents, bts uint64) {
+		name, ok := im.LookupTag(tg)
+		if !ok {
+			return
+		}
+		r = append(r, TagStats{Tag: name, Entries: ents, Bytes: bts})
+	})
+	sort.Slice(r, func(i, j int) bool { return r[i].Tag < r[j].Tag })
+	return
+}
@@ -0,0 +1,69 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestMuxerStats(t *testing.T) {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations: replTargets,
+		Tags:         []string{`foo`, `bar`},
+		CacheDepth:   16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = im.Stats(); err != ErrNotRunning {
+		t.Fatalf("got stats from a muxer that is not running: %v", err)
+	}
+	// no real connections, entries just pile up in the buffers
+	im.state = running
+
+	foo, err := im.GetTag(`foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := im.GetTag(`bar`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = im.Write(entry.Now(), foo, []byte(`hello`)); err != nil {
+		t.Fatal(err)
+	}
+	b := []*entry.Entry{
+		&entry.Entry{TS: entry.Now(), Tag: bar, Data: []byte(`a`)},
+		&entry.Entry{TS: entry.Now(), Tag: bar, Data: []byte(`bc`)},
+	}
+	if err = im.WriteBatch(b); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := im.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Connections != len(replTargets) {
+		t.Fatalf("bad connection count %d", st.Connections)
+	} else if st.Entries != 3 || st.Bytes != 8 {
+		t.Fatalf("bad totals %d %d", st.Entries, st.Bytes)
+	}
+
+	ts := im.TagStats()
+	if len(ts) != 2 {
+		t.Fatalf("bad tag stats count %d", len(ts))
+	} else if ts[0] != (TagStats{Tag: `bar`, Entries: 2, Bytes: 3}) {
+		t.Fatalf("bad bar stats %+v", ts[0])
+	} else if ts[1] != (TagStats{Tag: `foo`, Entries: 1, Bytes: 5}) {
+		t.Fatalf("bad foo stats %+v", ts[1])
+	}
+}
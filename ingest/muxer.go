@@ -54,6 +54,9 @@ var (
 	ErrTimeout               = errors.New("Timed out waiting for ingesters")
 	ErrWriteTimeout          = errors.New("Timed out waiting to write entry")
 	ErrInvalidEntry          = errors.New("Invalid entry value")
+	ErrInvalidCacheBackend   = errors.New("Invalid cache backend")
+	ErrInvalidReplication    = errors.New("Invalid replication factor")
+	ErrQueueCacheRequired    = errors.New("Destination queues require a cache path")
 
 	errNotImp = errors.New("Not implemented yet")
 )
@@ -81,6 +84,7 @@ type Target struct {
 	Address string
 	Tenant  string
 	Secret  string
+	Tags    []string // optional tags or tag globs routed to this target, empty receives all unrouted tags
 }
 
 type TargetError struct {
@@ -89,7 +93,12 @@ type TargetError struct {
 }
 
 type IngestMuxer struct {
-	cfg StreamConfiguration //stream configuration
+	//entryCount and entryBytes are bumped atomically by the write paths and folded
+	//into the ingester state when it is pushed, they lead the struct so they are
+	//64bit aligned on 32bit architectures
+	entryCount uint64
+	entryBytes uint64
+	cfg        StreamConfiguration //stream configuration
 	//connHot, and connDead have atomic operations
 	//its important that these are aligned on 8 byte boundaries
 	//or it will panic on 32bit architectures
@@ -110,7 +119,7 @@ type IngestMuxer struct {
 	eChanOut             chan interface{}
 	bChan                chan interface{}
 	bChanOut             chan interface{}
-	eq                   *emergencyQueue
+	mq                   *muxQueue
 	dieChan              chan bool
 	upChan               chan bool
 	errChan              chan error
@@ -125,6 +134,7 @@ type IngestMuxer struct {
 	cache                *chancacher.ChanCacher
 	bcache               *chancacher.ChanCacher
 	cacheAlways          bool
+	cacheEvicts          bool // the cache drops old data when full rather than blocking
 	name                 string
 	version              string
 	uuid                 string
@@ -136,6 +146,13 @@ type IngestMuxer struct {
 	start                time.Time    // when the muxer was started
 	attacher             *attach.Attacher
 	attachActive         bool
+	replicate            bool        // every entry is fanned out to replFactor destinations
+	replFactor           int         // number of destinations each entry is delivered to
+	replicas             []*muxQueue // per-destination queues when replicating
+	groups               []*routeGroup
+	destGroup            []int        // route group index for each destination
+	tagRoutes            atomic.Value // []int mapping local tags to route groups
+	tagStats             tagStats     // entries and bytes written for each tag
 }
 
 type UniformMuxerConfig struct {
@@ -151,7 +168,10 @@ type UniformMuxerConfig struct {
 	CachePath         string
 	CacheSize         int
 	CacheMode         string
-	LogLevel          string // deprecated, no longer used
+	CacheBackend      string        // gob or wal, empty is gob
+	CacheSync         string        // wal fsync policy
+	CacheMaxAge       time.Duration // wal evicts cached data older than this, zero is no limit
+	LogLevel          string        // deprecated, no longer used
 	Logger            Logger
 	IngesterName      string
 	IngesterVersion   string
@@ -160,6 +180,9 @@ type UniformMuxerConfig struct {
 	RateLimitBps      int64
 	LogSourceOverride net.IP
 	Attach            attach.AttachConfig
+	Replicate         bool                // deliver every entry to ReplicationFactor destinations instead of just one
+	ReplicationFactor int                 // number of destinations that receive each entry, zero means all of them
+	TagRoutes         map[string][]string // tags or tag globs routed to specific destinations
 }
 
 type MuxerConfig struct {
@@ -173,7 +196,10 @@ type MuxerConfig struct {
 	CachePath         string
 	CacheSize         int
 	CacheMode         string
-	LogLevel          string // deprecated, no longer used
+	CacheBackend      string        // gob or wal, empty is gob
+	CacheSync         string        // wal fsync policy
+	CacheMaxAge       time.Duration // wal evicts cached data older than this, zero is no limit
+	LogLevel          string        // deprecated, no longer used
 	Logger            Logger
 	IngesterName      string
 	IngesterVersion   string
@@ -182,6 +208,8 @@ type MuxerConfig struct {
 	RateLimitBps      int64
 	LogSourceOverride net.IP
 	Attach            attach.AttachConfig
+	Replicate         bool // deliver every entry to ReplicationFactor destinations instead of just one
+	ReplicationFactor int  // number of destinations that receive each entry, zero means all of them
 }
 
 func NewUniformMuxer(c UniformMuxerConfig) (*IngestMuxer, error) {
@@ -218,6 +246,7 @@ func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
 		destinations[i].Address = c.Destinations[i]
 		destinations[i].Secret = c.Auth
 		destinations[i].Tenant = c.Tenant
+		destinations[i].Tags = c.TagRoutes[c.Destinations[i]]
 	}
 	if len(destinations) == 0 {
 		return nil, ErrNoTargets
@@ -232,6 +261,9 @@ func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
 		CachePath:          c.CachePath,
 		CacheSize:          c.CacheSize,
 		CacheMode:          c.CacheMode,
+		CacheBackend:       c.CacheBackend,
+		CacheSync:          c.CacheSync,
+		CacheMaxAge:        c.CacheMaxAge,
 		CacheDepth:         c.CacheDepth,
 		LogLevel:           c.LogLevel,
 		IngesterName:       c.IngesterName,
@@ -242,6 +274,8 @@ func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
 		Logger:             c.Logger,
 		LogSourceOverride:  c.LogSourceOverride,
 		Attach:             c.Attach,
+		Replicate:          c.Replicate,
+		ReplicationFactor:  c.ReplicationFactor,
 	}
 	return newIngestMuxer(cfg)
 }
@@ -262,6 +296,30 @@ func NewIngestMuxerExt(dests []Target, tags []string, pubKey, privKey string, ca
 	return newIngestMuxer(c)
 }
 
+// newCacher creates a ChanCacher using the configured cache backend,
+// an empty path gets a purely in-memory cacher.
+func newCacher(c MuxerConfig, depth int, pth string) (*chancacher.ChanCacher, error) {
+	if pth == `` {
+		return chancacher.NewChanCacher(depth, ``, 0)
+	}
+	switch strings.ToLower(c.CacheBackend) {
+	case ``, config.CACHE_BACKEND_GOB:
+		return chancacher.NewChanCacher(depth, pth, mb*c.CacheSize)
+	case config.CACHE_BACKEND_WAL:
+		sp, err := chancacher.ParseSyncPolicy(c.CacheSync)
+		if err != nil {
+			return nil, err
+		}
+		return chancacher.NewChanCacherWAL(depth, pth, chancacher.WALConfig{
+			MaxSize: int64(mb * c.CacheSize),
+			MaxAge:  c.CacheMaxAge,
+			Sync:    sp,
+			Logger:  c.Logger,
+		})
+	}
+	return nil, fmt.Errorf("%w %q", ErrInvalidCacheBackend, c.CacheBackend)
+}
+
 func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 	localTags := make([]string, 0, len(c.Tags))
 	for i := range c.Tags {
@@ -273,6 +331,11 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 	if c.Logger == nil {
 		c.Logger = log.NewDiscardLogger()
 	}
+	if c.ReplicationFactor < 0 || c.ReplicationFactor > len(c.Destinations) {
+		return nil, fmt.Errorf("%w %d with %d destinations", ErrInvalidReplication, c.ReplicationFactor, len(c.Destinations))
+	} else if c.Replicate && c.CachePath == "" {
+		return nil, fmt.Errorf("%w, replication needs somewhere to hold entries for a slow destination", ErrQueueCacheRequired)
+	}
 
 	// connect up the chancacher
 	var cache *chancacher.ChanCacher
@@ -280,11 +343,11 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 
 	var err error
 	if c.CachePath != "" {
-		cache, err = chancacher.NewChanCacher(c.CacheDepth, filepath.Join(c.CachePath, "e"), mb*c.CacheSize)
+		cache, err = newCacher(c, c.CacheDepth, filepath.Join(c.CachePath, "e"))
 		if err != nil {
 			return nil, err
 		}
-		bcache, err = chancacher.NewChanCacher(c.CacheDepth, filepath.Join(c.CachePath, "b"), mb*c.CacheSize)
+		bcache, err = newCacher(c, c.CacheDepth, filepath.Join(c.CachePath, "b"))
 		if err != nil {
 			return nil, err
 		}
@@ -304,6 +367,13 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		bcache.CacheStop()
 	}
 
+	var replicas []*muxQueue
+	if c.Replicate {
+		if replicas, err = newReplicaQueues(c); err != nil {
+			return nil, err
+		}
+	}
+
 	id := uuid.Nil
 	if c.IngesterUUID != `` {
 		if id, err = uuid.Parse(c.IngesterUUID); err != nil {
@@ -357,6 +427,22 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		writeTagCache(tagMap, c.CachePath)
 	}
 
+	groups, destGroup, err := newRouteGroups(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, rg := range groups {
+		if !rg.isDefault() {
+			if err = checkRoutes(groups, c.Destinations, localTags); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if len(groups) > 1 && c.CachePath == "" {
+		return nil, fmt.Errorf("%w, tag routes split the destinations into %d groups", ErrQueueCacheRequired, len(groups))
+	}
+
 	var p *parent
 	if c.RateLimitBps > 0 {
 		p = newParent(c.RateLimitBps, 0)
@@ -381,6 +467,11 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		Tags:       c.Tags,
 	}
 
+	sc, err := getStreamConfig(c.IngestStreamConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	var ci *CircularIndex
 	if ci, err = NewCircularIndex(4096); err != nil {
 		return nil, err
@@ -390,8 +481,8 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		buff: make([]entry.Entry, 4096),
 	}
 
-	return &IngestMuxer{
-		cfg:               getStreamConfig(c.IngestStreamConfig),
+	im := &IngestMuxer{
+		cfg:               sc,
 		dests:             c.Destinations,
 		tags:              taglist,
 		tagMap:            tagMap,
@@ -408,7 +499,7 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		eChanOut:          cache.Out,
 		bChan:             bcache.In,
 		bChanOut:          bcache.Out,
-		eq:                newEmergencyQueue(),
+		mq:                newMuxQueue(cache, bcache, 0),
 		dieChan:           make(chan bool, len(c.Destinations)),
 		upChan:            make(chan bool, 1),
 		errChan:           make(chan error, len(c.Destinations)),
@@ -418,6 +509,7 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		cacheSize:         mb * c.CacheSize,
 		cachePath:         c.CachePath,
 		cacheAlways:       strings.ToLower(c.CacheMode) == CacheModeAlways,
+		cacheEvicts:       strings.ToLower(c.CacheBackend) == config.CACHE_BACKEND_WAL,
 		name:              c.IngesterName,
 		version:           c.IngesterVersion,
 		uuid:              c.IngesterUUID,
@@ -427,7 +519,14 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		logbuff:           logbuff,
 		attacher:          atch,
 		attachActive:      atch.Active(),
-	}, nil
+		replicate:         c.Replicate,
+		replFactor:        c.ReplicationFactor,
+		replicas:          replicas,
+		groups:            groups,
+		destGroup:         destGroup,
+	}
+	im.tagRoutes.Store(buildRouteTable(groups, tagMap))
+	return im, nil
 }
 
 func readTagCache(p string) (map[string]entry.EntryTag, error) {
@@ -488,6 +587,10 @@ func (im *IngestMuxer) Start() error {
 	for i := 0; i < len(im.dests); i++ {
 		go im.connRoutine(i)
 	}
+	if len(im.groups) > 0 {
+		im.wg.Add(1)
+		go im.fanoutRoutine()
+	}
 	im.start = time.Now()
 	im.state = running
 	// start the state report goroutine
@@ -528,9 +631,13 @@ func (im *IngestMuxer) Close() error {
 	// commit any outstanding data to disk, if the backing path is enabled.
 	im.cache.Commit()
 	im.bcache.Commit()
+	for _, q := range im.queues() {
+		q.close()
+		q.commit()
+	}
 
-	// If BOTH caches are empty, we can delete the stored tag map
-	if im.cacheEnabled && im.cache.Size() == 0 && im.bcache.Size() == 0 {
+	// If ALL caches are empty, we can delete the stored tag map
+	if im.cacheEnabled && im.cachedBytes() == 0 {
 		path := filepath.Join(im.cachePath, "tagcache")
 		os.Remove(path)
 	}
@@ -542,7 +649,7 @@ func (im *IngestMuxer) Close() error {
 
 func (im *IngestMuxer) ingesterStateDirty() (dirty bool) {
 	im.mtx.RLock()
-	if im.ingesterState.CacheSize != uint64(im.cache.Size()) {
+	if im.ingesterState.CacheSize != uint64(im.cachedBytes()) {
 		dirty = true
 	} else if len(im.ingesterState.Tags) != len(im.tags) {
 		dirty = true
@@ -555,7 +662,7 @@ func (im *IngestMuxer) ingesterStateDirty() (dirty bool) {
 
 func (im *IngestMuxer) getIngesterState(lastPush time.Time, lastEntryCount uint64) (s IngesterState, shouldPush bool) {
 	//check if it has been long enough that we push no matter what or the state is dirty and we need push
-	if time.Since(lastPush) > maxIngesterStateUpdateInterval || im.ingesterStateDirty() || im.ingesterState.Entries != lastEntryCount {
+	if time.Since(lastPush) > maxIngesterStateUpdateInterval || im.ingesterStateDirty() || atomic.LoadUint64(&im.entryCount) != lastEntryCount {
 		shouldPush = true
 	} else {
 		return //nothing new in the ingester state, just return
@@ -563,9 +670,11 @@ func (im *IngestMuxer) getIngesterState(lastPush time.Time, lastEntryCount uint6
 	im.mtx.Lock()
 
 	// update the cache stats real quick
-	im.ingesterState.CacheSize = uint64(im.cache.Size())
+	im.ingesterState.CacheSize = uint64(im.cachedBytes())
 	im.ingesterState.Uptime = time.Since(im.start)
 	im.ingesterState.Tags = im.tags
+	im.ingesterState.Entries = atomic.LoadUint64(&im.entryCount)
+	im.ingesterState.Size = atomic.LoadUint64(&im.entryBytes)
 
 	// The ingesterState object is of type ingest.IngesterState which contains a map of children.
 	// You must make a deep copy (which is what Copy does) if you are going to concurrently read and write it.
@@ -587,8 +696,10 @@ func (im *IngestMuxer) stateReportRoutine() {
 			//SendIngesterState throws a full sync and then pushes a potentially very large
 			//configuration block. DO NOT HOLD THE LOCK on the entire muxer when this is happening
 			//or you will most likely starve the ingest muxer.
-
-			for _, v := range im.igst {
+			im.mtx.RLock()
+			igst := append([]*IngestConnection(nil), im.igst...)
+			im.mtx.RUnlock()
+			for _, v := range igst {
 				if v != nil {
 					// we don't fuss over the return value
 					v.SendIngesterState(s)
@@ -596,7 +707,7 @@ func (im *IngestMuxer) stateReportRoutine() {
 			}
 			//update our last push time and the number of entries at the time of our last push
 			lastPush = time.Now()
-			lastEntryCount = im.ingesterState.Entries
+			lastEntryCount = s.Entries
 		}
 		time.Sleep(ingesterStateUpdateInterval)
 	}
@@ -613,6 +724,8 @@ func (im *IngestMuxer) WillBlock() bool {
 
 	if !im.cacheEnabled {
 		return true
+	} else if im.cacheEvicts {
+		return false
 	} else if im.cache.Size() >= im.cacheSize {
 		return true
 	} else if im.bcache.Size() >= im.cacheSize {
@@ -652,6 +765,38 @@ func (im *IngestMuxer) SetMetadata(obj interface{}) (err error) {
 	return
 }
 
+// SetMetadataKey sets a single key in the ingester state metadata without disturbing the
+// keys set by others, a nil obj removes the key.  The metadata must be a JSON object.
+func (im *IngestMuxer) SetMetadataKey(key string, obj interface{}) (err error) {
+	var msg []byte
+	if obj != nil {
+		if msg, err = json.Marshal(obj); err != nil {
+			return
+		}
+	}
+	im.mtx.Lock()
+	defer im.mtx.Unlock()
+	mp := map[string]json.RawMessage{}
+	if len(im.ingesterState.Metadata) > 0 {
+		if err = json.Unmarshal(im.ingesterState.Metadata, &mp); err != nil {
+			return fmt.Errorf("existing metadata is not an object: %w", err)
+		} else if mp == nil {
+			mp = map[string]json.RawMessage{}
+		}
+	}
+	if msg == nil {
+		delete(mp, key)
+	} else {
+		mp[key] = json.RawMessage(msg)
+	}
+	if msg, err = json.Marshal(mp); err != nil {
+		return
+	}
+	im.ingesterState.Metadata = json.RawMessage(msg)
+	im.ingesterStateUpdated = true
+	return
+}
+
 func (im *IngestMuxer) RegisterChild(k string, v IngesterState) {
 	im.mtx.Lock()
 	v.LastSeen = time.Now() // if its being registered, we want to update its state
@@ -709,6 +854,10 @@ func (im *IngestMuxer) NegotiateTag(name string) (tg entry.EntryTag, err error)
 		tg = tag
 		return
 	}
+	if len(im.groups) > 0 && routeTag(im.groups, name) < 0 {
+		err = ErrTagNotRouted
+		return
+	}
 
 	// update the tag list and map
 	im.tags = append(im.tags, name)
@@ -724,6 +873,9 @@ func (im *IngestMuxer) NegotiateTag(name string) (tg entry.EntryTag, err error)
 	im.tagMap[name] = entry.EntryTag(tagNext + 1)
 
 	tg = im.tagMap[name]
+	if len(im.groups) > 0 {
+		im.tagRoutes.Store(buildRouteTable(im.groups, im.tagMap))
+	}
 
 	// update the tag cache
 	if im.cachePath != "" {
@@ -731,7 +883,12 @@ func (im *IngestMuxer) NegotiateTag(name string) (tg entry.EntryTag, err error)
 	}
 
 	for k, v := range im.igst {
-		if v != nil {
+		if v != nil && !im.routedTo(k, name) {
+			// the tag is not routed here, just keep the translator in sync
+			if im.tagTranslators[k] == nil || im.tagTranslators[k].RegisterTag(tg, tagUnrouted) != nil {
+				v.Close()
+			}
+		} else if v != nil {
 			remoteTag, err := v.NegotiateTag(name)
 			if err != nil {
 				if err == ErrNotRunning {
@@ -773,6 +930,60 @@ func (im *IngestMuxer) NegotiateTag(name string) (tg entry.EntryTag, err error)
 	return
 }
 
+// ReleaseTags backs out tags that were negotiated but never used, such as when applying a
+// new configuration fails part way through.  Only the most recently negotiated tags can be
+// released so that local tag values stay dense, any others remain negotiated.  Indexers keep
+// their side of the negotiation, which is harmless.
+func (im *IngestMuxer) ReleaseTags(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	rel := make(map[string]bool, len(names))
+	for _, n := range names {
+		rel[n] = true
+	}
+	im.mtx.Lock()
+	defer im.mtx.Unlock()
+
+	var released bool
+	for len(im.tagMap) > 0 {
+		var top entry.EntryTag
+		var name string
+		for k, v := range im.tagMap {
+			if v >= top {
+				name, top = k, v
+			}
+		}
+		if !rel[name] {
+			break
+		}
+		delete(im.tagMap, name)
+		for i, v := range im.tags {
+			if v == name {
+				im.tags = append(im.tags[:i:i], im.tags[i+1:]...)
+				break
+			}
+		}
+		for _, tt := range im.tagTranslators {
+			if tt != nil && len(*tt) > int(top) {
+				*tt = (*tt)[:top]
+			}
+		}
+		released = true
+	}
+	if !released {
+		return
+	}
+	im.ingesterState.Tags = im.tags
+	im.ingesterStateUpdated = true
+	if len(im.groups) > 0 {
+		im.tagRoutes.Store(buildRouteTable(im.groups, im.tagMap))
+	}
+	if im.cachePath != "" {
+		writeTagCache(im.tagMap, im.cachePath)
+	}
+}
+
 func (im *IngestMuxer) Sync(to time.Duration) error {
 	return im.SyncContext(context.Background(), to)
 }
@@ -783,7 +994,7 @@ func (im *IngestMuxer) SyncContext(ctx context.Context, to time.Duration) error
 	}
 	ts := time.Now()
 	im.mtx.Lock()
-	for len(im.eChanOut) > 0 || len(im.bChanOut) > 0 {
+	for len(im.eChanOut) > 0 || len(im.bChanOut) > 0 || im.queuesPending() {
 		if err := ctx.Err(); err != nil {
 			im.mtx.Unlock()
 			return err
@@ -970,9 +1181,12 @@ func (im *IngestMuxer) WriteEntry(e *entry.Entry) error {
 	if im.attachActive {
 		im.attacher.Attach(e)
 	}
+	//grab the tag before the send, the writers may rewrite it once the entry is queued
+	tg, sz := e.Tag, len(e.Data)
 	im.eChan <- e
-	im.ingesterState.Entries++
-	im.ingesterState.Size += uint64(len(e.Data))
+	atomic.AddUint64(&im.entryCount, 1)
+	atomic.AddUint64(&im.entryBytes, uint64(sz))
+	im.tagStats.add(tg, sz)
 	return nil
 }
 
@@ -992,10 +1206,12 @@ func (im *IngestMuxer) WriteEntryContext(ctx context.Context, e *entry.Entry) er
 	if im.attachActive {
 		im.attacher.Attach(e)
 	}
+	tg, sz := e.Tag, len(e.Data)
 	select {
 	case im.eChan <- e:
-		im.ingesterState.Entries++
-		im.ingesterState.Size += uint64(len(e.Data))
+		atomic.AddUint64(&im.entryCount, 1)
+		atomic.AddUint64(&im.entryBytes, uint64(sz))
+		im.tagStats.add(tg, sz)
 	case <-ctx.Done():
 		return ctx.Err()
 	}
@@ -1018,11 +1234,13 @@ func (im *IngestMuxer) WriteEntryTimeout(e *entry.Entry, d time.Duration) (err e
 	if im.attachActive {
 		im.attacher.Attach(e)
 	}
+	tg, sz := e.Tag, len(e.Data)
 	tmr := time.NewTimer(d)
 	select {
 	case im.eChan <- e:
-		im.ingesterState.Entries++
-		im.ingesterState.Size += uint64(len(e.Data))
+		atomic.AddUint64(&im.entryCount, 1)
+		atomic.AddUint64(&im.entryBytes, uint64(sz))
+		im.tagStats.add(tg, sz)
 	case _ = <-tmr.C:
 		err = ErrWriteTimeout
 	}
@@ -1055,11 +1273,16 @@ func (im *IngestMuxer) WriteBatch(b []*entry.Entry) error {
 			im.attacher.Attach(e)
 		}
 	}
+	//grab the tags before the send, the writers may rewrite them once the batch is queued
+	ts := batchTagSizes(b)
 	im.bChan <- b
-	im.ingesterState.Entries += uint64(len(b))
-	for i := range b {
-		im.ingesterState.Size += uint64(len(b[i].Data))
+	atomic.AddUint64(&im.entryCount, uint64(len(ts)))
+	var sz uint64
+	for i := range ts {
+		sz += uint64(ts[i].sz)
 	}
+	atomic.AddUint64(&im.entryBytes, sz)
+	im.tagStats.addBatch(ts)
 	return nil
 }
 
@@ -1092,12 +1315,16 @@ func (im *IngestMuxer) WriteBatchContext(ctx context.Context, b []*entry.Entry)
 			im.attacher.Attach(e)
 		}
 	}
+	ts := batchTagSizes(b)
 	select {
 	case im.bChan <- b:
-		im.ingesterState.Entries += uint64(len(b))
-		for i := range b {
-			im.ingesterState.Size += uint64(len(b[i].Data))
+		atomic.AddUint64(&im.entryCount, uint64(len(ts)))
+		var sz uint64
+		for i := range ts {
+			sz += uint64(ts[i].sz)
 		}
+		atomic.AddUint64(&im.entryBytes, sz)
+		im.tagStats.addBatch(ts)
 	case <-ctx.Done():
 		return ctx.Err()
 	}
@@ -1156,7 +1383,7 @@ type connSet struct {
 }
 
 // keep attempting to get a new connection set that we can actually write to
-func (im *IngestMuxer) getNewConnSet(csc chan connSet, connFailure chan bool, orig bool) (nc connSet, ok bool) {
+func (im *IngestMuxer) getNewConnSet(csc chan connSet, connFailure chan bool, q *muxQueue, orig bool) (nc connSet, ok bool) {
 	if !orig {
 		//try to send, if we can't just roll on
 		select {
@@ -1169,7 +1396,7 @@ func (im *IngestMuxer) getNewConnSet(csc chan connSet, connFailure chan bool, or
 			return
 		}
 		//attempt to clear the emergency queue and throw at our new connection
-		if !im.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
+		if !q.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
 			//try to send, if we can't just roll on
 			select {
 			case connFailure <- true:
@@ -1196,10 +1423,11 @@ func tickerInterval() time.Duration {
 
 func (im *IngestMuxer) shouldSched() bool {
 	//if pipelines are empty, schedule ourselves so that we can get a better distribution of entries
-	return len(im.igst) > 1 && im.cache.BufferSize() == 0 && im.bcache.BufferSize() == 0
+	//replicated connections each have their own queue, so there is nothing to distribute
+	return !im.replicate && len(im.igst) > 1 && im.cache.BufferSize() == 0 && im.bcache.BufferSize() == 0
 }
 
-func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool) {
+func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool, q *muxQueue) {
 	tmr := time.NewTimer(tickerInterval())
 	defer tmr.Stop()
 	defer close(connFailure)
@@ -1210,12 +1438,12 @@ func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool
 	var ok bool
 	var err error
 	var ttag entry.EntryTag
-	if nc, ok = im.getNewConnSet(csc, connFailure, true); !ok {
+	if nc, ok = im.getNewConnSet(csc, connFailure, q, true); !ok {
 		return
 	}
 
-	eC := im.eChanOut
-	bC := im.bChanOut
+	eC := q.cache.Out
+	bC := q.bcache.Out
 
 inputLoop:
 	for {
@@ -1241,7 +1469,10 @@ inputLoop:
 			ttag, ok = nc.tt.Translate(e.Tag)
 			if !ok {
 				// If the ingest muxer has no idea what this tag is, drop it and notify
-				if name, ok := im.LookupTag(e.Tag); !ok {
+				if nc.tt.unrouted(e.Tag) {
+					im.Error("Got entry tagged with a tag not routed to this indexer, dropping it", log.KV("indexer", nc.dst), log.KV("tagvalue", e.Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
+					continue inputLoop
+				} else if name, ok := im.LookupTag(e.Tag); !ok {
 					im.Error("Got entry tagged with completely unknown intermediate tag, dropping it", log.KV("tagvalue", e.Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
 					continue inputLoop
 				} else {
@@ -1250,8 +1481,8 @@ inputLoop:
 					// We need to push this to the equeue and reconnect
 					// so we get the correct tag set.
 					// DO NOT reverse translate, muxer knows about the tag
-					im.recycleEntry(e)
-					if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+					q.recycleEntry(e)
+					if nc, ok = im.getNewConnSet(csc, connFailure, q, false); !ok {
 						break inputLoop
 					}
 					continue inputLoop
@@ -1264,8 +1495,8 @@ inputLoop:
 			}
 			if err = nc.ig.WriteEntry(e); err != nil {
 				e.Tag = nc.tt.Reverse(e.Tag)
-				im.recycleEntry(e)
-				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+				q.recycleEntry(e)
+				if nc, ok = im.getNewConnSet(csc, connFailure, q, false); !ok {
 					break inputLoop
 				}
 				continue inputLoop
@@ -1291,13 +1522,19 @@ inputLoop:
 				if b[i] != nil {
 					ttag, ok = nc.tt.Translate(b[i].Tag)
 					if !ok {
-						if name, ok := im.LookupTag(b[i].Tag); !ok {
+						if nc.tt.unrouted(b[i].Tag) {
+							im.Error("Got entry tagged with a tag not routed to this indexer, dropping it", log.KV("indexer", nc.dst), log.KV("tagvalue", b[i].Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
+							for j := 0; j < i; j++ {
+								b[j].Tag = nc.tt.Reverse(b[j].Tag)
+							}
+							q.recycleEntryBatch(append(b[:i:i], b[i+1:]...))
+						} else if name, ok := im.LookupTag(b[i].Tag); !ok {
 							im.Error("Got entry tagged with completely unknown intermediate tag, dropping it", log.KV("tagvalue", b[i].Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
 							// first, reverse anything we've translated already
 							for j := 0; j < i; j++ {
 								b[j].Tag = nc.tt.Reverse(b[j].Tag)
 							}
-							im.recycleEntryBatch(b[:i]) //recycle and save what we can
+							q.recycleEntryBatch(b[:i]) //recycle and save what we can
 						} else {
 							im.Info("Got entry with new tag, need to renegotiate connection", log.KV("tag", name), log.KV("tagvalue", b[i].Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
 							// Could not translate! We need to push this to the equeue and reconnect
@@ -1307,8 +1544,8 @@ inputLoop:
 							for j := 0; j < i; j++ {
 								b[j].Tag = nc.tt.Reverse(b[j].Tag)
 							}
-							im.recycleEntryBatch(b)
-							if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+							q.recycleEntryBatch(b)
+							if nc, ok = im.getNewConnSet(csc, connFailure, q, false); !ok {
 								break inputLoop
 							}
 						}
@@ -1326,8 +1563,8 @@ inputLoop:
 				for i := n; i < len(b); i++ {
 					b[i].Tag = nc.tt.Reverse(b[i].Tag)
 				}
-				im.recycleEntryBatch(b[n:])
-				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+				q.recycleEntryBatch(b[n:])
+				if nc, ok = im.getNewConnSet(csc, connFailure, q, false); !ok {
 					break inputLoop
 				}
 			}
@@ -1345,8 +1582,8 @@ inputLoop:
 			nc = tnc //just an update
 		case <-tmr.C:
 			//periodically check the emergency queue and sync
-			if !im.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
-				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+			if !q.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
+				if nc, ok = im.getNewConnSet(csc, connFailure, q, false); !ok {
 					break inputLoop
 				}
 			}
@@ -1371,6 +1608,8 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 		return
 	}
 
+	q := im.destQueue(igIdx)
+
 	var igst *IngestConnection
 	var tt tagTrans
 	var err error
@@ -1378,7 +1617,7 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 	ncc := make(chan connSet, 1)
 	defer close(ncc)
 
-	go im.writeRelayRoutine(ncc, connErrNotif)
+	go im.writeRelayRoutine(ncc, connErrNotif, q)
 
 	connErrNotif <- true
 
@@ -1410,11 +1649,11 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 						ents[i].Tag = tt.Reverse(ents[i].Tag)
 					}
 				}
-				im.recycleEntryBatch(ents)
+				q.recycleEntryBatch(ents)
 			}
 
 			//attempt to get the connection rolling again
-			igst, tt, err = im.getConnection(dst)
+			igst, tt, err = im.getConnection(dst, igIdx)
 			if err != nil {
 				im.connFailed(dst.Address, err)
 				return //we are done
@@ -1448,7 +1687,7 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 	}
 }
 
-func (im *IngestMuxer) recycleEntryBatch(ents []*entry.Entry) {
+func (q *muxQueue) recycleEntryBatch(ents []*entry.Entry) {
 	if len(ents) == 0 {
 		return
 	}
@@ -1461,15 +1700,15 @@ func (im *IngestMuxer) recycleEntryBatch(ents []*entry.Entry) {
 
 	select {
 	case _ = <-tmr.C:
-		if err := im.eq.push(nil, ents); err != nil {
+		if err := q.eq.push(nil, ents); err != nil {
 			//FIXME - throw a fit about this
 		}
-	case im.bChan <- ents:
+	case q.bcache.In <- ents:
 	}
 	return
 }
 
-func (im *IngestMuxer) recycleEntry(ent *entry.Entry) {
+func (q *muxQueue) recycleEntry(ent *entry.Entry) {
 	if ent == nil {
 		return
 	}
@@ -1482,10 +1721,10 @@ func (im *IngestMuxer) recycleEntry(ent *entry.Entry) {
 
 	select {
 	case _ = <-tmr.C:
-		if err := im.eq.push(ent, nil); err != nil {
+		if err := q.eq.push(ent, nil); err != nil {
 			//FIXME - throw a fit about this
 		}
-	case im.eChan <- ent:
+	case q.cache.In <- ent:
 	}
 	return
 }
@@ -1534,7 +1773,7 @@ func backoff(curr, max time.Duration) time.Duration {
 	return curr
 }
 
-func (im *IngestMuxer) getConnection(tgt Target) (ig *IngestConnection, tt tagTrans, err error) {
+func (im *IngestMuxer) getConnection(tgt Target, igIdx int) (ig *IngestConnection, tt tagTrans, err error) {
 	//initialize our retryDuration to zero, first call will set it to the default and then start backing off
 	var retryDuration time.Duration
 loop:
@@ -1546,7 +1785,7 @@ loop:
 			log.KV("version", version.GetVersion()),
 			log.KV("ingesteruuid", im.uuid))
 		im.mtx.RLock()
-		if ig, err = initConnection(tgt, im.tags, im.pubKey, im.privKey, im.verifyCert); err != nil {
+		if ig, err = initConnection(tgt, im.routedTags(igIdx), im.pubKey, im.privKey, im.verifyCert); err != nil {
 			im.mtx.RUnlock()
 			if isFatalConnError(err) {
 				im.Error("fatal connection error",
@@ -1582,7 +1821,7 @@ loop:
 
 		//no error, attempt to do a tag translation
 		//we have a good connection, build our tag map
-		if tt, err = im.newTagTrans(ig); err != nil {
+		if tt, err = im.newTagTrans(ig, igIdx); err != nil {
 			ig.Close()
 			ig = nil
 			tt = nil
@@ -1681,7 +1920,7 @@ loop:
 	return
 }
 
-func (im *IngestMuxer) newTagTrans(igst *IngestConnection) (tagTrans, error) {
+func (im *IngestMuxer) newTagTrans(igst *IngestConnection, igIdx int) (tagTrans, error) {
 	tt := tagTrans(make([]entry.EntryTag, len(im.tagMap)))
 	if len(tt) == 0 {
 		return nil, ErrTagMapInvalid
@@ -1690,6 +1929,11 @@ func (im *IngestMuxer) newTagTrans(igst *IngestConnection) (tagTrans, error) {
 		if int(v) > len(tt) {
 			return nil, ErrTagMapInvalid
 		}
+		if !im.routedTo(igIdx, k) {
+			// this connection never sees the tag, so it never negotiated it
+			tt[v] = tagUnrouted
+			continue
+		}
 		tg, ok := igst.GetTag(k)
 		if !ok {
 			return nil, ErrTagNotFound
@@ -1882,12 +2126,17 @@ func (tt tagTrans) Translate(t entry.EntryTag) (entry.EntryTag, bool) {
 	}
 	//if this is a tag we have not negotiated, set it to the first one we have
 	//we are assuming that its an error, but we still want the entry
-	if int(t) >= len(tt) {
+	if int(t) >= len(tt) || tt[t] == tagUnrouted {
 		return tt[0], false
 	}
 	return tt[t], true
 }
 
+// unrouted returns true if the local tag is known but deliberately not negotiated on the connection
+func (tt tagTrans) unrouted(t entry.EntryTag) bool {
+	return int(t) < len(tt) && tt[t] == tagUnrouted
+}
+
 func (tt *tagTrans) RegisterTag(local entry.EntryTag, remote entry.EntryTag) error {
 	if int(local) != len(*tt) {
 		// this means the local tag numbers got out of sync and something is bad
@@ -1913,9 +2162,18 @@ func (tt tagTrans) Reverse(t entry.EntryTag) entry.EntryTag {
 	return 0
 }
 
-func getStreamConfig(cfg config.IngestStreamConfig) (sc StreamConfiguration) {
-	if cfg.Enable_Compression {
+func getStreamConfig(cfg config.IngestStreamConfig) (sc StreamConfiguration, err error) {
+	if cfg.Compression_Type != `` {
+		if sc.Compression, err = ParseCompression(cfg.Compression_Type); err != nil {
+			return
+		}
+	} else if cfg.Enable_Compression {
 		sc.Compression = CompressSnappy
 	}
+	if cfg.Compression_Level < 0 || cfg.Compression_Level > 22 {
+		err = fmt.Errorf("Invalid compression level %d", cfg.Compression_Level)
+		return
+	}
+	sc.CompressionLevel = uint8(cfg.Compression_Level)
 	return
 }
@@ -0,0 +1,97 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetMetadataKey(t *testing.T) {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations: replTargets,
+		Tags:         []string{`foo`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = im.SetMetadata(map[string]string{`owner`: `ops`}); err != nil {
+		t.Fatal(err)
+	} else if err = im.SetMetadataKey(`RateLimits`, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	var mp map[string]json.RawMessage
+	if err = json.Unmarshal(im.ingesterState.Metadata, &mp); err != nil {
+		t.Fatal(err)
+	} else if string(mp[`owner`]) != `"ops"` || string(mp[`RateLimits`]) != `[1,2]` {
+		t.Fatalf("bad merged metadata %s", im.ingesterState.Metadata)
+	}
+
+	//a nil value removes only that key
+	if err = im.SetMetadataKey(`RateLimits`, nil); err != nil {
+		t.Fatal(err)
+	} else if string(im.ingesterState.Metadata) != `{"owner":"ops"}` {
+		t.Fatalf("bad metadata after removal %s", im.ingesterState.Metadata)
+	}
+
+	//metadata that is not an object is left alone
+	if err = im.SetMetadata([]string{`a`}); err != nil {
+		t.Fatal(err)
+	} else if err = im.SetMetadataKey(`RateLimits`, 1); err == nil {
+		t.Fatal("overwrote non-object metadata")
+	} else if string(im.ingesterState.Metadata) != `["a"]` {
+		t.Fatalf("metadata was modified %s", im.ingesterState.Metadata)
+	}
+}
+
+func TestReleaseTags(t *testing.T) {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations: routeTargets,
+		Tags:         []string{`windows`, `netflow`, `syslog`},
+		CachePath:    t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := tagTrans{1, 2, 3}
+	im.tagTranslators = []*tagTrans{&tt}
+	base := len(im.KnownTags())
+
+	fw, err := im.NegotiateTag(`firewall`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt = append(tt, 4) // stand in for the remote negotiation
+	if _, err = im.NegotiateTag(`windowsApp`); err != nil {
+		t.Fatal(err)
+	}
+	tt = append(tt, 5)
+
+	// firewall is buried under windowsApp, so only windowsApp can go
+	im.ReleaseTags([]string{`windowsApp`, `syslog`})
+	if n := len(im.KnownTags()); n != base+1 {
+		t.Fatalf("bad tag count after release %d", n)
+	} else if _, err = im.GetTag(`windowsApp`); err == nil {
+		t.Fatal("released tag still present")
+	} else if _, err = im.GetTag(`syslog`); err != nil {
+		t.Fatal("released a tag that was not at the top")
+	} else if len(tt) != int(fw)+1 {
+		t.Fatalf("translator not truncated: %v", tt)
+	} else if im.routeGroupOf(fw) == nil {
+		t.Fatal("lost the route for a remaining tag")
+	}
+
+	// the value is reused by the next negotiation
+	tg, err := im.NegotiateTag(`windowsSecurity`)
+	if err != nil {
+		t.Fatal(err)
+	} else if tg != fw+1 {
+		t.Fatalf("bad tag value %d after release", tg)
+	}
+}
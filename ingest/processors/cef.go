@@ -0,0 +1,382 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	CEFProcessor = `cef`
+
+	cefPrefix       = `CEF:`
+	cefHeaderFields = 7 // version, vendor, product, device version, signature id, name, severity
+	cefTimeField    = `rt`
+	attachAllFields = `*`
+)
+
+// CEFConfig configures the ArcSight Common Event Format preprocessor.  Header fields are
+// named Version, Vendor, Product, DeviceVersion, SignatureID, Name, and Severity, extension
+// fields keep the keys they were sent with.
+type CEFConfig struct {
+	Drop_Misses           bool     // drop entries that are not valid CEF
+	Attach                []string // fields to attach as enumerated values, * attaches every field
+	Route_Template        string   // optional tag template, e.g. ${Vendor}-${Product}
+	Extract_Timestamp     bool     // set the entry timestamp from the rt field
+	Assume_Local_Timezone bool
+	Timestamp_Override    string
+}
+
+func CEFLoadConfig(vc *config.VariableConfig) (c CEFConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c CEFConfig) validate() error {
+	return eventConfig(c).validate()
+}
+
+type CEF struct {
+	nocloser
+	CEFConfig
+	ex *eventExtractor
+}
+
+func NewCEF(cfg CEFConfig, tagger Tagger) (*CEF, error) {
+	ex, err := newEventExtractor(eventConfig(cfg), cefTimeField, tagger)
+	if err != nil {
+		return nil, err
+	}
+	return &CEF{
+		CEFConfig: cfg,
+		ex:        ex,
+	}, nil
+}
+
+func (c *CEF) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(CEFConfig); ok {
+		var ex *eventExtractor
+		if ex, err = newEventExtractor(eventConfig(cfg), cefTimeField, c.ex.tagger); err == nil {
+			c.CEFConfig = cfg
+			c.ex = ex
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *CEF) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	return c.ex.process(ents, parseCEF), nil
+}
+
+// parseCEF cracks a CEF record, anything in front of the CEF: marker such as a syslog
+// header is ignored
+func parseCEF(data []byte) (ef eventFields, ok bool) {
+	idx := bytes.Index(data, []byte(cefPrefix))
+	if idx == -1 {
+		return
+	}
+	hdr, ext, ok := splitEventHeader(string(data[idx+len(cefPrefix):]), cefHeaderFields)
+	if !ok {
+		return
+	} else if _, err := strconv.Atoi(strings.TrimSpace(hdr[0])); err != nil {
+		ok = false
+		return
+	}
+	ef = eventFields{
+		{`Version`, strings.TrimSpace(hdr[0])},
+		{`Vendor`, hdr[1]},
+		{`Product`, hdr[2]},
+		{`DeviceVersion`, hdr[3]},
+		{`SignatureID`, hdr[4]},
+		{`Name`, hdr[5]},
+		{`Severity`, strings.TrimSpace(hdr[6])},
+	}
+	ef = parseCEFExtension(ext, ef)
+	return
+}
+
+// splitEventHeader splits n pipe delimited fields off the front of s, pipes and
+// backslashes in the fields may be escaped with a backslash.  Whatever follows the
+// last pipe is returned in rest.  A record that ends right after the last header
+// field without the trailing pipe is accepted.
+func splitEventHeader(s string, n int) (hdr []string, rest string, ok bool) {
+	var start int
+	for i := 0; i < len(s) && len(hdr) < n; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '|':
+			hdr = append(hdr, unescapeHeader(s[start:i]))
+			start = i + 1
+		}
+	}
+	if len(hdr) == n {
+		rest = s[start:]
+	} else if len(hdr) == n-1 && start < len(s) {
+		hdr = append(hdr, unescapeHeader(strings.TrimRight(s[start:], "\r\n")))
+	} else {
+		return nil, ``, false
+	}
+	ok = true
+	return
+}
+
+var (
+	headerUnescaper = strings.NewReplacer(`\|`, `|`, `\\`, `\`)
+	cefUnescaper    = strings.NewReplacer(`\=`, `=`, `\\`, `\`, `\n`, "\n", `\r`, "\r", `\|`, `|`)
+)
+
+func unescapeHeader(v string) string {
+	if strings.IndexByte(v, '\\') == -1 {
+		return v
+	}
+	return headerUnescaper.Replace(v)
+}
+
+func unescapeCEFValue(v string) string {
+	if strings.IndexByte(v, '\\') == -1 {
+		return v
+	}
+	return cefUnescaper.Replace(v)
+}
+
+// parseCEFExtension pulls the space separated key=value pairs out of a CEF extension.
+// Values may contain spaces, so a value runs until the last space in front of the next
+// unescaped equals sign that is preceded by a valid key.  Unescaped equals signs that are
+// not preceded by a key are treated as part of the value, lots of devices do not bother
+// escaping them.
+func parseCEFExtension(s string, ef eventFields) eventFields {
+	var key string
+	valStart := -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			continue
+		case '=':
+		default:
+			continue
+		}
+		ks := strings.LastIndexByte(s[:i], ' ') + 1
+		if valStart >= 0 && ks <= valStart {
+			continue // no space since the last key, this is part of a value
+		}
+		k := s[ks:i]
+		if !validCEFKey(k) {
+			continue
+		}
+		if valStart >= 0 {
+			ef = append(ef, eventField{key, unescapeCEFValue(strings.TrimRight(s[valStart:ks], " "))})
+		}
+		key = k
+		valStart = i + 1
+	}
+	if valStart >= 0 {
+		ef = append(ef, eventField{key, unescapeCEFValue(strings.TrimRight(s[valStart:], " \r\n"))})
+	}
+	return ef
+}
+
+func validCEFKey(k string) bool {
+	if len(k) == 0 {
+		return false
+	}
+	for _, r := range k {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9':
+		case r == '_' || r == '.' || r == '-' || r == '[' || r == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type eventField struct {
+	name  string
+	value string
+}
+
+// eventFields is an ordered set of header and extension fields from a CEF or LEEF record,
+// header fields come first so they win over an extension field with the same name
+type eventFields []eventField
+
+func (ef eventFields) value(name string) (string, bool) {
+	for _, f := range ef {
+		if f.name == name {
+			return f.value, true
+		}
+	}
+	return ``, false
+}
+
+// Get implements the accessor interface for rendering route templates
+func (ef eventFields) Get(name string) interface{} {
+	if v, ok := ef.value(name); ok && v != `` {
+		return v
+	}
+	return nil
+}
+
+// eventConfig is the set of options shared by the CEF and LEEF preprocessors, the
+// exported config types convert directly to it
+type eventConfig struct {
+	Drop_Misses           bool
+	Attach                []string
+	Route_Template        string
+	Extract_Timestamp     bool
+	Assume_Local_Timezone bool
+	Timestamp_Override    string
+}
+
+func (c eventConfig) validate() (err error) {
+	for _, a := range c.Attach {
+		if strings.TrimSpace(a) == `` {
+			return errors.New("empty Attach field name")
+		}
+	}
+	if c.Route_Template != `` {
+		if _, err = newRouteFormatter(c.Route_Template); err != nil {
+			return
+		}
+	}
+	if ov := strings.TrimSpace(c.Timestamp_Override); ov != `` {
+		err = timegrinder.ValidateFormatOverride(ov)
+	}
+	return
+}
+
+// eventExtractor does the work for the CEF and LEEF preprocessors once a record is cracked
+type eventExtractor struct {
+	eventConfig
+	attachAll bool
+	tsField   string
+	tmp       *formatter // nil if we are not routing
+	routes    map[string]entry.EntryTag
+	tagger    Tagger
+	tg        *timegrinder.TimeGrinder // nil if we are not extracting timestamps
+}
+
+func newEventExtractor(cfg eventConfig, tsField string, tagger Tagger) (ex *eventExtractor, err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	ex = &eventExtractor{
+		eventConfig: cfg,
+		tsField:     tsField,
+		tagger:      tagger,
+	}
+	for _, a := range cfg.Attach {
+		if strings.TrimSpace(a) == attachAllFields {
+			ex.attachAll = true
+		}
+	}
+	if cfg.Route_Template != `` {
+		if tagger == nil {
+			return nil, errors.New("Route-Template requires a tagger")
+		} else if ex.tmp, err = newRouteFormatter(cfg.Route_Template); err != nil {
+			return nil, err
+		}
+		ex.routes = map[string]entry.EntryTag{}
+	}
+	if cfg.Extract_Timestamp {
+		if ex.tg, err = timegrinder.New(timegrinder.Config{FormatOverride: cfg.Timestamp_Override}); err != nil {
+			return nil, err
+		}
+		if cfg.Assume_Local_Timezone {
+			ex.tg.SetLocalTime()
+		}
+	}
+	return
+}
+
+func (ex *eventExtractor) process(ents []*entry.Entry, parse func([]byte) (eventFields, bool)) (rset []*entry.Entry) {
+	if len(ents) == 0 {
+		return
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		ef, ok := parse(ent.Data)
+		if !ok {
+			if !ex.Drop_Misses {
+				rset = append(rset, ent)
+			}
+			continue
+		}
+		if ex.tg != nil {
+			if v, ok := ef.value(ex.tsField); ok {
+				if ts, ok := ex.extractTime(v); ok {
+					ent.TS = entry.FromStandard(ts)
+				}
+			}
+		}
+		ex.attach(ent, ef)
+		if ex.tmp != nil {
+			if tag, err := routeTemplate(ex.tmp, ent, ef, ex.routes, ex.tagger); err == nil {
+				ent.Tag = tag
+			} else if ex.Drop_Misses {
+				continue
+			}
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+func (ex *eventExtractor) attach(ent *entry.Entry, ef eventFields) {
+	if ex.attachAll {
+		for _, f := range ef {
+			if f.value != `` {
+				ent.AddEnumeratedValueEx(f.name, f.value)
+			}
+		}
+		return
+	}
+	for _, name := range ex.Attach {
+		if v, ok := ef.value(name); ok && v != `` {
+			ent.AddEnumeratedValueEx(name, v)
+		}
+	}
+}
+
+// extractTime handles both epoch milliseconds, which is what most devices send, and
+// formatted timestamps
+func (ex *eventExtractor) extractTime(v string) (ts time.Time, ok bool) {
+	if v = strings.TrimSpace(v); v == `` {
+		return
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), true
+	}
+	var err error
+	if ts, ok, err = ex.tg.Extract([]byte(v)); err != nil {
+		ok = false
+	}
+	return
+}
@@ -0,0 +1,181 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type cefTest struct {
+	data   string
+	fields map[string]string
+}
+
+func TestCEFParse(t *testing.T) {
+	tests := []cefTest{
+		{
+			data: `Sep 19 08:26:10 host CEF:0|security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1 dst=2.1.2.2 spt=1232`,
+			fields: map[string]string{`Version`: `0`, `Vendor`: `security`, `Product`: `threatmanager`, `DeviceVersion`: `1.0`,
+				`SignatureID`: `100`, `Name`: `worm successfully stopped`, `Severity`: `10`,
+				`src`: `10.0.0.1`, `dst`: `2.1.2.2`, `spt`: `1232`},
+		},
+		{
+			data:   `CEF:0|security|threatmanager|1.0|100|detected a \| in message|10|src=10.0.0.1 act=blocked a \| dst=1.1.1.1`,
+			fields: map[string]string{`Name`: `detected a | in message`, `act`: `blocked a |`, `dst`: `1.1.1.1`},
+		},
+		{
+			data:   `CEF:0|security|threatmanager|1.0|100|detected a \\ in packet|10|src=10.0.0.1 act=blocked a \\ dst=1.1.1.1`,
+			fields: map[string]string{`Name`: `detected a \ in packet`, `act`: `blocked a \`, `dst`: `1.1.1.1`},
+		},
+		{
+			data:   `CEF:0|security|threatmanager|1.0|100|detected a = in message|10|src=10.0.0.1 act=blocked a \= dst=1.1.1.1`,
+			fields: map[string]string{`Name`: `detected a = in message`, `act`: `blocked a =`, `dst`: `1.1.1.1`},
+		},
+		{
+			data:   `CEF:1|vendor|product|2|sig|name|Low|msg=Detected a threat.\nNo action needed. request=http://x/?a=b&c=d cs1Label=my label`,
+			fields: map[string]string{`Version`: `1`, `Severity`: `Low`, `msg`: "Detected a threat.\nNo action needed.", `request`: `http://x/?a=b&c=d`, `cs1Label`: `my label`},
+		},
+		{
+			data:   `CEF:0|vendor|product|2|sig|name|5`,
+			fields: map[string]string{`Severity`: `5`},
+		},
+		{
+			data:   `CEF:0|vendor|product|2|sig|name|5| empty= next=1`,
+			fields: map[string]string{`empty`: ``, `next`: `1`},
+		},
+	}
+	for _, tst := range tests {
+		ef, ok := parseCEF([]byte(tst.data))
+		if !ok {
+			t.Fatalf("failed to parse %q", tst.data)
+		}
+		for k, v := range tst.fields {
+			if fv, ok := ef.value(k); !ok || fv != v {
+				t.Fatalf("bad value for %s in %q: %q != %q", k, tst.data, fv, v)
+			}
+		}
+	}
+
+	for _, bad := range []string{
+		`not cef`,
+		`CEF:0|vendor|product`,
+		`CEF:X|vendor|product|2|sig|name|5|src=1.1.1.1`,
+	} {
+		if _, ok := parseCEF([]byte(bad)); ok {
+			t.Fatalf("parsed bad record %q", bad)
+		}
+	}
+}
+
+func TestCEFConfig(t *testing.T) {
+	b := `
+	[preprocessor "cef"]
+		type = cef
+		Attach=src
+		Attach=Name
+		Route-Template="${Vendor}-${Product}"
+		Extract-Timestamp=true
+	`
+	p, err := testLoadPreprocessor(b, `cef`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp, ok := p.(*CEF); !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *CEF", p)
+	} else if len(cp.Attach) != 2 || !cp.Extract_Timestamp || cp.ex.tmp == nil || cp.ex.tg == nil {
+		t.Fatalf("bad config %+v", cp.CEFConfig)
+	}
+
+	b = `
+	[preprocessor "cef"]
+		type = cef
+		Route-Template="${Vendor}-!@"
+	`
+	if _, err = testLoadPreprocessor(b, `cef`); err == nil {
+		t.Fatal("failed to catch template with bad characters")
+	}
+}
+
+func TestCEFProcess(t *testing.T) {
+	var tagger testTagger
+	if _, err := tagger.NegotiateTag(`default`); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewCEF(CEFConfig{
+		Attach:            []string{`src`, `Name`, `missing`},
+		Route_Template:    `${Vendor}-${Product}`,
+		Extract_Timestamp: true,
+	}, &tagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ents := []*entry.Entry{
+		makeRawTestEntry(`CEF:0|Trend Micro|Deep Security Agent|10.0|4000000|Eicar_test_file|6|src=10.0.0.1 rt=1695139570123`),
+		makeRawTestEntry(`CEF:0|security|threatmanager|1.0|100|worm stopped|10|src=10.0.0.2 rt=Sep 19 2023 16:06:10`),
+		makeRawTestEntry(`not cef at all`),
+	}
+	set, err := p.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+
+	if tg, ok := tagger.mp[`Trend_Micro-Deep_Security_Agent`]; !ok || set[0].Tag != tg {
+		t.Fatalf("bad route %v", set[0].Tag)
+	} else if tg, ok := tagger.mp[`security-threatmanager`]; !ok || set[1].Tag != tg {
+		t.Fatalf("bad route %v", set[1].Tag)
+	} else if set[2].Tag != 0 {
+		t.Fatal("miss was routed")
+	}
+
+	if ts := set[0].TS.StandardTime(); !ts.Equal(time.UnixMilli(1695139570123)) {
+		t.Fatalf("bad epoch timestamp %v", ts)
+	} else if ts = set[1].TS.StandardTime(); !ts.Equal(time.Date(2023, 9, 19, 16, 6, 10, 0, time.UTC)) {
+		t.Fatalf("bad formatted timestamp %v", ts)
+	} else if set[2].TS != testTime {
+		t.Fatal("miss timestamp was modified")
+	}
+
+	if v, ok := set[0].GetEnumeratedValue(`src`); !ok || v != `10.0.0.1` {
+		t.Fatalf("bad src EV %v", v)
+	} else if v, ok = set[1].GetEnumeratedValue(`Name`); !ok || v != `worm stopped` {
+		t.Fatalf("bad Name EV %v", v)
+	} else if _, ok = set[0].GetEnumeratedValue(`rt`); ok {
+		t.Fatal("attached a field that was not requested")
+	} else if _, ok = set[0].GetEnumeratedValue(`missing`); ok {
+		t.Fatal("attached a missing field")
+	}
+}
+
+func TestCEFDropMissesAttachAll(t *testing.T) {
+	p, err := NewCEF(CEFConfig{Drop_Misses: true, Attach: []string{`*`}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		makeRawTestEntry(`not cef at all`),
+		makeRawTestEntry(`CEF:0|vendor|product|2|sig|name|5|src=1.1.1.1 dst=2.2.2.2`),
+	}
+	set, err := p.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	for _, k := range []string{`Version`, `Vendor`, `Product`, `DeviceVersion`, `SignatureID`, `Name`, `Severity`, `src`, `dst`} {
+		if _, ok := set[0].GetEnumeratedValue(k); !ok {
+			t.Fatalf("missing EV %s", k)
+		}
+	}
+}
@@ -124,8 +124,9 @@ type iseFormatter func(*entry.Entry, []glob.Glob, bool) bool
 
 type CiscoISE struct {
 	CiscoISEConfig
-	fmt iseFormatter
-	ma  *multipartAssembler
+	fmt     iseFormatter
+	ma      *multipartAssembler
+	dropped int // entries discarded since the last call to Dropped
 }
 
 func NewCiscoISEProcessor(cfg CiscoISEConfig) (ise *CiscoISE, err error) {
@@ -195,6 +196,7 @@ func (p *CiscoISE) processEnt(ent *entry.Entry) (r *entry.Entry, err error) {
 		//just attempt to reformat the entry
 		if !p.fmt(ent, p.filters, p.Attribute_Strip_Header) && p.Drop_Misses {
 			//bad formatting and no passthrough, just skip it
+			p.dropped++
 			return
 		}
 		r = ent
@@ -209,16 +211,22 @@ func (p *CiscoISE) processReassemble(ent *entry.Entry) (r *entry.Entry, err erro
 		err = nil // do not pass parsing errors up
 		if !p.Drop_Misses {
 			r = ent
+		} else {
+			p.dropped++
 		}
 	} else if msr, ejected, bad := p.ma.add(rmsg, ent); bad {
 		if !p.Drop_Misses {
 			r = ent
+		} else {
+			p.dropped++
 		}
 	} else if ejected {
 		if rent, ok := msr.meta.(*entry.Entry); ok {
 			rent.Data = []byte(msr.output)
 			if p.fmt(rent, p.filters, p.Attribute_Strip_Header) || !p.Drop_Misses {
 				r = rent
+			} else {
+				p.dropped++
 			}
 		}
 	}
@@ -233,6 +241,8 @@ func (p *CiscoISE) flush(force bool) (ents []*entry.Entry) {
 				rent.Data = []byte(out.output)
 				if p.fmt(rent, p.filters, p.Attribute_Strip_Header) || !p.Drop_Misses {
 					ents = append(ents, rent)
+				} else {
+					p.dropped++
 				}
 			}
 		}
@@ -245,6 +255,13 @@ func (p *CiscoISE) Flush() []*entry.Entry {
 	return p.flush(true)
 }
 
+// Dropped reports the entries discarded since the last call, message fragments held for
+// reassembly are not drops
+func (p *CiscoISE) Dropped() (n int) {
+	n, p.dropped = p.dropped, 0
+	return
+}
+
 func (p *CiscoISE) Close() (err error) {
 	return nil
 }
@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	DedupProcessor = `dedup`
+
+	dedupDefaultWindow     = time.Minute
+	dedupDefaultCountName  = `dedup_count`
+	dedupDefaultMaxTracked = 100000
+)
+
+// DedupConfig configures the deduplication preprocessor.  Entries are considered
+// identical when they share a tag and either their entire contents or their extracted
+// key match.  The first entry is held for Window and emitted with the number of times it
+// was seen once the window closes, entries without an extractable key pass through.
+type DedupConfig struct {
+	Window                 string // how long duplicates are suppressed, defaults to 1m
+	JSON_Path              string // deduplicate on the value at this JSON path
+	Regex                  string // deduplicate on a regular expression capture
+	Max_Tracked            int    // entries held at once, new keys pass through once full, defaults to 100000
+	Count_Enumerated_Value string // name of the enumerated value recording the count, defaults to dedup_count
+}
+
+func DedupLoadConfig(vc *config.VariableConfig) (c DedupConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+func (c DedupConfig) validate() (window time.Duration, err error) {
+	window = dedupDefaultWindow
+	if c.Window != `` {
+		if window, err = time.ParseDuration(c.Window); err != nil {
+			err = fmt.Errorf("Invalid Window %q: %w", c.Window, err)
+			return
+		} else if window <= 0 {
+			err = errors.New("Window must be greater than zero")
+			return
+		}
+	}
+	if c.Max_Tracked < 0 {
+		err = errors.New("Max-Tracked cannot be negative")
+		return
+	}
+	_, err = newValueSource(c.JSON_Path, c.Regex, ``)
+	return
+}
+
+func (c DedupConfig) maxTracked() int {
+	if c.Max_Tracked > 0 {
+		return c.Max_Tracked
+	}
+	return dedupDefaultMaxTracked
+}
+
+func (c DedupConfig) countName() string {
+	if c.Count_Enumerated_Value != `` {
+		return c.Count_Enumerated_Value
+	}
+	return dedupDefaultCountName
+}
+
+type dedupKey struct {
+	tag entry.EntryTag
+	key string
+}
+
+type dedupItem struct {
+	dedupKey
+	ent   *entry.Entry
+	start time.Time
+	count uint64
+}
+
+type Dedup struct {
+	nocloser
+	DedupConfig
+	src     valueSource
+	window  time.Duration
+	tracked map[dedupKey]*dedupItem
+	queue   []*dedupItem // ordered by window start
+	dropped int          // duplicates folded into a held entry since the last call to Dropped
+}
+
+func NewDedup(cfg DedupConfig) (d *Dedup, err error) {
+	d = &Dedup{
+		tracked: make(map[dedupKey]*dedupItem),
+	}
+	if err = d.init(cfg); err != nil {
+		d = nil
+	}
+	return
+}
+
+// init applies a configuration, entries that are already held are kept
+func (d *Dedup) init(cfg DedupConfig) (err error) {
+	var window time.Duration
+	var src valueSource
+	if window, err = cfg.validate(); err != nil {
+		return
+	} else if src, err = newValueSource(cfg.JSON_Path, cfg.Regex, ``); err != nil {
+		return
+	}
+	d.DedupConfig = cfg
+	d.window = window
+	d.src = src
+	return
+}
+
+func (d *Dedup) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(DedupConfig); ok {
+		err = d.init(cfg)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (d *Dedup) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	now := time.Now()
+	expired := d.expire(now)
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if d.hold(ent, now) {
+			continue
+		}
+		rset = append(rset, ent)
+	}
+	if len(expired) > 0 {
+		rset = append(expired, rset...)
+	}
+	return
+}
+
+// hold tracks the entry and reports if it was consumed
+func (d *Dedup) hold(ent *entry.Entry, now time.Time) bool {
+	k, ok := d.key(ent)
+	if !ok {
+		return false
+	}
+	if di, ok := d.tracked[k]; ok {
+		di.count++
+		d.dropped++
+		return true
+	} else if len(d.tracked) >= d.maxTracked() {
+		return false
+	}
+	di := &dedupItem{
+		dedupKey: k,
+		ent:      ent,
+		start:    now,
+		count:    1,
+	}
+	d.tracked[k] = di
+	d.queue = append(d.queue, di)
+	return true
+}
+
+func (d *Dedup) key(ent *entry.Entry) (k dedupKey, ok bool) {
+	k.tag = ent.Tag
+	if d.src.useSRC() {
+		h := fnv.New128a()
+		h.Write(ent.Data)
+		k.key, ok = string(h.Sum(nil)), true
+	} else {
+		var v []byte
+		if v, ok = d.src.value(ent); ok {
+			k.key = string(v)
+		}
+	}
+	return
+}
+
+// Expire releases every entry whose window has closed by now, a ProcessorSet calls it
+// periodically so held entries do not wait on new traffic
+func (d *Dedup) Expire(now time.Time) []*entry.Entry {
+	return d.expire(now)
+}
+
+// expireInterval is how often a ProcessorSet checks for closed windows, entries are
+// released at most a quarter of the window late and never more than a second late
+func (d *Dedup) expireInterval() (r time.Duration) {
+	if r = d.window / 4; r > time.Second {
+		r = time.Second
+	} else if r < time.Millisecond {
+		r = time.Millisecond
+	}
+	return
+}
+
+// Dropped reports the duplicates discarded since the last call, entries held for their
+// window are not drops
+func (d *Dedup) Dropped() (n int) {
+	n, d.dropped = d.dropped, 0
+	return
+}
+
+// expire releases every entry whose window has closed
+func (d *Dedup) expire(now time.Time) (ents []*entry.Entry) {
+	var i int
+	for ; i < len(d.queue); i++ {
+		if now.Sub(d.queue[i].start) < d.window {
+			break
+		}
+		ents = append(ents, d.release(d.queue[i]))
+		d.queue[i] = nil
+	}
+	if i > 0 {
+		d.queue = d.queue[i:]
+	}
+	return
+}
+
+func (d *Dedup) release(di *dedupItem) *entry.Entry {
+	delete(d.tracked, di.dedupKey)
+	di.ent.AddEnumeratedValueEx(d.countName(), di.count)
+	return di.ent
+}
+
+// Flush releases every held entry regardless of its window
+func (d *Dedup) Flush() (ents []*entry.Entry) {
+	for _, di := range d.queue {
+		ents = append(ents, d.release(di))
+	}
+	d.queue = nil
+	return
+}
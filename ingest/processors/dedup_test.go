@@ -0,0 +1,202 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestDedupConfig(t *testing.T) {
+	b := `
+	[preprocessor "dd"]
+		type = dedup
+		Window=30s
+		Regex="host=(\\S+)"
+		Max-Tracked=10
+	`
+	p, err := testLoadPreprocessor(b, `dd`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp, ok := p.(*Dedup)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *Dedup", p)
+	} else if dp.window != 30*time.Second || dp.maxTracked() != 10 || dp.src.rx == nil {
+		t.Fatalf("bad config %+v", dp.DedupConfig)
+	}
+	for _, c := range []DedupConfig{
+		{Window: `soon`},
+		{Window: `-1s`},
+		{Max_Tracked: -1},
+		{JSON_Path: `a`, Regex: `(b)`},
+	} {
+		if _, err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	d, err := NewDedup(DedupConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		makeEntry([]byte(`health check ok`), 0)[0],
+		makeEntry([]byte(`health check ok`), 0)[0],
+		makeEntry([]byte(`health check ok`), 1)[0], //different tag
+		makeEntry([]byte(`something else`), 0)[0],
+		makeEntry([]byte(`health check ok`), 0)[0],
+	}
+	set, err := d.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatalf("entries escaped before the window closed: %d", len(set))
+	}
+
+	//close the windows and make sure a new duplicate starts a fresh window
+	for _, di := range d.queue {
+		di.start = di.start.Add(-2 * dedupDefaultWindow)
+	}
+	if set, err = d.Process(makeEntry([]byte(`health check ok`), 0)); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("bad released count %d", len(set))
+	}
+	exp := []struct {
+		data  string
+		tag   entry.EntryTag
+		count uint64
+	}{
+		{`health check ok`, 0, 3},
+		{`health check ok`, 1, 1},
+		{`something else`, 0, 1},
+	}
+	for i, e := range exp {
+		if string(set[i].Data) != e.data || set[i].Tag != e.tag {
+			t.Fatalf("bad release %d: %q %d", i, set[i].Data, set[i].Tag)
+		} else if v, ok := set[i].GetEnumeratedValue(dedupDefaultCountName); !ok || v != e.count {
+			t.Fatalf("bad count on %d: %v != %d", i, v, e.count)
+		}
+	}
+
+	if set = d.Flush(); len(set) != 1 {
+		t.Fatalf("bad flush count %d", len(set))
+	} else if v, _ := set[0].GetEnumeratedValue(dedupDefaultCountName); v != uint64(1) {
+		t.Fatalf("bad flushed count %v", v)
+	} else if len(d.tracked) != 0 || len(d.queue) != 0 {
+		t.Fatal("flush left entries behind")
+	}
+}
+
+func TestDedupKeys(t *testing.T) {
+	d, err := NewDedup(DedupConfig{JSON_Path: `host`, Max_Tracked: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		makeRawTestEntry(`{"host":"a","n":1}`),
+		makeRawTestEntry(`{"host":"a","n":2}`),
+		makeRawTestEntry(`{"host":"b","n":1}`),
+		makeRawTestEntry(`{"host":"c","n":1}`), //over the limit
+		makeRawTestEntry(`not json`),           //no key
+	}
+	set, err := d.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 || string(set[0].Data) != `{"host":"c","n":1}` || string(set[1].Data) != `not json` {
+		t.Fatalf("bad pass through set %d", len(set))
+	}
+	if set = d.Flush(); len(set) != 2 || string(set[0].Data) != `{"host":"a","n":1}` {
+		t.Fatalf("bad flush %d", len(set))
+	} else if v, _ := set[0].GetEnumeratedValue(dedupDefaultCountName); v != uint64(2) {
+		t.Fatalf("bad count %v", v)
+	}
+}
+
+func TestDedupFlushOnClose(t *testing.T) {
+	b := []byte(`
+	[preprocessor "dd"]
+		type = dedup
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`dd`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = pr.Process(makeEntry([]byte("repeat"), 0)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tw.ents) != 0 {
+		t.Fatal("entries written before the window closed")
+	} else if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 1 {
+		t.Fatalf("bad write count on close %d", len(tw.ents))
+	} else if v, _ := tw.ents[0].GetEnumeratedValue(dedupDefaultCountName); v != uint64(5) {
+		t.Fatalf("bad count %v", v)
+	}
+}
+
+func TestDedupExpireIdle(t *testing.T) {
+	b := []byte(`
+	[preprocessor "dd"]
+		type = dedup
+		window = 20ms
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`dd`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = pr.Process(makeEntry([]byte("repeat"), 0)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// no further entries arrive, the window must still close and release the held entry
+	var cnt int
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		pr.Lock()
+		cnt = len(tw.ents)
+		pr.Unlock()
+		if cnt > 0 {
+			break
+		}
+	}
+	if cnt != 1 {
+		t.Fatalf("held entry was not released by the timer: %d", cnt)
+	} else if v, _ := tw.ents[0].GetEnumeratedValue(dedupDefaultCountName); v != uint64(3) {
+		t.Fatalf("bad count %v", v)
+	}
+	if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 1 {
+		t.Fatalf("entry released twice: %d", len(tw.ents))
+	}
+}
@@ -84,3 +84,113 @@ func TestDropper(t *testing.T) {
 		t.Fatalf("Drop did not drop: %d != 0", len(set))
 	}
 }
+
+type testTagWriter struct {
+	testTagger
+	testWriter
+}
+
+// WriteBatch tolerates the empty batches a ProcessorSet hands down when everything is dropped
+func (ttw *testTagWriter) WriteBatch(ents []*entry.Entry) error {
+	if len(ents) == 0 {
+		return nil
+	}
+	return ttw.testWriter.WriteBatch(ents)
+}
+
+func TestDropCounts(t *testing.T) {
+	b := []byte(`
+	[preprocessor "countedhole"]
+		type = drop
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`countedhole`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := DropCounts()[`countedhole`]
+	if err = pr.ProcessBatch(makeEntry([]byte("hello"), 0)); err != nil {
+		t.Fatal(err)
+	} else if err = pr.Process(makeEntry([]byte("hello"), 0)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if cnt := DropCounts()[`countedhole`] - before; cnt != 2 {
+		t.Fatalf("bad drop count %d", cnt)
+	} else if len(tw.ents) != 0 {
+		t.Fatalf("dropped entries were written")
+	}
+}
+
+func TestDropCountsHeld(t *testing.T) {
+	b := []byte(`
+	[preprocessor "counteddedup"]
+		type = dedup
+		window = 1h
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`counteddedup`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := DropCounts()[`counteddedup`]
+	// the first of each is held for the window, only the duplicates are drops
+	for _, v := range []string{`a`, `a`, `b`, `a`} {
+		if err = pr.Process(makeEntry([]byte(v), 0)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cnt := DropCounts()[`counteddedup`] - before; cnt != 2 {
+		t.Fatalf("bad drop count %d", cnt)
+	}
+	if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 2 {
+		t.Fatalf("held entries were not flushed: %d", len(tw.ents))
+	}
+}
+
+func TestDropCountsISE(t *testing.T) {
+	b := []byte(`
+	[preprocessor "countedise"]
+		type = cisco_ise
+		Enable-MultiPart-Reassembly=true
+		Passthrough-Misses=false
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`countedise`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := DropCounts()[`countedise`]
+	// fragments held for reassembly are not drops, only the garbage message is
+	for _, v := range append([]string{`not an ISE message`}, testdata...) {
+		if err = pr.Process(makeEntry([]byte(v), 0)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cnt := DropCounts()[`countedise`] - before; cnt != 1 {
+		t.Fatalf("bad drop count %d", cnt)
+	} else if len(tw.ents) != 1 {
+		t.Fatalf("reassembled entry was not written: %d", len(tw.ents))
+	} else if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,48 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// dropCounts maps preprocessor config names to the number of entries they have removed
+var dropCounts sync.Map // string -> *uint64
+
+// DropReporter is implemented by preprocessors that hold entries back or merge them, where
+// the change in the number of entries they return does not reflect what they discarded.
+// Dropped returns the number of entries discarded since the previous call.
+type DropReporter interface {
+	Dropped() int
+}
+
+func addDrops(name string, cnt int) {
+	if name == `` || cnt <= 0 {
+		return
+	}
+	v, ok := dropCounts.Load(name)
+	if !ok {
+		v, _ = dropCounts.LoadOrStore(name, new(uint64))
+	}
+	atomic.AddUint64(v.(*uint64), uint64(cnt))
+}
+
+// DropCounts returns the number of entries each named preprocessor has removed from the
+// stream since the process started.  Counts are keyed by the preprocessor config block
+// name and are summed across every ProcessorSet that uses the block.  Only preprocessors
+// created via ProcessorConfig.ProcessorSet are tracked.
+func DropCounts() map[string]uint64 {
+	r := map[string]uint64{}
+	dropCounts.Range(func(k, v interface{}) bool {
+		r[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return r
+}
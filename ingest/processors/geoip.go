@@ -0,0 +1,252 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	GeoIPProcessor = `geoip`
+
+	geoipDefaultLanguage = `en`
+	geoipRegexName       = `ip`
+	geoipReloadInterval  = 30 * time.Second
+)
+
+// GeoIPConfig configures the local GeoIP and ASN enrichment preprocessor.  IPs are taken
+// from the entry SRC unless JSON_Path or Regex is set.  If Regex has a capture group
+// named "ip" that group is used, otherwise the first capture group is.
+type GeoIPConfig struct {
+	City_Database string // MaxMind City or Country mmdb, provides country and city
+	ASN_Database  string // MaxMind ASN mmdb, provides asn and org
+	JSON_Path     string // pull the IP from this path in a JSON entry
+	Regex         string // pull the IP from a regular expression capture
+	Language      string // language for city names, defaults to en
+	Prefix        string // prepended to the attached enumerated value names, e.g. src_
+}
+
+func GeoIPLoadConfig(vc *config.VariableConfig) (c GeoIPConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c GeoIPConfig) validate() (err error) {
+	if c.City_Database == `` && c.ASN_Database == `` {
+		return errors.New("Missing City-Database and ASN-Database, at least one is required")
+	}
+	_, err = newValueSource(c.JSON_Path, c.Regex, geoipRegexName)
+	return
+}
+
+func (c GeoIPConfig) language() string {
+	if l := strings.TrimSpace(c.Language); l != `` {
+		return l
+	}
+	return geoipDefaultLanguage
+}
+
+type geoCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type geoASNRecord struct {
+	Number uint32 `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIP attaches country, city, asn, and org enumerated values to entries using local
+// mmdb files.  The files are checked for changes at most every 30 seconds and reloaded
+// when they change, a file that fails to load leaves the previous database in place.
+type GeoIP struct {
+	GeoIPConfig
+	city       *mmdbFile
+	asn        *mmdbFile
+	src        valueSource
+	lang       string
+	names      [4]string // country, city, asn, org
+	lastCheck  time.Time
+	reloadFreq time.Duration
+}
+
+func NewGeoIP(cfg GeoIPConfig) (g *GeoIP, err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	g = &GeoIP{
+		GeoIPConfig: cfg,
+		lang:        cfg.language(),
+		lastCheck:   time.Now(),
+		reloadFreq:  geoipReloadInterval,
+	}
+	if g.src, err = newValueSource(cfg.JSON_Path, cfg.Regex, geoipRegexName); err != nil {
+		return nil, err
+	}
+	for i, n := range []string{`country`, `city`, `asn`, `org`} {
+		g.names[i] = cfg.Prefix + n
+	}
+	if cfg.City_Database != `` {
+		if g.city, err = openMMDB(cfg.City_Database); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ASN_Database != `` {
+		if g.asn, err = openMMDB(cfg.ASN_Database); err != nil {
+			g.city.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+func (g *GeoIP) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(GeoIPConfig); ok {
+		var ng *GeoIP
+		if ng, err = NewGeoIP(cfg); err == nil {
+			g.Close()
+			*g = *ng
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (g *GeoIP) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return ents, nil
+	}
+	if time.Since(g.lastCheck) > g.reloadFreq {
+		g.city.reload()
+		g.asn.reload()
+		g.lastCheck = time.Now()
+	}
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		if ip := g.getIP(ent); ip != nil {
+			g.enrich(ent, ip)
+		}
+	}
+	return ents, nil
+}
+
+func (g *GeoIP) getIP(ent *entry.Entry) net.IP {
+	if g.src.useSRC() {
+		return ent.SRC
+	}
+	v, ok := g.src.value(ent)
+	if !ok {
+		return nil
+	}
+	return net.ParseIP(strings.TrimSpace(string(v)))
+}
+
+func (g *GeoIP) enrich(ent *entry.Entry, ip net.IP) {
+	if g.city != nil {
+		var rec geoCityRecord
+		if err := g.city.rdr.Lookup(ip, &rec); err == nil {
+			if rec.Country.ISOCode != `` {
+				ent.AddEnumeratedValueEx(g.names[0], rec.Country.ISOCode)
+			}
+			if name := rec.City.Names[g.lang]; name != `` {
+				ent.AddEnumeratedValueEx(g.names[1], name)
+			}
+		}
+	}
+	if g.asn != nil {
+		var rec geoASNRecord
+		if err := g.asn.rdr.Lookup(ip, &rec); err == nil {
+			if rec.Number != 0 {
+				ent.AddEnumeratedValueEx(g.names[2], rec.Number)
+			}
+			if rec.Org != `` {
+				ent.AddEnumeratedValueEx(g.names[3], rec.Org)
+			}
+		}
+	}
+}
+
+func (g *GeoIP) Flush() []*entry.Entry {
+	return nil
+}
+
+func (g *GeoIP) Close() (err error) {
+	if lerr := g.city.Close(); lerr != nil {
+		err = lerr
+	}
+	if lerr := g.asn.Close(); lerr != nil {
+		err = addError(lerr, err)
+	}
+	return
+}
+
+// mmdbFile is an open mmdb along with enough file state to tell when it changes
+type mmdbFile struct {
+	watchedFile
+	rdr *maxminddb.Reader
+}
+
+// openMMDB reads the entire database into memory rather than mapping it so that the
+// file can be rewritten in place without corrupting lookups
+func openMMDB(pth string) (m *mmdbFile, err error) {
+	var wf watchedFile
+	var buff []byte
+	var rdr *maxminddb.Reader
+	if wf, err = newWatchedFile(pth); err != nil {
+		return
+	} else if buff, err = os.ReadFile(pth); err != nil {
+		return
+	} else if rdr, err = maxminddb.FromBytes(buff); err != nil {
+		err = fmt.Errorf("failed to open %s %w", pth, err)
+		return
+	}
+	m = &mmdbFile{
+		watchedFile: wf,
+		rdr:         rdr,
+	}
+	return
+}
+
+// reload swaps in a new reader if the file changed, failures keep the existing reader
+func (m *mmdbFile) reload() {
+	if m == nil || !m.changed() {
+		return
+	}
+	if nm, err := openMMDB(m.path); err == nil {
+		m.rdr.Close()
+		*m = *nm
+	}
+}
+
+func (m *mmdbFile) Close() error {
+	if m == nil || m.rdr == nil {
+		return nil
+	}
+	return m.rdr.Close()
+}
@@ -0,0 +1,307 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	mmdbEmpty = iota
+	mmdbNode
+	mmdbData
+)
+
+type mmdbRecord struct {
+	kind int
+	val  int
+}
+
+type mmdbNetwork struct {
+	cidr string
+	data map[string]interface{}
+}
+
+// writeTestMMDB builds a minimal IPv4 MaxMind DB with 24 bit records
+func writeTestMMDB(t *testing.T, pth string, nets ...mmdbNetwork) {
+	nodes := [][2]mmdbRecord{{}}
+	data := bytes.NewBuffer(nil)
+	for _, n := range nets {
+		_, ipn, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := ipn.IP.To4()
+		bits, _ := ipn.Mask.Size()
+		off := data.Len()
+		mmdbEncode(data, n.data)
+		var cur int
+		for i := 0; i < bits; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == bits-1 {
+				nodes[cur][bit] = mmdbRecord{kind: mmdbData, val: off}
+			} else if nodes[cur][bit].kind == mmdbNode {
+				cur = nodes[cur][bit].val
+			} else {
+				nodes = append(nodes, [2]mmdbRecord{})
+				nodes[cur][bit] = mmdbRecord{kind: mmdbNode, val: len(nodes) - 1}
+				cur = len(nodes) - 1
+			}
+		}
+	}
+	bb := bytes.NewBuffer(nil)
+	cnt := len(nodes)
+	for _, n := range nodes {
+		for _, r := range n {
+			v := cnt // empty
+			switch r.kind {
+			case mmdbNode:
+				v = r.val
+			case mmdbData:
+				v = cnt + 16 + r.val
+			}
+			bb.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	bb.Write(make([]byte, 16))
+	bb.Write(data.Bytes())
+	bb.WriteString("\xAB\xCD\xEFMaxMind.com")
+	mmdbEncode(bb, map[string]interface{}{
+		`node_count`:                  uint32(cnt),
+		`record_size`:                 uint16(24),
+		`ip_version`:                  uint16(4),
+		`database_type`:               `Test`,
+		`binary_format_major_version`: uint16(2),
+		`binary_format_minor_version`: uint16(0),
+		`build_epoch`:                 uint64(time.Now().Unix()),
+	})
+	tmp := pth + `.tmp`
+	if err := os.WriteFile(tmp, bb.Bytes(), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Rename(tmp, pth); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mmdbControl(bb *bytes.Buffer, typ, size int) {
+	var sz []byte
+	if size >= 29 {
+		sz = []byte{byte(size - 29)}
+		size = 29
+	}
+	if typ <= 7 {
+		bb.WriteByte(byte(typ<<5 | size))
+	} else {
+		bb.WriteByte(byte(size))
+		bb.WriteByte(byte(typ - 7))
+	}
+	bb.Write(sz)
+}
+
+func mmdbEncode(bb *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case string:
+		mmdbControl(bb, 2, len(x))
+		bb.WriteString(x)
+	case uint16:
+		mmdbControl(bb, 5, 2)
+		binary.Write(bb, binary.BigEndian, x)
+	case uint32:
+		mmdbControl(bb, 6, 4)
+		binary.Write(bb, binary.BigEndian, x)
+	case uint64:
+		mmdbControl(bb, 9, 8)
+		binary.Write(bb, binary.BigEndian, x)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		mmdbControl(bb, 7, len(x))
+		for _, k := range keys {
+			mmdbEncode(bb, k)
+			mmdbEncode(bb, x[k])
+		}
+	default:
+		panic(fmt.Sprintf("unsupported mmdb type %T", v))
+	}
+}
+
+func cityRecord(country, city string) map[string]interface{} {
+	return map[string]interface{}{
+		`country`: map[string]interface{}{`iso_code`: country},
+		`city`:    map[string]interface{}{`names`: map[string]interface{}{`en`: city, `de`: city + `-de`}},
+	}
+}
+
+func asnRecord(asn uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		`autonomous_system_number`:       asn,
+		`autonomous_system_organization`: org,
+	}
+}
+
+func writeTestGeoDBs(t *testing.T) (city, asn string) {
+	dir := t.TempDir()
+	city, asn = filepath.Join(dir, `city.mmdb`), filepath.Join(dir, `asn.mmdb`)
+	writeTestMMDB(t, city,
+		mmdbNetwork{`1.2.3.0/24`, cityRecord(`US`, `Springfield`)},
+		mmdbNetwork{`10.0.0.0/8`, cityRecord(`CA`, `Ottawa`)},
+	)
+	writeTestMMDB(t, asn,
+		mmdbNetwork{`1.2.0.0/16`, asnRecord(64512, `Example Networks, Inc.`)},
+	)
+	return
+}
+
+func checkGeoEVs(t *testing.T, ent *entry.Entry, prefix string, exp map[string]interface{}) {
+	for _, n := range []string{`country`, `city`, `asn`, `org`} {
+		v, ok := ent.GetEnumeratedValue(prefix + n)
+		if ev, want := exp[n]; !want && ok {
+			t.Fatalf("unexpected %s%s = %v", prefix, n, v)
+		} else if want && (!ok || v != ev) {
+			t.Fatalf("bad %s%s: %v != %v", prefix, n, v, ev)
+		}
+	}
+}
+
+func TestGeoIPConfig(t *testing.T) {
+	city, asn := writeTestGeoDBs(t)
+	b := fmt.Sprintf(`
+	[preprocessor "geo"]
+		type = geoip
+		City-Database=%q
+		ASN-Database=%q
+		JSON-Path="src.ip"
+		Prefix=src_
+	`, city, asn)
+	p, err := testLoadPreprocessor(b, `geo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp, ok := p.(*GeoIP)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *GeoIP", p)
+	}
+	defer gp.Close()
+	if gp.Prefix != `src_` || len(gp.src.keys) != 2 {
+		t.Fatalf("bad config %+v", gp.GeoIPConfig)
+	}
+
+	bad := []GeoIPConfig{
+		{},
+		{City_Database: city, JSON_Path: `ip`, Regex: `(.*)`},
+		{City_Database: city, Regex: `no groups`},
+		{City_Database: city, Regex: `(`},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+	if _, err := NewGeoIP(GeoIPConfig{City_Database: filepath.Join(t.TempDir(), `missing.mmdb`)}); err == nil {
+		t.Fatal("opened a missing database")
+	}
+}
+
+func TestGeoIPSources(t *testing.T) {
+	city, asn := writeTestGeoDBs(t)
+	full := map[string]interface{}{`country`: `US`, `city`: `Springfield`, `asn`: uint32(64512), `org`: `Example Networks, Inc.`}
+
+	//pull from SRC
+	g, err := NewGeoIP(GeoIPConfig{City_Database: city, ASN_Database: asn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	ents := []*entry.Entry{
+		{SRC: net.ParseIP(`1.2.3.4`), Data: []byte(`hello`)},
+		{SRC: net.ParseIP(`10.1.1.1`), Data: []byte(`hello`)},
+		{SRC: net.ParseIP(`8.8.8.8`), Data: []byte(`hello`)},
+	}
+	if set, err := g.Process(ents); err != nil || len(set) != 3 {
+		t.Fatalf("bad process %d %v", len(set), err)
+	}
+	checkGeoEVs(t, ents[0], ``, full)
+	checkGeoEVs(t, ents[1], ``, map[string]interface{}{`country`: `CA`, `city`: `Ottawa`})
+	checkGeoEVs(t, ents[2], ``, nil)
+
+	//pull from a JSON path with a prefix and a different language
+	jg, err := NewGeoIP(GeoIPConfig{City_Database: city, ASN_Database: asn, JSON_Path: `dst.ip`, Prefix: `dst_`, Language: `de`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jg.Close()
+	ents = []*entry.Entry{
+		{SRC: net.ParseIP(`10.1.1.1`), Data: []byte(`{"dst":{"ip":"1.2.3.9"}}`)},
+		{SRC: net.ParseIP(`10.1.1.1`), Data: []byte(`{"dst":{"ip":"not an ip"}}`)},
+	}
+	jg.Process(ents)
+	checkGeoEVs(t, ents[0], `dst_`, map[string]interface{}{`country`: `US`, `city`: `Springfield-de`, `asn`: uint32(64512), `org`: `Example Networks, Inc.`})
+	checkGeoEVs(t, ents[1], `dst_`, nil)
+
+	//pull from a named regex capture
+	rg, err := NewGeoIP(GeoIPConfig{City_Database: city, ASN_Database: asn, Regex: `(\w+) ip=(?P<ip>\S+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rg.Close()
+	ents = []*entry.Entry{
+		{Data: []byte(`user ip=1.2.3.4 ok`)},
+		{Data: []byte(`no address here`)},
+	}
+	rg.Process(ents)
+	checkGeoEVs(t, ents[0], ``, full)
+	checkGeoEVs(t, ents[1], ``, nil)
+}
+
+func TestGeoIPReload(t *testing.T) {
+	city, _ := writeTestGeoDBs(t)
+	g, err := NewGeoIP(GeoIPConfig{City_Database: city})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.reloadFreq = 0
+
+	ent := &entry.Entry{SRC: net.ParseIP(`1.2.3.4`)}
+	g.Process([]*entry.Entry{ent})
+	checkGeoEVs(t, ent, ``, map[string]interface{}{`country`: `US`, `city`: `Springfield`})
+
+	//a broken file must not replace the working database
+	future := time.Now().Add(time.Minute)
+	if err = os.WriteFile(city, []byte(`garbage`), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(city, future, future); err != nil {
+		t.Fatal(err)
+	}
+	ent = &entry.Entry{SRC: net.ParseIP(`1.2.3.4`)}
+	g.Process([]*entry.Entry{ent})
+	checkGeoEVs(t, ent, ``, map[string]interface{}{`country`: `US`, `city`: `Springfield`})
+
+	writeTestMMDB(t, city, mmdbNetwork{`1.2.3.0/24`, cityRecord(`GB`, `London`)})
+	future = future.Add(time.Minute)
+	if err = os.Chtimes(city, future, future); err != nil {
+		t.Fatal(err)
+	}
+	ent = &entry.Entry{SRC: net.ParseIP(`1.2.3.4`)}
+	g.Process([]*entry.Entry{ent})
+	checkGeoEVs(t, ent, ``, map[string]interface{}{`country`: `GB`, `city`: `London`})
+}
@@ -0,0 +1,410 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	KVExtractProcessor = `kvextract`
+
+	kvDefaultPairDelim  = ` `
+	kvDefaultFieldDelim = `,`
+	kvDefaultKVDelim    = `=`
+	kvDefaultQuote      = `"`
+	kvDefaultEscape     = `\`
+
+	kvTypeString    = `string`
+	kvTypeInt       = `int`
+	kvTypeUint      = `uint`
+	kvTypeFloat     = `float`
+	kvTypeBool      = `bool`
+	kvTypeIP        = `ip`
+	kvTypeTimestamp = `timestamp`
+)
+
+// KVExtractConfig configures the key value extraction preprocessor.  By default entries
+// are parsed as key=value pairs, if Fields is set entries are instead split on
+// Pair_Delimiter and each field is named positionally.  Every extracted key that passes
+// the Allow and Deny lists is attached as an enumerated value, Convert entries in the form
+// key:type convert values to int, uint, float, bool, ip, or timestamp.  If Template is
+// set the entry is rewritten using ${key} references to the extracted values.
+type KVExtractConfig struct {
+	Pair_Delimiter        string   // separates pairs or fields, defaults to a space for pairs and a comma for fields
+	KV_Delimiter          string   // separates keys from values, defaults to =
+	Quote                 string   // characters that may quote a value, defaults to "
+	Escape                string   // escape character, defaults to \
+	Fields                []string // names for positional fields, enables delimited mode
+	Allow                 []string // only extract these keys
+	Deny                  []string // never extract these keys
+	Convert               []string // key:type conversions
+	Infer_Types           bool     // convert untyped values that look like integers, floats, or IPs
+	Template              string   // rewrite the entry, e.g. {"src":"${src}","msg":"${msg}"}
+	Drop_Misses           bool     // drop entries that yield no fields
+	Assume_Local_Timezone bool
+	Timestamp_Override    string
+}
+
+func KVExtractLoadConfig(vc *config.VariableConfig) (c KVExtractConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+// kvParams are the validated and parsed KVExtractConfig options
+type kvParams struct {
+	pairDelim string
+	kvDelim   string
+	quotes    string
+	escape    byte
+	allow     map[string]bool
+	deny      map[string]bool
+	types     map[string]string
+	tmp       *formatter
+	tg        *timegrinder.TimeGrinder
+}
+
+func (c KVExtractConfig) validate() (p kvParams, err error) {
+	p = kvParams{
+		pairDelim: c.Pair_Delimiter,
+		kvDelim:   c.KV_Delimiter,
+		quotes:    kvDefaultQuote,
+		escape:    kvDefaultEscape[0],
+	}
+	if p.pairDelim == `` {
+		if len(c.Fields) > 0 {
+			p.pairDelim = kvDefaultFieldDelim
+		} else {
+			p.pairDelim = kvDefaultPairDelim
+		}
+	}
+	if p.kvDelim == `` {
+		p.kvDelim = kvDefaultKVDelim
+	} else if len(c.Fields) > 0 {
+		err = errors.New("KV-Delimiter is not used when Fields are specified")
+		return
+	}
+	if p.kvDelim == p.pairDelim {
+		err = errors.New("KV-Delimiter and Pair-Delimiter must differ")
+		return
+	}
+	if c.Quote != `` {
+		p.quotes = c.Quote
+	}
+	if c.Escape != `` {
+		if len(c.Escape) != 1 {
+			err = fmt.Errorf("Invalid Escape %q, must be a single character", c.Escape)
+			return
+		}
+		p.escape = c.Escape[0]
+	}
+	if strings.ContainsAny(p.quotes, p.pairDelim+p.kvDelim) || strings.IndexByte(p.quotes, p.escape) != -1 {
+		err = errors.New("Quote characters cannot be delimiters or the escape character")
+		return
+	}
+	if len(c.Allow) > 0 && len(c.Deny) > 0 {
+		err = errors.New("Allow and Deny are mutually exclusive")
+		return
+	}
+	p.allow = stringSet(c.Allow)
+	p.deny = stringSet(c.Deny)
+
+	var needTG bool
+	p.types = make(map[string]string, len(c.Convert))
+	for _, t := range c.Convert {
+		k, typ, ok := strings.Cut(t, `:`)
+		k, typ = strings.TrimSpace(k), strings.TrimSpace(strings.ToLower(typ))
+		if !ok || k == `` {
+			err = fmt.Errorf("Invalid Convert %q, must be key:type", t)
+			return
+		}
+		switch typ {
+		case kvTypeString, kvTypeInt, kvTypeUint, kvTypeFloat, kvTypeBool, kvTypeIP:
+		case kvTypeTimestamp:
+			needTG = true
+		default:
+			err = fmt.Errorf("Invalid type %q for %s", typ, k)
+			return
+		}
+		p.types[k] = typ
+	}
+	if c.Template != `` {
+		if p.tmp, err = newFormatter(c.Template); err != nil {
+			return
+		}
+	}
+	if c.Timestamp_Override != `` {
+		if err = timegrinder.ValidateFormatOverride(c.Timestamp_Override); err != nil {
+			return
+		}
+	}
+	if needTG {
+		if p.tg, err = timegrinder.New(timegrinder.Config{FormatOverride: c.Timestamp_Override}); err != nil {
+			return
+		}
+		if c.Assume_Local_Timezone {
+			p.tg.SetLocalTime()
+		}
+	}
+	return
+}
+
+func stringSet(vals []string) (r map[string]bool) {
+	if len(vals) == 0 {
+		return
+	}
+	r = make(map[string]bool, len(vals))
+	for _, v := range vals {
+		r[strings.TrimSpace(v)] = true
+	}
+	return
+}
+
+type KVExtractor struct {
+	nocloser
+	KVExtractConfig
+	kvParams
+}
+
+func NewKVExtractor(cfg KVExtractConfig) (*KVExtractor, error) {
+	p, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &KVExtractor{
+		KVExtractConfig: cfg,
+		kvParams:        p,
+	}, nil
+}
+
+func (kv *KVExtractor) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(KVExtractConfig); ok {
+		var p kvParams
+		if p, err = cfg.validate(); err == nil {
+			kv.KVExtractConfig = cfg
+			kv.kvParams = p
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (kv *KVExtractor) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if ef := kv.parse(string(ent.Data)); len(ef) > 0 {
+			kv.attach(ent, ef)
+			if kv.tmp != nil {
+				ent.Data = []byte(kv.tmp.renderWithAccessor(ent, ef))
+			}
+		} else if kv.Drop_Misses {
+			continue
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+func (kv *KVExtractor) parse(s string) (ef eventFields) {
+	if len(kv.Fields) > 0 {
+		for i, v := range kv.split(s) {
+			if i >= len(kv.Fields) {
+				break
+			}
+			ef = kv.add(ef, kv.Fields[i], v)
+		}
+		return
+	}
+	for _, pair := range kv.split(s) {
+		if k, v, ok := kv.cutPair(pair); ok {
+			ef = kv.add(ef, k, v)
+		}
+	}
+	return
+}
+
+func (kv *KVExtractor) add(ef eventFields, k, v string) eventFields {
+	if k = strings.TrimSpace(k); k == `` {
+		return ef
+	} else if kv.allow != nil && !kv.allow[k] {
+		return ef
+	} else if kv.deny[k] {
+		return ef
+	}
+	return append(ef, eventField{k, v})
+}
+
+// split breaks s on the pair delimiter, quoted regions and escaped characters never
+// split.  Quotes and escapes are preserved so cutPair and unquote can interpret them.
+// A space delimiter treats runs of spaces as a single delimiter.
+func (kv *KVExtractor) split(s string) (r []string) {
+	var start int
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == kv.escape {
+			i++
+			continue
+		} else if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		} else if strings.IndexByte(kv.quotes, c) != -1 {
+			quote = c
+			continue
+		} else if strings.HasPrefix(s[i:], kv.pairDelim) {
+			r = kv.appendField(r, s[start:i])
+			i += len(kv.pairDelim) - 1
+			start = i + 1
+		}
+	}
+	return kv.appendField(r, s[start:])
+}
+
+func (kv *KVExtractor) appendField(r []string, v string) []string {
+	if kv.pairDelim == kvDefaultPairDelim && strings.TrimSpace(v) == `` {
+		return r //collapse runs of spaces
+	}
+	if len(kv.Fields) == 0 {
+		if v = strings.TrimSpace(v); v == `` {
+			return r
+		}
+	}
+	return append(r, kv.unquoteField(v))
+}
+
+// unquoteField unquotes fields in delimited mode, pairs are unquoted after they are cut
+func (kv *KVExtractor) unquoteField(v string) string {
+	if len(kv.Fields) == 0 {
+		return v
+	}
+	return kv.unquote(strings.TrimSpace(v))
+}
+
+// cutPair splits a pair on the first unquoted and unescaped key value delimiter
+func (kv *KVExtractor) cutPair(pair string) (k, v string, ok bool) {
+	for i := 0; i < len(pair); i++ {
+		c := pair[i]
+		if c == kv.escape {
+			i++
+		} else if strings.IndexByte(kv.quotes, c) != -1 {
+			return
+		} else if strings.HasPrefix(pair[i:], kv.kvDelim) {
+			k = kv.unquote(strings.TrimSpace(pair[:i]))
+			v = kv.unquote(strings.TrimSpace(pair[i+len(kv.kvDelim):]))
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// unquote strips a matching pair of surrounding quotes and resolves escapes
+func (kv *KVExtractor) unquote(v string) string {
+	if len(v) >= 2 && strings.IndexByte(kv.quotes, v[0]) != -1 && v[len(v)-1] == v[0] && v[len(v)-2] != kv.escape {
+		v = v[1 : len(v)-1]
+	}
+	if strings.IndexByte(v, kv.escape) == -1 {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == kv.escape && i+1 < len(v) {
+			i++
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
+
+func (kv *KVExtractor) attach(ent *entry.Entry, ef eventFields) {
+	for _, f := range ef {
+		if f.value == `` {
+			continue
+		}
+		ent.AddEnumeratedValueEx(f.name, kv.convert(f.name, f.value))
+	}
+}
+
+// convert applies the configured or inferred type, values that fail to convert are
+// attached as strings
+func (kv *KVExtractor) convert(k, v string) interface{} {
+	typ, ok := kv.types[k]
+	if !ok {
+		if kv.Infer_Types {
+			return inferKVValue(v)
+		}
+		return v
+	}
+	switch typ {
+	case kvTypeInt:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case kvTypeUint:
+		if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return u
+		}
+	case kvTypeFloat:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case kvTypeBool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	case kvTypeIP:
+		if ip := net.ParseIP(v); ip != nil {
+			return ip
+		}
+	case kvTypeTimestamp:
+		if ts, ok, err := kv.tg.Extract([]byte(v)); err == nil && ok {
+			return entry.FromStandard(ts)
+		}
+	}
+	return v
+}
+
+// inferKVValue only considers values that start like a number so that words such as
+// NaN and Inf stay strings
+func inferKVValue(v string) interface{} {
+	if d := strings.TrimLeft(v, `+-`); d == `` || d[0] < '0' || d[0] > '9' {
+		if ip := net.ParseIP(v); ip != nil {
+			return ip
+		}
+		return v
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return i
+	} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	} else if ip := net.ParseIP(v); ip != nil {
+		return ip
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestKVExtractConfig(t *testing.T) {
+	b := `
+	[preprocessor "kv"]
+		type = kvextract
+		Pair-Delimiter=";"
+		KV-Delimiter=":"
+		Deny=password
+		Convert="port:int"
+		Convert="ts:timestamp"
+		Template="{\"port\":${port}}"
+	`
+	p, err := testLoadPreprocessor(b, `kv`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp, ok := p.(*KVExtractor)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *KVExtractor", p)
+	} else if kp.pairDelim != `;` || kp.kvDelim != `:` || !kp.deny[`password`] || kp.types[`port`] != `int` || kp.tmp == nil || kp.tg == nil {
+		t.Fatalf("bad config %+v", kp.KVExtractConfig)
+	}
+
+	for _, c := range []KVExtractConfig{
+		{Pair_Delimiter: `=`},
+		{Fields: []string{`a`}, KV_Delimiter: `:`},
+		{Escape: `\\`},
+		{Quote: `=`},
+		{Allow: []string{`a`}, Deny: []string{`b`}},
+		{Convert: []string{`a`}},
+		{Convert: []string{`a:complex`}},
+		{Template: `${a`},
+	} {
+		if _, err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestKVExtractPairs(t *testing.T) {
+	kv, err := NewKVExtractor(KVExtractConfig{
+		Convert:     []string{`port:int`, `src:ip`, `ok:bool`, `ts:timestamp`, `bad:int`},
+		Infer_Types: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := makeRawTestEntry(`date=2024-01-02   src=10.0.0.1 port=443 msg="hello \"world\" a=b" ok=true bare ts="2023-09-19T16:06:10Z" ` +
+		`ratio=0.5 count=-12 name=NaN esc=a\ b bad=x empty= dst=::1`)
+	set, err := kv.Process([]*entry.Entry{ent})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	exp := map[string]interface{}{
+		`date`:  `2024-01-02`,
+		`src`:   net.ParseIP(`10.0.0.1`).To4(),
+		`port`:  int64(443),
+		`msg`:   `hello "world" a=b`,
+		`ok`:    true,
+		`ts`:    entry.FromStandard(time.Date(2023, 9, 19, 16, 6, 10, 0, time.UTC)),
+		`ratio`: 0.5,
+		`count`: int64(-12),
+		`name`:  `NaN`,
+		`esc`:   `a b`,
+		`bad`:   `x`,
+		`dst`:   net.ParseIP(`::1`),
+	}
+	for k, v := range exp {
+		ev, ok := ent.GetEnumeratedValue(k)
+		if !ok {
+			t.Fatalf("missing %s", k)
+		}
+		if ip, isIP := v.(net.IP); isIP {
+			if eip, ok := ev.(net.IP); !ok || !eip.Equal(ip) {
+				t.Fatalf("bad %s: %v != %v", k, ev, v)
+			}
+		} else if ev != v {
+			t.Fatalf("bad %s: %#v != %#v", k, ev, v)
+		}
+	}
+	for _, k := range []string{`bare`, `empty`, `a`} {
+		if _, ok := ent.GetEnumeratedValue(k); ok {
+			t.Fatalf("extracted %s", k)
+		}
+	}
+}
+
+func TestKVExtractFields(t *testing.T) {
+	kv, err := NewKVExtractor(KVExtractConfig{
+		Fields:   []string{`time`, `host`, `action`, `msg`},
+		Allow:    []string{`host`, `action`, `msg`},
+		Template: `{"host":"${host}","action":"${action}","msg":"${msg}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		makeRawTestEntry(`12:00,fw1,,"blocked, then logged",extra`),
+		makeRawTestEntry(`12:01,fw2,allow`),
+	}
+	set, err := kv.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	if exp := `{"host":"fw1","action":"","msg":"blocked, then logged"}`; string(set[0].Data) != exp {
+		t.Fatalf("bad template\n%s\n%s", set[0].Data, exp)
+	} else if exp = `{"host":"fw2","action":"allow","msg":""}`; string(set[1].Data) != exp {
+		t.Fatalf("bad template\n%s\n%s", set[1].Data, exp)
+	} else if _, ok := set[0].GetEnumeratedValue(`time`); ok {
+		t.Fatal("extracted a field that was not allowed")
+	}
+
+	//drop entries that produce nothing
+	dkv, err := NewKVExtractor(KVExtractConfig{Drop_Misses: true, Pair_Delimiter: "\t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents = []*entry.Entry{
+		makeRawTestEntry("a=1\tb=two words"),
+		makeRawTestEntry(`nothing to see here`),
+	}
+	if set, err = dkv.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 {
+		t.Fatalf("bad result count %d", len(set))
+	} else if v, ok := set[0].GetEnumeratedValue(`b`); !ok || v != `two words` {
+		t.Fatalf("bad tab delimited value %v", v)
+	}
+}
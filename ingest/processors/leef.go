@@ -0,0 +1,150 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	LEEFProcessor = `leef`
+
+	leefPrefix       = `LEEF:`
+	leefHeaderFields = 5 // version, vendor, product, version, event id
+	leefTimeField    = `devTime`
+	leefDefaultDelim = "\t"
+)
+
+// LEEFConfig configures the IBM Log Event Extended Format preprocessor.  Header fields
+// are named Version, Vendor, Product, DeviceVersion, and EventID, attributes keep the
+// keys they were sent with.  Both LEEF 1.0 and 2.0 are supported, including the 2.0
+// custom attribute delimiter.
+type LEEFConfig struct {
+	Drop_Misses           bool     // drop entries that are not valid LEEF
+	Attach                []string // fields to attach as enumerated values, * attaches every field
+	Route_Template        string   // optional tag template, e.g. ${Vendor}-${Product}
+	Extract_Timestamp     bool     // set the entry timestamp from the devTime attribute
+	Assume_Local_Timezone bool
+	Timestamp_Override    string
+}
+
+func LEEFLoadConfig(vc *config.VariableConfig) (c LEEFConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c LEEFConfig) validate() error {
+	return eventConfig(c).validate()
+}
+
+type LEEF struct {
+	nocloser
+	LEEFConfig
+	ex *eventExtractor
+}
+
+func NewLEEF(cfg LEEFConfig, tagger Tagger) (*LEEF, error) {
+	ex, err := newEventExtractor(eventConfig(cfg), leefTimeField, tagger)
+	if err != nil {
+		return nil, err
+	}
+	return &LEEF{
+		LEEFConfig: cfg,
+		ex:         ex,
+	}, nil
+}
+
+func (l *LEEF) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(LEEFConfig); ok {
+		var ex *eventExtractor
+		if ex, err = newEventExtractor(eventConfig(cfg), leefTimeField, l.ex.tagger); err == nil {
+			l.LEEFConfig = cfg
+			l.ex = ex
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (l *LEEF) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	return l.ex.process(ents, parseLEEF), nil
+}
+
+// parseLEEF cracks a LEEF record, anything in front of the LEEF: marker such as a syslog
+// header is ignored
+func parseLEEF(data []byte) (ef eventFields, ok bool) {
+	idx := bytes.Index(data, []byte(leefPrefix))
+	if idx == -1 {
+		return
+	}
+	hdr, attrs, ok := splitEventHeader(string(data[idx+len(leefPrefix):]), leefHeaderFields)
+	if !ok {
+		return
+	}
+	ver := strings.TrimSpace(hdr[0])
+	if _, err := strconv.ParseFloat(ver, 64); err != nil {
+		ok = false
+		return
+	}
+	delim := leefDefaultDelim
+	if !strings.HasPrefix(ver, `1`) {
+		delim, attrs = leefDelimiter(attrs)
+	}
+	ef = eventFields{
+		{`Version`, ver},
+		{`Vendor`, hdr[1]},
+		{`Product`, hdr[2]},
+		{`DeviceVersion`, hdr[3]},
+		{`EventID`, hdr[4]},
+	}
+	for _, attr := range strings.Split(strings.TrimRight(attrs, "\r\n"), delim) {
+		if k, v, found := strings.Cut(attr, `=`); found {
+			if k = strings.TrimSpace(k); k != `` {
+				ef = append(ef, eventField{k, v})
+			}
+		}
+	}
+	return
+}
+
+// leefDelimiter pulls the optional LEEF 2.0 delimiter field off the front of the
+// attributes.  The delimiter is either a single character or a hex value such as
+// x09 or 0x5E, if the field is missing attributes are tab delimited.
+func leefDelimiter(s string) (delim, attrs string) {
+	if strings.HasPrefix(s, `||`) {
+		return `|`, s[2:]
+	}
+	idx := strings.IndexByte(s, '|')
+	if idx == -1 {
+		return leefDefaultDelim, s
+	}
+	spec := s[:idx]
+	if len(spec) == 1 {
+		return spec, s[idx+1:]
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(spec), `0`), `x`)
+	if len(hex) > 0 && len(hex) <= 4 && len(hex) < len(spec) {
+		if v, err := strconv.ParseUint(hex, 16, 16); err == nil && v > 0 {
+			return string(rune(v)), s[idx+1:]
+		}
+	}
+	// not a delimiter spec, the pipe is part of an attribute
+	return leefDefaultDelim, s
+}
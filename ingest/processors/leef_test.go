@@ -0,0 +1,115 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestLEEFParse(t *testing.T) {
+	tests := []cefTest{
+		{
+			data: "Jan 18 11:07:53 host LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdst=172.50.123.1\tsev=5\tcat=anomaly\tmsg=this is a message",
+			fields: map[string]string{`Version`: `1.0`, `Vendor`: `Microsoft`, `Product`: `MSExchange`, `DeviceVersion`: `4.0 SP1`,
+				`EventID`: `15345`, `src`: `192.0.2.0`, `dst`: `172.50.123.1`, `msg`: `this is a message`},
+		},
+		{
+			data:   `LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^sev=5^url=http://x/?a=b`,
+			fields: map[string]string{`Version`: `2.0`, `EventID`: `41`, `src`: `10.0.1.8`, `dst`: `10.0.0.5`, `url`: `http://x/?a=b`},
+		},
+		{
+			data:   `LEEF:2.0|Lancope|StealthWatch|1.0|41|x5E|src=10.0.1.8^dst=10.0.0.5`,
+			fields: map[string]string{`src`: `10.0.1.8`, `dst`: `10.0.0.5`},
+		},
+		{
+			data:   `LEEF:2.0|Lancope|StealthWatch|1.0|41|0x7C|src=10.0.1.8|dst=10.0.0.5`,
+			fields: map[string]string{`src`: `10.0.1.8`, `dst`: `10.0.0.5`},
+		},
+		{
+			data:   "LEEF:2.0|Lancope|StealthWatch|1.0|41|src=10.0.1.8\tdst=10.0.0.5",
+			fields: map[string]string{`src`: `10.0.1.8`, `dst`: `10.0.0.5`},
+		},
+		{
+			data:   `LEEF:2.0|Lancope|StealthWatch|1.0|41|||src=10.0.1.8|dst=10.0.0.5`,
+			fields: map[string]string{`src`: `10.0.1.8`, `dst`: `10.0.0.5`},
+		},
+	}
+	for _, tst := range tests {
+		ef, ok := parseLEEF([]byte(tst.data))
+		if !ok {
+			t.Fatalf("failed to parse %q", tst.data)
+		}
+		for k, v := range tst.fields {
+			if fv, ok := ef.value(k); !ok || fv != v {
+				t.Fatalf("bad value for %s in %q: %q != %q", k, tst.data, fv, v)
+			}
+		}
+	}
+
+	for _, bad := range []string{
+		`not leef`,
+		`LEEF:1.0|vendor|product`,
+		`LEEF:abc|vendor|product|1.0|41|src=1.1.1.1`,
+	} {
+		if _, ok := parseLEEF([]byte(bad)); ok {
+			t.Fatalf("parsed bad record %q", bad)
+		}
+	}
+}
+
+func TestLEEFProcess(t *testing.T) {
+	b := `
+	[preprocessor "leef"]
+		type = leef
+		Attach="*"
+		Route-Template="${Vendor}"
+		Extract-Timestamp=true
+		Drop-Misses=true
+	`
+	p, err := testLoadPreprocessor(b, `leef`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, ok := p.(*LEEF)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *LEEF", p)
+	}
+	var tagger testTagger
+	lp.ex.tagger = &tagger
+
+	ents := []*entry.Entry{
+		makeRawTestEntry("LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.0\tdevTime=1695139570123"),
+		makeRawTestEntry("LEEF:2.0|IBM|QRadar|7.5|100|^|src=10.0.0.1^devTime=Sep 19 2023 16:06:10^devTimeFormat=MMM dd yyyy HH:mm:ss"),
+		makeRawTestEntry(`not leef`),
+	}
+	set, err := lp.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	if tg, ok := tagger.mp[`Microsoft`]; !ok || set[0].Tag != tg {
+		t.Fatalf("bad route %v", set[0].Tag)
+	} else if tg, ok := tagger.mp[`IBM`]; !ok || set[1].Tag != tg {
+		t.Fatalf("bad route %v", set[1].Tag)
+	}
+	if ts := set[0].TS.StandardTime(); !ts.Equal(time.UnixMilli(1695139570123)) {
+		t.Fatalf("bad epoch timestamp %v", ts)
+	} else if ts = set[1].TS.StandardTime(); !ts.Equal(time.Date(2023, 9, 19, 16, 6, 10, 0, time.UTC)) {
+		t.Fatalf("bad formatted timestamp %v", ts)
+	}
+	if v, ok := set[1].GetEnumeratedValue(`EventID`); !ok || v != `100` {
+		t.Fatalf("bad EventID EV %v", v)
+	} else if v, ok = set[0].GetEnumeratedValue(`src`); !ok || v != `192.0.2.0` {
+		t.Fatalf("bad src EV %v", v)
+	}
+}
@@ -0,0 +1,350 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	LookupProcessor = `lookup`
+
+	lookupTableCSV       = `csv`
+	lookupTableIPExist   = `ipexist`
+	lookupRegexName      = `key`
+	lookupAttachAll      = `*`
+	lookupReloadInterval = 30 * time.Second
+)
+
+// LookupConfig configures the lookup table preprocessor.  Keys are taken from the entry
+// SRC unless JSON_Path or Regex is set.  If Regex has a capture group named "key" that
+// group is used, otherwise the first capture group is.
+type LookupConfig struct {
+	Table_Type   string   // csv or ipexist, defaults to csv
+	Table        string   // path to the table
+	Key_Column   string   // CSV column to match keys against, defaults to the first column
+	Attach       []string // CSV columns to attach as enumerated values, * attaches every non-key column
+	Prefix       string   // prepended to the attached enumerated value names
+	JSON_Path    string   // pull the key from this path in a JSON entry
+	Regex        string   // pull the key from a regular expression capture
+	Match_Tag    string   // retag entries that match the table
+	Drop_Matches bool     // drop entries that match the table
+	Drop_Misses  bool     // drop entries that do not match the table
+}
+
+func LookupLoadConfig(vc *config.VariableConfig) (c LookupConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c LookupConfig) tableType() string {
+	if tt := strings.TrimSpace(strings.ToLower(c.Table_Type)); tt != `` {
+		return tt
+	}
+	return lookupTableCSV
+}
+
+func (c LookupConfig) validate() (err error) {
+	if c.Table == `` {
+		return errors.New("Missing Table")
+	}
+	switch c.tableType() {
+	case lookupTableCSV:
+	case lookupTableIPExist:
+		if len(c.Attach) > 0 || c.Key_Column != `` {
+			return errors.New("Attach and Key-Column are not supported on ipexist tables")
+		}
+	default:
+		return fmt.Errorf("Invalid Table-Type %q", c.Table_Type)
+	}
+	if c.Drop_Matches && c.Drop_Misses {
+		return errors.New("Drop-Matches and Drop-Misses are mutually exclusive")
+	}
+	if c.Match_Tag != `` {
+		if err = ingest.CheckTag(c.Match_Tag); err != nil {
+			return fmt.Errorf("Invalid Match-Tag %q: %w", c.Match_Tag, err)
+		}
+	}
+	_, err = newValueSource(c.JSON_Path, c.Regex, lookupRegexName)
+	return
+}
+
+// lookupTable is a loaded table, values returned on a match line up with columns
+type lookupTable interface {
+	lookup(key string) ([]string, bool)
+	columns() []string
+	Close() error
+}
+
+// Lookup joins a key extracted from each entry against a local table.  Matching entries
+// can be enriched, retagged, or dropped.  The table is checked for changes at most every
+// 30 seconds and reloaded when it changes, a table that fails to load leaves the
+// previous table in place.
+type Lookup struct {
+	LookupConfig
+	tagger     Tagger
+	tbl        lookupTable
+	file       watchedFile
+	src        valueSource
+	names      []string
+	tag        entry.EntryTag
+	retag      bool
+	lastCheck  time.Time
+	reloadFreq time.Duration
+}
+
+func NewLookup(cfg LookupConfig, tagger Tagger) (l *Lookup, err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	l = &Lookup{
+		LookupConfig: cfg,
+		tagger:       tagger,
+		lastCheck:    time.Now(),
+		reloadFreq:   lookupReloadInterval,
+	}
+	if l.src, err = newValueSource(cfg.JSON_Path, cfg.Regex, lookupRegexName); err != nil {
+		return nil, err
+	}
+	if cfg.Match_Tag != `` {
+		if tagger == nil {
+			return nil, errors.New("Match-Tag requires a tagger")
+		} else if l.tag, err = tagger.NegotiateTag(cfg.Match_Tag); err != nil {
+			return nil, fmt.Errorf("Failed to negotiate tag %s: %w", cfg.Match_Tag, err)
+		}
+		l.retag = true
+	}
+	if l.file, err = newWatchedFile(cfg.Table); err != nil {
+		return nil, err
+	} else if err = l.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (l *Lookup) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(LookupConfig); ok {
+		var nl *Lookup
+		if nl, err = NewLookup(cfg, l.tagger); err == nil {
+			l.Close()
+			*l = *nl
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+// load opens the table and swaps it in, on failure the current table is untouched
+func (l *Lookup) load() (err error) {
+	var tbl lookupTable
+	switch l.tableType() {
+	case lookupTableIPExist:
+		tbl, err = openIPExistTable(l.Table)
+	default:
+		tbl, err = openCSVTable(l.Table, l.Key_Column, l.Attach)
+	}
+	if err != nil {
+		return
+	}
+	if l.tbl != nil {
+		l.tbl.Close()
+	}
+	l.tbl = tbl
+	l.names = l.names[:0]
+	for _, c := range tbl.columns() {
+		l.names = append(l.names, l.Prefix+c)
+	}
+	return
+}
+
+func (l *Lookup) reload() {
+	if !l.file.changed() {
+		return
+	}
+	if wf, err := newWatchedFile(l.Table); err == nil {
+		//remember the new file state even if the load fails so we don't retry a broken file every pass
+		l.file = wf
+		l.load()
+	}
+}
+
+func (l *Lookup) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	if time.Since(l.lastCheck) > l.reloadFreq {
+		l.reload()
+		l.lastCheck = time.Now()
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if l.processItem(ent) {
+			rset = append(rset, ent)
+		}
+	}
+	return
+}
+
+// processItem enriches or retags the entry and reports if it should be kept
+func (l *Lookup) processItem(ent *entry.Entry) bool {
+	key, ok := l.getKey(ent)
+	if !ok {
+		return !l.Drop_Misses
+	}
+	vals, ok := l.tbl.lookup(key)
+	if !ok {
+		return !l.Drop_Misses
+	} else if l.Drop_Matches {
+		return false
+	}
+	for i, v := range vals {
+		if v != `` {
+			ent.AddEnumeratedValueEx(l.names[i], v)
+		}
+	}
+	if l.retag {
+		ent.Tag = l.tag
+	}
+	return true
+}
+
+func (l *Lookup) getKey(ent *entry.Entry) (string, bool) {
+	if l.src.useSRC() {
+		if ent.SRC == nil {
+			return ``, false
+		}
+		return ent.SRC.String(), true
+	}
+	v, ok := l.src.value(ent)
+	if !ok {
+		return ``, false
+	}
+	return strings.TrimSpace(string(v)), true
+}
+
+func (l *Lookup) Flush() []*entry.Entry {
+	return nil
+}
+
+func (l *Lookup) Close() (err error) {
+	if l.tbl != nil {
+		err = l.tbl.Close()
+		l.tbl = nil
+	}
+	return
+}
+
+// csvTable maps trimmed key column values to the attached columns of each row, when a
+// key appears more than once the last row wins
+type csvTable struct {
+	cols []string
+	rows map[string][]string
+}
+
+// openCSVTable loads a CSV file with a header row, keyCol and attach refer to header names
+func openCSVTable(pth, keyCol string, attach []string) (ct *csvTable, err error) {
+	var fin *os.File
+	if fin, err = os.Open(pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	rdr := csv.NewReader(fin)
+	rdr.FieldsPerRecord = -1
+	rdr.TrimLeadingSpace = true
+	var hdr []string
+	if hdr, err = rdr.Read(); err != nil {
+		err = fmt.Errorf("failed to read header from %s: %w", pth, err)
+		return
+	}
+	for i := range hdr {
+		hdr[i] = strings.TrimSpace(hdr[i])
+	}
+	keyIdx := 0
+	if keyCol != `` {
+		if keyIdx = slices.Index(hdr, keyCol); keyIdx == -1 {
+			err = fmt.Errorf("Key-Column %q is not in %s", keyCol, pth)
+			return
+		}
+	}
+	ct = &csvTable{
+		rows: make(map[string][]string),
+	}
+	var idxs []int
+	for _, a := range attach {
+		if a == lookupAttachAll {
+			idxs = idxs[:0]
+			ct.cols = ct.cols[:0]
+			for i, h := range hdr {
+				if i != keyIdx {
+					idxs = append(idxs, i)
+					ct.cols = append(ct.cols, h)
+				}
+			}
+			break
+		}
+		idx := slices.Index(hdr, a)
+		if idx == -1 {
+			err = fmt.Errorf("Attach column %q is not in %s", a, pth)
+			return nil, err
+		}
+		idxs = append(idxs, idx)
+		ct.cols = append(ct.cols, a)
+	}
+	for {
+		var rec []string
+		if rec, err = rdr.Read(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pth, err)
+		} else if keyIdx >= len(rec) {
+			continue
+		}
+		vals := make([]string, len(idxs))
+		for i, idx := range idxs {
+			if idx < len(rec) {
+				vals[i] = rec[idx]
+			}
+		}
+		ct.rows[strings.TrimSpace(rec[keyIdx])] = vals
+	}
+	return
+}
+
+func (ct *csvTable) lookup(key string) (vals []string, ok bool) {
+	vals, ok = ct.rows[key]
+	return
+}
+
+func (ct *csvTable) columns() []string {
+	return ct.cols
+}
+
+func (ct *csvTable) Close() error {
+	ct.rows = nil
+	return nil
+}
@@ -0,0 +1,52 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/gravwell/gravwell/v3/ipexist"
+)
+
+// ipexistTable is an ipexist set decoded into memory, it has no columns to attach
+type ipexistTable struct {
+	bm *ipexist.IpBitMap
+}
+
+func openIPExistTable(pth string) (it *ipexistTable, err error) {
+	var fin *os.File
+	if fin, err = os.Open(pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	var bm *ipexist.IpBitMap
+	if bm, err = ipexist.LoadIPBitMap(fin); err != nil {
+		err = fmt.Errorf("failed to load ipexist set %s: %w", pth, err)
+		return
+	}
+	it = &ipexistTable{bm: bm}
+	return
+}
+
+func (it *ipexistTable) lookup(key string) (vals []string, ok bool) {
+	if ip := net.ParseIP(key); ip != nil {
+		ok, _ = it.bm.IPExists(ip)
+	}
+	return
+}
+
+func (it *ipexistTable) columns() []string {
+	return nil
+}
+
+func (it *ipexistTable) Close() error {
+	return it.bm.Close()
+}
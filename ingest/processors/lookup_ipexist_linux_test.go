@@ -0,0 +1,67 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ipexist"
+)
+
+func TestLookupIPExist(t *testing.T) {
+	bm := ipexist.NewIPBitMap()
+	if err := bm.AddIP(net.ParseIP(`192.168.1.1`)); err != nil {
+		t.Fatal(err)
+	}
+	_, cidr, _ := net.ParseCIDR(`10.1.0.0/16`)
+	if err := bm.AddCIDR(cidr); err != nil {
+		t.Fatal(err)
+	}
+	pth := filepath.Join(t.TempDir(), `bad.ipexist`)
+	fout, err := os.Create(pth)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = bm.Encode(fout); err != nil {
+		t.Fatal(err)
+	} else if err = fout.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tagger testTagger
+	if _, err = tagger.NegotiateTag(`default`); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLookup(LookupConfig{Table: pth, Table_Type: `ipexist`, JSON_Path: `src`, Match_Tag: `badips`}, &tagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ents := []*entry.Entry{
+		makeRawTestEntry(`{"src":"192.168.1.1"}`),
+		makeRawTestEntry(`{"src":"10.1.200.3"}`),
+		makeRawTestEntry(`{"src":"8.8.8.8"}`),
+		makeRawTestEntry(`{"src":"not an ip"}`),
+	}
+	set, err := l.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 4 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	tg := tagger.mp[`badips`]
+	for i, exp := range []bool{true, true, false, false} {
+		if (set[i].Tag == tg) != exp {
+			t.Fatalf("bad tag on %s: %v", set[i].Data, set[i].Tag)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+)
+
+var ErrIPExistUnsupported = errors.New("ipexist lookup tables are only supported on Linux")
+
+func openIPExistTable(pth string) (lookupTable, error) {
+	return nil, ErrIPExistUnsupported
+}
@@ -0,0 +1,193 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const testAssetTable = `ip,owner,site,notes
+10.0.0.1, alice,hq,
+10.0.0.2,bob,"branch, east",laptop
+"  10.0.0.3 ",carol
+`
+
+func writeTestTable(t *testing.T, data string) string {
+	pth := filepath.Join(t.TempDir(), `table.csv`)
+	if err := os.WriteFile(pth, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
+
+func TestLookupConfig(t *testing.T) {
+	pth := writeTestTable(t, testAssetTable)
+	b := fmt.Sprintf(`
+	[preprocessor "lk"]
+		type = lookup
+		Table=%q
+		Key-Column=ip
+		Attach=owner
+		Attach=site
+		JSON-Path="src.ip"
+		Match-Tag=assets
+	`, pth)
+	p, err := testLoadPreprocessor(b, `lk`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, ok := p.(*Lookup)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *Lookup", p)
+	}
+	defer lp.Close()
+	if len(lp.Attach) != 2 || lp.Match_Tag != `assets` || !lp.retag || len(lp.names) != 2 {
+		t.Fatalf("bad config %+v", lp.LookupConfig)
+	}
+
+	bad := []LookupConfig{
+		{},
+		{Table: pth, Table_Type: `sqlite`},
+		{Table: pth, Table_Type: `ipexist`, Attach: []string{`owner`}},
+		{Table: pth, Drop_Matches: true, Drop_Misses: true},
+		{Table: pth, Match_Tag: `bad tag`},
+		{Table: pth, JSON_Path: `ip`, Regex: `(.*)`},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+	if _, err = NewLookup(LookupConfig{Table: pth, Attach: []string{`missing`}}, nil); err == nil {
+		t.Fatal("attached a missing column")
+	} else if _, err = NewLookup(LookupConfig{Table: pth, Key_Column: `missing`}, nil); err == nil {
+		t.Fatal("used a missing key column")
+	} else if _, err = NewLookup(LookupConfig{Table: filepath.Join(t.TempDir(), `missing.csv`)}, nil); err == nil {
+		t.Fatal("opened a missing table")
+	}
+}
+
+func TestLookupAttach(t *testing.T) {
+	pth := writeTestTable(t, testAssetTable)
+	l, err := NewLookup(LookupConfig{Table: pth, Attach: []string{`*`}, Prefix: `asset_`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ents := []*entry.Entry{
+		{SRC: net.ParseIP(`10.0.0.2`), Data: []byte(`hello`)},
+		{SRC: net.ParseIP(`10.0.0.3`), Data: []byte(`hello`)},
+		{SRC: net.ParseIP(`10.9.9.9`), Data: []byte(`hello`)},
+	}
+	set, err := l.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("bad result count %d", len(set))
+	}
+	exp := map[string]string{`asset_owner`: `bob`, `asset_site`: `branch, east`, `asset_notes`: `laptop`}
+	for k, v := range exp {
+		if ev, ok := set[0].GetEnumeratedValue(k); !ok || ev != v {
+			t.Fatalf("bad %s: %v != %v", k, ev, v)
+		}
+	}
+	if ev, ok := set[1].GetEnumeratedValue(`asset_owner`); !ok || ev != `carol` {
+		t.Fatalf("bad short row owner %v", ev)
+	} else if _, ok = set[1].GetEnumeratedValue(`asset_site`); ok {
+		t.Fatal("attached a missing column from a short row")
+	} else if _, ok = set[2].GetEnumeratedValue(`asset_owner`); ok {
+		t.Fatal("attached values to a miss")
+	}
+}
+
+func TestLookupRetagDrop(t *testing.T) {
+	pth := writeTestTable(t, testAssetTable)
+	var tagger testTagger
+	if _, err := tagger.NegotiateTag(`default`); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLookup(LookupConfig{Table: pth, Key_Column: `owner`, Regex: `user=(?P<key>\w+)`, Match_Tag: `known`, Drop_Misses: true}, &tagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ents := []*entry.Entry{
+		makeRawTestEntry(`login user=alice ok`),
+		makeRawTestEntry(`login user=mallory ok`),
+		makeRawTestEntry(`no user here`),
+	}
+	set, err := l.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 {
+		t.Fatalf("bad result count %d", len(set))
+	} else if tg, ok := tagger.mp[`known`]; !ok || set[0].Tag != tg {
+		t.Fatalf("bad tag %v", set[0].Tag)
+	}
+
+	//drop anything that matches
+	dl, err := NewLookup(LookupConfig{Table: pth, JSON_Path: `ip`, Drop_Matches: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dl.Close()
+	ents = []*entry.Entry{
+		makeRawTestEntry(`{"ip":"10.0.0.1"}`),
+		makeRawTestEntry(`{"ip":"192.168.1.1"}`),
+		makeRawTestEntry(`not json`),
+	}
+	if set, err = dl.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 || string(set[0].Data) != `{"ip":"192.168.1.1"}` {
+		t.Fatalf("bad drop results %d", len(set))
+	}
+}
+
+func TestLookupReload(t *testing.T) {
+	pth := writeTestTable(t, testAssetTable)
+	l, err := NewLookup(LookupConfig{Table: pth, Attach: []string{`owner`}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.reloadFreq = 0
+	check := func(exp string) {
+		t.Helper()
+		ent := &entry.Entry{SRC: net.ParseIP(`10.0.0.1`)}
+		l.Process([]*entry.Entry{ent})
+		if v, ok := ent.GetEnumeratedValue(`owner`); !ok || v != exp {
+			t.Fatalf("bad owner %v != %v", v, exp)
+		}
+	}
+	check(`alice`)
+
+	//a table missing the attached column must not replace the working table
+	future := time.Now().Add(time.Minute)
+	if err = os.WriteFile(pth, []byte("ip,site\n10.0.0.1,hq\n"), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(pth, future, future); err != nil {
+		t.Fatal(err)
+	}
+	check(`alice`)
+
+	future = future.Add(time.Minute)
+	if err = os.WriteFile(pth, []byte("ip,owner\n10.0.0.1,dave\n"), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(pth, future, future); err != nil {
+		t.Fatal(err)
+	}
+	check(`dave`)
+}
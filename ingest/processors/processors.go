@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
@@ -42,8 +43,13 @@ var (
 
 type ProcessorSet struct {
 	sync.Mutex
-	wtr entWriter
-	set []Processor
+	wtr   entWriter
+	set   []Processor
+	names []string //config block names for each processor, used for drop counts
+
+	expDone chan struct{} // closed to stop the expiry tickers
+	expWg   sync.WaitGroup
+	expErr  error // most recent write failure from an expiry ticker, returned by Close
 }
 
 type ProcessorConfig map[string]*config.VariableConfig
@@ -76,6 +82,15 @@ func CheckProcessor(id string) error {
 	case VpcProcessor:
 	case CorelightProcessor:
 	case SyslogRouterProcessor:
+	case CEFProcessor:
+	case LEEFProcessor:
+	case GeoIPProcessor:
+	case LookupProcessor:
+	case RedactProcessor:
+	case SampleProcessor:
+	case DedupProcessor:
+	case RateLimitProcessor:
+	case KVExtractProcessor:
 	default:
 		return checkProcessorOS(id)
 	}
@@ -141,6 +156,24 @@ func ProcessorLoadConfig(vc *config.VariableConfig) (cfg interface{}, err error)
 		cfg, err = CorelightLoadConfig(vc)
 	case SyslogRouterProcessor:
 		cfg, err = SyslogRouterLoadConfig(vc)
+	case CEFProcessor:
+		cfg, err = CEFLoadConfig(vc)
+	case LEEFProcessor:
+		cfg, err = LEEFLoadConfig(vc)
+	case GeoIPProcessor:
+		cfg, err = GeoIPLoadConfig(vc)
+	case LookupProcessor:
+		cfg, err = LookupLoadConfig(vc)
+	case RedactProcessor:
+		cfg, err = RedactLoadConfig(vc)
+	case SampleProcessor:
+		cfg, err = SampleLoadConfig(vc)
+	case DedupProcessor:
+		cfg, err = DedupLoadConfig(vc)
+	case RateLimitProcessor:
+		cfg, err = RateLimitLoadConfig(vc)
+	case KVExtractProcessor:
+		cfg, err = KVExtractLoadConfig(vc)
 	default:
 		cfg, err = processorLoadConfigOS(vc)
 	}
@@ -298,6 +331,60 @@ func newProcessor(vc *config.VariableConfig, tgr Tagger) (p Processor, err error
 			return
 		}
 		p, err = NewSyslogRouter(cfg, tgr)
+	case CEFProcessor:
+		var cfg CEFConfig
+		if cfg, err = CEFLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewCEF(cfg, tgr)
+	case LEEFProcessor:
+		var cfg LEEFConfig
+		if cfg, err = LEEFLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewLEEF(cfg, tgr)
+	case GeoIPProcessor:
+		var cfg GeoIPConfig
+		if cfg, err = GeoIPLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewGeoIP(cfg)
+	case LookupProcessor:
+		var cfg LookupConfig
+		if cfg, err = LookupLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewLookup(cfg, tgr)
+	case RedactProcessor:
+		var cfg RedactConfig
+		if cfg, err = RedactLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewRedact(cfg)
+	case SampleProcessor:
+		var cfg SampleConfig
+		if cfg, err = SampleLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewSample(cfg)
+	case DedupProcessor:
+		var cfg DedupConfig
+		if cfg, err = DedupLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewDedup(cfg)
+	case RateLimitProcessor:
+		var cfg RateLimitConfig
+		if cfg, err = RateLimitLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewRateLimit(cfg, tgr)
+	case KVExtractProcessor:
+		var cfg KVExtractConfig
+		if cfg, err = KVExtractLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewKVExtractor(cfg)
 	default:
 		p, err = newProcessorOS(vc, tgr)
 	}
@@ -317,9 +404,113 @@ func (pr *ProcessorSet) Enabled() bool {
 }
 
 func (pr *ProcessorSet) AddProcessor(p Processor) {
+	pr.addNamedProcessor(``, p)
+}
+
+// addNamedProcessor adds a processor whose drops are tracked under name, see DropCounts
+func (pr *ProcessorSet) addNamedProcessor(name string, p Processor) {
 	pr.Lock()
 	defer pr.Unlock()
 	pr.set = append(pr.set, p)
+	pr.names = append(pr.names, name)
+	if np, ok := p.(namedProcessor); ok && name != `` {
+		np.setName(name)
+	}
+	if pp, ok := p.(pacer); ok {
+		pp.deferDelay()
+	}
+	if ep, ok := p.(expirer); ok {
+		if pr.expDone == nil {
+			pr.expDone = make(chan struct{})
+		}
+		pr.expWg.Add(1)
+		go pr.expireRoutine(ep, pr.expDone)
+	}
+}
+
+// expirer is implemented by processors that hold entries for a period of time, the
+// ProcessorSet calls Expire on a timer so held entries are released even when no new
+// entries arrive
+type expirer interface {
+	Processor
+	Expire(now time.Time) []*entry.Entry
+	expireInterval() time.Duration
+}
+
+func (pr *ProcessorSet) expireRoutine(ep expirer, done chan struct{}) {
+	defer pr.expWg.Done()
+	tckr := time.NewTicker(ep.expireInterval())
+	defer tckr.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tckr.C:
+			pr.Lock()
+			if err := pr.expire(ep, now); err != nil {
+				pr.expErr = err
+			}
+			pr.Unlock()
+		}
+	}
+}
+
+// expire releases the entries held by ep and hands them to the processors after it, the caller must hold the lock
+func (pr *ProcessorSet) expire(ep expirer, now time.Time) (err error) {
+	for i, p := range pr.set {
+		if p != Processor(ep) {
+			continue
+		}
+		ents := ep.Expire(now)
+		if len(ents) == 0 {
+			return
+		} else if ents, err = pr.processItemsOnFlush(pr.set[i+1:], ents); err == nil && len(ents) > 0 && pr.wtr != nil {
+			if err = pr.pace(context.Background()); err == nil {
+				err = pr.writeSet(ents)
+			}
+		}
+		return
+	}
+	return
+}
+
+// pacer is implemented by processors that slow entries down rather than dropping them,
+// the ProcessorSet collects the delay after processing and waits it out without holding
+// its lock so the expiry tickers and other writers are not stalled
+type pacer interface {
+	deferDelay()
+	takeDelay() time.Duration
+}
+
+// pace waits out the longest delay requested by the pacers, the caller must hold the
+// lock and it is released while waiting
+func (pr *ProcessorSet) pace(ctx context.Context) (err error) {
+	var d time.Duration
+	for _, p := range pr.set {
+		if pp, ok := p.(pacer); ok {
+			if v := pp.takeDelay(); v > d {
+				d = v
+			}
+		}
+	}
+	if d <= 0 {
+		return
+	}
+	pr.Unlock()
+	tmr := time.NewTimer(d)
+	select {
+	case <-tmr.C:
+	case <-ctx.Done():
+		tmr.Stop()
+		err = ctx.Err()
+	}
+	pr.Lock()
+	return
+}
+
+// namedProcessor is implemented by processors that report state under their config name
+type namedProcessor interface {
+	setName(string)
 }
 
 func (pr *ProcessorSet) Process(ent *entry.Entry) (err error) {
@@ -335,7 +526,9 @@ func (pr *ProcessorSet) Process(ent *entry.Entry) (err error) {
 		//we have processors, start recursing into them
 		var set []*entry.Entry
 		if set, err = pr.processItems([]*entry.Entry{ent}); err == nil {
-			err = pr.writeSet(set)
+			if err = pr.pace(context.Background()); err == nil {
+				err = pr.writeSet(set)
+			}
 		}
 	}
 	pr.Unlock()
@@ -355,7 +548,9 @@ func (pr *ProcessorSet) ProcessBatch(ents []*entry.Entry) (err error) {
 		//we have processors, start recursing into them
 		var set []*entry.Entry
 		if set, err = pr.processItems(ents); err == nil {
-			err = pr.writeSet(set)
+			if err = pr.pace(context.Background()); err == nil {
+				err = pr.writeSet(set)
+			}
 		}
 	}
 	pr.Unlock()
@@ -375,7 +570,9 @@ func (pr *ProcessorSet) ProcessContext(ent *entry.Entry, ctx context.Context) (e
 		//we have processors, start recursing into them
 		var set []*entry.Entry
 		if set, err = pr.processItems([]*entry.Entry{ent}); err == nil {
-			err = pr.writeSetContext(set, ctx)
+			if err = pr.pace(ctx); err == nil {
+				err = pr.writeSetContext(set, ctx)
+			}
 		}
 	}
 	pr.Unlock()
@@ -395,7 +592,9 @@ func (pr *ProcessorSet) ProcessBatchContext(ents []*entry.Entry, ctx context.Con
 		//we have processors, start recursing into them
 		var set []*entry.Entry
 		if set, err = pr.processItems(ents); err == nil {
-			err = pr.writeSetContext(set, ctx)
+			if err = pr.pace(ctx); err == nil {
+				err = pr.writeSetContext(set, ctx)
+			}
 		}
 	}
 	pr.Unlock()
@@ -435,6 +634,11 @@ func (pr *ProcessorSet) processItems(ents []*entry.Entry) (set []*entry.Entry, e
 			}
 			break // something intentionally returned an error, break out
 		}
+		if dr, ok := pr.set[i].(DropReporter); ok {
+			addDrops(pr.names[i], dr.Dropped())
+		} else if len(set) < len(orig) {
+			addDrops(pr.names[i], len(orig)-len(set))
+		}
 	}
 	return
 }
@@ -458,6 +662,16 @@ func (pr *ProcessorSet) processItemsOnFlush(prs []Processor, ents []*entry.Entry
 // This function DOES NOT close the ingest muxer handle.
 // It is ONLY for shutting down preprocessors
 func (pr *ProcessorSet) Close() (err error) {
+	pr.Lock()
+	if pr.expDone != nil {
+		close(pr.expDone)
+		pr.expDone = nil
+	}
+	pr.Unlock()
+	pr.expWg.Wait()
+	pr.Lock()
+	err, pr.expErr = pr.expErr, nil
+	pr.Unlock()
 	for i, v := range pr.set {
 		if v != nil {
 			if ents := v.Flush(); len(ents) > 0 {
@@ -503,7 +717,7 @@ func (pc ProcessorConfig) ProcessorSet(t tagWriter, names []string) (pr *Process
 			err = fmt.Errorf("%s %v", n, err)
 			return
 		}
-		pr.AddProcessor(p)
+		pr.addNamedProcessor(n, p)
 	}
 	return
 }
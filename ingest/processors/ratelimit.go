@@ -0,0 +1,470 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"golang.org/x/time/rate"
+)
+
+const (
+	RateLimitProcessor = `ratelimit`
+
+	rateLimitKeyTag   = `tag`
+	rateLimitKeySrc   = `src`
+	rateLimitKeyField = `field`
+
+	rateLimitActionDrop  = `drop`
+	rateLimitActionSpill = `spill`
+	rateLimitActionDelay = `delay`
+
+	rateLimitDefaultMaxKeys  = 10000
+	rateLimitDefaultMaxDelay = 5 * time.Second
+	rateLimitOverflowKey     = `[overflow]`
+	rateLimitReportKeys      = 32 // most limited keys included in a report
+)
+
+// RateLimitConfig configures the per key token bucket preprocessor.  Exactly one of
+// Entries_Per_Second or Bandwidth must be set, Bandwidth uses the same syntax as the
+// global Rate-Limit and is charged by entry size.  Keys beyond Max_Keys share a single
+// overflow bucket.
+type RateLimitConfig struct {
+	Key                string // tag, src, or field, defaults to tag
+	JSON_Path          string // field keys are pulled from this JSON path
+	Regex              string // field keys are pulled from a regular expression capture
+	Entries_Per_Second int    // entry limit for each key
+	Bandwidth          string // byte limit for each key, e.g. 10Mbit
+	Burst              int    // bucket size in entries or bytes, defaults to one second of traffic
+	Action             string // drop, spill, or delay, defaults to drop
+	Spill_Tag          string // tag that over limit entries are moved to by the spill action
+	Max_Delay          string // longest the delay action waits before dropping, defaults to 5s
+	Max_Keys           int    // number of keys tracked, defaults to 10000
+}
+
+func RateLimitLoadConfig(vc *config.VariableConfig) (c RateLimitConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+func (c RateLimitConfig) key() string {
+	if k := strings.TrimSpace(strings.ToLower(c.Key)); k != `` {
+		return k
+	}
+	return rateLimitKeyTag
+}
+
+func (c RateLimitConfig) action() string {
+	if a := strings.TrimSpace(strings.ToLower(c.Action)); a != `` {
+		return a
+	}
+	return rateLimitActionDrop
+}
+
+func (c RateLimitConfig) maxKeys() int {
+	if c.Max_Keys > 0 {
+		return c.Max_Keys
+	}
+	return rateLimitDefaultMaxKeys
+}
+
+// rateLimitParams are the validated and parsed limits
+type rateLimitParams struct {
+	limit    rate.Limit
+	burst    int
+	bytes    bool
+	maxDelay time.Duration
+	desc     string
+}
+
+func (c RateLimitConfig) validate() (p rateLimitParams, err error) {
+	switch c.key() {
+	case rateLimitKeyTag, rateLimitKeySrc:
+		if c.JSON_Path != `` || c.Regex != `` {
+			err = errors.New("JSON-Path and Regex require Key=field")
+			return
+		}
+	case rateLimitKeyField:
+		if c.JSON_Path == `` && c.Regex == `` {
+			err = errors.New("Key=field requires a JSON-Path or Regex")
+			return
+		} else if _, err = newValueSource(c.JSON_Path, c.Regex, ``); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("Invalid Key %q", c.Key)
+		return
+	}
+
+	if c.Entries_Per_Second > 0 && c.Bandwidth != `` {
+		err = errors.New("Entries-Per-Second and Bandwidth are mutually exclusive")
+		return
+	} else if c.Entries_Per_Second > 0 {
+		p.limit = rate.Limit(c.Entries_Per_Second)
+		p.burst = c.Entries_Per_Second
+		p.desc = fmt.Sprintf("%d entries/s", c.Entries_Per_Second)
+	} else if c.Bandwidth != `` {
+		var bps int64
+		if bps, err = config.ParseRate(c.Bandwidth); err != nil {
+			err = fmt.Errorf("Invalid Bandwidth %q: %w", c.Bandwidth, err)
+			return
+		} else if bps < 8 {
+			err = fmt.Errorf("Bandwidth %q is too low", c.Bandwidth)
+			return
+		}
+		p.limit = rate.Limit(bps / 8)
+		p.burst = int(bps / 8)
+		p.bytes = true
+		p.desc = c.Bandwidth
+	} else {
+		err = errors.New("Missing Entries-Per-Second or Bandwidth")
+		return
+	}
+	if c.Burst < 0 {
+		err = errors.New("Burst cannot be negative")
+		return
+	} else if c.Burst > 0 {
+		p.burst = c.Burst
+	}
+
+	switch c.action() {
+	case rateLimitActionDrop:
+	case rateLimitActionSpill:
+		if c.Spill_Tag == `` {
+			err = errors.New("The spill action requires a Spill-Tag")
+			return
+		} else if err = ingest.CheckTag(c.Spill_Tag); err != nil {
+			err = fmt.Errorf("Invalid Spill-Tag %q: %w", c.Spill_Tag, err)
+			return
+		}
+	case rateLimitActionDelay:
+		p.maxDelay = rateLimitDefaultMaxDelay
+		if c.Max_Delay != `` {
+			if p.maxDelay, err = time.ParseDuration(c.Max_Delay); err != nil {
+				err = fmt.Errorf("Invalid Max-Delay %q: %w", c.Max_Delay, err)
+				return
+			} else if p.maxDelay <= 0 {
+				err = errors.New("Max-Delay must be greater than zero")
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("Invalid Action %q", c.Action)
+		return
+	}
+	if c.Max_Keys < 0 {
+		err = errors.New("Max-Keys cannot be negative")
+	}
+	return
+}
+
+type rateLimitKey struct {
+	lmt         *rate.Limiter
+	tag         entry.EntryTag
+	isTag       bool
+	passed      uint64
+	limited     uint64
+	lastLimited time.Time
+}
+
+// RateLimit enforces token bucket limits per tag, source, or extracted field so that a
+// single noisy key cannot starve the rest of the stream.  Over limit entries are dropped,
+// moved to a spill tag, or delayed until they fit.
+type RateLimit struct {
+	RateLimitConfig
+	mtx      sync.Mutex
+	name     string
+	tagger   Tagger
+	params   rateLimitParams
+	src      valueSource
+	spillTag entry.EntryTag
+	keys     map[string]*rateLimitKey
+	passed   uint64
+	limited  uint64
+	sleep    func(time.Duration)
+	deferred bool      // a ProcessorSet waits out delays so the limiter never sleeps under the set lock
+	until    time.Time // end of the longest deferred delay, see takeDelay
+}
+
+func NewRateLimit(cfg RateLimitConfig, tagger Tagger) (rl *RateLimit, err error) {
+	rl = &RateLimit{
+		tagger: tagger,
+		sleep:  time.Sleep,
+	}
+	if err = rl.init(cfg); err != nil {
+		return nil, err
+	}
+	rateLimiters.Store(rl, struct{}{})
+	return
+}
+
+func (rl *RateLimit) init(cfg RateLimitConfig) (err error) {
+	var p rateLimitParams
+	var src valueSource
+	var spill entry.EntryTag
+	if p, err = cfg.validate(); err != nil {
+		return
+	} else if src, err = newValueSource(cfg.JSON_Path, cfg.Regex, ``); err != nil {
+		return
+	}
+	if cfg.action() == rateLimitActionSpill {
+		if rl.tagger == nil {
+			return errors.New("The spill action requires a tagger")
+		} else if spill, err = rl.tagger.NegotiateTag(cfg.Spill_Tag); err != nil {
+			return fmt.Errorf("Failed to negotiate tag %s: %w", cfg.Spill_Tag, err)
+		}
+	}
+	rl.mtx.Lock()
+	rl.RateLimitConfig = cfg
+	rl.params = p
+	rl.src = src
+	rl.spillTag = spill
+	rl.keys = make(map[string]*rateLimitKey)
+	rl.mtx.Unlock()
+	return
+}
+
+func (rl *RateLimit) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(RateLimitConfig); ok {
+		err = rl.init(cfg)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+// setName is called by the ProcessorSet so reports can carry the config block name
+func (rl *RateLimit) setName(name string) {
+	rl.mtx.Lock()
+	rl.name = name
+	rl.mtx.Unlock()
+}
+
+// deferDelay is called by the ProcessorSet, the limiter records delays for takeDelay
+// instead of sleeping so that the set can wait without holding its lock
+func (rl *RateLimit) deferDelay() {
+	rl.mtx.Lock()
+	rl.deferred = true
+	rl.mtx.Unlock()
+}
+
+// takeDelay returns how long the entries processed since the last call must wait, the
+// reservations queue behind each other so the longest delay covers the whole set
+func (rl *RateLimit) takeDelay() (d time.Duration) {
+	rl.mtx.Lock()
+	if !rl.until.IsZero() {
+		d = time.Until(rl.until)
+		rl.until = time.Time{}
+	}
+	rl.mtx.Unlock()
+	return
+}
+
+func (rl *RateLimit) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		rl.mtx.Lock()
+		ok, d := rl.allow(ent)
+		deferred := rl.deferred
+		if d > 0 && deferred {
+			if u := time.Now().Add(d); u.After(rl.until) {
+				rl.until = u
+			}
+		}
+		rl.mtx.Unlock()
+		if d > 0 && !deferred {
+			//the tokens are already reserved, wait without blocking reports and reconfiguration
+			rl.sleep(d)
+		}
+		if ok {
+			rset = append(rset, ent)
+		}
+	}
+	return
+}
+
+// allow charges the entry against its bucket and reports if it should be kept and how long
+// the caller must wait before passing it on, the spill action retags over limit entries
+// rather than dropping them.  The caller must hold the lock and must not sleep while holding it.
+func (rl *RateLimit) allow(ent *entry.Entry) (bool, time.Duration) {
+	k := rl.getKey(ent)
+	cost := 1
+	if rl.params.bytes {
+		if cost = len(ent.Data); cost > rl.params.burst {
+			cost = rl.params.burst //entries larger than the bucket would never fit
+		}
+	}
+	now := time.Now()
+	var delay time.Duration
+	ok := k.lmt.AllowN(now, cost)
+	if !ok && rl.action() == rateLimitActionDelay {
+		r := k.lmt.ReserveN(now, cost)
+		if d := r.DelayFrom(now); r.OK() && d <= rl.params.maxDelay {
+			delay = d
+			ok = true
+		} else {
+			r.CancelAt(now)
+		}
+	}
+	if ok {
+		k.passed++
+		rl.passed++
+		return true, delay
+	}
+	k.limited++
+	k.lastLimited = now
+	rl.limited++
+	if rl.action() == rateLimitActionSpill {
+		ent.Tag = rl.spillTag
+		return true, 0
+	}
+	return false, 0
+}
+
+func (rl *RateLimit) getKey(ent *entry.Entry) *rateLimitKey {
+	var name string
+	isTag := rl.key() == rateLimitKeyTag
+	switch rl.key() {
+	case rateLimitKeyTag:
+		name = strconv.Itoa(int(ent.Tag))
+	case rateLimitKeySrc:
+		if ent.SRC != nil {
+			name = ent.SRC.String()
+		}
+	case rateLimitKeyField:
+		if v, ok := rl.src.value(ent); ok {
+			name = string(v)
+		}
+	}
+	if k, ok := rl.keys[name]; ok {
+		return k
+	} else if len(rl.keys) >= rl.maxKeys() {
+		name, isTag = rateLimitOverflowKey, false
+		if k, ok = rl.keys[name]; ok {
+			return k
+		}
+	}
+	k := &rateLimitKey{
+		lmt:   rate.NewLimiter(rl.params.limit, rl.params.burst),
+		tag:   ent.Tag,
+		isTag: isTag,
+	}
+	rl.keys[name] = k
+	return k
+}
+
+// keyName resolves tag keys to tag names when the tagger can do so, this is expensive
+// so it is only done when building reports
+func (rl *RateLimit) keyName(name string, k *rateLimitKey) string {
+	if k.isTag && rl.tagger != nil {
+		if tn, ok := rl.tagger.LookupTag(k.tag); ok {
+			return tn
+		}
+	}
+	return name
+}
+
+func (rl *RateLimit) Flush() []*entry.Entry {
+	return nil
+}
+
+func (rl *RateLimit) Close() error {
+	rateLimiters.Delete(rl)
+	return nil
+}
+
+// RateLimitKeyReport describes a single key that has been limited
+type RateLimitKeyReport struct {
+	Key          string
+	Passed       uint64
+	Limited      uint64
+	Last_Limited time.Time
+}
+
+// RateLimitReport summarizes a rate limiting preprocessor and the keys it is shedding
+type RateLimitReport struct {
+	Name     string
+	Key      string
+	Limit    string
+	Action   string
+	Keys     int
+	Passed   uint64
+	Limited  uint64
+	Shedding []RateLimitKeyReport `json:",omitempty"` // most limited keys first
+}
+
+func (rl *RateLimit) report() (r RateLimitReport) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	r = RateLimitReport{
+		Name:    rl.name,
+		Key:     rl.key(),
+		Limit:   rl.params.desc,
+		Action:  rl.action(),
+		Keys:    len(rl.keys),
+		Passed:  rl.passed,
+		Limited: rl.limited,
+	}
+	for name, k := range rl.keys {
+		if k.limited > 0 {
+			r.Shedding = append(r.Shedding, RateLimitKeyReport{
+				Key:          rl.keyName(name, k),
+				Passed:       k.passed,
+				Limited:      k.limited,
+				Last_Limited: k.lastLimited,
+			})
+		}
+	}
+	sort.Slice(r.Shedding, func(i, j int) bool {
+		if r.Shedding[i].Limited == r.Shedding[j].Limited {
+			return r.Shedding[i].Key < r.Shedding[j].Key
+		}
+		return r.Shedding[i].Limited > r.Shedding[j].Limited
+	})
+	if len(r.Shedding) > rateLimitReportKeys {
+		r.Shedding = r.Shedding[:rateLimitReportKeys]
+	}
+	return
+}
+
+// rateLimiters holds every open RateLimit preprocessor
+var rateLimiters sync.Map // *RateLimit -> struct{}
+
+// RateLimitReports returns a report for every open rate limiting preprocessor, sorted by
+// name.  Ingesters publish these in their ingester state so it is visible which keys are
+// being shed.
+func RateLimitReports() (r []RateLimitReport) {
+	rateLimiters.Range(func(k, v interface{}) bool {
+		r = append(r, k.(*RateLimit).report())
+		return true
+	})
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return
+}
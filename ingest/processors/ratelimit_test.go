@@ -0,0 +1,240 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestRateLimitConfig(t *testing.T) {
+	b := `
+	[preprocessor "rl"]
+		type = ratelimit
+		Key=field
+		JSON-Path=host
+		Bandwidth=8kbit
+		Burst=4096
+		Action=delay
+		Max-Delay=250ms
+	`
+	p, err := testLoadPreprocessor(b, `rl`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, ok := p.(*RateLimit)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *RateLimit", p)
+	}
+	defer rl.Close()
+	if !rl.params.bytes || rl.params.limit != 1024 || rl.params.burst != 4096 || rl.params.maxDelay != 250*time.Millisecond {
+		t.Fatalf("bad params %+v", rl.params)
+	}
+
+	for _, c := range []RateLimitConfig{
+		{},
+		{Entries_Per_Second: 10, Bandwidth: `1mbit`},
+		{Bandwidth: `fast`},
+		{Entries_Per_Second: 10, Key: `host`},
+		{Entries_Per_Second: 10, Key: `field`},
+		{Entries_Per_Second: 10, JSON_Path: `host`},
+		{Entries_Per_Second: 10, Action: `spill`},
+		{Entries_Per_Second: 10, Action: `spill`, Spill_Tag: `bad tag`},
+		{Entries_Per_Second: 10, Action: `delay`, Max_Delay: `-1s`},
+		{Entries_Per_Second: 10, Action: `shed`},
+		{Entries_Per_Second: 10, Burst: -1},
+	} {
+		if _, err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestRateLimitPerTag(t *testing.T) {
+	var tagger testTagger
+	noisy, _ := tagger.NegotiateTag(`noisy`)
+	quiet, _ := tagger.NegotiateTag(`quiet`)
+	rl, err := NewRateLimit(RateLimitConfig{Entries_Per_Second: 10}, &tagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	var ents []*entry.Entry
+	for i := 0; i < 100; i++ {
+		ents = append(ents, &entry.Entry{Tag: noisy, Data: []byte(`flood`)})
+	}
+	for i := 0; i < 5; i++ {
+		ents = append(ents, &entry.Entry{Tag: quiet, Data: []byte(`hello`)})
+	}
+	set, err := rl.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n, q int
+	for _, ent := range set {
+		if ent.Tag == noisy {
+			n++
+		} else {
+			q++
+		}
+	}
+	if n < 10 || n > 12 {
+		t.Fatalf("noisy tag was not limited, %d passed", n)
+	} else if q != 5 {
+		t.Fatalf("quiet tag was starved, %d of 5 passed", q)
+	}
+
+	r := rl.report()
+	if r.Keys != 2 || r.Passed != uint64(n+q) || r.Limited != uint64(100-n) {
+		t.Fatalf("bad report %+v", r)
+	} else if len(r.Shedding) != 1 || r.Shedding[0].Key != `noisy` || r.Shedding[0].Last_Limited.IsZero() {
+		t.Fatalf("bad shedding report %+v", r.Shedding)
+	}
+}
+
+func TestRateLimitSpillAndDelay(t *testing.T) {
+	var tagger testTagger
+	tagger.NegotiateTag(`default`)
+	rl, err := NewRateLimit(RateLimitConfig{Key: `src`, Entries_Per_Second: 1, Action: `spill`, Spill_Tag: `overflow`}, &tagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rl.Close()
+	ents := []*entry.Entry{
+		{SRC: net.ParseIP(`10.0.0.1`)},
+		{SRC: net.ParseIP(`10.0.0.1`)},
+		{SRC: net.ParseIP(`10.0.0.2`)},
+	}
+	set, err := rl.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("spill dropped entries %d", len(set))
+	} else if tg := tagger.mp[`overflow`]; set[0].Tag == tg || set[1].Tag != tg || set[2].Tag == tg {
+		t.Fatalf("bad spill tags %d %d %d", set[0].Tag, set[1].Tag, set[2].Tag)
+	}
+
+	//delay sleeps when the wait is short enough and drops when it is not
+	dl, err := NewRateLimit(RateLimitConfig{Entries_Per_Second: 10, Burst: 1, Action: `delay`, Max_Delay: `150ms`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dl.Close()
+	var slept []time.Duration
+	dl.sleep = func(d time.Duration) {
+		//taking the lock here deadlocks if the limiter sleeps while holding it
+		dl.mtx.Lock()
+		slept = append(slept, d)
+		dl.mtx.Unlock()
+	}
+	ents = nil
+	for i := 0; i < 4; i++ {
+		ents = append(ents, makeEntry([]byte(`x`), 0)[0])
+	}
+	if set, err = dl.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 || len(slept) != 1 {
+		t.Fatalf("bad delay results %d %v", len(set), slept)
+	} else if slept[0] <= 0 || slept[0] > 150*time.Millisecond {
+		t.Fatalf("bad delay %v", slept[0])
+	}
+}
+
+func TestRateLimitReports(t *testing.T) {
+	b := []byte(`
+	[preprocessor "shedder"]
+		type = ratelimit
+		Key=field
+		Regex="host=(\\S+)"
+		Entries-Per-Second=1
+		Max-Keys=1
+	`)
+	tc := struct {
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tw testTagWriter
+	pr, err := tc.Preprocessor.ProcessorSet(&tw, []string{`shedder`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{`host=a`, `host=a`, `host=b`, `host=c`} {
+		if err = pr.Process(makeEntry([]byte(v), 0)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var found bool
+	for _, r := range RateLimitReports() {
+		if r.Name != `shedder` {
+			continue
+		}
+		found = true
+		if r.Keys != 2 || r.Limited != 2 || len(r.Shedding) != 2 {
+			t.Fatalf("bad report %+v", r)
+		} else if r.Shedding[0].Key != `[overflow]` && r.Shedding[0].Key != `a` {
+			t.Fatalf("bad shedding keys %+v", r.Shedding)
+		}
+	}
+	if !found {
+		t.Fatal("missing report")
+	} else if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range RateLimitReports() {
+		if r.Name == `shedder` {
+			t.Fatal("closed preprocessor is still reported")
+		}
+	}
+}
+
+func TestRateLimitSetDelay(t *testing.T) {
+	rl, err := NewRateLimit(RateLimitConfig{Entries_Per_Second: 10, Burst: 1, Action: `delay`, Max_Delay: `1s`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.sleep = func(time.Duration) {
+		t.Error("limiter slept inside a processor set")
+	}
+	var tw testTagWriter
+	pr := NewProcessorSet(&tw)
+	pr.AddProcessor(rl)
+
+	var ents []*entry.Entry
+	for i := 0; i < 4; i++ {
+		ents = append(ents, makeEntry([]byte(`x`), 0)[0])
+	}
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- pr.ProcessBatch(ents)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	//the set lock must be free while the batch waits out its delay
+	if !pr.Enabled() {
+		t.Fatal("set is not enabled")
+	} else if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("set lock held for %v while delaying", d)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	} else if d := time.Since(start); d < 250*time.Millisecond {
+		t.Fatalf("batch was not delayed %v", d)
+	} else if len(tw.ents) != 4 {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+	if err = pr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,402 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/jsonparser"
+)
+
+const (
+	RedactProcessor = `redact`
+
+	redactActionRedact = `redact`
+	redactActionMask   = `mask`
+	redactActionHMAC   = `hmac`
+
+	redactDefaultReplacement = `[REDACTED]`
+	redactDefaultMaskKeep    = 4
+	redactDefaultMaskChar    = '*'
+	redactHMACLength         = 16 // bytes of the HMAC-SHA256 sum that are kept
+)
+
+// built in detector names
+const (
+	DetectCreditCard = `credit-card`
+	DetectSSN        = `ssn`
+	DetectEmail      = `email`
+	DetectIPv4       = `ipv4`
+	DetectIPv6       = `ipv6`
+	DetectJWT        = `jwt`
+	DetectBearer     = `bearer`
+)
+
+// RedactConfig configures the redaction preprocessor.  Detectors and Regex scan the
+// entire entry, JSON_Path and Enumerated_Value replace the whole value they refer to.
+// If a Regex contains a capture group only the first group is replaced.
+type RedactConfig struct {
+	Detector         []string // built in detectors, credit-card, ssn, email, ipv4, ipv6, jwt, bearer
+	Regex            []string // custom regular expressions
+	JSON_Path        []string // JSON values to replace
+	Enumerated_Value []string // enumerated values to replace
+	Action           string   // redact, mask, or hmac, defaults to redact
+	Replacement      string   // replacement text for the redact action, defaults to [REDACTED]
+	Mask_Keep        int      // trailing letters and digits left visible by the mask action, defaults to 4, -1 masks everything
+	Mask_Char        string   // character used by the mask action, defaults to *
+	HMAC_Key         string   // key for the hmac action
+	HMAC_Key_File    string   // file containing the key for the hmac action
+}
+
+func RedactLoadConfig(vc *config.VariableConfig) (c RedactConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+func (c RedactConfig) action() string {
+	if a := strings.TrimSpace(strings.ToLower(c.Action)); a != `` {
+		return a
+	}
+	return redactActionRedact
+}
+
+func (c RedactConfig) validate() (rs *redactor, err error) {
+	rs = &redactor{
+		replacement: []byte(redactDefaultReplacement),
+		maskKeep:    redactDefaultMaskKeep,
+		maskChar:    redactDefaultMaskChar,
+	}
+	if len(c.Detector) == 0 && len(c.Regex) == 0 && len(c.JSON_Path) == 0 && len(c.Enumerated_Value) == 0 {
+		return nil, errors.New("No Detector, Regex, JSON-Path, or Enumerated-Value specified")
+	}
+	if rs.matchers, err = redactDetectors(c.Detector); err != nil {
+		return nil, err
+	}
+	for _, r := range c.Regex {
+		var rx *regexp.Regexp
+		if rx, err = regexp.Compile(r); err != nil {
+			return nil, fmt.Errorf("Invalid Regex %q: %w", r, err)
+		}
+		rm := redactMatcher{rx: rx}
+		if rx.NumSubexp() > 0 {
+			rm.group = 1
+		}
+		rs.matchers = append(rs.matchers, rm)
+	}
+	for _, p := range c.JSON_Path {
+		if p = strings.TrimSpace(p); p == `` {
+			return nil, errors.New("Empty JSON-Path")
+		}
+		rs.paths = append(rs.paths, unquoteFields(splitRespectQuotes(p, dotSplitter)))
+	}
+	for _, n := range c.Enumerated_Value {
+		if n = strings.TrimSpace(n); n == `` {
+			return nil, errors.New("Empty Enumerated-Value name")
+		}
+		rs.evs = append(rs.evs, n)
+	}
+
+	switch c.action() {
+	case redactActionRedact:
+		if c.Replacement != `` {
+			rs.replacement = []byte(c.Replacement)
+		}
+		rs.act = rs.redact
+	case redactActionMask:
+		if c.Mask_Keep < 0 {
+			rs.maskKeep = 0
+		} else if c.Mask_Keep > 0 {
+			rs.maskKeep = c.Mask_Keep
+		}
+		if c.Mask_Char != `` {
+			if utf8.RuneCountInString(c.Mask_Char) != 1 {
+				return nil, fmt.Errorf("Invalid Mask-Char %q, must be a single character", c.Mask_Char)
+			}
+			rs.maskChar, _ = utf8.DecodeRuneInString(c.Mask_Char)
+		}
+		rs.act = rs.mask
+	case redactActionHMAC:
+		var key string
+		if c.HMAC_Key != `` && c.HMAC_Key_File != `` {
+			return nil, errors.New("HMAC-Key and HMAC-Key-File are mutually exclusive")
+		} else if c.HMAC_Key_File != `` {
+			var bts []byte
+			if bts, err = os.ReadFile(c.HMAC_Key_File); err != nil {
+				return nil, fmt.Errorf("Failed to read HMAC-Key-File: %w", err)
+			}
+			key = strings.TrimSpace(string(bts))
+		} else {
+			key = c.HMAC_Key
+		}
+		if key == `` {
+			return nil, errors.New("The hmac action requires an HMAC-Key or HMAC-Key-File")
+		}
+		rs.key = []byte(key)
+		rs.act = rs.hmac
+	default:
+		return nil, fmt.Errorf("Invalid Action %q", c.Action)
+	}
+	return
+}
+
+// Redact scrubs sensitive values out of entries and their enumerated values before they
+// leave the ingester.  Values are either replaced outright, masked so that only the last
+// few characters remain, or replaced with a keyed HMAC so they can still be joined on.
+type Redact struct {
+	nocloser
+	RedactConfig
+	rs *redactor
+}
+
+func NewRedact(cfg RedactConfig) (*Redact, error) {
+	rs, err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &Redact{
+		RedactConfig: cfg,
+		rs:           rs,
+	}, nil
+}
+
+func (r *Redact) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(RedactConfig); ok {
+		var rs *redactor
+		if rs, err = cfg.validate(); err == nil {
+			r.RedactConfig = cfg
+			r.rs = rs
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (r *Redact) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	for _, ent := range ents {
+		if ent != nil {
+			r.rs.process(ent)
+		}
+	}
+	return ents, nil
+}
+
+type redactor struct {
+	matchers    []redactMatcher
+	paths       [][]string
+	evs         []string
+	act         func([]byte) []byte
+	replacement []byte
+	maskKeep    int
+	maskChar    rune
+	key         []byte
+}
+
+func (rs *redactor) process(ent *entry.Entry) {
+	for _, keys := range rs.paths {
+		ent.Data = rs.scrubJSON(ent.Data, keys)
+	}
+	for _, rm := range rs.matchers {
+		ent.Data = rm.scrub(ent.Data, rs.act)
+	}
+	for _, n := range rs.evs {
+		if ev, ok := ent.EVB.Get(n); ok {
+			ent.AddEnumeratedValueEx(n, string(rs.act([]byte(ev.Value.String()))))
+		}
+	}
+}
+
+// scrubJSON replaces the value at keys with a string, non-string values are acted on
+// using their JSON encoding
+func (rs *redactor) scrubJSON(data []byte, keys []string) []byte {
+	v, dt, _, err := jsonparser.Get(data, keys...)
+	if err != nil || dt == jsonparser.Null {
+		return data
+	}
+	if dt == jsonparser.String {
+		var s string
+		if s, err = jsonparser.ParseString(v); err != nil {
+			return data
+		}
+		v = []byte(s)
+	}
+	enc, err := json.Marshal(string(rs.act(v)))
+	if err != nil {
+		return data
+	}
+	if nd, err := jsonparser.Set(data, enc, keys...); err == nil {
+		data = nd
+	}
+	return data
+}
+
+func (rs *redactor) redact(v []byte) []byte {
+	return rs.replacement
+}
+
+// mask replaces every letter and digit except the last maskKeep with the mask character,
+// separators and punctuation are left in place so the shape of the value is preserved
+func (rs *redactor) mask(v []byte) []byte {
+	s := []rune(string(v))
+	keep := rs.maskKeep
+	for i := len(s) - 1; i >= 0; i-- {
+		if !isAlnum(s[i]) {
+			continue
+		} else if keep > 0 {
+			keep--
+		} else {
+			s[i] = rs.maskChar
+		}
+	}
+	return []byte(string(s))
+}
+
+// hmac replaces the value with a hex encoded, truncated HMAC-SHA256
+func (rs *redactor) hmac(v []byte) []byte {
+	mac := hmac.New(sha256.New, rs.key)
+	mac.Write(v)
+	sum := mac.Sum(nil)
+	r := make([]byte, hex.EncodedLen(redactHMACLength))
+	hex.Encode(r, sum[:redactHMACLength])
+	return r
+}
+
+func isAlnum(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// redactMatcher finds candidate values with a regular expression, valid is an optional
+// check that is handed the entire data buffer and the bounds of the candidate
+type redactMatcher struct {
+	rx    *regexp.Regexp
+	group int
+	valid func(data []byte, s, e int) bool
+}
+
+func (rm redactMatcher) scrub(data []byte, act func([]byte) []byte) []byte {
+	idxs := rm.rx.FindAllSubmatchIndex(data, -1)
+	if len(idxs) == 0 {
+		return data
+	}
+	var out []byte
+	var last int
+	for _, m := range idxs {
+		s, e := m[rm.group*2], m[rm.group*2+1]
+		if s < 0 || s == e || (rm.valid != nil && !rm.valid(data, s, e)) {
+			continue
+		}
+		out = append(out, data[last:s]...)
+		out = append(out, act(data[s:e])...)
+		last = e
+	}
+	if out == nil {
+		return data
+	}
+	return append(out, data[last:]...)
+}
+
+var (
+	redactBearerRx = regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)
+	redactJWTRx    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	redactEmailRx  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	redactCardRx   = regexp.MustCompile(`\b[3-6](?:[ -]?\d){12,18}\b`)
+	redactSSNRx    = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+	redactIPv6Rx   = regexp.MustCompile(`(?i)[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){1,6}:(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9a-f]{0,4})`)
+	redactIPv4Rx   = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+
+	// detectors run in this order regardless of the order they are configured in so that
+	// tokens are consumed before their contents can be picked up by narrower detectors
+	redactDetectorOrder = []string{DetectBearer, DetectJWT, DetectEmail, DetectCreditCard, DetectSSN, DetectIPv6, DetectIPv4}
+	redactDetectorSet   = map[string]redactMatcher{
+		DetectBearer:     {rx: redactBearerRx, group: 1},
+		DetectJWT:        {rx: redactJWTRx},
+		DetectEmail:      {rx: redactEmailRx},
+		DetectCreditCard: {rx: redactCardRx, valid: validCardNumber},
+		DetectSSN:        {rx: redactSSNRx, valid: validSSN},
+		DetectIPv6:       {rx: redactIPv6Rx, valid: validIPv6},
+		DetectIPv4:       {rx: redactIPv4Rx},
+	}
+)
+
+func redactDetectors(names []string) (rms []redactMatcher, err error) {
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		n = strings.TrimSpace(strings.ToLower(n))
+		if _, ok := redactDetectorSet[n]; !ok {
+			return nil, fmt.Errorf("Unknown Detector %q", n)
+		}
+		want[n] = true
+	}
+	for _, n := range redactDetectorOrder {
+		if want[n] {
+			rms = append(rms, redactDetectorSet[n])
+		}
+	}
+	return
+}
+
+// validCardNumber checks the length and Luhn checksum of a candidate card number
+func validCardNumber(data []byte, s, e int) bool {
+	var sum, cnt int
+	for i := e - 1; i >= s; i-- {
+		c := data[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if cnt%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		cnt++
+	}
+	return cnt >= 13 && cnt <= 19 && sum%10 == 0
+}
+
+// validSSN rejects area, group, and serial numbers that are never issued
+func validSSN(data []byte, s, e int) bool {
+	v := string(data[s:e])
+	area, group, serial := v[0:3], v[4:6], v[7:11]
+	if area == `000` || area == `666` || area[0] == '9' {
+		return false
+	}
+	return group != `00` && serial != `0000`
+}
+
+// validIPv6 requires the candidate to parse, contain a digit, and not be part of a larger
+// token so that things like Foo::Bar and timestamps are left alone
+func validIPv6(data []byte, s, e int) bool {
+	if (s > 0 && isIPv6Boundary(data[s-1])) || (e < len(data) && isIPv6Boundary(data[e])) {
+		return false
+	}
+	v := string(data[s:e])
+	return strings.ContainsAny(v, `0123456789`) && net.ParseIP(v) != nil
+}
+
+func isIPv6Boundary(c byte) bool {
+	return c == ':' || c == '.' || c == '_' || (c < utf8.RuneSelf && isAlnum(rune(c)))
+}
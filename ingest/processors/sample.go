@@ -0,0 +1,177 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	SampleProcessor = `sample`
+
+	sampleModeCount       = `count`
+	sampleModeHash        = `hash`
+	sampleDefaultRateName = `sample_rate`
+	sampleMaxKeys         = 0x10000
+)
+
+// SampleConfig configures the sampling preprocessor.  In count mode the first of every
+// Rate entries is kept, counted per tag or per extracted key.  In hash mode entries are
+// kept when the hash of the extracted key, or of the entry when there is no key, falls
+// in a 1 in Rate bucket, so every entry with a given key is either kept or dropped.
+type SampleConfig struct {
+	Rate                  int    // keep 1 in Rate entries
+	Mode                  string // count or hash, defaults to count
+	JSON_Path             string // sample per value at this JSON path
+	Regex                 string // sample per regular expression capture
+	Rate_Enumerated_Value string // name of the enumerated value recording the rate, defaults to sample_rate
+}
+
+func SampleLoadConfig(vc *config.VariableConfig) (c SampleConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c SampleConfig) mode() string {
+	if m := strings.TrimSpace(strings.ToLower(c.Mode)); m != `` {
+		return m
+	}
+	return sampleModeCount
+}
+
+func (c SampleConfig) rateName() string {
+	if c.Rate_Enumerated_Value != `` {
+		return c.Rate_Enumerated_Value
+	}
+	return sampleDefaultRateName
+}
+
+func (c SampleConfig) validate() (err error) {
+	if c.Rate <= 0 {
+		return errors.New("Rate must be greater than zero")
+	}
+	switch c.mode() {
+	case sampleModeCount:
+	case sampleModeHash:
+	default:
+		return fmt.Errorf("Invalid Mode %q", c.Mode)
+	}
+	_, err = newValueSource(c.JSON_Path, c.Regex, ``)
+	return
+}
+
+type Sample struct {
+	nocloser
+	SampleConfig
+	src    valueSource
+	hash   bool
+	rate   uint64
+	evName string
+	tags   map[entry.EntryTag]uint64
+	keys   map[string]uint64
+}
+
+func NewSample(cfg SampleConfig) (s *Sample, err error) {
+	s = &Sample{}
+	if err = s.init(cfg); err != nil {
+		s = nil
+	}
+	return
+}
+
+func (s *Sample) init(cfg SampleConfig) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	var src valueSource
+	if src, err = newValueSource(cfg.JSON_Path, cfg.Regex, ``); err != nil {
+		return
+	}
+	*s = Sample{
+		SampleConfig: cfg,
+		src:          src,
+		hash:         cfg.mode() == sampleModeHash,
+		rate:         uint64(cfg.Rate),
+		evName:       cfg.rateName(),
+		tags:         make(map[entry.EntryTag]uint64),
+		keys:         make(map[string]uint64),
+	}
+	return
+}
+
+func (s *Sample) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(SampleConfig); ok {
+		var ns Sample
+		if err = ns.init(cfg); err == nil {
+			*s = ns
+		}
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (s *Sample) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if s.keep(ent) {
+			ent.AddEnumeratedValueEx(s.evName, s.rate)
+			rset = append(rset, ent)
+		}
+	}
+	return
+}
+
+func (s *Sample) keep(ent *entry.Entry) bool {
+	var key []byte
+	var ok bool
+	if !s.src.useSRC() {
+		key, ok = s.src.value(ent)
+	}
+	if s.hash {
+		if !ok {
+			key = ent.Data
+		}
+		h := fnv.New64a()
+		h.Write(key)
+		return h.Sum64()%s.rate == 0
+	}
+	var cnt uint64
+	if ok {
+		if len(s.keys) >= sampleMaxKeys {
+			//don't let a high cardinality key grow without bound
+			s.keys = make(map[string]uint64)
+		}
+		cnt = s.keys[string(key)]
+		s.keys[string(key)] = cnt + 1
+	} else {
+		cnt = s.tags[ent.Tag]
+		s.tags[ent.Tag] = cnt + 1
+	}
+	return cnt%s.rate == 0
+}
+
+func (s *Sample) Flush() []*entry.Entry {
+	return nil
+}
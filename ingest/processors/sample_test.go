@@ -0,0 +1,125 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestSampleConfig(t *testing.T) {
+	b := `
+	[preprocessor "smp"]
+		type = sample
+		Rate=10
+		Mode=hash
+		JSON-Path="user.id"
+		Rate-Enumerated-Value=rate
+	`
+	p, err := testLoadPreprocessor(b, `smp`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, ok := p.(*Sample)
+	if !ok {
+		t.Fatalf("preprocessor is the wrong type: %T != *Sample", p)
+	} else if !sp.hash || sp.rate != 10 || sp.evName != `rate` {
+		t.Fatalf("bad config %+v", sp.SampleConfig)
+	}
+	for _, c := range []SampleConfig{
+		{},
+		{Rate: -1},
+		{Rate: 2, Mode: `random`},
+		{Rate: 2, JSON_Path: `a`, Regex: `(b)`},
+	} {
+		if err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestSampleCount(t *testing.T) {
+	s, err := NewSample(SampleConfig{Rate: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ents []*entry.Entry
+	for i := 0; i < 9; i++ {
+		ents = append(ents, &entry.Entry{Tag: entry.EntryTag(i % 2), Data: []byte(fmt.Sprintf("entry %d", i))})
+	}
+	set, err := s.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//tag 0 gets entries 0,2,4,6,8 and keeps 0 and 6, tag 1 gets 1,3,5,7 and keeps 1 and 7
+	exp := []string{`entry 0`, `entry 1`, `entry 6`, `entry 7`}
+	if len(set) != len(exp) {
+		t.Fatalf("bad result count %d != %d", len(set), len(exp))
+	}
+	for i := range exp {
+		if string(set[i].Data) != exp[i] {
+			t.Fatalf("bad sample %d %q != %q", i, set[i].Data, exp[i])
+		} else if v, ok := set[i].GetEnumeratedValue(sampleDefaultRateName); !ok || v != uint64(3) {
+			t.Fatalf("bad rate EV %v", v)
+		}
+	}
+
+	//per key counters are independent of the tag
+	ks, err := NewSample(SampleConfig{Rate: 2, Regex: `user=(\w+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents = []*entry.Entry{
+		makeRawTestEntry(`user=a 1`),
+		makeRawTestEntry(`user=a 2`),
+		makeRawTestEntry(`user=b 1`),
+		makeRawTestEntry(`user=a 3`),
+		makeRawTestEntry(`nobody`),
+	}
+	if set, err = ks.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 4 || string(set[1].Data) != `user=b 1` || string(set[2].Data) != `user=a 3` {
+		t.Fatalf("bad keyed sample %d", len(set))
+	}
+}
+
+func TestSampleHash(t *testing.T) {
+	s, err := NewSample(SampleConfig{Rate: 4, Mode: `hash`, JSON_Path: `id`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := map[string]int{}
+	var total int
+	for pass := 0; pass < 2; pass++ {
+		var ents []*entry.Entry
+		for i := 0; i < 1000; i++ {
+			ents = append(ents, makeRawTestEntry(fmt.Sprintf(`{"id":"key%d","pass":%d}`, i, pass)))
+		}
+		set, err := s.Process(ents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += len(set)
+		for _, ent := range set {
+			id, _ := s.src.value(ent)
+			kept[string(id)]++
+		}
+	}
+	//every key is either always or never kept
+	for k, v := range kept {
+		if v != 2 {
+			t.Fatalf("key %s was not sampled consistently", k)
+		}
+	}
+	if total < 300 || total > 700 {
+		t.Fatalf("hash sampling is badly skewed, kept %d of 2000", total)
+	}
+}
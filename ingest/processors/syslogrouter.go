@@ -31,11 +31,17 @@ type SyslogRouterConfig struct {
 }
 
 func (src *SyslogRouterConfig) validate() (err error) {
-	var f *formatter
 	if src.Template == `` {
 		err = errors.New("missing Template")
 		return
-	} else if f, err = newFormatter(src.Template); err != nil {
+	}
+	_, err = newRouteFormatter(src.Template)
+	return
+}
+
+// newRouteFormatter builds a formatter for a tag routing template
+func newRouteFormatter(tmpl string) (f *formatter, err error) {
+	if f, err = newFormatter(tmpl); err != nil {
 		return
 	}
 
@@ -44,7 +50,7 @@ func (src *SyslogRouterConfig) validate() (err error) {
 		if cn, ok := n.(*constNode); ok && cn != nil && len(cn.val) > 0 {
 			if err = ingest.CheckTag(string(cn.val)); err != nil {
 				err = fmt.Errorf("constant value %q violates tag spec %w", string(cn.val), err)
-				return
+				return nil, err
 			}
 		}
 	}
@@ -182,12 +188,18 @@ func remapTagCharacters(orig string) (ret string, err error) {
 }
 
 func (sr *SyslogRouter) processEntry(ent *entry.Entry, parts syslogparser.LogParts) (tag entry.EntryTag, err error) {
-	var tagname string
-	var ok bool
 	if ent == nil {
 		return
 	}
-	if tagname = sr.tmp.renderWithAccessor(ent, getter{parts: parts}); tagname != `` {
+	return routeTemplate(sr.tmp, ent, getter{parts: parts}, sr.routes, sr.tagger)
+}
+
+// routeTemplate renders tmp against an entry and resolves the result to a tag, remapping
+// any characters that are not allowed in tag names.  Resolved tags are cached in routes.
+func routeTemplate(tmp *formatter, ent *entry.Entry, acc accessor, routes map[string]entry.EntryTag, tgr Tagger) (tag entry.EntryTag, err error) {
+	var ok bool
+	var tagname string
+	if tagname = tmp.renderWithAccessor(ent, acc); tagname != `` {
 		//check tag
 		if err = ingest.CheckTag(tagname); err != nil {
 			//tag has invalid stuff, remap it
@@ -197,10 +209,10 @@ func (sr *SyslogRouter) processEntry(ent *entry.Entry, parts syslogparser.LogPar
 		}
 	}
 	//tagname is good, try to resolve it
-	if tag, ok = sr.routes[tagname]; !ok {
+	if tag, ok = routes[tagname]; !ok {
 		//try to negotiate
-		if tag, err = sr.tagger.NegotiateTag(tagname); err == nil {
-			sr.routes[tagname] = tag
+		if tag, err = tgr.NegotiateTag(tagname); err == nil {
+			routes[tagname] = tag
 		}
 	}
 	return
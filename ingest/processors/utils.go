@@ -9,6 +9,13 @@
 package processors
 
 import (
+	"errors"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/jsonparser"
 	"github.com/inhies/go-bytesize"
 )
 
@@ -19,3 +26,77 @@ func parseDataSize(v string) (s int, err error) {
 	}
 	return
 }
+
+// valueSource pulls a single value out of an entry using either a JSON path or a regular
+// expression capture.  If neither is set the caller is expected to use the entry SRC.
+type valueSource struct {
+	keys  []string
+	rx    *regexp.Regexp
+	rxIdx int
+}
+
+// newValueSource builds a valueSource, jsonPath and regex are mutually exclusive.  If the
+// regex has a capture group named group that group is used, otherwise the first is.
+func newValueSource(jsonPath, regex, group string) (vs valueSource, err error) {
+	if jsonPath != `` && regex != `` {
+		err = errors.New("JSON-Path and Regex are mutually exclusive")
+		return
+	} else if jsonPath != `` {
+		vs.keys = unquoteFields(splitRespectQuotes(jsonPath, dotSplitter))
+	} else if regex != `` {
+		if vs.rx, err = regexp.Compile(regex); err != nil {
+			return
+		} else if vs.rx.NumSubexp() == 0 {
+			err = errors.New("Regex does not contain a capture group")
+			return
+		}
+		if vs.rxIdx = vs.rx.SubexpIndex(group); vs.rxIdx == -1 {
+			vs.rxIdx = 1
+		}
+	}
+	return
+}
+
+// useSRC reports if neither a JSON path or regex was configured
+func (vs valueSource) useSRC() bool {
+	return vs.keys == nil && vs.rx == nil
+}
+
+func (vs valueSource) value(ent *entry.Entry) (v []byte, ok bool) {
+	if vs.keys != nil {
+		if val, _, _, err := jsonparser.Get(ent.Data, vs.keys...); err == nil {
+			v, ok = val, true
+		}
+	} else if vs.rx != nil {
+		if mtchs := vs.rx.FindSubmatch(ent.Data); len(mtchs) > vs.rxIdx && mtchs[vs.rxIdx] != nil {
+			v, ok = mtchs[vs.rxIdx], true
+		}
+	}
+	return
+}
+
+// watchedFile remembers the modification time and size of a file so that preprocessors
+// can cheaply tell when a file they loaded has been rewritten
+type watchedFile struct {
+	path string
+	mod  time.Time
+	size int64
+}
+
+func newWatchedFile(pth string) (wf watchedFile, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(pth); err == nil {
+		wf = watchedFile{path: pth, mod: fi.ModTime(), size: fi.Size()}
+	}
+	return
+}
+
+// changed reports if the file has a different modification time or size than when it was
+// last seen, files that cannot be read are reported as unchanged
+func (wf watchedFile) changed() bool {
+	fi, err := os.Stat(wf.path)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(wf.mod) || fi.Size() != wf.size
+}
@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	replicaCacheDir   = `replicas`
+	queueDropWarnRate = 10 * time.Second
+)
+
+// muxQueue is the set of entry and batch channels that feed connection routines.
+// In the default distribution mode every connection pulls from a single shared queue,
+// when routing tags each route group gets its own queue, and when replicating each
+// destination gets its own queue, cache, and emergency queue so that a slow or dead
+// indexer never holds up the others.  Queues that spill to disk only drop entries once
+// their cache reaches its size limit.
+type muxQueue struct {
+	cache   *chancacher.ChanCacher
+	bcache  *chancacher.ChanCacher
+	eq      *emergencyQueue
+	dst     string
+	spill   bool // the caches are backed by disk and will absorb overflow
+	maxSize int  // maximum size of the backing caches in bytes, zero means unbounded
+
+	dropped  uint64 // entries dropped because the queue was full, atomic
+	lastWarn time.Time
+}
+
+func newMuxQueue(cache, bcache *chancacher.ChanCacher, maxSize int) *muxQueue {
+	return &muxQueue{
+		cache:   cache,
+		bcache:  bcache,
+		eq:      newEmergencyQueue(),
+		maxSize: maxSize,
+	}
+}
+
+// newReplicaQueues builds a queue for each destination, if a cache path is specified
+// each destination gets a subdirectory keyed on its address so that a restart with
+// reordered destinations still drains each cache to the right indexer.
+func newReplicaQueues(c MuxerConfig) (qs []*muxQueue, err error) {
+	depth := c.CacheDepth
+	if depth <= 0 {
+		depth = config.CACHE_DEPTH_DEFAULT
+	}
+	qs = make([]*muxQueue, 0, len(c.Destinations))
+	for _, dst := range c.Destinations {
+		var cache, bcache *chancacher.ChanCacher
+		var ePath, bPath string
+		if c.CachePath != `` {
+			base := filepath.Join(c.CachePath, replicaCacheDir, replicaCacheName(dst.Address))
+			ePath, bPath = filepath.Join(base, "e"), filepath.Join(base, "b")
+		}
+		if cache, err = newCacher(c, depth, ePath); err != nil {
+			return nil, fmt.Errorf("failed to create replica cache for %s %w", dst.Address, err)
+		}
+		if bcache, err = newCacher(c, depth, bPath); err != nil {
+			return nil, fmt.Errorf("failed to create replica cache for %s %w", dst.Address, err)
+		}
+		// replica caches are left running regardless of the cache mode, they only take
+		// data when the in-memory buffer is full, which is exactly when we want to spill
+		q := newMuxQueue(cache, bcache, mb*c.CacheSize)
+		q.dst = dst.Address
+		q.spill = c.CachePath != ``
+		qs = append(qs, q)
+	}
+	return
+}
+
+// replicaCacheName converts a destination address into something safe to use as a directory name
+func replicaCacheName(addr string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, addr)
+}
+
+// full returns true if a write to the cacher would block
+func (q *muxQueue) full(cc *chancacher.ChanCacher) bool {
+	if q.spill && (q.maxSize == 0 || cc.Size() < q.maxSize) {
+		return false
+	}
+	return len(cc.Out) >= cap(cc.Out)
+}
+
+// push hands a value to the cacher without letting a stuck destination block the caller.
+// If the queue is full and its cache is at the size limit the value is dropped and false is returned.
+func (q *muxQueue) push(cc *chancacher.ChanCacher, v interface{}, die chan bool) bool {
+	select {
+	case cc.In <- v:
+		return true
+	default:
+	}
+	if q.full(cc) {
+		return false
+	}
+	select {
+	case cc.In <- v:
+		return true
+	case <-die:
+	}
+	// the muxer is closing, take one last shot before giving up
+	select {
+	case cc.In <- v:
+		return true
+	default:
+	}
+	return false
+}
+
+// pushWait hands a value to the cacher, blocking until it is accepted or the muxer is closing
+func (q *muxQueue) pushWait(cc *chancacher.ChanCacher, v interface{}, die chan bool) bool {
+	select {
+	case cc.In <- v:
+		return true
+	case <-die:
+	}
+	select {
+	case cc.In <- v:
+		return true
+	default:
+	}
+	return false
+}
+
+func (q *muxQueue) pending() bool {
+	return len(q.cache.Out) > 0 || len(q.bcache.Out) > 0
+}
+
+func (q *muxQueue) size() int {
+	return q.cache.Size() + q.bcache.Size()
+}
+
+func (q *muxQueue) close() {
+	close(q.cache.In)
+	close(q.bcache.In)
+}
+
+func (q *muxQueue) commit() {
+	q.cache.Commit()
+	q.bcache.Commit()
+}
+
+// replicaSet appends the destination queues in a route group that should receive the next entry.
+// When replicating to a subset of the destinations we slide a window around the
+// group so that each destination ends up with an even share.
+func (im *IngestMuxer) replicaSet(rg *routeGroup, set []*muxQueue) []*muxQueue {
+	if im.replFactor == 0 || im.replFactor >= len(rg.targets) {
+		for _, idx := range rg.targets {
+			set = append(set, im.replicas[idx])
+		}
+		return set
+	}
+	for i := 0; i < im.replFactor; i++ {
+		set = append(set, im.replicas[rg.targets[(rg.next+i)%len(rg.targets)]])
+	}
+	rg.next = (rg.next + 1) % len(rg.targets)
+	return set
+}
+
+func (im *IngestMuxer) queueDropped(q *muxQueue, cnt int) {
+	total := atomic.AddUint64(&q.dropped, uint64(cnt))
+	if time.Since(q.lastWarn) < queueDropWarnRate {
+		return
+	}
+	q.lastWarn = time.Now()
+	im.Warn("destination queue and cache full, dropping entries",
+		log.KV("destination", q.dst), log.KV("dropped", total),
+		log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
+}
+
+// queuesPending returns true if any connected destination with its own queue still has entries queued.
+// Dead destinations are skipped, their data is held by their own queue until they return.
+// Caller must hold the lock.
+func (im *IngestMuxer) queuesPending() bool {
+	if len(im.groups) == 0 {
+		return false
+	}
+	for i := range im.igst {
+		if im.igst[i] != nil && im.igst[i].Running() && im.destQueue(i).pending() {
+			return true
+		}
+	}
+	return false
+}
+
+// queues returns every queue other than the shared intake
+func (im *IngestMuxer) queues() (qs []*muxQueue) {
+	if im.replicate {
+		return im.replicas
+	}
+	for _, rg := range im.groups {
+		qs = append(qs, rg.q)
+	}
+	return
+}
+
+// cachedBytes returns the number of bytes committed to disk across all caches
+func (im *IngestMuxer) cachedBytes() (sz int) {
+	sz = im.cache.Size() + im.bcache.Size()
+	for _, q := range im.queues() {
+		sz += q.size()
+	}
+	return
+}
+
+// QueueDropped returns the number of entries dropped by each destination queue because it was full.
+// Replica queues are keyed on the destination address and route group queues on their tag routes.
+// Drops only occur once the cache behind a queue has reached the configured maximum size.
+func (im *IngestMuxer) QueueDropped() map[string]uint64 {
+	qs := im.queues()
+	r := make(map[string]uint64, len(qs))
+	for _, q := range qs {
+		r[q.dst] = atomic.LoadUint64(&q.dropped)
+	}
+	return r
+}
+
+func copyEntryBatch(b []*entry.Entry) []*entry.Entry {
+	r := make([]*entry.Entry, len(b))
+	for i, e := range b {
+		if e != nil {
+			ce := *e
+			r[i] = &ce
+		}
+	}
+	return r
+}
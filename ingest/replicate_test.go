@@ -0,0 +1,246 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var replTargets = []Target{
+	{Address: `tcp://10.0.0.1:4023`, Secret: `foo`},
+	{Address: `tcp://10.0.0.2:4023`, Secret: `foo`},
+	{Address: `tls://10.0.0.3:4024`, Secret: `foo`},
+}
+
+func newTestReplicaMuxer(t *testing.T, factor, depth int) *IngestMuxer {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations:      replTargets,
+		Tags:              []string{`foo`, `bar`},
+		CacheDepth:        depth,
+		CachePath:         t.TempDir(),
+		CacheSize:         1,
+		Replicate:         true,
+		ReplicationFactor: factor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(im.replicas) != len(replTargets) {
+		t.Fatalf("invalid replica count %d", len(im.replicas))
+	}
+	// we do not want real connections, just fire up the fan out routine
+	im.state = running
+	im.wg.Add(1)
+	go im.fanoutRoutine()
+	return im
+}
+
+func stopTestReplicaMuxer(im *IngestMuxer) {
+	close(im.dieChan)
+	im.wg.Wait()
+}
+
+func TestReplicationFactorValidation(t *testing.T) {
+	_, err := NewMuxer(MuxerConfig{
+		Destinations:      replTargets,
+		Tags:              []string{`foo`},
+		Replicate:         true,
+		ReplicationFactor: len(replTargets) + 1,
+	})
+	if !errors.Is(err, ErrInvalidReplication) {
+		t.Fatalf("failed to catch bad replication factor: %v", err)
+	}
+	_, err = NewMuxer(MuxerConfig{
+		Destinations:      replTargets,
+		Tags:              []string{`foo`},
+		Replicate:         true,
+		ReplicationFactor: -1,
+	})
+	if !errors.Is(err, ErrInvalidReplication) {
+		t.Fatalf("failed to catch negative replication factor: %v", err)
+	}
+	_, err = NewMuxer(MuxerConfig{
+		Destinations: replTargets,
+		Tags:         []string{`foo`},
+		Replicate:    true,
+	})
+	if !errors.Is(err, ErrQueueCacheRequired) {
+		t.Fatalf("failed to catch replication without a cache: %v", err)
+	}
+}
+
+func TestReplicateAll(t *testing.T) {
+	im := newTestReplicaMuxer(t, 0, 16)
+	defer stopTestReplicaMuxer(im)
+
+	tag, err := im.GetTag(`bar`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Write(entry.Now(), tag, []byte(`hello`)); err != nil {
+		t.Fatal(err)
+	}
+	b := []*entry.Entry{
+		&entry.Entry{TS: entry.Now(), Tag: tag, Data: []byte(`a`)},
+		&entry.Entry{TS: entry.Now(), Tag: tag, Data: []byte(`b`)},
+	}
+	if err := im.WriteBatch(b); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []*entry.Entry
+	for _, q := range im.replicas {
+		select {
+		case v := <-q.cache.Out:
+			e := v.(*entry.Entry)
+			if string(e.Data) != `hello` || e.Tag != tag {
+				t.Fatalf("bad replicated entry: %+v", e)
+			}
+			seen = append(seen, e)
+		case <-time.After(time.Second):
+			t.Fatalf("replica %s did not get entry", q.dst)
+		}
+		select {
+		case v := <-q.bcache.Out:
+			if rb := v.([]*entry.Entry); len(rb) != len(b) || string(rb[1].Data) != `b` {
+				t.Fatalf("bad replicated batch: %+v", rb)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("replica %s did not get batch", q.dst)
+		}
+	}
+	// each destination translates tags in place, make sure nobody shares an entry
+	for i := range seen {
+		for j := i + 1; j < len(seen); j++ {
+			if seen[i] == seen[j] {
+				t.Fatal("replicas share an entry pointer")
+			}
+		}
+	}
+}
+
+func TestReplicateSubset(t *testing.T) {
+	im := newTestReplicaMuxer(t, 2, 16)
+	defer stopTestReplicaMuxer(im)
+
+	cnt := 3 * len(replTargets)
+	for i := 0; i < cnt; i++ {
+		if err := im.Write(entry.Now(), 0, []byte(`x`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := im.Sync(time.Second); err != ErrAllConnsDown {
+		// no connections are hot and there is no cache, sync must say so
+		t.Fatalf("bad sync response: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var total int
+	for _, q := range im.replicas {
+		if n := len(q.cache.Out); n != 2*cnt/len(replTargets) {
+			t.Fatalf("replica %s has uneven share %d", q.dst, n)
+		} else {
+			total += n
+		}
+	}
+	if total != 2*cnt {
+		t.Fatalf("bad replicated count %d != %d", total, 2*cnt)
+	}
+}
+
+func TestReplicaFullDrop(t *testing.T) {
+	im := newTestReplicaMuxer(t, 0, 1)
+	defer stopTestReplicaMuxer(im)
+
+	// nobody is draining the replicas, once their caches pass the 1MB limit writes must not block
+	buff := make([]byte, 64*1024)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 32; i++ {
+			if err := im.Write(entry.Now(), 0, buff); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stuck replica blocked the muxer")
+	}
+	time.Sleep(100 * time.Millisecond)
+	for k, v := range im.QueueDropped() {
+		if v == 0 {
+			t.Fatalf("replica %s did not drop anything", k)
+		}
+	}
+}
+
+func TestReplicaCacheSpill(t *testing.T) {
+	im := newTestReplicaMuxer(t, 0, 1)
+	for i := 0; i < 32; i++ {
+		if err := im.Write(entry.Now(), 0, []byte(`x`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for k, v := range im.QueueDropped() {
+		if v != 0 {
+			t.Fatalf("replica %s dropped %d entries with a cache", k, v)
+		}
+	}
+	stopTestReplicaMuxer(im)
+	for _, q := range im.replicas {
+		q.close()
+		q.commit()
+		if q.size() == 0 {
+			t.Fatalf("replica %s did not cache anything", q.dst)
+		}
+	}
+}
+
+func TestReplicaCacheName(t *testing.T) {
+	if v := replicaCacheName(`tls://10.0.0.1:4024`); v != `tls___10.0.0.1_4024` {
+		t.Fatalf("bad name %q", v)
+	}
+}
+
+func TestNewCacherBackend(t *testing.T) {
+	c := MuxerConfig{CacheBackend: `wal`, CacheSize: 1, CacheSync: `always`}
+	cc, err := newCacher(c, 4, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cc.WALStats(); !ok {
+		t.Fatal("cacher is not backed by a wal")
+	}
+	close(cc.In)
+	cc.Commit()
+
+	c.CacheBackend = `gob`
+	if cc, err = newCacher(c, 4, t.TempDir()); err != nil {
+		t.Fatal(err)
+	} else if _, ok := cc.WALStats(); ok {
+		t.Fatal("gob cacher is backed by a wal")
+	}
+	close(cc.In)
+	cc.Commit()
+
+	c.CacheBackend = `foo`
+	if _, err = newCacher(c, 4, t.TempDir()); !errors.Is(err, ErrInvalidCacheBackend) {
+		t.Fatalf("bad backend not caught: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	routeCacheDir = `routes`
+
+	// tagUnrouted is placed in a tag translator for tags that are not routed to the connection
+	tagUnrouted entry.EntryTag = entry.GravwellTagId - 1
+)
+
+var (
+	ErrTagNotRouted     = errors.New("Tag does not route to any destination")
+	ErrRouteMissingTags = errors.New("Destination tag route does not match any tags")
+)
+
+// routeGroup is a set of destinations that share the same tag routes.
+// Every tag is routed to exactly one group; destinations without any tag routes
+// form the default group which receives every tag that no other group claims.
+type routeGroup struct {
+	key      string
+	patterns []glob.Glob
+	targets  []int     // indexes into the muxer destinations
+	q        *muxQueue // shared queue for the group when not replicating
+	next     int       // rotation for partial replication
+}
+
+func (rg *routeGroup) isDefault() bool {
+	return len(rg.patterns) == 0
+}
+
+func (rg *routeGroup) match(tag string) bool {
+	for _, p := range rg.patterns {
+		if p.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeKey normalizes a destination tag route list so that destinations with the
+// same set of routes land in the same group regardless of ordering.
+func routeKey(tags []string) string {
+	tgs := append([]string{}, tags...)
+	sort.Strings(tgs)
+	return strings.Join(tgs, ",")
+}
+
+// newRouteGroups collects destinations into route groups.  If no destinations specify tag
+// routes and we are not replicating there is nothing to do and the muxer uses a single shared queue.
+func newRouteGroups(c MuxerConfig) (groups []*routeGroup, destGroup []int, err error) {
+	var routed bool
+	for _, d := range c.Destinations {
+		if len(d.Tags) > 0 {
+			routed = true
+			break
+		}
+	}
+	if !routed && !c.Replicate {
+		return
+	}
+	destGroup = make([]int, len(c.Destinations))
+	keys := map[string]int{}
+	for i, d := range c.Destinations {
+		key := routeKey(d.Tags)
+		idx, ok := keys[key]
+		if !ok {
+			rg := &routeGroup{
+				key: key,
+			}
+			for _, t := range d.Tags {
+				var g glob.Glob
+				if g, err = glob.Compile(t); err != nil {
+					err = fmt.Errorf("invalid tag route %q for %s %w", t, d.Address, err)
+					return
+				}
+				rg.patterns = append(rg.patterns, g)
+			}
+			idx = len(groups)
+			keys[key] = idx
+			groups = append(groups, rg)
+		}
+		groups[idx].targets = append(groups[idx].targets, i)
+		destGroup[i] = idx
+	}
+
+	if !c.Replicate {
+		// each group gets its own queue and cache so that a backed up group does not hold the others up
+		for _, rg := range groups {
+			var cache, bcache *chancacher.ChanCacher
+			var ePath, bPath string
+			if c.CachePath != `` {
+				h := fnv.New64a()
+				h.Write([]byte(rg.key))
+				base := filepath.Join(c.CachePath, routeCacheDir, fmt.Sprintf("%x", h.Sum64()))
+				ePath, bPath = filepath.Join(base, "e"), filepath.Join(base, "b")
+			}
+			if cache, err = newCacher(c, c.CacheDepth, ePath); err != nil {
+				return
+			}
+			if bcache, err = newCacher(c, c.CacheDepth, bPath); err != nil {
+				return
+			}
+			rg.q = newMuxQueue(cache, bcache, mb*c.CacheSize)
+			rg.q.dst = rg.key
+			rg.q.spill = c.CachePath != ``
+		}
+	}
+	return
+}
+
+// routeTag returns the index of the group that a tag routes to, -1 means it does not route anywhere
+func routeTag(groups []*routeGroup, name string) int {
+	def := -1
+	for i, rg := range groups {
+		if rg.isDefault() {
+			if def == -1 {
+				def = i
+			}
+		} else if rg.match(name) {
+			return i
+		}
+	}
+	return def
+}
+
+// buildRouteTable maps every local tag to a route group
+func buildRouteTable(groups []*routeGroup, tagMap map[string]entry.EntryTag) []int {
+	var sz int
+	for _, v := range tagMap {
+		if int(v) >= sz {
+			sz = int(v) + 1
+		}
+	}
+	tbl := make([]int, sz)
+	for i := range tbl {
+		tbl[i] = -1
+	}
+	for k, v := range tagMap {
+		tbl[v] = routeTag(groups, k)
+	}
+	return tbl
+}
+
+// checkRoutes ensures that every tag can be routed and every route will negotiate at least one tag
+func checkRoutes(groups []*routeGroup, dests []Target, tags []string) error {
+	hits := make([]int, len(groups))
+	for _, t := range tags {
+		idx := routeTag(groups, t)
+		if idx < 0 {
+			return fmt.Errorf("%w: %s", ErrTagNotRouted, t)
+		}
+		hits[idx]++
+	}
+	for i, rg := range groups {
+		if hits[i] == 0 {
+			return fmt.Errorf("%w: %s", ErrRouteMissingTags, dests[rg.targets[0]].Address)
+		}
+	}
+	return nil
+}
+
+// routeGroupOf returns the group that local tag should be delivered to
+func (im *IngestMuxer) routeGroupOf(tag entry.EntryTag) *routeGroup {
+	tbl, _ := im.tagRoutes.Load().([]int)
+	if tag == entry.GravwellTagId {
+		// ingester logs go to the default group if there is one, otherwise just the first
+		if idx := routeTag(im.groups, entry.GravwellTagName); idx >= 0 {
+			return im.groups[idx]
+		}
+		return im.groups[0]
+	}
+	if int(tag) >= len(tbl) || tbl[tag] < 0 {
+		return nil
+	}
+	return im.groups[tbl[tag]]
+}
+
+// destQueue returns the queue a destination's connection pulls from
+func (im *IngestMuxer) destQueue(igIdx int) *muxQueue {
+	if im.replicate {
+		return im.replicas[igIdx]
+	} else if len(im.groups) > 0 {
+		return im.groups[im.destGroup[igIdx]].q
+	}
+	return im.mq
+}
+
+// routedTo returns true if the local tag should be negotiated on the destination.
+// Caller must hold the lock.
+func (im *IngestMuxer) routedTo(igIdx int, name string) bool {
+	if len(im.groups) == 0 {
+		return true
+	}
+	return routeTag(im.groups, name) == im.destGroup[igIdx]
+}
+
+// routedTags returns the list of tags that should be negotiated on a destination.
+// Caller must hold the lock.
+func (im *IngestMuxer) routedTags(igIdx int) []string {
+	if len(im.groups) == 0 {
+		return im.tags
+	}
+	tags := make([]string, 0, len(im.tags))
+	for _, t := range im.tags {
+		if im.routedTo(igIdx, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// fanoutRoutine pulls entries from the muxer's intake and hands them to destination queues.
+// Entries are routed to the group that owns their tag, when replicating a copy goes to
+// each destination in the group selected by the replication factor.
+func (im *IngestMuxer) fanoutRoutine() {
+	defer im.wg.Done()
+	set := make([]*muxQueue, 0, len(im.replicas))
+	parts := make([][]*entry.Entry, len(im.groups))
+	eC := im.eChanOut
+	bC := im.bChanOut
+	for {
+		select {
+		case <-im.dieChan:
+			return
+		case ee, ok := <-eC:
+			if !ok {
+				if eC = nil; bC == nil {
+					return
+				}
+				continue
+			}
+			e, ok := ee.(*entry.Entry)
+			if !ok || e == nil {
+				continue
+			}
+			rg := im.routeGroupOf(e.Tag)
+			if rg == nil {
+				im.unroutable(e.Tag, 1)
+				continue
+			}
+			if !im.replicate {
+				im.pushGroup(rg.q, rg.q.cache, e, 1)
+				continue
+			}
+			set = im.replicaSet(rg, set[:0])
+			for i, q := range set {
+				ne := e
+				if i < len(set)-1 {
+					//each destination translates tags in place, so everyone but the last gets a copy
+					ce := *e
+					ne = &ce
+				}
+				if !q.push(q.cache, ne, im.dieChan) {
+					im.queueDropped(q, 1)
+				}
+			}
+		case bb, ok := <-bC:
+			if !ok {
+				if bC = nil; eC == nil {
+					return
+				}
+				continue
+			}
+			b, ok := bb.([]*entry.Entry)
+			if !ok || len(b) == 0 {
+				continue
+			}
+			// split the batch up by route group
+			if len(im.groups) == 1 && im.groups[0].isDefault() {
+				parts[0] = b
+			} else {
+				for i := range b {
+					if b[i] == nil {
+						continue
+					}
+					rg := im.routeGroupOf(b[i].Tag)
+					if rg == nil {
+						im.unroutable(b[i].Tag, 1)
+						continue
+					}
+					idx := im.destGroup[rg.targets[0]]
+					parts[idx] = append(parts[idx], b[i])
+				}
+			}
+			for idx, pb := range parts {
+				if len(pb) == 0 {
+					continue
+				}
+				parts[idx] = nil
+				rg := im.groups[idx]
+				if !im.replicate {
+					im.pushGroup(rg.q, rg.q.bcache, pb, len(pb))
+					continue
+				}
+				set = im.replicaSet(rg, set[:0])
+				for i, q := range set {
+					nb := pb
+					if i < len(set)-1 {
+						nb = copyEntryBatch(pb)
+					}
+					if !q.push(q.bcache, nb, im.dieChan) {
+						im.queueDropped(q, len(nb))
+					}
+				}
+			}
+		}
+	}
+}
+
+// pushGroup hands entries to a route group queue.  With a single group there is nobody else
+// to hold up so we wait on it.  Multiple groups always have a cache to spill into, a group
+// only drops entries once its cache is at the size limit so it cannot stall the others.
+func (im *IngestMuxer) pushGroup(q *muxQueue, cc *chancacher.ChanCacher, v interface{}, cnt int) {
+	if len(im.groups) == 1 {
+		q.pushWait(cc, v, im.dieChan)
+	} else if !q.push(cc, v, im.dieChan) {
+		im.queueDropped(q, cnt)
+	}
+}
+
+func (im *IngestMuxer) unroutable(tag entry.EntryTag, cnt int) {
+	name, _ := im.LookupTag(tag)
+	im.Error("Got entry with a tag that does not route to any indexer, dropping it",
+		log.KV("tag", name), log.KV("tagvalue", tag), log.KV("count", cnt),
+		log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
+}
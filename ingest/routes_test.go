@@ -0,0 +1,266 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var routeTargets = []Target{
+	{Address: `tcp://10.0.0.1:4023`, Secret: `foo`, Tags: []string{`windows*`}},
+	{Address: `tcp://10.0.0.2:4023`, Secret: `foo`, Tags: []string{`windows*`}},
+	{Address: `tcp://10.0.0.3:4023`, Secret: `foo`, Tags: []string{`netflow`}},
+	{Address: `tcp://10.0.0.4:4023`, Secret: `foo`},
+}
+
+func newTestRouteMuxer(t *testing.T, dests []Target, tags []string, replicate bool) *IngestMuxer {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations: dests,
+		Tags:         tags,
+		CacheDepth:   16,
+		CachePath:    t.TempDir(),
+		CacheSize:    1,
+		Replicate:    replicate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	im.state = running
+	im.wg.Add(1)
+	go im.fanoutRoutine()
+	return im
+}
+
+func TestRouteGroups(t *testing.T) {
+	groups, destGroup, err := newRouteGroups(MuxerConfig{Destinations: routeTargets, CacheDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 3 {
+		t.Fatalf("bad group count %d", len(groups))
+	}
+	if destGroup[0] != destGroup[1] || destGroup[1] == destGroup[2] || destGroup[2] == destGroup[3] {
+		t.Fatalf("bad destination groups %v", destGroup)
+	}
+	tests := map[string]int{
+		`windows`:        destGroup[0],
+		`windowsSysmon`:  destGroup[0],
+		`netflow`:        destGroup[2],
+		`netflowv9`:      destGroup[3],
+		`syslog`:         destGroup[3],
+		`winlog-windows`: destGroup[3],
+	}
+	for tag, grp := range tests {
+		if v := routeTag(groups, tag); v != grp {
+			t.Fatalf("tag %s routed to %d, expected %d", tag, v, grp)
+		}
+	}
+
+	// no routes and no replication means no groups at all
+	if groups, _, err = newRouteGroups(MuxerConfig{Destinations: replTargets}); err != nil {
+		t.Fatal(err)
+	} else if groups != nil {
+		t.Fatal("got route groups without any routes")
+	}
+}
+
+func TestRouteValidation(t *testing.T) {
+	// nobody takes syslog
+	_, err := NewMuxer(MuxerConfig{
+		Destinations: routeTargets[:3],
+		Tags:         []string{`windows`, `netflow`, `syslog`},
+	})
+	if !errors.Is(err, ErrTagNotRouted) {
+		t.Fatalf("failed to catch unrouted tag: %v", err)
+	}
+	// nothing goes to netflow
+	_, err = NewMuxer(MuxerConfig{
+		Destinations: routeTargets,
+		Tags:         []string{`windows`, `syslog`},
+	})
+	if !errors.Is(err, ErrRouteMissingTags) {
+		t.Fatalf("failed to catch empty route: %v", err)
+	}
+	// bad glob
+	_, err = NewMuxer(MuxerConfig{
+		Destinations: []Target{{Address: `tcp://10.0.0.1`, Tags: []string{`[foo`}}},
+		Tags:         []string{`foo`},
+	})
+	if err == nil {
+		t.Fatal("failed to catch bad tag glob")
+	}
+	// several groups need somewhere to spill
+	_, err = NewMuxer(MuxerConfig{
+		Destinations: routeTargets,
+		Tags:         []string{`windows`, `netflow`, `syslog`},
+	})
+	if !errors.Is(err, ErrQueueCacheRequired) {
+		t.Fatalf("failed to catch route groups without a cache: %v", err)
+	}
+	// a single group does not
+	_, err = NewMuxer(MuxerConfig{
+		Destinations: routeTargets[:2],
+		Tags:         []string{`windows`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutedTags(t *testing.T) {
+	im, err := NewMuxer(MuxerConfig{
+		Destinations: routeTargets[:3],
+		Tags:         []string{`windows`, `windowsApp`, `netflow`},
+		CachePath:    t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgs := im.routedTags(1)
+	sort.Strings(tgs)
+	if len(tgs) != 2 || tgs[0] != `windows` || tgs[1] != `windowsApp` {
+		t.Fatalf("bad routed tags %v", tgs)
+	}
+	if tgs = im.routedTags(2); len(tgs) != 1 || tgs[0] != `netflow` {
+		t.Fatalf("bad routed tags %v", tgs)
+	}
+
+	// no default route, so tags that match nothing cannot be negotiated
+	if _, err = im.NegotiateTag(`syslog`); !errors.Is(err, ErrTagNotRouted) {
+		t.Fatalf("negotiated an unroutable tag: %v", err)
+	}
+	if _, err = im.NegotiateTag(`windowsSecurity`); err != nil {
+		t.Fatal(err)
+	}
+	if tgs = im.routedTags(0); len(tgs) != 3 {
+		t.Fatalf("new tag not routed: %v", tgs)
+	}
+}
+
+func TestTagTransUnrouted(t *testing.T) {
+	tt := tagTrans{5, tagUnrouted, 7}
+	if v, ok := tt.Translate(2); !ok || v != 7 {
+		t.Fatalf("bad translation %v %v", v, ok)
+	}
+	if _, ok := tt.Translate(1); ok {
+		t.Fatal("translated an unrouted tag")
+	} else if !tt.unrouted(1) || tt.unrouted(0) || tt.unrouted(10) {
+		t.Fatal("bad unrouted check")
+	}
+	if err := tt.RegisterTag(3, tagUnrouted); err != nil {
+		t.Fatal(err)
+	} else if !tt.unrouted(3) {
+		t.Fatal("registered unrouted tag is routed")
+	}
+}
+
+func TestRouteFanout(t *testing.T) {
+	im := newTestRouteMuxer(t, routeTargets, []string{`windows`, `netflow`, `syslog`}, false)
+	defer stopTestReplicaMuxer(im)
+
+	win, _ := im.GetTag(`windows`)
+	nf, _ := im.GetTag(`netflow`)
+	sl, _ := im.GetTag(`syslog`)
+	if err := im.Write(entry.Now(), win, []byte(`win`)); err != nil {
+		t.Fatal(err)
+	}
+	b := []*entry.Entry{
+		&entry.Entry{Tag: nf, Data: []byte(`nf`)},
+		&entry.Entry{Tag: sl, Data: []byte(`sl`)},
+		&entry.Entry{Tag: win, Data: []byte(`win2`)},
+	}
+	if err := im.WriteBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// both windows destinations share a queue
+	if im.destQueue(0) != im.destQueue(1) || im.destQueue(0) == im.destQueue(2) {
+		t.Fatal("bad destination queues")
+	}
+	if v := <-im.destQueue(0).cache.Out; string(v.(*entry.Entry).Data) != `win` {
+		t.Fatalf("bad windows entry %v", v)
+	}
+	expect := map[int]string{0: `win2`, 2: `nf`, 3: `sl`}
+	for idx, data := range expect {
+		select {
+		case v := <-im.destQueue(idx).bcache.Out:
+			if pb := v.([]*entry.Entry); len(pb) != 1 || string(pb[0].Data) != data {
+				t.Fatalf("bad split batch for %d: %v", idx, pb)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("destination %d did not get its batch", idx)
+		}
+	}
+}
+
+func TestRouteReplicate(t *testing.T) {
+	im := newTestRouteMuxer(t, routeTargets, []string{`windows`, `netflow`, `syslog`}, true)
+	defer stopTestReplicaMuxer(im)
+
+	win, _ := im.GetTag(`windows`)
+	if err := im.Write(entry.Now(), win, []byte(`win`)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i, q := range im.replicas {
+		n := len(q.cache.Out)
+		if i < 2 && n != 1 {
+			t.Fatalf("windows replica %d did not get entry", i)
+		} else if i >= 2 && n != 0 {
+			t.Fatalf("non-windows replica %d got entry", i)
+		}
+	}
+}
+
+func TestRouteGroupFull(t *testing.T) {
+	im := newTestRouteMuxer(t, routeTargets, []string{`windows`, `netflow`, `syslog`}, false)
+	defer stopTestReplicaMuxer(im)
+
+	// nobody drains the windows group, once its cache passes the 1MB limit it must not hold up netflow
+	win, _ := im.GetTag(`windows`)
+	nf, _ := im.GetTag(`netflow`)
+	buff := make([]byte, 64*1024)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 64; i++ {
+			if err := im.Write(entry.Now(), win, buff); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- im.Write(entry.Now(), nf, []byte(`nf`))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("full route group blocked the muxer")
+	}
+	select {
+	case v := <-im.destQueue(2).cache.Out:
+		if string(v.(*entry.Entry).Data) != `nf` {
+			t.Fatalf("bad netflow entry %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("netflow group did not get its entry")
+	}
+	for end := time.Now().Add(5 * time.Second); im.QueueDropped()[im.destQueue(0).dst] == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatalf("windows group did not drop anything: %v", im.QueueDropped())
+		}
+	}
+}
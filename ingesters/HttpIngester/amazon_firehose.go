@@ -109,6 +109,7 @@ func handleAFH(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Requ
 				e.TS = entry.FromStandard(hts)
 			}
 		}
+		h.stampClient(cfg, e)
 		batch = append(batch, e)
 	}
 	if err := cfg.pproc.ProcessBatch(batch); err != nil {
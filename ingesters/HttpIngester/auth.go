@@ -124,6 +124,21 @@ func (a *auth) Validate() (enabled bool, err error) {
 	return
 }
 
+// validateRoutes validates the authentication config and registers the login URL, if any,
+// against the routes already in use
+func (a *auth) validateRoutes(name string, urls map[route]string) error {
+	if enabled, err := a.Validate(); err != nil {
+		return fmt.Errorf("Auth for %s is invalid: %v", name, err)
+	} else if enabled && a.LoginURL != `` {
+		rt := newRoute(http.MethodPost, a.LoginURL)
+		if orig, ok := urls[rt]; ok {
+			return fmt.Errorf("%s %s duplicated in %s (was in %s)", rt.method, rt.uri, name, orig)
+		}
+		urls[rt] = name
+	}
+	return nil
+}
+
 func (a auth) NewAuthHandler(lgr *log.Logger) (url string, hnd authHandler, err error) {
 	if lgr == nil {
 		err = errors.New("Nil logger")
@@ -9,7 +9,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
@@ -22,6 +21,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 )
 
 const (
@@ -34,11 +34,14 @@ const (
 
 type gbl struct {
 	config.IngestConfig
-	Bind                 string
-	Max_Body             int
-	TLS_Certificate_File string
-	TLS_Key_File         string
-	Health_Check_URL     string
+	Bind                           string
+	Max_Body                       int
+	TLS_Certificate_File           string
+	TLS_Key_File                   string
+	TLS_Client_CA_File             string   //require client certificates signed by a CA in this bundle
+	TLS_Client_Allowed_Name        []string //only accept client certificates with a matching CN or SAN
+	TLS_Client_CN_Enumerated_Value string   //enumerated value that receives the client CN, defaults to client_cn
+	Health_Check_URL               string
 }
 
 type cfgReadType struct {
@@ -47,6 +50,9 @@ type cfgReadType struct {
 	Listener                 map[string]*lst
 	HEC_Compatible_Listener  map[string]*hecCompatible
 	Amazon_Firehose_Listener map[string]*afh
+	OTLP_Listener            map[string]*otlp
+	Elastic_Bulk_Listener    map[string]*elasticBulk
+	Loki_Listener            map[string]*loki
 	Preprocessor             processors.ProcessorConfig
 	TimeFormat               config.CustomTimeFormat
 }
@@ -71,6 +77,9 @@ type cfgType struct {
 	Listener     map[string]*lst
 	HECListener  map[string]*hecCompatible
 	AFHListener  map[string]*afh
+	OTLPListener map[string]*otlp
+	ESListener   map[string]*elasticBulk
+	LokiListener map[string]*loki
 	Preprocessor processors.ProcessorConfig
 	TimeFormat   config.CustomTimeFormat
 }
@@ -88,6 +97,9 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		Listener:     cr.Listener,
 		HECListener:  cr.HEC_Compatible_Listener,
 		AFHListener:  cr.Amazon_Firehose_Listener,
+		OTLPListener: cr.OTLP_Listener,
+		ESListener:   cr.Elastic_Bulk_Listener,
+		LokiListener: cr.Loki_Listener,
 		Preprocessor: cr.Preprocessor,
 		TimeFormat:   cr.TimeFormat,
 	}
@@ -110,7 +122,8 @@ func (c *cfgType) Verify() error {
 		return err
 	}
 	urls := map[route]string{}
-	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.AFHListener) == 0 {
+	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.AFHListener) == 0 &&
+		len(c.OTLPListener) == 0 && len(c.ESListener) == 0 && len(c.LokiListener) == 0 {
 		return errors.New("No Listeners specified")
 	}
 	if err := c.Preprocessor.Validate(); err != nil {
@@ -179,6 +192,73 @@ func (c *cfgType) Verify() error {
 		c.AFHListener[k] = v
 	}
 
+	for k, v := range c.OTLPListener {
+		pth, err := v.validate(k)
+		if err != nil {
+			return err
+		}
+		rt := newRoute(http.MethodPost, pth)
+		if orig, ok := urls[rt]; ok {
+			return fmt.Errorf("URL %s duplicated in %s (was in %s)", v.URL, k, orig)
+		}
+		if err := v.auth.validateRoutes(k, urls); err != nil {
+			return err
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP OTLP-Listener %s preprocessor invalid: %v", k, err)
+		}
+		urls[rt] = k
+		c.OTLPListener[k] = v
+	}
+
+	for k, v := range c.ESListener {
+		pth, err := v.validate(k)
+		if err != nil {
+			return err
+		}
+		//the bulk API accepts POST and PUT, the base URL answers version checks
+		rts := []route{
+			newRoute(http.MethodPost, pth),
+			newRoute(http.MethodPut, pth),
+			newRoute(http.MethodGet, v.URL),
+			newRoute(http.MethodHead, v.URL),
+		}
+		for _, rt := range rts {
+			if orig, ok := urls[rt]; ok {
+				return fmt.Errorf("%s %s duplicated in %s (was in %s)", rt.method, rt.uri, k, orig)
+			}
+		}
+		if err := v.auth.validateRoutes(k, urls); err != nil {
+			return err
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP Elastic-Bulk-Listener %s preprocessor invalid: %v", k, err)
+		}
+		for _, rt := range rts {
+			urls[rt] = k
+		}
+		c.ESListener[k] = v
+	}
+
+	for k, v := range c.LokiListener {
+		pth, err := v.validate(k)
+		if err != nil {
+			return err
+		}
+		rt := newRoute(http.MethodPost, pth)
+		if orig, ok := urls[rt]; ok {
+			return fmt.Errorf("URL %s duplicated in %s (was in %s)", v.URL, k, orig)
+		}
+		if err := v.auth.validateRoutes(k, urls); err != nil {
+			return err
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP Loki-Listener %s preprocessor invalid: %v", k, err)
+		}
+		urls[rt] = k
+		c.LokiListener[k] = v
+	}
+
 	if len(urls) == 0 {
 		return fmt.Errorf("No listeners specified")
 	}
@@ -219,6 +299,45 @@ func (c *cfgType) Tags() (tags []string, err error) {
 			tagMp[v.Tag_Name] = true
 		}
 	}
+	for k, v := range c.OTLPListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on OTLP-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+	for k, v := range c.ESListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on Elastic-Bulk-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+	for k, v := range c.LokiListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on Loki-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
 
 	if len(tags) == 0 {
 		err = errors.New("No tags specified")
@@ -244,18 +363,44 @@ func (c *cfgType) MaxBody() int {
 }
 
 func (g gbl) ValidateTLS() (err error) {
+	clientAuth := g.TLS_Client_CA_File != `` || len(g.TLS_Client_Allowed_Name) > 0 || g.TLS_Client_CN_Enumerated_Value != ``
 	if !g.TLSEnabled() {
 		//not enabled
+		if clientAuth {
+			err = errors.New("TLS-Client options require TLS-Certificate-File and TLS-Key-File")
+		}
 	} else if g.TLS_Certificate_File == `` {
 		err = errors.New("TLS-Certificate-File argument is missing")
 	} else if g.TLS_Key_File == `` {
 		err = errors.New("TLS-Key-File argument is missing")
+	} else if clientAuth && g.TLS_Client_CA_File == `` {
+		err = errors.New("TLS-Client-CA-File is required to verify client certificates")
 	} else {
-		_, err = tls.LoadX509KeyPair(g.TLS_Certificate_File, g.TLS_Key_File)
+		err = g.ServerTLS().Validate()
 	}
 	return
 }
 
+func (g gbl) ServerTLS() utils.ServerTLSConfig {
+	return utils.ServerTLSConfig{
+		CertFile:     g.TLS_Certificate_File,
+		KeyFile:      g.TLS_Key_File,
+		ClientCAFile: g.TLS_Client_CA_File,
+		ClientAllow:  g.TLS_Client_Allowed_Name,
+	}
+}
+
+// ClientCNEnumeratedValue returns the enumerated value name for verified client
+// certificate names, it is empty when client certificates are not required
+func (g gbl) ClientCNEnumeratedValue() string {
+	if g.TLS_Client_CA_File == `` {
+		return ``
+	} else if g.TLS_Client_CN_Enumerated_Value != `` {
+		return g.TLS_Client_CN_Enumerated_Value
+	}
+	return utils.DefaultClientCNEnumeratedValue
+}
+
 func (g gbl) TLSEnabled() (r bool) {
 	r = g.TLS_Certificate_File != `` && g.TLS_Key_File != ``
 	return
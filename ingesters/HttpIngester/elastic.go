@@ -0,0 +1,436 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	defaultESUrl = `/`
+
+	esBulkPath        = `_bulk`
+	esIndexEV         = `index`
+	esVersion         = `8.11.0`
+	esLuceneVersion   = `9.8.0`
+	esMinWireVersion  = `7.17.0`
+	esMinIndexVersion = `7.0.0`
+	esProductHeader   = `X-Elastic-Product`
+	esProduct         = `Elasticsearch`
+
+	esActionIndex  = `index`
+	esActionCreate = `create`
+	esActionUpdate = `update`
+	esActionDelete = `delete`
+)
+
+var (
+	ErrESMissingAction = errors.New("action line is missing a document")
+	ErrESEmptyRequest  = errors.New("request body is required")
+)
+
+// elasticBulk emulates enough of the Elasticsearch API for Beats, Logstash, Fluent Bit, and
+// Vector to ship documents with their stock Elasticsearch outputs.  Documents sent with index
+// and create actions become entries, the destination index selects the tag via Tag_Match
+// which may use shell style wildcards to match dated indexes, e.g. filebeat-*:filebeat.
+type elasticBulk struct {
+	auth                     //authentication information
+	URL               string //base URL, the bulk API is served at URL/_bulk, defaults to /
+	Tag_Name          string //the tag to assign to documents that do not match a Tag_Match
+	Tag_Match         []string
+	Ignore_Timestamps bool //ignore the @timestamp field and use the time of arrival
+	Attach_Index      bool //attach the destination index as the "index" enumerated value
+	Preprocessor      []string
+}
+
+func (v *elasticBulk) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultESUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := path.Clean(`/` + p.Path)
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if _, err = v.tagMatchers(); err != nil {
+		return ``, fmt.Errorf("Elastic-Bulk-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return path.Join(pth, esBulkPath), nil
+}
+
+func (v *elasticBulk) tagMatchers() (tags []tagMatcher, err error) {
+	var tm tagMatcher
+	for i := range v.Tag_Match {
+		if tm.Value, tm.Tag, err = extractElementTag(v.Tag_Match[i]); err != nil {
+			break
+		} else if _, err = path.Match(tm.Value, ``); err != nil {
+			err = fmt.Errorf("Tag-Match %q has an invalid index pattern: %w", v.Tag_Match[i], err)
+			break
+		}
+		tags = append(tags, tm)
+	}
+	return
+}
+
+func (v *elasticBulk) tags() (tags []string, err error) {
+	var tms []tagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		tags = []string{v.Tag_Name}
+		mp[v.Tag_Name] = true
+	}
+	for _, tm := range tms {
+		if _, ok := mp[tm.Tag]; !ok {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
+
+// esIndexRoute maps index names or patterns to tags, patterns are checked in config order
+type esIndexRoute struct {
+	pattern string
+	tag     entry.EntryTag
+}
+
+func (v *elasticBulk) loadIndexRouter(igst *ingest.IngestMuxer) (r []esIndexRoute) {
+	if igst == nil || len(v.Tag_Match) == 0 {
+		return
+	}
+	if tm, err := v.tagMatchers(); err == nil {
+		for _, v := range tm {
+			if tag, err := igst.NegotiateTag(v.Tag); err == nil {
+				r = append(r, esIndexRoute{pattern: v.Value, tag: tag})
+			}
+		}
+	}
+	return
+}
+
+type esHandler struct {
+	name        string
+	attachIndex bool
+	router      []esIndexRoute
+}
+
+func (eh *esHandler) tag(def entry.EntryTag, index string) entry.EntryTag {
+	for _, r := range eh.router {
+		if ok, _ := path.Match(r.pattern, index); ok {
+			return r.tag
+		}
+	}
+	return def
+}
+
+// esAction is the metadata line preceding each document in a bulk request
+type esAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+type esError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type esItemResult struct {
+	Index       string   `json:"_index"`
+	ID          string   `json:"_id"`
+	Version     int      `json:"_version,omitempty"`
+	Result      string   `json:"result,omitempty"`
+	Shards      *esShard `json:"_shards,omitempty"`
+	SeqNo       *int     `json:"_seq_no,omitempty"`
+	PrimaryTerm int      `json:"_primary_term,omitempty"`
+	Status      int      `json:"status"`
+	Error       *esError `json:"error,omitempty"`
+}
+
+type esShard struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type esBulkResponse struct {
+	Took   int64                     `json:"took"`
+	Errors bool                      `json:"errors"`
+	Items  []map[string]esItemResult `json:"items"`
+}
+
+func (br *esBulkResponse) add(action string, r esItemResult) {
+	if r.Error != nil {
+		br.Errors = true
+	}
+	br.Items = append(br.Items, map[string]esItemResult{action: r})
+}
+
+// handleBulk processes an NDJSON bulk request and responds with a result for every action.
+// Documents are acknowledged only after they have been handed to the ingest muxer, if that
+// fails the document and everything after it are rejected with a 429 which the stock
+// outputs retry.
+func (eh *esHandler) handleBulk(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	start := time.Now()
+	resp := esBulkResponse{
+		Items: []map[string]esItemResult{},
+	}
+	var seq int
+	var ingestErr error
+	brdr := bufio.NewReader(io.LimitReader(rdr, int64(maxBody)+1))
+	var total int
+	for {
+		ln, err := readNDJSONLine(brdr, &total)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", eh.name), log.KV("max-body", maxBody), log.KVErr(err))
+			sendESError(w, http.StatusBadRequest, `parse_exception`, err.Error())
+			return
+		} else if len(ln) == 0 {
+			continue
+		}
+		var act map[string]esAction
+		if err = json.Unmarshal(ln, &act); err != nil || len(act) != 1 {
+			if err == nil {
+				err = fmt.Errorf("malformed action/metadata line [%d], expected a single action", len(resp.Items)+1)
+			}
+			h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", eh.name), log.KVErr(err))
+			sendESError(w, http.StatusBadRequest, `illegal_argument_exception`, err.Error())
+			return
+		}
+		var action string
+		var meta esAction
+		for k, v := range act {
+			action, meta = k, v
+		}
+
+		switch action {
+		case esActionDelete:
+			resp.add(action, esItemResult{Index: meta.Index, ID: meta.ID, Status: http.StatusBadRequest,
+				Error: &esError{Type: `illegal_argument_exception`, Reason: `delete is not supported`}})
+			continue
+		case esActionIndex, esActionCreate, esActionUpdate:
+		default:
+			sendESError(w, http.StatusBadRequest, `illegal_argument_exception`, fmt.Sprintf("Malformed action/metadata line [%d], expected one of [create, delete, index, update] but found [%s]", len(resp.Items)+1, action))
+			return
+		}
+
+		//every remaining action is followed by a document
+		var doc []byte
+		if doc, err = readNDJSONLine(brdr, &total); err == io.EOF {
+			sendESError(w, http.StatusBadRequest, `illegal_argument_exception`, ErrESMissingAction.Error())
+			return
+		} else if err != nil {
+			h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", eh.name), log.KV("max-body", maxBody), log.KVErr(err))
+			sendESError(w, http.StatusBadRequest, `parse_exception`, err.Error())
+			return
+		}
+		if meta.ID == `` {
+			meta.ID = newESID()
+		}
+		res := esItemResult{Index: meta.Index, ID: meta.ID}
+		if action == esActionUpdate {
+			res.Status = http.StatusBadRequest
+			res.Error = &esError{Type: `illegal_argument_exception`, Reason: `update is not supported`}
+		} else if ingestErr != nil {
+			res.Status = http.StatusTooManyRequests
+			res.Error = &esError{Type: `es_rejected_execution_exception`, Reason: ingestErr.Error()}
+		} else if !json.Valid(doc) {
+			res.Status = http.StatusBadRequest
+			res.Error = &esError{Type: `document_parsing_exception`, Reason: `failed to parse document`}
+		} else if ingestErr = h.handleEntryEx(cfg, eh.entry(cfg, meta.Index, doc, ip)); ingestErr != nil {
+			h.lgr.Error("failed to send entry", log.KV("listener", eh.name), log.KVErr(ingestErr))
+			res.Status = http.StatusTooManyRequests
+			res.Error = &esError{Type: `es_rejected_execution_exception`, Reason: ingestErr.Error()}
+		} else {
+			s := seq
+			seq++
+			res.Status = http.StatusCreated
+			res.Result = `created`
+			res.Version = 1
+			res.SeqNo = &s
+			res.PrimaryTerm = 1
+			res.Shards = &esShard{Total: 1, Successful: 1}
+		}
+		resp.add(action, res)
+	}
+	if len(resp.Items) == 0 {
+		sendESError(w, http.StatusBadRequest, `action_request_validation_exception`, ErrESEmptyRequest.Error())
+		return
+	}
+	resp.Took = time.Since(start).Milliseconds()
+	sendESJSON(w, http.StatusOK, resp)
+}
+
+func (eh *esHandler) entry(cfg routeHandler, index string, doc []byte, ip net.IP) (ent *entry.Entry) {
+	ent = &entry.Entry{
+		TS:   entry.Now(),
+		SRC:  ip,
+		Tag:  eh.tag(cfg.tag, index),
+		Data: append([]byte(nil), doc...),
+	}
+	if !cfg.ignoreTs {
+		var ts struct {
+			TS time.Time `json:"@timestamp"`
+		}
+		if err := json.Unmarshal(doc, &ts); err == nil && !ts.TS.IsZero() {
+			ent.TS = entry.FromStandard(ts.TS)
+		}
+	}
+	if eh.attachIndex && index != `` {
+		ent.AddEnumeratedValueEx(esIndexEV, index)
+	}
+	return
+}
+
+// handleInfo answers the root endpoint that clients query to detect the cluster version
+func (eh *esHandler) handleInfo(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	resp := map[string]interface{}{
+		`name`:         eh.name,
+		`cluster_name`: `gravwell`,
+		`cluster_uuid`: `gravwell`,
+		`version`: map[string]interface{}{
+			`number`:                              esVersion,
+			`build_flavor`:                        `default`,
+			`build_type`:                          `docker`,
+			`build_snapshot`:                      false,
+			`lucene_version`:                      esLuceneVersion,
+			`minimum_wire_compatibility_version`:  esMinWireVersion,
+			`minimum_index_compatibility_version`: esMinIndexVersion,
+		},
+		`tagline`: `You Know, for Search`,
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set(esProductHeader, esProduct)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	sendESJSON(w, http.StatusOK, resp)
+}
+
+// readNDJSONLine returns the next line without the trailing newline, total tracks the number
+// of bytes consumed so oversized requests are refused
+func readNDJSONLine(br *bufio.Reader, total *int) (ln []byte, err error) {
+	if ln, err = br.ReadBytes('\n'); err == io.EOF && len(ln) > 0 {
+		err = nil
+	}
+	if *total += len(ln); *total > maxBody {
+		err = ErrBodyTooLarge
+	}
+	ln = bytes.TrimSpace(ln)
+	return
+}
+
+// newESID generates a document ID in the same 20 character URL safe form Elasticsearch uses
+func newESID() string {
+	var b [15]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func sendESJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set(esProductHeader, esProduct)
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func sendESError(w http.ResponseWriter, code int, typ, reason string) {
+	e := esError{Type: typ, Reason: reason}
+	sendESJSON(w, code, map[string]interface{}{
+		`error`: map[string]interface{}{
+			`root_cause`: []esError{e},
+			`type`:       e.Type,
+			`reason`:     e.Reason,
+		},
+		`status`: code,
+	})
+}
+
+func includeESListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
+	for k, v := range cfg.ESListener {
+		eh := &esHandler{
+			name:        k,
+			attachIndex: v.Attach_Index,
+			router:      v.loadIndexRouter(igst),
+		}
+		hcfg := routeHandler{
+			handler:  eh.handleBulk,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		//check if authentication is enabled for this URL
+		var pth string
+		if pth, hcfg.auth, err = v.NewAuthHandler(lgr); err != nil {
+			lg.Error("failed to get a new authentication handler", log.KVErr(err))
+			return
+		} else if pth != `` {
+			if err = hnd.addAuthHandler(http.MethodPost, pth, hcfg.auth); err != nil {
+				lg.Error("failed to add auth handler", log.KV("url", pth), log.KVErr(err))
+				return
+			}
+		}
+		bulk := path.Join(v.URL, esBulkPath)
+		for _, m := range []string{http.MethodPost, http.MethodPut} {
+			if err = hnd.addHandler(m, bulk, hcfg); err != nil {
+				lg.Error("failed to add Elastic-Bulk-Listener handler", log.KV("url", bulk), log.KVErr(err))
+				return
+			}
+		}
+		// the root answers version detection from the clients
+		hcfg.handler = eh.handleInfo
+		for _, m := range []string{http.MethodGet, http.MethodHead} {
+			if err = hnd.addHandler(m, v.URL, hcfg); err != nil {
+				lg.Error("failed to add Elastic-Bulk-Listener info handler", log.KV("url", v.URL), log.KVErr(err))
+				return
+			}
+		}
+		debugout("Elastic Bulk Handler URL %s handling %s\n", bulk, v.Tag_Name)
+	}
+	return
+}
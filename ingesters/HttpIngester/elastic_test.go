@@ -0,0 +1,233 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const esTestListener = `
+[Elastic-Bulk-Listener "es"]
+	Tag-Name=es
+	Tag-Match="filebeat-*:filebeat"
+	Tag-Match="audit:esaudit"
+	Attach-Index=true
+`
+
+const esTestBulk = `{"index":{"_index":"filebeat-2024.01.01","_id":"1"}}
+{"@timestamp":"2024-01-01T00:00:00Z","message":"one"}
+
+{"create":{"_index":"other"}}
+{"message":"two"}
+{"delete":{"_index":"other","_id":"9"}}
+{"update":{"_index":"other","_id":"3"}}
+{"doc":{"a":1}}
+{"index":{"_index":"audit"}}
+{not json
+{"index":{"_index":"audit","_id":"4"}}
+{"message":"three"}
+`
+
+type esTestResponse struct {
+	Errors bool                      `json:"errors"`
+	Items  []map[string]esItemResult `json:"items"`
+}
+
+func TestESHandlerTag(t *testing.T) {
+	eh := esHandler{
+		router: []esIndexRoute{
+			{pattern: `filebeat-*`, tag: 1},
+			{pattern: `filebeat-8*`, tag: 2}, //shadowed, config order wins
+			{pattern: `audit`, tag: 3},
+		},
+	}
+	tests := map[string]entry.EntryTag{
+		`filebeat-8.11.0-2024.01.01`: 1,
+		`audit`:                      3,
+		`audit-2024`:                 0,
+		``:                           0,
+	}
+	for index, want := range tests {
+		if got := eh.tag(0, index); got != want {
+			t.Fatalf("index %q got tag %d != %d", index, got, want)
+		}
+	}
+}
+
+func TestReadNDJSONLine(t *testing.T) {
+	maxBody = 32
+	defer func() { maxBody = defaultMaxBody }()
+	br := bufio.NewReader(strings.NewReader("{\"a\":1}\r\n  \n{\"b\":2}"))
+	var total int
+	for _, want := range []string{`{"a":1}`, ``, `{"b":2}`} {
+		if ln, err := readNDJSONLine(br, &total); err != nil || string(ln) != want {
+			t.Fatalf("bad line %q %v != %q", ln, err, want)
+		}
+	}
+	if _, err := readNDJSONLine(br, &total); err != io.EOF {
+		t.Fatalf("expected EOF: %v", err)
+	}
+	br = bufio.NewReader(strings.NewReader(strings.Repeat(`x`, 20) + "\n" + strings.Repeat(`y`, 20) + "\n"))
+	total = 0
+	if _, err := readNDJSONLine(br, &total); err != nil {
+		t.Fatal(err)
+	} else if _, err = readNDJSONLine(br, &total); err != ErrBodyTooLarge {
+		t.Fatalf("oversized body not refused: %v", err)
+	}
+}
+
+func TestESBulk(t *testing.T) {
+	hs, srv := newTestIngester(t, esTestListener)
+	code, body := post(t, hs, `/_bulk`, `application/x-ndjson`, []byte(esTestBulk), false)
+	if code != http.StatusOK {
+		t.Fatalf("bad status %d %s", code, body)
+	}
+	var resp esTestResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	} else if !resp.Errors {
+		t.Fatal("per item errors were not flagged")
+	}
+
+	//every action gets a result in order, documents stay paired with their action
+	want := []struct {
+		action string
+		index  string
+		status int
+		errTyp string
+	}{
+		{esActionIndex, `filebeat-2024.01.01`, http.StatusCreated, ``},
+		{esActionCreate, `other`, http.StatusCreated, ``},
+		{esActionDelete, `other`, http.StatusBadRequest, `illegal_argument_exception`},
+		{esActionUpdate, `other`, http.StatusBadRequest, `illegal_argument_exception`},
+		{esActionIndex, `audit`, http.StatusBadRequest, `document_parsing_exception`},
+		{esActionIndex, `audit`, http.StatusCreated, ``},
+	}
+	if len(resp.Items) != len(want) {
+		t.Fatalf("bad item count %d != %d", len(resp.Items), len(want))
+	}
+	for i, w := range want {
+		res, ok := resp.Items[i][w.action]
+		if !ok || len(resp.Items[i]) != 1 {
+			t.Fatalf("item %d is not a %s result: %v", i, w.action, resp.Items[i])
+		} else if res.Index != w.index || res.Status != w.status {
+			t.Fatalf("item %d bad result %+v", i, res)
+		} else if w.errTyp == `` && res.Error != nil {
+			t.Fatalf("item %d unexpected error %+v", i, res.Error)
+		} else if w.errTyp != `` && (res.Error == nil || res.Error.Type != w.errTyp) {
+			t.Fatalf("item %d bad error %+v", i, res.Error)
+		}
+	}
+	if id := resp.Items[0][esActionIndex].ID; id != `1` {
+		t.Fatalf("supplied ID was not kept: %q", id)
+	} else if id = resp.Items[1][esActionCreate].ID; len(id) != 20 {
+		t.Fatalf("bad generated ID %q", id)
+	}
+
+	ents := waitEntries(t, srv, 3)
+	if len(ents) != 3 {
+		t.Fatalf("bad entry count %d", len(ents))
+	}
+	wantEnts := []struct {
+		msg, tag, index string
+	}{
+		{`one`, `filebeat`, `filebeat-2024.01.01`},
+		{`two`, `es`, `other`},
+		{`three`, `esaudit`, `audit`},
+	}
+	for i, w := range wantEnts {
+		var doc struct{ Message string }
+		if err := json.Unmarshal(ents[i].Data, &doc); err != nil || doc.Message != w.msg {
+			t.Fatalf("entry %d bad data %s", i, ents[i].Data)
+		} else if tg := tagOf(t, srv, ents[i]); tg != w.tag {
+			t.Fatalf("entry %d bad tag %s != %s", i, tg, w.tag)
+		} else if v, _ := evString(ents[i], esIndexEV); v != w.index {
+			t.Fatalf("entry %d bad index %q", i, v)
+		}
+	}
+	if !ents[0].TS.StandardTime().Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("@timestamp was not used: %v", ents[0].TS)
+	}
+}
+
+func TestESBulkBad(t *testing.T) {
+	hs, srv := newTestIngester(t, esTestListener)
+	tests := []struct {
+		name   string
+		body   string
+		errTyp string
+	}{
+		{`empty`, "\n\n", `action_request_validation_exception`},
+		{`missing document`, "{\"index\":{}}\n", `illegal_argument_exception`},
+		{`unknown action`, "{\"upsert\":{}}\n{}\n", `illegal_argument_exception`},
+		{`two actions`, "{\"index\":{},\"create\":{}}\n{}\n", `illegal_argument_exception`},
+		{`bad action`, "{index\n{}\n", `illegal_argument_exception`},
+		//a bad action part way through fails the request, documents before it are already ingested
+		{`bad second action`, "{\"index\":{}}\n{\"a\":1}\n[]\n{}\n", `illegal_argument_exception`},
+	}
+	for _, tt := range tests {
+		code, body := post(t, hs, `/_bulk`, `application/x-ndjson`, []byte(tt.body), false)
+		if code != http.StatusBadRequest {
+			t.Fatalf("%s: bad status %d %s", tt.name, code, body)
+		}
+		var resp struct {
+			Error struct {
+				Type string `json:"type"`
+			} `json:"error"`
+			Status int `json:"status"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		} else if resp.Error.Type != tt.errTyp || resp.Status != http.StatusBadRequest {
+			t.Fatalf("%s: bad error %+v", tt.name, resp)
+		}
+	}
+	//only the document ahead of the bad action made it through
+	if ents := waitEntries(t, srv, 1); len(ents) != 1 || string(ents[0].Data) != `{"a":1}` {
+		t.Fatalf("bad requests ingested %d entries", len(ents))
+	}
+}
+
+func TestESInfo(t *testing.T) {
+	hs, _ := newTestIngester(t, esTestListener)
+	resp, err := hs.Client().Get(hs.URL + `/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(esProductHeader) != esProduct {
+		t.Fatalf("bad info response %d %v", resp.StatusCode, resp.Header)
+	} else if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	} else if info.Version.Number != esVersion {
+		t.Fatalf("bad version %q", info.Version.Number)
+	}
+
+	req, _ := http.NewRequest(http.MethodHead, hs.URL+`/`, nil)
+	if resp, err = hs.Client().Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(esProductHeader) != esProduct {
+		t.Fatalf("bad HEAD response %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -34,6 +34,10 @@ const (
 	keepAliveTimeoutHeader = `timeout=120`
 )
 
+var (
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
 // note that handleFuncs should read from the reader, not from the Request.Body.
 type handleFunc func(*handler, routeHandler, http.ResponseWriter, *http.Request, io.Reader, net.IP)
 
@@ -45,6 +49,7 @@ type routeHandler struct {
 	auth          authHandler
 	pproc         *processors.ProcessorSet
 	paramAttacher paramAttacher
+	clientCN      string // verified client certificate name for the current request
 }
 
 type handler struct {
@@ -59,6 +64,7 @@ type handler struct {
 	custom         map[route]http.Handler
 	rawLineBreaker string
 	healthCheckURL string
+	clientEV       string // enumerated value name for client certificate names, empty if not required
 }
 
 func (rh routeHandler) handle(h *handler, w http.ResponseWriter, req *http.Request, rdr io.Reader, ip net.IP) {
@@ -69,6 +75,9 @@ func (rh routeHandler) handle(h *handler, w http.ResponseWriter, req *http.Reque
 		return
 	}
 	rh.paramAttacher.process(req)
+	if h.clientEV != `` && req.TLS != nil {
+		rh.clientCN = utils.ClientName(*req.TLS)
+	}
 	rh.handler(h, rh, w, req, rdr, ip)
 }
 
@@ -279,6 +288,7 @@ func (h *handler) handleEntry(cfg routeHandler, b []byte, ip net.IP, tag entry.E
 		Data: b,
 	}
 	cfg.paramAttacher.attach(&e)
+	h.stampClient(cfg, &e)
 	debugout("Handling: %+v\n", e)
 	if err = cfg.pproc.ProcessContext(&e, exitCtx); err != nil {
 		h.lgr.Error("failed to send entry", log.KVErr(err))
@@ -291,6 +301,7 @@ func (h *handler) handleEntry(cfg routeHandler, b []byte, ip net.IP, tag entry.E
 
 func (h *handler) handleEntryEx(rh routeHandler, ent *entry.Entry) (err error) {
 	if ent != nil {
+		h.stampClient(rh, ent)
 		if err = rh.pproc.ProcessContext(ent, exitCtx); err == nil {
 			h.entSI.Add(1)
 			h.bytesSI.Add(ent.Size())
@@ -299,6 +310,22 @@ func (h *handler) handleEntryEx(rh routeHandler, ent *entry.Entry) (err error) {
 	return
 }
 
+// stampClient attaches the verified client certificate name to the entry
+func (h *handler) stampClient(rh routeHandler, ent *entry.Entry) {
+	if rh.clientCN != `` {
+		ent.AddEnumeratedValueEx(h.clientEV, rh.clientCN)
+	}
+}
+
+// readLimitedBody reads the entire body, refusing anything larger than maxBody
+func readLimitedBody(rdr io.Reader) (b []byte, err error) {
+	lr := io.LimitedReader{R: rdr, N: int64(maxBody + 1)}
+	if b, err = io.ReadAll(&lr); err == nil && len(b) > maxBody {
+		err = ErrBodyTooLarge
+	}
+	return
+}
+
 // getReadableBody checks the encoding header and if this request is gzip compressed
 // then we transparently wrap it in a gzip reader
 func getReadableBody(r *http.Request) (rc io.ReadCloser, err error) {
@@ -0,0 +1,145 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/ingesttest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const testConfigBase = `
+[Global]
+Ingest-Secret = IngestSecrets
+Cleartext-Backend-target=127.0.0.1:4023
+Bind=127.0.0.1:8080
+`
+
+// newTestIngester builds the handler for the listeners in blocks and serves it with httptest,
+// entries land in an in-process indexer
+func newTestIngester(t *testing.T, blocks string) (*httptest.Server, *ingesttest.Server) {
+	lg = log.NewDiscardLogger()
+	pth := filepath.Join(t.TempDir(), `http_ingester.conf`)
+	if err := os.WriteFile(pth, []byte(testConfigBase+blocks), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := GetConfig(pth, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxBody = cfg.MaxBody()
+	tags, err := cfg.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := ingesttest.NewServer(ingesttest.Config{Secret: `IngestSecrets`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	tgt, err := srv.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	igst, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations: []string{tgt},
+		Tags:         tags,
+		Auth:         `IngestSecrets`,
+		IngesterName: appName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = igst.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = igst.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { igst.Close() })
+
+	hnd, err := newHandler(igst, lg, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = includeOTLPListeners(hnd, igst, cfg, lg); err != nil {
+		t.Fatal(err)
+	} else if err = includeESListeners(hnd, igst, cfg, lg); err != nil {
+		t.Fatal(err)
+	} else if err = includeLokiListeners(hnd, igst, cfg, lg); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(hnd)
+	t.Cleanup(hs.Close)
+	return hs, srv
+}
+
+// post sends body to the test server and returns the response code and body
+func post(t *testing.T, hs *httptest.Server, pth, contentType string, body []byte, gz bool) (int, []byte) {
+	if gz {
+		var bb bytes.Buffer
+		gw := gzip.NewWriter(&bb)
+		gw.Write(body)
+		gw.Close()
+		body = bb.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, hs.URL+pth, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != `` {
+		req.Header.Set(`Content-Type`, contentType)
+	}
+	if gz {
+		req.Header.Set(`Content-Encoding`, `gzip`)
+	}
+	resp, err := hs.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rb bytes.Buffer
+	rb.ReadFrom(resp.Body)
+	return resp.StatusCode, rb.Bytes()
+}
+
+// waitEntries waits for cnt entries to land in the indexer
+func waitEntries(t *testing.T, srv *ingesttest.Server, cnt int) []*entry.Entry {
+	if err := srv.WaitForEntries(cnt, 5*time.Second); err != nil {
+		t.Fatalf("waiting for %d entries: %v (have %d)", cnt, err, srv.Count())
+	}
+	return srv.Entries()
+}
+
+// tagOf resolves an entry tag to its name on the indexer
+func tagOf(t *testing.T, srv *ingesttest.Server, ent *entry.Entry) string {
+	name, ok := srv.TagName(ent.Tag)
+	if !ok {
+		t.Fatalf("unknown tag %d", ent.Tag)
+	}
+	return name
+}
+
+// evString returns an enumerated value rendered as a string
+func evString(ent *entry.Entry, name string) (string, bool) {
+	v, ok := ent.GetEnumeratedValue(name)
+	if !ok {
+		return ``, false
+	}
+	return fmt.Sprint(v), true
+}
@@ -0,0 +1,446 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultLokiUrl      = `/loki/api/v1/push`
+	defaultLokiTagLabel = `job`
+)
+
+var (
+	ErrLokiLabels = errors.New("invalid stream labels")
+	ErrLokiEntry  = errors.New("invalid stream entry")
+)
+
+// loki emulates the Grafana Loki push API, each stream entry becomes an entry with the stream
+// labels and any structured metadata attached as enumerated values.  The tag is selected by
+// looking up the Tag_Label value (job by default) in the Tag_Match list, streams that do not
+// match get Tag_Name.
+type loki struct {
+	auth                     //authentication information
+	URL               string //override the URL, defaults to "/loki/api/v1/push"
+	Tag_Name          string //the default tag to assign to entries
+	Tag_Label         string //stream label used for Tag_Match, defaults to job
+	Tag_Match         []string
+	Ignore_Timestamps bool //ignore the entry timestamps and use the time of arrival
+	Preprocessor      []string
+}
+
+func (v *loki) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultLokiUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := p.Path
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if len(v.Tag_Label) == 0 {
+		v.Tag_Label = defaultLokiTagLabel
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if _, err = v.tagMatchers(); err != nil {
+		return ``, fmt.Errorf("Loki-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return pth, nil
+}
+
+func (v *loki) tagMatchers() (tags []tagMatcher, err error) {
+	var tm tagMatcher
+	for i := range v.Tag_Match {
+		if tm.Value, tm.Tag, err = extractElementTag(v.Tag_Match[i]); err != nil {
+			break
+		}
+		tags = append(tags, tm)
+	}
+	return
+}
+
+func (v *loki) tags() (tags []string, err error) {
+	var tms []tagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		tags = []string{v.Tag_Name}
+		mp[v.Tag_Name] = true
+	}
+	for _, tm := range tms {
+		if _, ok := mp[tm.Tag]; !ok {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
+
+func (v *loki) loadTagRouter(igst *ingest.IngestMuxer) (mp map[string]entry.EntryTag) {
+	if igst == nil || len(v.Tag_Match) == 0 {
+		return
+	}
+	if tm, err := v.tagMatchers(); err == nil && len(tm) > 0 {
+		mp = make(map[string]entry.EntryTag, len(tm))
+		for _, v := range tm {
+			if tag, err := igst.NegotiateTag(v.Tag); err == nil {
+				mp[v.Value] = tag
+			}
+		}
+	}
+	return
+}
+
+// lokiLabel is a stream label or structured metadata pair
+type lokiLabel struct {
+	Name  string
+	Value string
+}
+
+type lokiEntry struct {
+	TS       time.Time
+	Line     string
+	Metadata []lokiLabel
+}
+
+type lokiStream struct {
+	Labels  []lokiLabel
+	Entries []lokiEntry
+}
+
+func (s lokiStream) label(name string) (string, bool) {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return ``, false
+}
+
+// decodeLokiJSON decodes the JSON push format where each value is a tuple of a nanosecond
+// timestamp string, the line, and an optional object of structured metadata
+func decodeLokiJSON(b []byte) (streams []lokiStream, err error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err = json.Unmarshal(b, &req); err != nil {
+		return
+	}
+	for _, s := range req.Streams {
+		ls := lokiStream{
+			Labels: make([]lokiLabel, 0, len(s.Stream)),
+		}
+		for k, v := range s.Stream {
+			ls.Labels = append(ls.Labels, lokiLabel{Name: k, Value: v})
+		}
+		sortLokiLabels(ls.Labels)
+		for _, v := range s.Values {
+			var le lokiEntry
+			if le, err = decodeLokiJSONValue(v); err != nil {
+				return
+			}
+			ls.Entries = append(ls.Entries, le)
+		}
+		streams = append(streams, ls)
+	}
+	return
+}
+
+func decodeLokiJSONValue(v []json.RawMessage) (le lokiEntry, err error) {
+	if len(v) < 2 || len(v) > 3 {
+		err = ErrLokiEntry
+		return
+	}
+	var tss string
+	var ts int64
+	if err = json.Unmarshal(v[0], &tss); err != nil {
+		return
+	} else if ts, err = strconv.ParseInt(tss, 10, 64); err != nil {
+		return
+	} else if err = json.Unmarshal(v[1], &le.Line); err != nil {
+		return
+	}
+	le.TS = time.Unix(0, ts)
+	if len(v) == 3 {
+		var md map[string]string
+		if err = json.Unmarshal(v[2], &md); err != nil {
+			return
+		}
+		for k, v := range md {
+			le.Metadata = append(le.Metadata, lokiLabel{Name: k, Value: v})
+		}
+		sortLokiLabels(le.Metadata)
+	}
+	return
+}
+
+// sortLokiLabels puts labels decoded from JSON objects in the same order the protobuf
+// encoding uses
+func sortLokiLabels(ls []lokiLabel) {
+	sort.Slice(ls, func(i, j int) bool { return ls[i].Name < ls[j].Name })
+}
+
+// decodeLokiProto decodes a snappy compressed logproto.PushRequest
+func decodeLokiProto(b []byte) (streams []lokiStream, err error) {
+	var n int
+	if n, err = snappy.DecodedLen(b); err != nil {
+		return
+	} else if n > maxBody {
+		err = ErrBodyTooLarge
+		return
+	}
+	if b, err = snappy.Decode(nil, b); err != nil {
+		return
+	}
+	err = pbWalk(b, func(f pbField) (err error) {
+		if f.num == 1 && f.typ == protowire.BytesType {
+			var ls lokiStream
+			if ls, err = decodePBLokiStream(f.b); err == nil {
+				streams = append(streams, ls)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodePBLokiStream(b []byte) (ls lokiStream, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		if f.typ != protowire.BytesType {
+			return
+		}
+		switch f.num {
+		case 1: //labels
+			ls.Labels, err = parseLokiLabels(string(f.b))
+		case 2: //entries
+			var le lokiEntry
+			if le, err = decodePBLokiEntry(f.b); err == nil {
+				ls.Entries = append(ls.Entries, le)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodePBLokiEntry(b []byte) (le lokiEntry, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		switch f.num {
+		case 1: //google.protobuf.Timestamp
+			var sec, nsec int64
+			err = pbWalk(f.b, func(f pbField) error {
+				switch f.num {
+				case 1:
+					sec = int64(f.v)
+				case 2:
+					nsec = int64(int32(f.v))
+				}
+				return nil
+			})
+			le.TS = time.Unix(sec, nsec)
+		case 2:
+			le.Line = string(f.b)
+		case 3: //structured metadata
+			var l lokiLabel
+			err = pbWalk(f.b, func(f pbField) error {
+				switch f.num {
+				case 1:
+					l.Name = string(f.b)
+				case 2:
+					l.Value = string(f.b)
+				}
+				return nil
+			})
+			le.Metadata = append(le.Metadata, l)
+		}
+		return
+	})
+	return
+}
+
+// parseLokiLabels parses a Prometheus style label set such as {job="varlogs", host="a"}
+func parseLokiLabels(s string) (r []lokiLabel, err error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, `{`) || !strings.HasSuffix(s, `}`) {
+		return nil, ErrLokiLabels
+	}
+	s = s[1 : len(s)-1]
+	for {
+		if s = strings.TrimLeft(s, " \t,"); s == `` {
+			return
+		}
+		name, rest, ok := strings.Cut(s, `=`)
+		if name = strings.TrimSpace(name); !ok || name == `` {
+			return nil, ErrLokiLabels
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		var q string
+		if q, err = strconv.QuotedPrefix(rest); err != nil {
+			return nil, ErrLokiLabels
+		}
+		var val string
+		if val, err = strconv.Unquote(q); err != nil {
+			return nil, ErrLokiLabels
+		}
+		r = append(r, lokiLabel{Name: name, Value: val})
+		s = rest[len(q):]
+	}
+}
+
+type lokiHandler struct {
+	name      string
+	tagLabel  string
+	tagRouter map[string]entry.EntryTag
+}
+
+func (lh *lokiHandler) handle(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	b, err := readLimitedBody(rdr)
+	if err == nil {
+		var streams []lokiStream
+		if lokiIsJSON(r) {
+			streams, err = decodeLokiJSON(b)
+		} else {
+			streams, err = decodeLokiProto(b)
+		}
+		if err == nil {
+			lh.process(h, cfg, w, streams, ip)
+			return
+		}
+	}
+	h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", lh.name), log.KV("max-body", maxBody), log.KVErr(err))
+	if err == ErrBodyTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (lh *lokiHandler) process(h *handler, cfg routeHandler, w http.ResponseWriter, streams []lokiStream, ip net.IP) {
+	for _, s := range streams {
+		tag := cfg.tag
+		if lh.tagRouter != nil {
+			if v, ok := s.label(lh.tagLabel); ok {
+				if t, ok := lh.tagRouter[v]; ok {
+					tag = t
+				}
+			}
+		}
+		for _, le := range s.Entries {
+			if len(le.Line) == 0 {
+				continue
+			}
+			ent := &entry.Entry{
+				TS:   entry.Now(),
+				SRC:  ip,
+				Tag:  tag,
+				Data: []byte(le.Line),
+			}
+			if !cfg.ignoreTs && !le.TS.IsZero() {
+				ent.TS = entry.FromStandard(le.TS)
+			}
+			for _, l := range s.Labels {
+				if l.Name != `` && l.Value != `` {
+					ent.AddEnumeratedValueEx(l.Name, l.Value)
+				}
+			}
+			for _, l := range le.Metadata {
+				if l.Name != `` && l.Value != `` {
+					ent.AddEnumeratedValueEx(l.Name, l.Value)
+				}
+			}
+			if err := h.handleEntryEx(cfg, ent); err != nil {
+				h.lgr.Error("failed to send entry", log.KV("listener", lh.name), log.KVErr(err))
+				//the client will retry the whole request, duplicates are better than loss
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lokiIsJSON checks the content type, Loki treats anything other than JSON as protobuf
+func lokiIsJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	return err == nil && mt == `application/json`
+}
+
+func includeLokiListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
+	for k, v := range cfg.LokiListener {
+		lh := &lokiHandler{
+			name:      k,
+			tagLabel:  v.Tag_Label,
+			tagRouter: v.loadTagRouter(igst),
+		}
+		hcfg := routeHandler{
+			handler:  lh.handle,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		//check if authentication is enabled for this URL
+		var pth string
+		if pth, hcfg.auth, err = v.NewAuthHandler(lgr); err != nil {
+			lg.Error("failed to get a new authentication handler", log.KVErr(err))
+			return
+		} else if pth != `` {
+			if err = hnd.addAuthHandler(http.MethodPost, pth, hcfg.auth); err != nil {
+				lg.Error("failed to add auth handler", log.KV("url", pth), log.KVErr(err))
+				return
+			}
+		}
+		if err = hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
+			lg.Error("failed to add Loki-Listener handler", log.KV("url", v.URL), log.KVErr(err))
+			return
+		}
+		debugout("Loki Handler URL %s handling %s\n", v.URL, v.Tag_Name)
+	}
+	return
+}
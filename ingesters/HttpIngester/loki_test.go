@@ -0,0 +1,218 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestParseLokiLabels(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []lokiLabel
+	}{
+		{`{}`, nil},
+		{` { } `, nil},
+		{`{job="varlogs"}`, []lokiLabel{{`job`, `varlogs`}}},
+		{`{job="varlogs", host="a"}`, []lokiLabel{{`job`, `varlogs`}, {`host`, `a`}}},
+		{`{ job = "x" ,host="b",}`, []lokiLabel{{`job`, `x`}, {`host`, `b`}}},
+		{`{msg="say \"hi\"\n", path="C:\\logs"}`, []lokiLabel{{`msg`, "say \"hi\"\n"}, {`path`, `C:\logs`}}},
+		{`{a="b,c=d"}`, []lokiLabel{{`a`, `b,c=d`}}},
+		{`{empty=""}`, []lokiLabel{{`empty`, ``}}},
+	}
+	for _, tt := range tests {
+		if got, err := parseLokiLabels(tt.in); err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: %v != %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		``,
+		`job="varlogs"`,
+		`{job="varlogs"`,
+		`job="varlogs"}`,
+		`{job=varlogs}`,
+		`{job="varlogs}`,
+		`{="x"}`,
+		`{job}`,
+		`{job="a" host="b"`,
+	} {
+		if r, err := parseLokiLabels(bad); err != ErrLokiLabels {
+			t.Fatalf("%s: parsed bad labels %v %v", bad, r, err)
+		}
+	}
+}
+
+// pbLokiEntry builds a logproto.EntryAdapter, metadata is given as name value pairs
+func pbLokiEntry(ts time.Time, line string, md ...string) []byte {
+	b := pbMsg(
+		pbBytes(1, pbMsg(pbVarint(1, uint64(ts.Unix())), pbVarint(2, uint64(ts.Nanosecond())))),
+		pbString(2, line),
+	)
+	for i := 0; i+1 < len(md); i += 2 {
+		b = append(b, pbBytes(3, pbMsg(pbString(1, md[i]), pbString(2, md[i+1])))...)
+	}
+	return b
+}
+
+// pbLokiPush builds a snappy compressed logproto.PushRequest with a single stream
+func pbLokiPush(labels string, entries ...[]byte) []byte {
+	s := pbString(1, labels)
+	for _, e := range entries {
+		s = append(s, pbBytes(2, e)...)
+	}
+	return snappy.Encode(nil, pbBytes(1, s))
+}
+
+var (
+	lokiTestTS1 = time.Unix(1700000000, 123)
+	lokiTestTS2 = time.Unix(1700000001, 0)
+)
+
+func lokiTestProto() []byte {
+	return pbLokiPush(`{host="web1", job="nginx"}`,
+		pbLokiEntry(lokiTestTS1, `GET /index.html`, `trace_id`, `abc`, `user`, `alice`),
+		pbLokiEntry(lokiTestTS2, `GET /favicon.ico`),
+	)
+}
+
+const lokiTestJSON = `{"streams":[{
+	"stream":{"job":"nginx","host":"web1"},
+	"values":[
+		["1700000000000000123","GET /index.html",{"user":"alice","trace_id":"abc"}],
+		["1700000001000000000","GET /favicon.ico"]
+	]}]}`
+
+var lokiTestStreams = []lokiStream{{
+	Labels: []lokiLabel{{`host`, `web1`}, {`job`, `nginx`}},
+	Entries: []lokiEntry{
+		{TS: lokiTestTS1, Line: `GET /index.html`, Metadata: []lokiLabel{{`trace_id`, `abc`}, {`user`, `alice`}}},
+		{TS: lokiTestTS2, Line: `GET /favicon.ico`},
+	},
+}}
+
+func TestLokiDecode(t *testing.T) {
+	maxBody = defaultMaxBody
+	tests := []struct {
+		name   string
+		decode func([]byte) ([]lokiStream, error)
+		in     []byte
+		want   []lokiStream
+	}{
+		{`proto`, decodeLokiProto, lokiTestProto(), lokiTestStreams},
+		{`json`, decodeLokiJSON, []byte(lokiTestJSON), lokiTestStreams},
+		{`proto empty`, decodeLokiProto, snappy.Encode(nil, nil), nil},
+		{`json empty`, decodeLokiJSON, []byte(`{"streams":[]}`), nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.decode(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: bad decode\n%+v\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLokiDecodeBad(t *testing.T) {
+	maxBody = defaultMaxBody
+	raw, _ := snappy.Decode(nil, lokiTestProto())
+	tests := []struct {
+		name   string
+		decode func([]byte) ([]lokiStream, error)
+		in     []byte
+	}{
+		{`proto not snappy`, decodeLokiProto, raw},
+		{`proto truncated`, decodeLokiProto, snappy.Encode(nil, raw[:len(raw)-1])},
+		{`proto bad labels`, decodeLokiProto, pbLokiPush(`job="nginx"`, pbLokiEntry(lokiTestTS1, `x`))},
+		{`json truncated`, decodeLokiJSON, []byte(lokiTestJSON[:len(lokiTestJSON)/2])},
+		{`json numeric timestamp`, decodeLokiJSON, []byte(`{"streams":[{"stream":{},"values":[[1700000000,"x"]]}]}`)},
+		{`json bad timestamp`, decodeLokiJSON, []byte(`{"streams":[{"stream":{},"values":[["soon","x"]]}]}`)},
+		{`json short value`, decodeLokiJSON, []byte(`{"streams":[{"stream":{},"values":[["1"]]}]}`)},
+		{`json long value`, decodeLokiJSON, []byte(`{"streams":[{"stream":{},"values":[["1","x",{},{}]]}]}`)},
+		{`json bad metadata`, decodeLokiJSON, []byte(`{"streams":[{"stream":{},"values":[["1","x",{"a":1}]]}]}`)},
+		{`json bad labels`, decodeLokiJSON, []byte(`{"streams":[{"stream":{"job":1},"values":[]}]}`)},
+	}
+	for _, tt := range tests {
+		if _, err := tt.decode(tt.in); err == nil {
+			t.Fatalf("%s: decoded bad input", tt.name)
+		}
+	}
+}
+
+const lokiTestListener = `
+[Loki-Listener "loki"]
+	Tag-Name=loki
+	Tag-Match="nginx:weblogs"
+`
+
+func TestLokiHandler(t *testing.T) {
+	hs, srv := newTestIngester(t, lokiTestListener)
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		gz          bool
+	}{
+		{`protobuf`, `application/x-protobuf`, lokiTestProto(), false},
+		{`json`, `application/json`, []byte(lokiTestJSON), false},
+		{`json gzip`, `application/json`, []byte(lokiTestJSON), true},
+	}
+	for _, tt := range tests {
+		srv.Reset()
+		if code, body := post(t, hs, defaultLokiUrl, tt.contentType, tt.body, tt.gz); code != http.StatusNoContent {
+			t.Fatalf("%s: bad status %d %s", tt.name, code, body)
+		}
+		ents := waitEntries(t, srv, 2)
+		if len(ents) != 2 {
+			t.Fatalf("%s: bad entry count %d", tt.name, len(ents))
+		}
+		for i, ent := range ents {
+			if tg := tagOf(t, srv, ent); tg != `weblogs` {
+				t.Fatalf("%s: entry %d bad tag %s", tt.name, i, tg)
+			} else if v, _ := evString(ent, `host`); v != `web1` {
+				t.Fatalf("%s: entry %d missing stream label %q", tt.name, i, v)
+			}
+		}
+		first := ents[0]
+		if string(first.Data) != `GET /index.html` || !first.TS.StandardTime().Equal(lokiTestTS1) {
+			t.Fatalf("%s: bad entry %q %v", tt.name, first.Data, first.TS)
+		} else if v, _ := evString(first, `trace_id`); v != `abc` {
+			t.Fatalf("%s: missing structured metadata %q", tt.name, v)
+		} else if _, ok := evString(ents[1], `trace_id`); ok {
+			t.Fatalf("%s: structured metadata leaked to the next entry", tt.name)
+		}
+	}
+
+	//streams that do not match get the default tag
+	srv.Reset()
+	body := pbLokiPush(`{job="syslog"}`, pbLokiEntry(lokiTestTS1, `hello`))
+	if code, _ := post(t, hs, defaultLokiUrl, ``, body, false); code != http.StatusNoContent {
+		t.Fatalf("bad status %d", code)
+	} else if ents := waitEntries(t, srv, 1); tagOf(t, srv, ents[0]) != `loki` {
+		t.Fatalf("unmatched stream got tag %s", tagOf(t, srv, ents[0]))
+	}
+
+	//malformed pushes are rejected without ingesting anything
+	srv.Reset()
+	if code, _ := post(t, hs, defaultLokiUrl, `application/x-protobuf`, []byte(lokiTestJSON), false); code != http.StatusBadRequest {
+		t.Fatalf("bad status on malformed protobuf %d", code)
+	} else if code, _ = post(t, hs, defaultLokiUrl, `application/json`, lokiTestProto(), false); code != http.StatusBadRequest {
+		t.Fatalf("bad status on malformed JSON %d", code)
+	} else if srv.Count() != 0 {
+		t.Fatalf("malformed requests ingested %d entries", srv.Count())
+	}
+}
@@ -10,7 +10,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -103,6 +102,7 @@ func main() {
 	if hcurl, ok := cfg.HealthCheck(); ok {
 		hnd.healthCheckURL = path.Clean(hcurl)
 	}
+	hnd.clientEV = cfg.ClientCNEnumeratedValue()
 	for _, v := range cfg.Listener {
 		hcfg := routeHandler{
 			handler:       handleSingle,
@@ -170,6 +170,15 @@ func main() {
 	if err = includeAFHListeners(hnd, igst, cfg, lg); err != nil {
 		lg.Fatal("failed to include Amazon Firehose Listeners", log.KVErr(err))
 	}
+	if err = includeOTLPListeners(hnd, igst, cfg, lg); err != nil {
+		lg.Fatal("failed to include OTLP Listeners", log.KVErr(err))
+	}
+	if err = includeESListeners(hnd, igst, cfg, lg); err != nil {
+		lg.Fatal("failed to include Elastic Bulk Listeners", log.KVErr(err))
+	}
+	if err = includeLokiListeners(hnd, igst, cfg, lg); err != nil {
+		lg.Fatal("failed to include Loki Listeners", log.KVErr(err))
+	}
 	var httpLogger *dlog.Logger
 	if debugOn || cfg.LogLevel() == `INFO` {
 		httpLogger = lg.StandardLogger()
@@ -192,12 +201,15 @@ func main() {
 
 	done := make(chan error, 1)
 	if cfg.TLSEnabled() {
-		srv.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
+		//the certificate, key, and client CA are reloaded when they change on disk
+		st, err := utils.NewServerTLS(cfg.ServerTLS(), lg)
+		if err != nil {
+			lg.Fatal("failed to load TLS configuration", log.KVErr(err))
 		}
+		srv.TLSConfig = st.Config()
 		go func(dc chan error) {
 			defer close(dc)
-			if err := srv.ServeTLS(lst, cfg.TLS_Certificate_File, cfg.TLS_Key_File); err != nil {
+			if err := srv.ServeTLS(lst, ``, ``); err != nil {
 				lg.Error(`failed to serve HTTPS`, log.KVErr(err))
 			}
 		}(done)
@@ -0,0 +1,370 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	defaultOTLPUrl          = `/v1/logs`
+	defaultOTLPTagAttribute = `service.name`
+
+	otlpContentProtobuf = `application/x-protobuf`
+	otlpContentJSON     = `application/json`
+
+	otlpSeverityEV     = `severity`
+	otlpSeverityNumEV  = `severity_number`
+	otlpTraceIDEV      = `trace_id`
+	otlpSpanIDEV       = `span_id`
+	otlpScopeNameEV    = `scope.name`
+	otlpScopeVersionEV = `scope.version`
+)
+
+// otlp is an OTLP/HTTP logs receiver, each LogRecord becomes an entry with the resource and
+// record attributes attached as enumerated values.  The tag is selected by looking up the
+// Tag_Attribute value (service.name by default) in the Tag_Match list, with Tag_From_Attribute
+// set the value itself is used as the tag when it is not in Tag_Match.  Records that do not
+// match get Tag_Name.
+type otlp struct {
+	auth                      //authentication information
+	URL                string //override the URL, defaults to "/v1/logs"
+	Tag_Name           string //the default tag to assign to records
+	Tag_Attribute      string //record or resource attribute used for Tag_Match, defaults to service.name
+	Tag_Match          []string
+	Tag_From_Attribute bool //use the Tag_Attribute value as the tag, forbidden characters become _
+	Ignore_Timestamps  bool //ignore time_unix_nano and use the time of arrival
+	Preprocessor       []string
+}
+
+func (v *otlp) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultOTLPUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := p.Path
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if len(v.Tag_Attribute) == 0 {
+		v.Tag_Attribute = defaultOTLPTagAttribute
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if _, err = v.tagMatchers(); err != nil {
+		return ``, fmt.Errorf("OTLP-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return pth, nil
+}
+
+func (v *otlp) tagMatchers() (tags []tagMatcher, err error) {
+	var tm tagMatcher
+	for i := range v.Tag_Match {
+		if tm.Value, tm.Tag, err = extractElementTag(v.Tag_Match[i]); err != nil {
+			break
+		}
+		tags = append(tags, tm)
+	}
+	return
+}
+
+func (v *otlp) tags() (tags []string, err error) {
+	var tms []tagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		tags = []string{v.Tag_Name}
+		mp[v.Tag_Name] = true
+	}
+	for _, tm := range tms {
+		if _, ok := mp[tm.Tag]; !ok {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
+
+func (v *otlp) loadTagRouter(igst *ingest.IngestMuxer) (mp map[string]entry.EntryTag) {
+	if igst == nil || len(v.Tag_Match) == 0 {
+		return
+	}
+	if tm, err := v.tagMatchers(); err == nil && len(tm) > 0 {
+		mp = make(map[string]entry.EntryTag, len(tm))
+		for _, v := range tm {
+			if tag, err := igst.NegotiateTag(v.Tag); err == nil {
+				mp[v.Value] = tag
+			}
+		}
+	}
+	return
+}
+
+type otlpHandler struct {
+	name      string
+	tagAttr   string
+	tagRouter map[string]entry.EntryTag
+
+	// tags derived from attribute values when Tag_From_Attribute is set
+	tagFromAttr bool
+	negotiate   func(string) (entry.EntryTag, error)
+	mtx         sync.Mutex
+	derived     map[string]otlpDerivedTag
+}
+
+type otlpDerivedTag struct {
+	tag entry.EntryTag
+	ok  bool // false if the value could not be used as a tag
+}
+
+// derivedTag maps an attribute value to a tag, negotiating it on first use.  Forbidden
+// characters are remapped so "checkout.api" becomes the tag checkout_api.  Values that
+// cannot be used are remembered so they are only logged once.
+func (oh *otlpHandler) derivedTag(v string) (tag entry.EntryTag, ok bool) {
+	if !oh.tagFromAttr || oh.negotiate == nil {
+		return
+	}
+	oh.mtx.Lock()
+	defer oh.mtx.Unlock()
+	if dt, found := oh.derived[v]; found {
+		return dt.tag, dt.ok
+	}
+	var dt otlpDerivedTag
+	if name, err := ingest.RemapTag(v, '_'); err != nil {
+		lg.Warn("attribute value cannot be used as a tag", log.KV("listener", oh.name), log.KV("value", v), log.KVErr(err))
+	} else if dt.tag, err = oh.negotiate(name); err != nil {
+		lg.Warn("failed to negotiate tag from attribute", log.KV("listener", oh.name), log.KV("tag", name), log.KVErr(err))
+	} else {
+		dt.ok = true
+	}
+	if oh.derived == nil {
+		oh.derived = map[string]otlpDerivedTag{}
+	}
+	oh.derived[v] = dt
+	return dt.tag, dt.ok
+}
+
+func (oh *otlpHandler) handle(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	isJSON := otlpIsJSON(r)
+	b, err := readLimitedBody(rdr)
+	if err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", oh.name), log.KV("max-body", maxBody), log.KVErr(err))
+		if err == ErrBodyTooLarge {
+			otlpRespond(w, isJSON, http.StatusRequestEntityTooLarge)
+		} else {
+			otlpRespond(w, isJSON, http.StatusBadRequest)
+		}
+		return
+	}
+
+	var req otlpLogsRequest
+	if isJSON {
+		req, err = decodeOTLPLogsJSON(b)
+	} else {
+		req, err = decodeOTLPLogsProto(b)
+	}
+	if err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("listener", oh.name), log.KVErr(err))
+		otlpRespond(w, isJSON, http.StatusBadRequest)
+		return
+	}
+
+	now := entry.Now()
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				ent := oh.entry(cfg, rl, sl, lr, ip, now)
+				if ent == nil {
+					continue
+				}
+				if err = h.handleEntryEx(cfg, ent); err != nil {
+					h.lgr.Error("failed to send entry", log.KV("listener", oh.name), log.KVErr(err))
+					//the client will retry the whole request, duplicates are better than loss
+					otlpRespond(w, isJSON, http.StatusServiceUnavailable)
+					return
+				}
+			}
+		}
+	}
+	otlpRespond(w, isJSON, http.StatusOK)
+}
+
+// entry maps a single LogRecord to an entry, records without a body are skipped
+func (oh *otlpHandler) entry(cfg routeHandler, rl otlpResourceLogs, sl otlpScopeLogs, lr otlpLogRecord, ip net.IP, now entry.Timestamp) (ent *entry.Entry) {
+	data := lr.Body.data()
+	if len(data) == 0 {
+		return
+	}
+	ent = &entry.Entry{
+		TS:   now,
+		SRC:  ip,
+		Tag:  cfg.tag,
+		Data: data,
+	}
+	if !cfg.ignoreTs {
+		if lr.TimeUnixNano != 0 {
+			ent.TS = entry.FromStandard(time.Unix(0, int64(lr.TimeUnixNano)))
+		} else if lr.ObservedTimeUnixNano != 0 {
+			ent.TS = entry.FromStandard(time.Unix(0, int64(lr.ObservedTimeUnixNano)))
+		}
+	}
+
+	//record attributes override resource attributes with the same key
+	var evs otlpEVs
+	for _, kv := range rl.Resource.Attributes {
+		evs.set(kv.Key, kv.Value)
+	}
+	for _, kv := range lr.Attributes {
+		evs.set(kv.Key, kv.Value)
+	}
+	if v, ok := evs.get(oh.tagAttr); ok {
+		if tag, ok := oh.tagRouter[v.String()]; ok {
+			ent.Tag = tag
+		} else if tag, ok = oh.derivedTag(v.String()); ok {
+			ent.Tag = tag
+		}
+	}
+	for _, kv := range evs {
+		if v, ok := kv.Value.evValue(); ok {
+			ent.AddEnumeratedValueEx(kv.Key, v)
+		}
+	}
+
+	if sl.Scope.Name != `` {
+		ent.AddEnumeratedValueEx(otlpScopeNameEV, sl.Scope.Name)
+	}
+	if sl.Scope.Version != `` {
+		ent.AddEnumeratedValueEx(otlpScopeVersionEV, sl.Scope.Version)
+	}
+	if lr.SeverityText != `` {
+		ent.AddEnumeratedValueEx(otlpSeverityEV, lr.SeverityText)
+	}
+	if lr.SeverityNumber != 0 {
+		ent.AddEnumeratedValueEx(otlpSeverityNumEV, int64(lr.SeverityNumber))
+	}
+	if len(lr.TraceID) > 0 {
+		ent.AddEnumeratedValueEx(otlpTraceIDEV, lr.TraceID.String())
+	}
+	if len(lr.SpanID) > 0 {
+		ent.AddEnumeratedValueEx(otlpSpanIDEV, lr.SpanID.String())
+	}
+	return
+}
+
+// otlpEVs is an ordered set of attributes
+type otlpEVs []otlpKeyValue
+
+func (evs *otlpEVs) set(k string, v otlpAnyValue) {
+	if k == `` {
+		return
+	}
+	for i := range *evs {
+		if (*evs)[i].Key == k {
+			(*evs)[i].Value = v
+			return
+		}
+	}
+	*evs = append(*evs, otlpKeyValue{Key: k, Value: v})
+}
+
+func (evs otlpEVs) get(k string) (v otlpAnyValue, ok bool) {
+	for _, kv := range evs {
+		if kv.Key == k {
+			return kv.Value, true
+		}
+	}
+	return
+}
+
+// otlpIsJSON checks the content type, OTLP/HTTP only defines protobuf and JSON encodings
+// and protobuf is the default
+func otlpIsJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	return err == nil && mt == otlpContentJSON
+}
+
+// otlpRespond sends an empty ExportLogsServiceResponse on success, the empty protobuf message
+// is zero bytes and the empty JSON message is {}
+func otlpRespond(w http.ResponseWriter, isJSON bool, code int) {
+	if isJSON {
+		w.Header().Set(`Content-Type`, otlpContentJSON)
+	} else {
+		w.Header().Set(`Content-Type`, otlpContentProtobuf)
+	}
+	w.WriteHeader(code)
+	if isJSON && code == http.StatusOK {
+		io.WriteString(w, `{}`)
+	}
+}
+
+func includeOTLPListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
+	for k, v := range cfg.OTLPListener {
+		oh := &otlpHandler{
+			name:        k,
+			tagAttr:     v.Tag_Attribute,
+			tagRouter:   v.loadTagRouter(igst),
+			tagFromAttr: v.Tag_From_Attribute,
+			negotiate:   igst.NegotiateTag,
+		}
+		hcfg := routeHandler{
+			handler:  oh.handle,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		//check if authentication is enabled for this URL
+		var pth string
+		if pth, hcfg.auth, err = v.NewAuthHandler(lgr); err != nil {
+			lg.Error("failed to get a new authentication handler", log.KVErr(err))
+			return
+		} else if pth != `` {
+			if err = hnd.addAuthHandler(http.MethodPost, pth, hcfg.auth); err != nil {
+				lg.Error("failed to add auth handler", log.KV("url", pth), log.KVErr(err))
+				return
+			}
+		}
+		if err = hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
+			lg.Error("failed to add OTLP-Listener handler", log.KV("url", v.URL), log.KVErr(err))
+			return
+		}
+		debugout("OTLP Handler URL %s handling %s\n", v.URL, v.Tag_Name)
+	}
+	return
+}
@@ -0,0 +1,394 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The types below are the subset of the OTLP logs data model that we ingest.  They decode
+// the OTLP/JSON encoding directly and are filled in by hand from the protobuf encoding, see
+// opentelemetry/proto/collector/logs/v1/logs_service.proto and opentelemetry/proto/logs/v1/logs.proto
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpUint64     `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpUint64     `json:"observedTimeUnixNano"`
+	SeverityNumber       int32          `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              otlpHexBytes   `json:"traceId"`
+	SpanID               otlpHexBytes   `json:"spanId"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *otlpInt64      `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKVList     `json:"kvlistValue,omitempty"`
+	BytesValue  []byte          `json:"bytesValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKVList struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpUint64 and otlpInt64 accept both the quoted strings that protojson emits for 64 bit
+// integers and bare JSON numbers
+type otlpUint64 uint64
+type otlpInt64 int64
+
+func (v *otlpUint64) UnmarshalJSON(b []byte) (err error) {
+	var x uint64
+	if s := string(bytes.Trim(b, `"`)); s != `` && s != `null` {
+		if x, err = strconv.ParseUint(s, 10, 64); err == nil {
+			*v = otlpUint64(x)
+		}
+	}
+	return
+}
+
+func (v *otlpInt64) UnmarshalJSON(b []byte) (err error) {
+	var x int64
+	if s := string(bytes.Trim(b, `"`)); s != `` && s != `null` {
+		if x, err = strconv.ParseInt(s, 10, 64); err == nil {
+			*v = otlpInt64(x)
+		}
+	}
+	return
+}
+
+// otlpHexBytes are trace and span IDs, which OTLP/JSON encodes as hex rather than base64
+type otlpHexBytes []byte
+
+func (v *otlpHexBytes) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err == nil {
+		*v, err = hex.DecodeString(s)
+	}
+	return
+}
+
+func (v otlpHexBytes) String() string {
+	return hex.EncodeToString(v)
+}
+
+// native converts the value to plain Go types, nil means the value was empty
+func (v otlpAnyValue) native() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		r := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, x := range v.ArrayValue.Values {
+			r = append(r, x.native())
+		}
+		return r
+	case v.KvlistValue != nil:
+		r := make(map[string]interface{}, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			r[kv.Key] = kv.Value.native()
+		}
+		return r
+	case v.BytesValue != nil:
+		return v.BytesValue
+	}
+	return nil
+}
+
+// evValue returns a value suitable for an enumerated value, arrays and key value lists are
+// rendered as JSON
+func (v otlpAnyValue) evValue() (r interface{}, ok bool) {
+	switch x := v.native().(type) {
+	case nil:
+	case []interface{}, map[string]interface{}:
+		if b, err := json.Marshal(x); err == nil {
+			r, ok = string(b), true
+		}
+	default:
+		r, ok = x, true
+	}
+	return
+}
+
+// data returns the value as entry data, strings and bytes are used as is and anything
+// structured is rendered as JSON
+func (v otlpAnyValue) data() []byte {
+	switch x := v.native().(type) {
+	case nil:
+	case string:
+		return []byte(x)
+	case []byte:
+		return x
+	default:
+		if b, err := json.Marshal(x); err == nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func (v otlpAnyValue) String() string {
+	if x, ok := v.native().(string); ok {
+		return x
+	}
+	return string(v.data())
+}
+
+func decodeOTLPLogsJSON(b []byte) (req otlpLogsRequest, err error) {
+	err = json.Unmarshal(b, &req)
+	return
+}
+
+// pbField is a single decoded protobuf field, v holds varint and fixed width values and b
+// holds length delimited values
+type pbField struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64
+	b   []byte
+}
+
+// pbWalk hands each field of an encoded message to fn, unknown fields are simply passed
+// along so callers can ignore them
+func pbWalk(b []byte, fn func(pbField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := pbField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.v = uint64(v)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeOTLPLogsProto(b []byte) (req otlpLogsRequest, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		if f.num == 1 && f.typ == protowire.BytesType {
+			var rl otlpResourceLogs
+			if rl, err = decodePBResourceLogs(f.b); err == nil {
+				req.ResourceLogs = append(req.ResourceLogs, rl)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodePBResourceLogs(b []byte) (rl otlpResourceLogs, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		if f.typ != protowire.BytesType {
+			return
+		}
+		switch f.num {
+		case 1: //resource
+			err = pbWalk(f.b, func(f pbField) (err error) {
+				if f.num == 1 && f.typ == protowire.BytesType {
+					var kv otlpKeyValue
+					if kv, err = decodePBKeyValue(f.b); err == nil {
+						rl.Resource.Attributes = append(rl.Resource.Attributes, kv)
+					}
+				}
+				return
+			})
+		case 2: //scope_logs
+			var sl otlpScopeLogs
+			if sl, err = decodePBScopeLogs(f.b); err == nil {
+				rl.ScopeLogs = append(rl.ScopeLogs, sl)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodePBScopeLogs(b []byte) (sl otlpScopeLogs, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		if f.typ != protowire.BytesType {
+			return
+		}
+		switch f.num {
+		case 1: //scope
+			err = pbWalk(f.b, func(f pbField) error {
+				if f.typ == protowire.BytesType {
+					switch f.num {
+					case 1:
+						sl.Scope.Name = string(f.b)
+					case 2:
+						sl.Scope.Version = string(f.b)
+					}
+				}
+				return nil
+			})
+		case 2: //log_records
+			var lr otlpLogRecord
+			if lr, err = decodePBLogRecord(f.b); err == nil {
+				sl.LogRecords = append(sl.LogRecords, lr)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodePBLogRecord(b []byte) (lr otlpLogRecord, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		switch f.num {
+		case 1:
+			lr.TimeUnixNano = otlpUint64(f.v)
+		case 11:
+			lr.ObservedTimeUnixNano = otlpUint64(f.v)
+		case 2:
+			lr.SeverityNumber = int32(f.v)
+		case 3:
+			lr.SeverityText = string(f.b)
+		case 5:
+			lr.Body, err = decodePBAnyValue(f.b)
+		case 6:
+			var kv otlpKeyValue
+			if kv, err = decodePBKeyValue(f.b); err == nil {
+				lr.Attributes = append(lr.Attributes, kv)
+			}
+		case 9:
+			lr.TraceID = otlpHexBytes(f.b)
+		case 10:
+			lr.SpanID = otlpHexBytes(f.b)
+		}
+		return
+	})
+	return
+}
+
+func decodePBKeyValue(b []byte) (kv otlpKeyValue, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		switch f.num {
+		case 1:
+			kv.Key = string(f.b)
+		case 2:
+			kv.Value, err = decodePBAnyValue(f.b)
+		}
+		return
+	})
+	return
+}
+
+func decodePBAnyValue(b []byte) (v otlpAnyValue, err error) {
+	err = pbWalk(b, func(f pbField) (err error) {
+		switch f.num {
+		case 1:
+			s := string(f.b)
+			v.StringValue = &s
+		case 2:
+			x := f.v != 0
+			v.BoolValue = &x
+		case 3:
+			x := otlpInt64(f.v)
+			v.IntValue = &x
+		case 4:
+			x := math.Float64frombits(f.v)
+			v.DoubleValue = &x
+		case 5:
+			av := &otlpArrayValue{}
+			err = pbWalk(f.b, func(f pbField) (err error) {
+				if f.num == 1 {
+					var x otlpAnyValue
+					if x, err = decodePBAnyValue(f.b); err == nil {
+						av.Values = append(av.Values, x)
+					}
+				}
+				return
+			})
+			v.ArrayValue = av
+		case 6:
+			kvl := &otlpKVList{}
+			err = pbWalk(f.b, func(f pbField) (err error) {
+				if f.num == 1 {
+					var kv otlpKeyValue
+					if kv, err = decodePBKeyValue(f.b); err == nil {
+						kvl.Values = append(kvl.Values, kv)
+					}
+				}
+				return
+			})
+			v.KvlistValue = kvl
+		case 7:
+			v.BytesValue = append([]byte{}, f.b...)
+		}
+		return
+	})
+	return
+}
@@ -0,0 +1,373 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobuf builders for ExportLogsServiceRequest payloads, field numbers follow
+// opentelemetry/proto/logs/v1/logs.proto and opentelemetry/proto/common/v1/common.proto
+
+func pbMsg(fields ...[]byte) (b []byte) {
+	for _, f := range fields {
+		b = append(b, f...)
+	}
+	return
+}
+
+func pbBytes(num protowire.Number, v []byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func pbString(num protowire.Number, v string) []byte {
+	return pbBytes(num, []byte(v))
+}
+
+func pbVarint(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func pbFixed64(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func pbKV(key string, val []byte) []byte {
+	return pbMsg(pbString(1, key), pbBytes(2, val))
+}
+
+func pbStrVal(v string) []byte { return pbString(1, v) }
+
+func pbResourceLogs(service string, scopeLogs ...[]byte) []byte {
+	rl := pbBytes(1, pbBytes(1, pbKV(`service.name`, pbStrVal(service))))
+	for _, sl := range scopeLogs {
+		rl = append(rl, pbBytes(2, sl)...)
+	}
+	return rl
+}
+
+func pbScopeLogs(name, version string, records ...[]byte) []byte {
+	sl := pbBytes(1, pbMsg(pbString(1, name), pbString(2, version)))
+	for _, lr := range records {
+		sl = append(sl, pbBytes(2, lr)...)
+	}
+	return sl
+}
+
+func pbRequest(resourceLogs ...[]byte) (b []byte) {
+	for _, rl := range resourceLogs {
+		b = append(b, pbBytes(1, rl)...)
+	}
+	return
+}
+
+var (
+	otlpTestTraceID, _ = hex.DecodeString(`5b8efff798038103d269b633813fc60c`)
+	otlpTestSpanID, _  = hex.DecodeString(`eee19b7ec3c1b174`)
+)
+
+// pbCheckoutRecord is the protobuf form of the first record in otlpTestJSON
+func pbCheckoutRecord() []byte {
+	return pbMsg(
+		pbFixed64(1, 1700000000000000000),
+		pbVarint(2, 9),
+		pbString(3, `INFO`),
+		pbBytes(5, pbStrVal(`order placed`)),
+		pbBytes(6, pbKV(`order.id`, pbVarint(3, 42))),
+		pbBytes(6, pbKV(`ok`, pbVarint(2, 1))),
+		pbBytes(6, pbKV(`ratio`, pbFixed64(4, math.Float64bits(0.5)))),
+		pbBytes(6, pbKV(`tags`, pbBytes(5, pbMsg(pbBytes(1, pbStrVal(`a`)), pbBytes(1, pbStrVal(`b`)))))),
+		pbBytes(9, otlpTestTraceID),
+		pbBytes(10, otlpTestSpanID),
+		pbVarint(99, 1), //unknown fields are skipped
+	)
+}
+
+// pbStructuredRecord has a key value list body and only an observed timestamp
+func pbStructuredRecord() []byte {
+	body := pbBytes(6, pbMsg(
+		pbBytes(1, pbKV(`user`, pbStrVal(`alice`))),
+		pbBytes(1, pbKV(`bytes`, pbBytes(7, []byte{0x01, 0x02}))),
+	))
+	return pbMsg(
+		pbFixed64(11, 1700000001000000000),
+		pbString(3, `WARN`),
+		pbBytes(5, body),
+	)
+}
+
+func pbTestRequest() []byte {
+	return pbRequest(
+		pbResourceLogs(`checkout`, pbScopeLogs(`app`, `1.2`, pbCheckoutRecord())),
+		pbResourceLogs(`frontend`, pbScopeLogs(`web`, ``, pbStructuredRecord())),
+	)
+}
+
+const otlpTestJSON = `{"resourceLogs":[
+ {"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+  "scopeLogs":[{"scope":{"name":"app","version":"1.2"},"logRecords":[{
+   "timeUnixNano":"1700000000000000000","severityNumber":9,"severityText":"INFO",
+   "body":{"stringValue":"order placed"},
+   "attributes":[
+    {"key":"order.id","value":{"intValue":"42"}},
+    {"key":"ok","value":{"boolValue":true}},
+    {"key":"ratio","value":{"doubleValue":0.5}},
+    {"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"stringValue":"b"}]}}}],
+   "droppedAttributesCount":0,
+   "traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b174"}]}]},
+ {"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"frontend"}}]},
+  "scopeLogs":[{"scope":{"name":"web"},"logRecords":[{
+   "observedTimeUnixNano":1700000001000000000,"severityText":"WARN",
+   "body":{"kvlistValue":{"values":[
+    {"key":"user","value":{"stringValue":"alice"}},
+    {"key":"bytes","value":{"bytesValue":"AQI="}}]}}}]}]}
+]}`
+
+// otlpFlat is a decoded record reduced to comparable values
+type otlpFlat struct {
+	Service  string
+	Scope    string
+	Version  string
+	Time     uint64
+	Observed uint64
+	SevNum   int32
+	SevText  string
+	Body     string
+	Attrs    map[string]interface{}
+	TraceID  string
+	SpanID   string
+}
+
+func flattenOTLP(req otlpLogsRequest) (r []otlpFlat) {
+	for _, rl := range req.ResourceLogs {
+		var svc string
+		for _, kv := range rl.Resource.Attributes {
+			if kv.Key == `service.name` {
+				svc = kv.Value.String()
+			}
+		}
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				f := otlpFlat{
+					Service:  svc,
+					Scope:    sl.Scope.Name,
+					Version:  sl.Scope.Version,
+					Time:     uint64(lr.TimeUnixNano),
+					Observed: uint64(lr.ObservedTimeUnixNano),
+					SevNum:   lr.SeverityNumber,
+					SevText:  lr.SeverityText,
+					Body:     string(lr.Body.data()),
+					TraceID:  lr.TraceID.String(),
+					SpanID:   lr.SpanID.String(),
+				}
+				for _, kv := range lr.Attributes {
+					if f.Attrs == nil {
+						f.Attrs = map[string]interface{}{}
+					}
+					f.Attrs[kv.Key], _ = kv.Value.evValue()
+				}
+				r = append(r, f)
+			}
+		}
+	}
+	return
+}
+
+var otlpTestFlat = []otlpFlat{
+	{
+		Service: `checkout`, Scope: `app`, Version: `1.2`,
+		Time: 1700000000000000000, SevNum: 9, SevText: `INFO`, Body: `order placed`,
+		Attrs: map[string]interface{}{
+			`order.id`: int64(42),
+			`ok`:       true,
+			`ratio`:    0.5,
+			`tags`:     `["a","b"]`,
+		},
+		TraceID: `5b8efff798038103d269b633813fc60c`, SpanID: `eee19b7ec3c1b174`,
+	},
+	{
+		Service: `frontend`, Scope: `web`,
+		Observed: 1700000001000000000, SevText: `WARN`, Body: `{"bytes":"AQI=","user":"alice"}`,
+	},
+}
+
+func TestOTLPDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func([]byte) (otlpLogsRequest, error)
+		in     []byte
+		want   []otlpFlat
+	}{
+		{`proto`, decodeOTLPLogsProto, pbTestRequest(), otlpTestFlat},
+		{`json`, decodeOTLPLogsJSON, []byte(otlpTestJSON), otlpTestFlat},
+		{`proto empty`, decodeOTLPLogsProto, nil, nil},
+		{`json empty`, decodeOTLPLogsJSON, []byte(`{}`), nil},
+		{`proto no records`, decodeOTLPLogsProto, pbRequest(pbResourceLogs(`idle`)), nil},
+		{`json bare numbers`, decodeOTLPLogsJSON, []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[
+			{"timeUnixNano":5,"body":{"intValue":7}}]}]}]}`), []otlpFlat{{Time: 5, Body: `7`}}},
+	}
+	for _, tt := range tests {
+		req, err := tt.decode(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		} else if got := flattenOTLP(req); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: bad decode\n%+v\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOTLPDecodeBad(t *testing.T) {
+	full := pbTestRequest()
+	tests := []struct {
+		name   string
+		decode func([]byte) (otlpLogsRequest, error)
+		in     []byte
+	}{
+		{`proto truncated`, decodeOTLPLogsProto, full[:len(full)-1]},
+		{`proto truncated tag`, decodeOTLPLogsProto, []byte{0xff}},
+		{`proto field zero`, decodeOTLPLogsProto, []byte{0x00, 0x01}},
+		{`proto short nested`, decodeOTLPLogsProto, pbBytes(1, []byte{0x12, 0x05, 0x01})},
+		{`proto short record`, decodeOTLPLogsProto, pbRequest(pbResourceLogs(`x`, pbScopeLogs(`s`, ``, []byte{0x09, 0x01})))},
+		{`json truncated`, decodeOTLPLogsJSON, []byte(otlpTestJSON[:len(otlpTestJSON)/2])},
+		{`json wrong type`, decodeOTLPLogsJSON, []byte(`{"resourceLogs":{}}`)},
+		{`json bad trace id`, decodeOTLPLogsJSON, []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"traceId":"zz"}]}]}]}`)},
+		{`json bad int`, decodeOTLPLogsJSON, []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"soon"}]}]}]}`)},
+		{`protobuf as json`, decodeOTLPLogsJSON, full},
+	}
+	for _, tt := range tests {
+		if _, err := tt.decode(tt.in); err == nil {
+			t.Fatalf("%s: decoded bad input", tt.name)
+		}
+	}
+}
+
+func TestOTLPDerivedTag(t *testing.T) {
+	var calls []string
+	oh := &otlpHandler{
+		tagFromAttr: true,
+		negotiate: func(name string) (entry.EntryTag, error) {
+			calls = append(calls, name)
+			if name == `unrouted` {
+				return 0, errors.New("not routed")
+			}
+			return entry.EntryTag(len(calls)), nil
+		},
+	}
+	lg = log.NewDiscardLogger()
+	for i := 0; i < 2; i++ {
+		if tg, ok := oh.derivedTag(`billing.api`); !ok || tg != 1 {
+			t.Fatalf("bad derived tag %d %v", tg, ok)
+		} else if _, ok = oh.derivedTag(`unrouted`); ok {
+			t.Fatal("got a tag that failed negotiation")
+		} else if _, ok = oh.derivedTag(`   `); ok {
+			t.Fatal("got a tag from an empty value")
+		}
+	}
+	//each value is negotiated once, failures included
+	if !reflect.DeepEqual(calls, []string{`billing_api`, `unrouted`}) {
+		t.Fatalf("bad negotiations %v", calls)
+	}
+	oh.tagFromAttr = false
+	if _, ok := oh.derivedTag(`other`); ok {
+		t.Fatal("derived a tag while disabled")
+	}
+}
+
+const otlpTestListener = `
+[OTLP-Listener "otel"]
+	Tag-Name=otlp
+	Tag-Match="checkout:otlp-checkout"
+`
+
+func TestOTLPHandler(t *testing.T) {
+	hs, srv := newTestIngester(t, otlpTestListener)
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		gz          bool
+	}{
+		{`protobuf`, otlpContentProtobuf, pbTestRequest(), false},
+		{`protobuf gzip`, otlpContentProtobuf, pbTestRequest(), true},
+		{`json`, otlpContentJSON, []byte(otlpTestJSON), false},
+		{`json gzip`, otlpContentJSON + `; charset=utf-8`, []byte(otlpTestJSON), true},
+	}
+	for _, tt := range tests {
+		srv.Reset()
+		code, body := post(t, hs, defaultOTLPUrl, tt.contentType, tt.body, tt.gz)
+		if code != http.StatusOK {
+			t.Fatalf("%s: bad status %d", tt.name, code)
+		} else if tt.contentType != otlpContentProtobuf && string(body) != `{}` {
+			t.Fatalf("%s: bad JSON response %q", tt.name, body)
+		}
+		ents := waitEntries(t, srv, 2)
+		if len(ents) != 2 {
+			t.Fatalf("%s: bad entry count %d", tt.name, len(ents))
+		}
+		checkout, frontend := ents[0], ents[1]
+		if string(checkout.Data) != `order placed` || tagOf(t, srv, checkout) != `otlp-checkout` {
+			t.Fatalf("%s: bad checkout entry %q %s", tt.name, checkout.Data, tagOf(t, srv, checkout))
+		} else if !checkout.TS.StandardTime().Equal(time.Unix(0, 1700000000000000000)) {
+			t.Fatalf("%s: bad timestamp %v", tt.name, checkout.TS)
+		} else if v, _ := evString(checkout, otlpTraceIDEV); v != `5b8efff798038103d269b633813fc60c` {
+			t.Fatalf("%s: bad trace id %q", tt.name, v)
+		} else if v, _ = evString(checkout, `service.name`); v != `checkout` {
+			t.Fatalf("%s: bad service.name %q", tt.name, v)
+		}
+		if tagOf(t, srv, frontend) != `otlp` {
+			t.Fatalf("%s: unmatched service was not given the default tag: %s", tt.name, tagOf(t, srv, frontend))
+		} else if v, _ := evString(frontend, otlpSeverityEV); v != `WARN` {
+			t.Fatalf("%s: bad severity %q", tt.name, v)
+		}
+	}
+
+	//garbage is rejected without ingesting anything
+	srv.Reset()
+	if code, _ := post(t, hs, defaultOTLPUrl, otlpContentProtobuf, []byte{0xff}, false); code != http.StatusBadRequest {
+		t.Fatalf("bad status on malformed protobuf %d", code)
+	} else if code, _ = post(t, hs, defaultOTLPUrl, otlpContentJSON, []byte(`{"resourceLogs":`), true); code != http.StatusBadRequest {
+		t.Fatalf("bad status on malformed JSON %d", code)
+	} else if srv.Count() != 0 {
+		t.Fatalf("malformed requests ingested %d entries", srv.Count())
+	}
+}
+
+func TestOTLPHandlerTagFromAttribute(t *testing.T) {
+	hs, srv := newTestIngester(t, otlpTestListener+"\tTag-From-Attribute=true\n")
+	req := pbRequest(
+		pbResourceLogs(`checkout`, pbScopeLogs(`app`, ``, pbCheckoutRecord())),
+		pbResourceLogs(`billing.api`, pbScopeLogs(`app`, ``, pbCheckoutRecord())),
+		pbMsg(pbBytes(2, pbScopeLogs(`app`, ``, pbCheckoutRecord()))), //no service.name
+	)
+	if code, _ := post(t, hs, defaultOTLPUrl, otlpContentProtobuf, req, false); code != http.StatusOK {
+		t.Fatalf("bad status %d", code)
+	}
+	ents := waitEntries(t, srv, 3)
+	var tags []string
+	for _, ent := range ents {
+		tags = append(tags, tagOf(t, srv, ent))
+	}
+	//Tag-Match wins, then the remapped service.name, then Tag-Name
+	if want := []string{`otlp-checkout`, `billing_api`, `otlp`}; !reflect.DeepEqual(tags, want) {
+		t.Fatalf("bad tags %v != %v", tags, want)
+	}
+}
@@ -0,0 +1,64 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// clientIdent stamps the verified client certificate name onto entries.  ev is the
+// enumerated value name for a listener and is empty when client certificates are not
+// required, cn is filled in per connection once the handshake completes.
+type clientIdent struct {
+	ev string
+	cn string
+}
+
+func newClientIdent(bc baseConfig) (ci clientIdent) {
+	if bc.Client_CA_File != `` {
+		if ci.ev = bc.Client_CN_Enumerated_Value; ci.ev == `` {
+			ci.ev = utils.DefaultClientCNEnumeratedValue
+		}
+	}
+	return
+}
+
+// identify completes the TLS handshake so that the client certificate is known before any
+// data is read, it returns false if the handshake failed and the connection should be dropped
+func (ci *clientIdent) identify(c net.Conn, listener string) bool {
+	tc, ok := c.(*tls.Conn)
+	if !ok || ci.ev == `` {
+		return true
+	}
+	tc.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tc.Handshake(); err != nil {
+		lg.Warn("TLS handshake failed", log.KV("address", c.RemoteAddr()), log.KV("listener", listener), log.KVErr(err))
+		return false
+	}
+	tc.SetDeadline(time.Time{})
+	ci.cn = utils.ClientName(tc.ConnectionState())
+	return true
+}
+
+func (ci clientIdent) stamp(ent *entry.Entry) *entry.Entry {
+	if ent != nil && ci.cn != `` {
+		ent.AddEnumeratedValueEx(ci.ev, ci.cn)
+	}
+	return ent
+}
@@ -0,0 +1,134 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+// issueTestCert creates a certificate signed by ca, or a self signed CA if ca is nil
+func issueTestCert(t *testing.T, cn string, serial int64, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		IPAddresses:  []net.IP{net.ParseIP(`127.0.0.1`)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signKey := tmpl, interface{}(key)
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		parent, signKey = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	if c.Leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeTestPEM(t *testing.T, pth, typ string, der []byte) {
+	if err := os.WriteFile(pth, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCertListener(t *testing.T) {
+	lg = log.NewDiscardLogger()
+	dir := t.TempDir()
+	ca := issueTestCert(t, `test ca`, 1, nil)
+	srvCert := issueTestCert(t, `relay`, 2, &ca)
+	allowed := issueTestCert(t, `host1.example.com`, 3, &ca)
+	denied := issueTestCert(t, `host2.example.org`, 4, &ca)
+
+	caPath, certPath, keyPath := filepath.Join(dir, `ca.pem`), filepath.Join(dir, `cert.pem`), filepath.Join(dir, `key.pem`)
+	writeTestPEM(t, caPath, `CERTIFICATE`, ca.Certificate[0])
+	writeTestPEM(t, certPath, `CERTIFICATE`, srvCert.Certificate[0])
+	kb, err := x509.MarshalECPrivateKey(srvCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPEM(t, keyPath, `EC PRIVATE KEY`, kb)
+
+	port := freePort(t)
+	block := fmt.Sprintf("\n[Listener \"mtls\"]\n\tBind-String=tls://127.0.0.1:%d\n\tTag-Name=mtls\n\tIgnore-Timestamps=true\n"+
+		"\tCert-File=%s\n\tKey-File=%s\n\tClient-CA-File=%s\n\tClient-Allowed-Name=\"*.example.com\"\n\tClient-CN-Enumerated-Value=peer\n",
+		port, certPath, keyPath, caPath)
+	srv, im := newReloadMuxer(t, `mtls`)
+	ls := newListenerSet(im, context.Background())
+	defer ls.Close()
+	if err := ls.apply(loadReloadConfig(t, block)); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	send := func(cert *tls.Certificate, line string, rejected bool) error {
+		cfg := &tls.Config{RootCAs: pool}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{*cert}
+		}
+		c, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), cfg)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = fmt.Fprintf(c, "%s\n", line); err == nil && rejected {
+			//rejected TLS 1.3 clients only find out when they read
+			_, err = c.Read(make([]byte, 1))
+		}
+		return err
+	}
+	if err := send(&allowed, `hello`, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*tls.Certificate{&denied, nil} {
+		if err := send(c, `nope`, true); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("connection was not rejected: %v", err)
+		}
+	}
+	waitTag(t, srv, `mtls`, 1)
+	time.Sleep(50 * time.Millisecond)
+	ents := srv.TagEntries(`mtls`)
+	if len(ents) != 1 || string(ents[0].Data) != `hello` {
+		t.Fatalf("bad entries %v", ents)
+	} else if v, ok := ents[0].GetEnumeratedValue(`peer`); !ok || v != `host1.example.com` {
+		t.Fatalf("missing client name: %v", v)
+	}
+}
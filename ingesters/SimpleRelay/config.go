@@ -20,6 +20,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 )
 
 const (
@@ -33,6 +34,7 @@ const (
 	lineReader    readerType = iota
 	rfc5424Reader readerType = iota
 	rfc6587Reader readerType = iota
+	relpReader    readerType = iota
 )
 
 var ()
@@ -48,16 +50,19 @@ type listener struct {
 }
 
 type baseConfig struct {
-	Tag_Name                  string
-	Bind_String               string //IP port pair 127.0.0.1:1234
-	Ignore_Timestamps         bool   //Just apply the current timestamp to lines as we get them
-	Assume_Local_Timezone     bool
-	Timezone_Override         string
-	Source_Override           string
-	Timestamp_Format_Override string //override the timestamp format
-	Cert_File                 string
-	Key_File                  string
-	Preprocessor              []string
+	Tag_Name                   string
+	Bind_String                string //IP port pair 127.0.0.1:1234
+	Ignore_Timestamps          bool   //Just apply the current timestamp to lines as we get them
+	Assume_Local_Timezone      bool
+	Timezone_Override          string
+	Source_Override            string
+	Timestamp_Format_Override  string //override the timestamp format
+	Cert_File                  string
+	Key_File                   string
+	Client_CA_File             string   //require client certificates signed by a CA in this bundle
+	Client_Allowed_Name        []string //only accept client certificates with a matching CN or SAN
+	Client_CN_Enumerated_Value string   //enumerated value that receives the client CN, defaults to client_cn
+	Preprocessor               []string
 }
 
 type cfgReadType struct {
@@ -284,7 +289,7 @@ func checkListenerSettings(l *listener) (err error) {
 	if bt, _, err = translateBindType(l.Bind_String); err != nil {
 		return
 	}
-	if l.Drop_Priority && !(lt == rfc5424Reader || lt == rfc6587Reader) {
+	if l.Drop_Priority && !(lt == rfc5424Reader || lt == rfc6587Reader || lt == relpReader) {
 		err = fmt.Errorf("Drop-Priority is not compatible with reader type %s", lt)
 		return
 	}
@@ -292,6 +297,10 @@ func checkListenerSettings(l *listener) (err error) {
 		err = fmt.Errorf("RFC6587 reader type is not compatible with a UDP bind string")
 		return
 	}
+	if lt == relpReader && bt.UDP() {
+		err = fmt.Errorf("RELP reader type is not compatible with a UDP bind string")
+		return
+	}
 	return
 }
 
@@ -299,9 +308,30 @@ func (l baseConfig) Validate() error {
 	if len(l.Bind_String) == 0 {
 		return errors.New("No Bind-String provided")
 	}
+	if l.Client_CA_File != `` || len(l.Client_Allowed_Name) > 0 || l.Client_CN_Enumerated_Value != `` {
+		if bt, _, err := translateBindType(l.Bind_String); err != nil {
+			return err
+		} else if !bt.TLS() {
+			return errors.New("client certificate options require a tls:// Bind-String")
+		} else if l.Client_CA_File == `` {
+			return errors.New("Client-CA-File is required to verify client certificates")
+		}
+		if err := l.serverTLS().Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (l baseConfig) serverTLS() utils.ServerTLSConfig {
+	return utils.ServerTLSConfig{
+		CertFile:     l.Cert_File,
+		KeyFile:      l.Key_File,
+		ClientCAFile: l.Client_CA_File,
+		ClientAllow:  l.Client_Allowed_Name,
+	}
+}
+
 func translateBindType(bstr string) (bindType, string, error) {
 	bits := strings.SplitN(bstr, "://", 2)
 	//if nothing specified, just return the tcp type
@@ -368,6 +398,8 @@ func translateReaderType(s string) (readerType, error) {
 		return rfc5424Reader, nil
 	case `rfc6587`:
 		return rfc6587Reader, nil
+	case `relp`:
+		return relpReader, nil
 	case ``:
 		return lineReader, nil
 	}
@@ -382,6 +414,8 @@ func (rt readerType) String() string {
 		return `RFC5424`
 	case rfc6587Reader:
 		return `RFC6587`
+	case relpReader:
+		return `RELP`
 	}
 	return "UNKNOWN"
 }
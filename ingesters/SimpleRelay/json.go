@@ -11,7 +11,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -34,6 +33,7 @@ import (
 
 type jsonHandlerConfig struct {
 	name             string
+	owner            string
 	defTag           entry.EntryTag
 	tags             map[string]entry.EntryTag
 	ignoreTimestamps bool
@@ -48,129 +48,95 @@ type jsonHandlerConfig struct {
 	timeFormats      config.CustomTimeFormat
 	maxObjectSize    int64
 	disableCompact   bool
+	client           clientIdent
 }
 
-func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
-	var err error
-	//short circuit out on empty
-	if len(cfg.JSONListener) == 0 {
-		return nil
+// startJSONListener fires up a single JSONListener block, the listener and every connection
+// it accepts are registered under owner
+func startJSONListener(k string, v *jsonListener, owner string, cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, ctx context.Context) (proc *processors.ProcessorSet, err error) {
+	if err = v.Validate(); err != nil {
+		return nil, fmt.Errorf("JSONListener %s configuration is invalid: %w", k, err)
 	}
-
-	for k, v := range cfg.JSONListener {
-		if err := v.Validate(); err != nil {
-			return fmt.Errorf("JSONListener %s configuration is invalid: %w", k, err)
-		}
-		jhc := jsonHandlerConfig{
-			name:             k,
-			wg:               wg,
-			tags:             map[string]entry.EntryTag{},
-			ignoreTimestamps: v.Ignore_Timestamps,
-			setLocalTime:     v.Assume_Local_Timezone,
-			timezoneOverride: v.Timezone_Override,
-			ctx:              ctx,
-			formatOverride:   v.Timestamp_Format_Override,
-			timeFormats:      cfg.TimeFormat,
-			maxObjectSize:    int64(v.Max_Object_Size),
-			disableCompact:   v.Disable_Compact,
-		}
-		if jhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
-			lg.Fatal("preprocessor error", log.KVErr(err))
-		}
-		f.Add(jhc.proc)
-		if jhc.flds, err = v.GetJsonFields(); err != nil {
-			return err
-		}
-		if v.Source_Override != `` {
-			jhc.src = net.ParseIP(v.Source_Override)
-			if jhc.src == nil {
-				return fmt.Errorf("JSONListener %v invalid source override \"%s\"", k, v.Source_Override)
-			}
-		} else if cfg.Source_Override != `` {
-			// global override
-			jhc.src = net.ParseIP(cfg.Source_Override)
-			if jhc.src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
-			}
+	jhc := jsonHandlerConfig{
+		name:             k,
+		owner:            owner,
+		wg:               wg,
+		tags:             map[string]entry.EntryTag{},
+		ignoreTimestamps: v.Ignore_Timestamps,
+		setLocalTime:     v.Assume_Local_Timezone,
+		timezoneOverride: v.Timezone_Override,
+		ctx:              ctx,
+		formatOverride:   v.Timestamp_Format_Override,
+		timeFormats:      cfg.TimeFormat,
+		maxObjectSize:    int64(v.Max_Object_Size),
+		disableCompact:   v.Disable_Compact,
+		client:           newClientIdent(v.baseConfig),
+	}
+	if jhc.flds, err = v.GetJsonFields(); err != nil {
+		return
+	}
+	if v.Source_Override != `` {
+		jhc.src = net.ParseIP(v.Source_Override)
+		if jhc.src == nil {
+			return nil, fmt.Errorf("JSONListener %v invalid source override \"%s\"", k, v.Source_Override)
 		}
-		//resolve the default tag
-		if jhc.defTag, err = igst.GetTag(v.Default_Tag); err != nil {
-			return err
+	} else if cfg.Source_Override != `` {
+		// global override
+		jhc.src = net.ParseIP(cfg.Source_Override)
+		if jhc.src == nil {
+			return nil, fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
 		}
+	}
+	//resolve the default tag
+	if jhc.defTag, err = igst.GetTag(v.Default_Tag); err != nil {
+		return
+	}
 
-		//resolve all the other tags
-		tms, err := v.TagMatchers()
+	//resolve all the other tags
+	tms, err := v.TagMatchers()
+	if err != nil {
+		return
+	}
+	for _, tm := range tms {
+		tg, err := igst.GetTag(tm.Tag)
 		if err != nil {
-			return err
-		}
-		for _, tm := range tms {
-			tg, err := igst.GetTag(tm.Tag)
-			if err != nil {
-				return err
-			}
-			jhc.tags[tm.Value] = tg
+			return nil, err
 		}
+		jhc.tags[tm.Value] = tg
+	}
 
-		tp, str, err := translateBindType(v.Bind_String)
-		if err != nil {
-			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
-		}
+	tp, str, err := translateBindType(v.Bind_String)
+	if err != nil {
+		return nil, fmt.Errorf("JSONListener %s invalid bind %q: %w", k, v.Bind_String, err)
+	}
+	if jhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		return nil, fmt.Errorf("JSONListener %s preprocessor error: %w", k, err)
+	}
 
+	if tp.TCP() || tp.TLS() {
 		if tp.TCP() {
-			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
-			if err != nil {
-				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
-			}
-			l, err := net.ListenTCP("tcp", addr)
-			if err != nil {
-				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go jsonAcceptor(l, connID, igst, jhc, tp)
-		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
-			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("jsonlistener", k), log.KVErr(err))
-			}
-			l, err := tls.Listen("tcp", addr.String(), config)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("jsonlistener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go jsonAcceptor(l, connID, igst, jhc, tp)
-		} else if tp.UDP() {
-			addr, err := net.ResolveUDPAddr(tp.String(), str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
-			}
-			l, err := net.ListenUDP(tp.String(), addr)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via udp", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			wg.Add(1)
-			go jsonAcceptorUDP(l, connID, igst, jhc)
-
+			tp = tcp // json listeners have always bound dual stack
 		}
-
+		var l net.Listener
+		if l, err = listenStream(k, tp, str, v.baseConfig); err != nil {
+			jhc.proc.Close()
+			return nil, err
+		}
+		connID := addConn(l, owner)
+		//start the acceptor
+		wg.Add(1)
+		go jsonAcceptor(l, connID, igst, jhc, tp)
+	} else if tp.UDP() {
+		var l *net.UDPConn
+		if l, err = listenPacket(k, tp, str); err != nil {
+			jhc.proc.Close()
+			return nil, err
+		}
+		connID := addConn(l, owner)
+		wg.Add(1)
+		go jsonAcceptorUDP(l, connID, igst, jhc)
 	}
-	debugout("Started %d json listeners\n", len(cfg.JSONListener))
-	return nil
+	return jhc.proc, nil
 }
 
 func jsonAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg jsonHandlerConfig, tp bindType) {
@@ -262,10 +228,13 @@ func jsonAcceptorUDP(conn *net.UDPConn, id int, igst *ingest.IngestMuxer, cfg js
 
 func jsonConnHandler(c net.Conn, cfg jsonHandlerConfig, igst *ingest.IngestMuxer) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := addConn(c, cfg.owner)
 	defer cfg.wg.Done()
 	defer delConn(id)
 	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
 	var rip net.IP
 	var lip net.IP // just used for logging
 	var tg *timegrinder.TimeGrinder
@@ -390,7 +359,7 @@ consumerLoop:
 			Tag:  tag,
 			Data: data,
 		}
-		cfg.proc.ProcessContext(ent, cfg.ctx)
+		cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx)
 	}
 	return nil
 }
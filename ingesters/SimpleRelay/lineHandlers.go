@@ -22,10 +22,13 @@ import (
 
 func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := addConn(c, cfg.owner)
 	defer cfg.wg.Done()
 	defer delConn(id)
 	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
 	var rip net.IP
 
 	if cfg.src == nil {
@@ -81,7 +84,7 @@ func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 		if len(data) > 0 {
 			if ent, err := handleLog(data, rip, cfg.ignoreTimestamps, cfg.tag, tg); err != nil {
 				return
-			} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+			} else if err = cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx); err != nil {
 				return
 			}
 		}
@@ -159,7 +162,7 @@ func lineConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 			//because we are using and reusing a local buffer, we have to copy the bytes when handing in
 			if ent, err := handleLog(append([]byte(nil), ln...), rip, cfg.ignoreTimestamps, cfg.tag, tg); err != nil {
 				return
-			} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+			} else if err = cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx); err != nil {
 				return
 			}
 		}
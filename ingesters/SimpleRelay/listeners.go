@@ -0,0 +1,255 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	listenerCloseTimeout = time.Second
+)
+
+// listenerDef is a single listener block along with the config it came from
+type listenerDef struct {
+	kind  string // Listener, RegexListener, or JSONListener
+	name  string
+	block interface{}
+	cfg   *cfgType
+}
+
+// activeListener is a running listener and everything needed to shut it down
+type activeListener struct {
+	def    listenerDef
+	wg     *sync.WaitGroup
+	cancel context.CancelFunc
+	proc   *processors.ProcessorSet
+}
+
+// listenerSet tracks running listeners by config block so that a configuration reload
+// only touches the listeners that actually changed.  Unchanged listeners keep their
+// sockets, so a reload does not drop UDP traffic or connected clients on them.
+type listenerSet struct {
+	sync.Mutex
+	igst   *ingest.IngestMuxer
+	ctx    context.Context
+	active map[string]*activeListener
+}
+
+func newListenerSet(igst *ingest.IngestMuxer, ctx context.Context) *listenerSet {
+	return &listenerSet{
+		igst:   igst,
+		ctx:    ctx,
+		active: map[string]*activeListener{},
+	}
+}
+
+func listenerDefs(cfg *cfgType) map[string]listenerDef {
+	defs := make(map[string]listenerDef, len(cfg.Listener)+len(cfg.RegexListener)+len(cfg.JSONListener))
+	add := func(kind, name string, block interface{}) {
+		d := listenerDef{kind: kind, name: name, block: block, cfg: cfg}
+		defs[d.key()] = d
+	}
+	for k, v := range cfg.Listener {
+		add(`Listener`, k, v)
+	}
+	for k, v := range cfg.RegexListener {
+		add(`RegexListener`, k, v)
+	}
+	for k, v := range cfg.JSONListener {
+		add(`JSONListener`, k, v)
+	}
+	return defs
+}
+
+func (d listenerDef) key() string {
+	return d.kind + ` ` + d.name
+}
+
+// preprocessors returns the preprocessor blocks this listener references
+func (d listenerDef) preprocessors() (r []*config.VariableConfig) {
+	var names []string
+	switch v := d.block.(type) {
+	case *listener:
+		names = v.Preprocessor
+	case *regexListener:
+		names = v.Preprocessor
+	case *jsonListener:
+		names = v.Preprocessor
+	}
+	for _, n := range names {
+		r = append(r, d.cfg.Preprocessor[n])
+	}
+	return
+}
+
+// same reports if two definitions would produce identical listeners
+func (d listenerDef) same(x listenerDef) bool {
+	return reflect.DeepEqual(d.block, x.block) &&
+		d.cfg.Source_Override == x.cfg.Source_Override &&
+		reflect.DeepEqual(d.cfg.TimeFormat, x.cfg.TimeFormat) &&
+		reflect.DeepEqual(d.preprocessors(), x.preprocessors())
+}
+
+// apply brings the running listeners in line with cfg.  Listeners that were removed or
+// changed are stopped, new and changed listeners are started.  If anything fails to start
+// the set is rolled back to the listeners that were running before.
+func (ls *listenerSet) apply(cfg *cfgType) (err error) {
+	ls.Lock()
+	defer ls.Unlock()
+	defs := listenerDefs(cfg)
+
+	//stop first so that changed listeners can bind the same address again
+	var stopped []listenerDef
+	for _, k := range sortedKeys(ls.active) {
+		al := ls.active[k]
+		if d, ok := defs[k]; ok && d.same(al.def) {
+			continue
+		}
+		ls.stop(k)
+		stopped = append(stopped, al.def)
+	}
+
+	var started []string
+	for _, k := range sortedKeys(defs) {
+		if _, ok := ls.active[k]; ok {
+			continue
+		}
+		if err = ls.start(defs[k]); err != nil {
+			ls.rollback(started, stopped)
+			return
+		}
+		started = append(started, k)
+	}
+	if len(started) > 0 || len(stopped) > 0 {
+		lg.Info("listeners updated", log.KV("started", len(started)), log.KV("stopped", len(stopped)), log.KV("active", len(ls.active)))
+	}
+	return
+}
+
+// rollback caller must hold the lock
+func (ls *listenerSet) rollback(started []string, stopped []listenerDef) {
+	for _, k := range started {
+		ls.stop(k)
+	}
+	for _, d := range stopped {
+		if err := ls.start(d); err != nil {
+			lg.Error("failed to restore listener", log.KV("listener", d.key()), log.KVErr(err))
+		}
+	}
+}
+
+// start caller must hold the lock
+func (ls *listenerSet) start(d listenerDef) (err error) {
+	al := &activeListener{
+		def: d,
+		wg:  &sync.WaitGroup{},
+	}
+	ctx, cancel := context.WithCancel(ls.ctx)
+	k := d.key()
+	switch v := d.block.(type) {
+	case *listener:
+		al.proc, err = startSimpleListener(d.name, v, k, d.cfg, ls.igst, al.wg, ctx)
+	case *regexListener:
+		al.proc, err = startRegexListener(d.name, v, k, d.cfg, ls.igst, al.wg, ctx)
+	case *jsonListener:
+		al.proc, err = startJSONListener(d.name, v, k, d.cfg, ls.igst, al.wg, ctx)
+	default:
+		err = fmt.Errorf("unknown listener type %T", d.block)
+	}
+	if err != nil {
+		cancel()
+		return
+	}
+	al.cancel = cancel
+	ls.active[k] = al
+	debugout("Started %s\n", k)
+	return
+}
+
+// stop closes a listener and everything it accepted, then flushes its preprocessors
+// caller must hold the lock
+func (ls *listenerSet) stop(k string) {
+	al, ok := ls.active[k]
+	if !ok {
+		return
+	}
+	delete(ls.active, k)
+	closeConns(k)
+	if !waitTimeout(al.wg, listenerCloseTimeout) {
+		lg.Error("Failed to wait for listener connections to close", log.KV("listener", k), log.KV("timeout", listenerCloseTimeout))
+	}
+	al.cancel()
+	if err := al.proc.Close(); err != nil {
+		lg.Error("failed to close preprocessors", log.KV("listener", k), log.KVErr(err))
+	}
+	debugout("Stopped %s\n", k)
+}
+
+// Close shuts down every listener, it waits at most listenerCloseTimeout for connections to
+// finish up before cutting off the preprocessors
+func (ls *listenerSet) Close() (err error) {
+	ls.Lock()
+	defer ls.Unlock()
+	for k := range ls.active {
+		closeConns(k)
+	}
+	deadline := time.Now().Add(listenerCloseTimeout)
+	for k, al := range ls.active {
+		if !waitTimeout(al.wg, time.Until(deadline)) {
+			lg.Error("Failed to wait for listener connections to close", log.KV("listener", k), log.KV("timeout", listenerCloseTimeout))
+		}
+	}
+	for k, al := range ls.active {
+		al.cancel()
+		if lerr := al.proc.Close(); lerr != nil {
+			err = addError(lerr, err)
+		}
+		delete(ls.active, k)
+	}
+	return
+}
+
+func (ls *listenerSet) count() int {
+	ls.Lock()
+	defer ls.Unlock()
+	return len(ls.active)
+}
+
+func waitTimeout(wg *sync.WaitGroup, to time.Duration) bool {
+	wch := make(chan bool, 1)
+	go func() {
+		wg.Wait()
+		wch <- true
+	}()
+	select {
+	case <-wch:
+		return true
+	case <-time.After(to):
+	}
+	return false
+}
+
+func sortedKeys[T any](mp map[string]T) (r []string) {
+	for k := range mp {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return
+}
@@ -0,0 +1,159 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/ingesttest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const reloadConfigBase = `
+[Global]
+Ingest-Secret = IngestSecrets
+Cleartext-Backend-target=127.0.0.1:4023
+`
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func listenerBlock(name, bind, tag string) string {
+	return fmt.Sprintf("\n[Listener %q]\n\tBind-String=%s\n\tTag-Name=%s\n\tReader-Type=line\n\tIgnore-Timestamps=true\n", name, bind, tag)
+}
+
+func loadReloadConfig(t *testing.T, blocks ...string) *cfgType {
+	s := reloadConfigBase
+	for _, b := range blocks {
+		s += b
+	}
+	pth, err := dropConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := GetConfig(pth, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func newReloadMuxer(t *testing.T, tags ...string) (*ingesttest.Server, *ingest.IngestMuxer) {
+	srv, err := ingesttest.NewServer(ingesttest.Config{Secret: `IngestSecrets`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	tgt, err := srv.ListenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	im, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations: []string{tgt},
+		Tags:         tags,
+		Auth:         `IngestSecrets`,
+		IngesterName: `simplerelay`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { im.Close() })
+	return srv, im
+}
+
+func sendLine(t *testing.T, network string, port int, line string) {
+	c, err := net.Dial(network, fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = fmt.Fprintf(c, "%s\n", line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitTag(t *testing.T, srv *ingesttest.Server, tag string, cnt int) {
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(20 * time.Millisecond) {
+		if len(srv.TagEntries(tag)) >= cnt {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %d entries on %s", cnt, tag)
+}
+
+func TestListenerReload(t *testing.T) {
+	lg = log.NewDiscardLogger()
+	pa, pb, pc := freePort(t), freePort(t), freePort(t)
+	blockA := listenerBlock(`a`, fmt.Sprintf("tcp://127.0.0.1:%d", pa), `taga`)
+	blockB := listenerBlock(`b`, fmt.Sprintf("udp://127.0.0.1:%d", pb), `tagb`)
+	blockC := listenerBlock(`c`, fmt.Sprintf("tcp://127.0.0.1:%d", pc), `tagc`)
+
+	srv, im := newReloadMuxer(t, `taga`, `tagb`)
+	ls := newListenerSet(im, context.Background())
+	defer ls.Close()
+	if err := ls.apply(loadReloadConfig(t, blockA, blockB)); err != nil {
+		t.Fatal(err)
+	} else if ls.count() != 2 {
+		t.Fatalf("bad listener count %d", ls.count())
+	}
+	sendLine(t, "tcp", pa, "hello a")
+	sendLine(t, "udp", pb, "hello b")
+	waitTag(t, srv, `taga`, 1)
+	waitTag(t, srv, `tagb`, 1)
+
+	//drop b, add c, and make sure a is left alone
+	origA := ls.active[`Listener a`]
+	if _, err := im.NegotiateTag(`tagc`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.apply(loadReloadConfig(t, blockA, blockC)); err != nil {
+		t.Fatal(err)
+	} else if ls.count() != 2 {
+		t.Fatalf("bad listener count %d", ls.count())
+	} else if ls.active[`Listener a`] != origA {
+		t.Fatal("unchanged listener was restarted")
+	} else if _, ok := ls.active[`Listener b`]; ok {
+		t.Fatal("removed listener is still active")
+	}
+	sendLine(t, "tcp", pa, "hello again a")
+	sendLine(t, "tcp", pc, "hello c")
+	waitTag(t, srv, `taga`, 2)
+	waitTag(t, srv, `tagc`, 1)
+
+	//a listener that cannot bind must leave the old set in place
+	blocker, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", pb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocker.Close()
+	blockD := listenerBlock(`d`, fmt.Sprintf("tcp://127.0.0.1:%d", pb), `taga`)
+	if err := ls.apply(loadReloadConfig(t, blockA, blockD)); err == nil {
+		t.Fatal("apply succeeded with an unusable bind")
+	}
+	if _, ok := ls.active[`Listener c`]; !ok || ls.count() != 2 {
+		t.Fatal("failed apply did not roll back")
+	}
+	sendLine(t, "tcp", pc, "hello again c")
+	waitTag(t, srv, `tagc`, 2)
+}
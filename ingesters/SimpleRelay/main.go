@@ -11,15 +11,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/debug"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingesters/base"
-	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/ingesters/utils/caps"
 )
 
@@ -73,67 +70,38 @@ func main() {
 
 	debugout("Started ingester muxer\n")
 
-	connClosers = make(map[int]closer, 1)
 	//check capabilities so we can scream and throw a potential warning upstream
 	if !caps.Has(caps.NET_BIND_SERVICE) {
 		lg.Warn("missing capability", log.KV("capability", "NET_BIND_SERVICE"), log.KV("warning", "may not be able to bind to service ports"))
 		debugout("missing capability NET_BIND_SERVICE, may not be able to bind to service ports")
 	}
 
-	wg := &sync.WaitGroup{}
-
-	var flshr flusher
-
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	//fire off our simple listeners
-	if err := startSimpleListeners(cfg, igst, wg, &flshr, ctx); err != nil {
-		lg.FatalCode(0, "Failed to start simple listeners", log.KV("ingesteruuid", id), log.KVErr(err))
-		return
-	}
-	// fire off our regex listeners
-	if err := startRegexListeners(cfg, igst, wg, &flshr, ctx); err != nil {
-		lg.FatalCode(0, "Failed to start regex listeners", log.KV("ingesteruuid", id), log.KVErr(err))
-		return
-	}
-	//fire off our json listeners
-	if err := startJSONListeners(cfg, igst, wg, &flshr, ctx); err != nil {
-		lg.FatalCode(0, "Failed to start json listeners", log.KV("ingesteruuid", id), log.KVErr(err))
+	//fire off all of our listeners
+	ls := newListenerSet(igst, ctx)
+	if err := ls.apply(cfg); err != nil {
+		lg.FatalCode(0, "Failed to start listeners", log.KV("ingesteruuid", id), log.KVErr(err))
 		return
 	}
+	debugout("Started %d listeners\n", ls.count())
 
 	lg.Info("Ingester running")
 
-	//listen for signals so we can close gracefully
-	utils.WaitForQuit()
+	//listen for signals so we can close gracefully, a SIGHUP applies any listener changes in place
+	ib.WaitForQuitOrReload(igst, func(_, nc interface{}) error {
+		ncfg, ok := nc.(*cfgType)
+		if !ok {
+			return fmt.Errorf("unexpected configuration type %T", nc)
+		}
+		return ls.apply(ncfg)
+	})
 	ib.AnnounceShutdown()
 	debugout("Closing %d connections\n", connCount())
 	lg.Info("Closing active connections", log.KV("ingesteruuid", id), log.KV("active", connCount()))
 
-	go func() {
-		time.Sleep(time.Second)
-		cancel()
-	}()
-
-	mtx.Lock()
-	for _, v := range connClosers {
-		v.Close()
-	}
-	mtx.Unlock() //must unlock so they can delete their connections
-
-	//wait for everyone to exit with a timeout
-	wch := make(chan bool, 1)
-
-	go func() {
-		wg.Wait()
-		wch <- true
-	}()
-	select {
-	case <-wch:
-	case <-time.After(1 * time.Second):
-		lg.Error("Failed to wait for all connections to close", log.KV("timeout", time.Second), log.KV("active", connCount()))
-	}
-	if err := flshr.Close(); err != nil {
+	if err := ls.Close(); err != nil {
 		lg.Error("failed to close preprocessors", log.KVErr(err))
 	}
 	if err := igst.Sync(time.Second); err != nil {
@@ -150,31 +118,6 @@ func debugout(format string, args ...interface{}) {
 	}
 }
 
-type flusher struct {
-	sync.Mutex
-	set []io.Closer
-}
-
-func (f *flusher) Add(c io.Closer) {
-	if c == nil {
-		return
-	}
-	f.Lock()
-	f.set = append(f.set, c)
-	f.Unlock()
-}
-
-func (f *flusher) Close() (err error) {
-	f.Lock()
-	for _, v := range f.set {
-		if lerr := v.Close(); lerr != nil {
-			err = addError(lerr, err)
-		}
-	}
-	f.Unlock()
-	return
-}
-
 func addError(nerr, err error) error {
 	if nerr == nil {
 		return err
@@ -12,7 +12,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -36,6 +35,7 @@ const (
 
 type regexHandlerConfig struct {
 	name             string
+	owner            string
 	defTag           entry.EntryTag
 	ignoreTimestamps bool
 	setLocalTime     bool
@@ -48,113 +48,80 @@ type regexHandlerConfig struct {
 	regex            string
 	timeFormats      config.CustomTimeFormat
 	trimWhitespace   bool
+	client           clientIdent
 	maxBuffer        int
 }
 
-func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
-	var err error
-	//short circuit out on empty
-	if len(cfg.RegexListener) == 0 {
-		return nil
+// startRegexListener fires up a single RegexListener block, the listener and every connection
+// it accepts are registered under owner
+func startRegexListener(k string, v *regexListener, owner string, cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, ctx context.Context) (proc *processors.ProcessorSet, err error) {
+	rhc := regexHandlerConfig{
+		name:             k,
+		owner:            owner,
+		wg:               wg,
+		ignoreTimestamps: v.Ignore_Timestamps,
+		setLocalTime:     v.Assume_Local_Timezone,
+		timezoneOverride: v.Timezone_Override,
+		ctx:              ctx,
+		formatOverride:   v.Timestamp_Format_Override,
+		timeFormats:      cfg.TimeFormat,
+		regex:            v.Regex,
+		trimWhitespace:   v.Trim_Whitespace,
+		maxBuffer:        v.Max_Buffer,
+		client:           newClientIdent(v.baseConfig),
 	}
-
-	for k, v := range cfg.RegexListener {
-		rhc := regexHandlerConfig{
-			name:             k,
-			wg:               wg,
-			ignoreTimestamps: v.Ignore_Timestamps,
-			setLocalTime:     v.Assume_Local_Timezone,
-			timezoneOverride: v.Timezone_Override,
-			ctx:              ctx,
-			formatOverride:   v.Timestamp_Format_Override,
-			timeFormats:      cfg.TimeFormat,
-			regex:            v.Regex,
-			trimWhitespace:   v.Trim_Whitespace,
-			maxBuffer:        v.Max_Buffer,
-		}
-		if rhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
-			lg.Fatal("preprocessor error", log.KVErr(err))
-		}
-		f.Add(rhc.proc)
-		if _, err = regexp.Compile(v.Regex); err != nil {
-			return err
-		}
-		if v.Source_Override != `` {
-			rhc.src = net.ParseIP(v.Source_Override)
-			if rhc.src == nil {
-				return fmt.Errorf("RegexListener %v invalid source override \"%s\"", k, v.Source_Override)
-			}
-		} else if cfg.Source_Override != `` {
-			// global override
-			rhc.src = net.ParseIP(cfg.Source_Override)
-			if rhc.src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
-			}
+	if _, err = regexp.Compile(v.Regex); err != nil {
+		return
+	}
+	if v.Source_Override != `` {
+		rhc.src = net.ParseIP(v.Source_Override)
+		if rhc.src == nil {
+			return nil, fmt.Errorf("RegexListener %v invalid source override \"%s\"", k, v.Source_Override)
 		}
-		//resolve default tag
-		if rhc.defTag, err = igst.GetTag(v.Tag_Name); err != nil {
-			return err
+	} else if cfg.Source_Override != `` {
+		// global override
+		rhc.src = net.ParseIP(cfg.Source_Override)
+		if rhc.src == nil {
+			return nil, fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
 		}
+	}
+	//resolve default tag
+	if rhc.defTag, err = igst.GetTag(v.Tag_Name); err != nil {
+		return
+	}
 
-		tp, str, err := translateBindType(v.Bind_String)
-		if err != nil {
-			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
-		}
+	tp, str, err := translateBindType(v.Bind_String)
+	if err != nil {
+		return nil, fmt.Errorf("RegexListener %s invalid bind %q: %w", k, v.Bind_String, err)
+	}
+	if rhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		return nil, fmt.Errorf("RegexListener %s preprocessor error: %w", k, err)
+	}
 
+	if tp.TCP() || tp.TLS() {
 		if tp.TCP() {
-			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
-			if err != nil {
-				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
-			}
-			l, err := net.ListenTCP("tcp", addr)
-			if err != nil {
-				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go regexAcceptor(l, connID, igst, rhc, tp)
-		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
-			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("regexlistener", k), log.KVErr(err))
-			}
-			l, err := tls.Listen("tcp", addr.String(), config)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("regexlistener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go regexAcceptor(l, connID, igst, rhc, tp)
-		} else if tp.UDP() {
-			addr, err := net.ResolveUDPAddr(`udp`, str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
-			}
-			l, err := net.ListenUDP(`udp`, addr)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via udp", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			wg.Add(1)
-			go regexAcceptorUDP(l, connID, rhc, igst)
+			tp = tcp // regex listeners have always bound dual stack
 		}
-
+		var l net.Listener
+		if l, err = listenStream(k, tp, str, v.baseConfig); err != nil {
+			rhc.proc.Close()
+			return nil, err
+		}
+		connID := addConn(l, owner)
+		//start the acceptor
+		wg.Add(1)
+		go regexAcceptor(l, connID, igst, rhc, tp)
+	} else if tp.UDP() {
+		var l *net.UDPConn
+		if l, err = listenPacket(k, udp, str); err != nil {
+			rhc.proc.Close()
+			return nil, err
+		}
+		connID := addConn(l, owner)
+		wg.Add(1)
+		go regexAcceptorUDP(l, connID, rhc, igst)
 	}
-	debugout("Started %d regex listeners\n", len(cfg.RegexListener))
-	return nil
+	return rhc.proc, nil
 }
 
 func regexAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg regexHandlerConfig, tp bindType) {
@@ -258,10 +225,13 @@ func regexAcceptorUDP(conn *net.UDPConn, id int, cfg regexHandlerConfig, igst *i
 
 func regexConnHandler(c net.Conn, cfg regexHandlerConfig, igst *ingest.IngestMuxer) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := addConn(c, cfg.owner)
 	defer cfg.wg.Done()
 	defer delConn(id)
 	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
 	var rip net.IP
 
 	if cfg.src == nil {
@@ -433,7 +403,7 @@ readerLoop:
 				Tag:  cfg.defTag,
 				Data: data,
 			}
-			cfg.proc.ProcessContext(ent, cfg.ctx)
+			cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx)
 		}
 	}
 }
@@ -0,0 +1,308 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	relpCmdOpen        = `open`
+	relpCmdClose       = `close`
+	relpCmdSyslog      = `syslog`
+	relpCmdRsp         = `rsp`
+	relpCmdServerClose = `serverclose`
+
+	relpVersion      = `0`
+	relpSoftware     = `gravwell`
+	relpMaxTxnr      = 999999999
+	relpMaxCmdLen    = 32
+	relpMaxNumLen    = 9
+	relpCloseTimeout = time.Second
+
+	relpOK = `200 OK`
+)
+
+var (
+	ErrRELPFraming = errors.New("invalid RELP frame")
+	ErrRELPTooBig  = errors.New("RELP frame exceeds maximum size")
+)
+
+// relpFrame is a single RELP frame: TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER
+type relpFrame struct {
+	txnr uint64
+	cmd  string
+	data []byte
+}
+
+// readRELPFrame reads the next frame off br, frames with more than maxData bytes are refused
+func readRELPFrame(br *bufio.Reader, maxData int) (f relpFrame, err error) {
+	var tok []byte
+	var term byte
+	if tok, term, err = readRELPToken(br, relpMaxNumLen); err != nil {
+		return
+	} else if term != ' ' {
+		err = ErrRELPFraming
+		return
+	} else if f.txnr, err = strconv.ParseUint(string(tok), 10, 32); err != nil || f.txnr > relpMaxTxnr {
+		err = ErrRELPFraming
+		return
+	}
+	if tok, term, err = readRELPToken(br, relpMaxCmdLen); err != nil {
+		return
+	} else if term != ' ' || len(tok) == 0 {
+		err = ErrRELPFraming
+		return
+	}
+	f.cmd = string(tok)
+
+	var sz uint64
+	if tok, term, err = readRELPToken(br, relpMaxNumLen); err != nil {
+		return
+	} else if sz, err = strconv.ParseUint(string(tok), 10, 32); err != nil {
+		err = ErrRELPFraming
+		return
+	} else if sz > uint64(maxData) {
+		err = ErrRELPTooBig
+		return
+	}
+	if sz == 0 {
+		//an empty frame goes straight to the trailer
+		if term != '\n' {
+			err = ErrRELPFraming
+		}
+		return
+	} else if term != ' ' {
+		err = ErrRELPFraming
+		return
+	}
+	f.data = make([]byte, sz)
+	if _, err = io.ReadFull(br, f.data); err != nil {
+		return
+	}
+	if term, err = br.ReadByte(); err == nil && term != '\n' {
+		err = ErrRELPFraming
+	}
+	return
+}
+
+// readRELPToken reads up to a space or newline, returning the token and the terminator
+func readRELPToken(br *bufio.Reader, maxLen int) (tok []byte, term byte, err error) {
+	for {
+		if term, err = br.ReadByte(); err != nil {
+			return
+		} else if term == ' ' || term == '\n' {
+			return
+		} else if len(tok) >= maxLen {
+			err = ErrRELPFraming
+			return
+		}
+		tok = append(tok, term)
+	}
+}
+
+// relpOffers parses the newline delimited name=value offers sent with an open command
+func relpOffers(b []byte) map[string]string {
+	mp := map[string]string{}
+	for _, ln := range bytes.Split(b, []byte("\n")) {
+		if k, v, _ := bytes.Cut(ln, []byte("=")); len(k) > 0 {
+			mp[string(bytes.TrimSpace(k))] = string(bytes.TrimSpace(v))
+		}
+	}
+	return mp
+}
+
+// relpSession serializes writes to a RELP connection.  It is registered as the closer
+// for the connection so that shutting down the listener tells the client we are going
+// away with a serverclose hint rather than just dropping the socket.
+type relpSession struct {
+	sync.Mutex
+	c      net.Conn
+	bw     *bufio.Writer
+	opened bool
+}
+
+func newRELPSession(c net.Conn) *relpSession {
+	return &relpSession{
+		c:  c,
+		bw: bufio.NewWriter(c),
+	}
+}
+
+func (rs *relpSession) respond(txnr uint64, msg string) (err error) {
+	rs.Lock()
+	defer rs.Unlock()
+	if len(msg) == 0 {
+		_, err = fmt.Fprintf(rs.bw, "%d %s 0\n", txnr, relpCmdRsp)
+	} else {
+		_, err = fmt.Fprintf(rs.bw, "%d %s %d %s\n", txnr, relpCmdRsp, len(msg), msg)
+	}
+	return
+}
+
+func (rs *relpSession) flush() (err error) {
+	rs.Lock()
+	err = rs.bw.Flush()
+	rs.Unlock()
+	return
+}
+
+func (rs *relpSession) isOpened() (r bool) {
+	rs.Lock()
+	r = rs.opened
+	rs.Unlock()
+	return
+}
+
+func (rs *relpSession) setOpened() {
+	rs.Lock()
+	rs.opened = true
+	rs.Unlock()
+}
+
+func (rs *relpSession) Close() error {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.opened {
+		rs.c.SetWriteDeadline(time.Now().Add(relpCloseTimeout))
+		fmt.Fprintf(rs.bw, "0 %s 0\n", relpCmdServerClose)
+		rs.bw.Flush()
+	}
+	return rs.c.Close()
+}
+
+// relpConnHandlerTCP services a RELP session.  Each syslog frame is acknowledged only
+// after the entry has been handed off to the ingest muxer, if that fails the connection
+// is dropped without an ack and the client will resend the frame on its next session.
+func relpConnHandlerTCP(c net.Conn, cfg handlerConfig) {
+	cfg.wg.Add(1)
+	rs := newRELPSession(c)
+	id := addConn(rs, cfg.owner)
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
+	var rip net.IP
+	debugout("new RELP connection from %v\n", c.RemoteAddr().String())
+
+	if cfg.src == nil {
+		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err != nil {
+			lg.Error("failed to get host from remote address", log.KV("address", c.RemoteAddr()), log.KVErr(err))
+			return
+		}
+		if rip = net.ParseIP(ipstr); rip == nil {
+			lg.Error("failed to parse remote address", log.KV("address", ipstr))
+			return
+		}
+	} else {
+		rip = cfg.src
+	}
+
+	tcfg := timegrinder.Config{
+		EnableLeftMostSeed: true,
+	}
+	tg, err := timegrinder.NewTimeGrinder(tcfg)
+	if err != nil {
+		lg.Error("failed to get a handle on the timegrinder", log.KVErr(err))
+		return
+	} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
+		lg.Error("failed to load custom time formats", log.KVErr(err))
+		return
+	}
+	if cfg.setLocalTime {
+		tg.SetLocalTime()
+	}
+	if cfg.timezoneOverride != `` {
+		if err = tg.SetTimezone(cfg.timezoneOverride); err != nil {
+			lg.Error("failed to set timezone", log.KV("timezone", cfg.timezoneOverride), log.KVErr(err))
+			return
+		}
+	}
+	if cfg.formatOverride != `` {
+		if err = tg.SetFormatOverride(cfg.formatOverride); err != nil {
+			lg.Error("Failed to load format override", log.KV("override", cfg.formatOverride), log.KVErr(err))
+			return
+		}
+	}
+
+	br := bufio.NewReader(c)
+	for {
+		f, err := readRELPFrame(br, maxDataSize)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				lg.Warn("failed to read RELP frame", log.KV("address", c.RemoteAddr()), log.KV("listener", cfg.name), log.KVErr(err))
+			}
+			return
+		}
+		switch f.cmd {
+		case relpCmdOpen:
+			if rs.isOpened() {
+				err = rs.respond(f.txnr, `500 session already open`)
+				break
+			}
+			offers := relpOffers(f.data)
+			if _, ok := offers[`relp_version`]; !ok {
+				rs.respond(f.txnr, `500 missing relp_version offer`)
+				rs.flush()
+				return
+			}
+			rs.setOpened()
+			err = rs.respond(f.txnr, relpOK+"\nrelp_version="+relpVersion+"\nrelp_software="+relpSoftware+"\ncommands="+relpCmdSyslog)
+		case relpCmdSyslog:
+			if !rs.isOpened() {
+				rs.respond(f.txnr, `500 session not open`)
+				rs.flush()
+				return
+			}
+			data := bytes.TrimSpace(f.data)
+			if cfg.dropPriority {
+				data = dropPriority(data)
+			}
+			if len(data) > 0 {
+				ent, err := handleLog(data, rip, cfg.ignoreTimestamps, cfg.tag, tg)
+				if err != nil {
+					return
+				} else if err = cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx); err != nil {
+					//no ack, the client will retransmit
+					return
+				}
+			}
+			err = rs.respond(f.txnr, relpOK)
+		case relpCmdClose:
+			rs.respond(f.txnr, ``)
+			rs.flush()
+			return
+		default:
+			err = rs.respond(f.txnr, `500 unsupported command `+f.cmd)
+		}
+		if err != nil {
+			return
+		}
+		//batch acks while the client has more frames in flight
+		if br.Buffered() == 0 {
+			if err = rs.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
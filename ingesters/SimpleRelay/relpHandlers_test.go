@@ -0,0 +1,122 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+func TestRELPFrames(t *testing.T) {
+	input := "1 open 14 relp_version=0\n" +
+		"2 syslog 11 hello\nworld\n" +
+		"3 close 0\n"
+	br := bufio.NewReader(strings.NewReader(input))
+	exp := []relpFrame{
+		{txnr: 1, cmd: relpCmdOpen, data: []byte("relp_version=0")},
+		{txnr: 2, cmd: relpCmdSyslog, data: []byte("hello\nworld")},
+		{txnr: 3, cmd: relpCmdClose},
+	}
+	for _, e := range exp {
+		f, err := readRELPFrame(br, 1024)
+		if err != nil {
+			t.Fatal(err)
+		} else if f.txnr != e.txnr || f.cmd != e.cmd || string(f.data) != string(e.data) {
+			t.Fatalf("bad frame %+v != %+v", f, e)
+		}
+	}
+
+	for _, bad := range []string{
+		"x syslog 1 a\n",
+		"1 syslog 2 a\n",
+		"1 syslog 1 ab\n",
+		"1 syslog 0 \n",
+		"1 syslog\n",
+		"1234567890 syslog 1 a\n",
+		"1 syslog 2048 a\n",
+	} {
+		if _, err := readRELPFrame(bufio.NewReader(strings.NewReader(bad)), 1024); err == nil {
+			t.Fatalf("failed to catch bad frame %q", bad)
+		}
+	}
+
+	if mp := relpOffers([]byte("relp_version=0\nrelp_software=test\ncommands=syslog")); mp[`relp_version`] != `0` || mp[`commands`] != `syslog` {
+		t.Fatalf("bad offers %v", mp)
+	}
+}
+
+func relpExpect(t *testing.T, br *bufio.Reader, txnr uint64, prefix string) {
+	f, err := readRELPFrame(br, 1024)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.txnr != txnr || f.cmd != relpCmdRsp || !strings.HasPrefix(string(f.data), prefix) {
+		t.Fatalf("bad response %d %s %q", f.txnr, f.cmd, f.data)
+	}
+}
+
+func TestRELPSession(t *testing.T) {
+	lg = log.NewDiscardLogger()
+	port := freePort(t)
+	block := fmt.Sprintf("\n[Listener \"relp\"]\n\tBind-String=tcp://127.0.0.1:%d\n\tTag-Name=relp\n\tReader-Type=relp\n\tDrop-Priority=true\n\tIgnore-Timestamps=true\n", port)
+	srv, im := newReloadMuxer(t, `relp`)
+	ls := newListenerSet(im, context.Background())
+	defer ls.Close()
+	if err := ls.apply(loadReloadConfig(t, block)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(c)
+
+	//syslog before open is refused
+	fmt.Fprintf(c, "1 syslog 5 hello\n")
+	relpExpect(t, br, 1, `500`)
+
+	if c, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	br = bufio.NewReader(c)
+	offer := "relp_version=0\nrelp_software=test\ncommands=syslog"
+	fmt.Fprintf(c, "1 open %d %s\n", len(offer), offer)
+	relpExpect(t, br, 1, relpOK+"\nrelp_version=0")
+
+	//send a window of frames before reading any acks
+	msgs := []string{`<13>first message`, `<13>second message`, `<13>third message`}
+	for i, m := range msgs {
+		fmt.Fprintf(c, "%d syslog %d %s\n", i+2, len(m), m)
+	}
+	for i := range msgs {
+		relpExpect(t, br, uint64(i+2), relpOK)
+	}
+	fmt.Fprintf(c, "5 bogus 0\n")
+	relpExpect(t, br, 5, `500`)
+	fmt.Fprintf(c, "6 close 0\n")
+	relpExpect(t, br, 6, ``)
+
+	waitTag(t, srv, `relp`, len(msgs))
+	for i, ent := range srv.TagEntries(`relp`) {
+		if exp := strings.TrimPrefix(msgs[i], `<13>`); string(ent.Data) != exp {
+			t.Fatalf("bad entry %q != %q", ent.Data, exp)
+		}
+	}
+}
@@ -25,10 +25,13 @@ import (
 
 func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := addConn(c, cfg.owner)
 	defer cfg.wg.Done()
 	defer delConn(id)
 	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
 	var rip net.IP
 	debugout("new connection from %v\n", c.RemoteAddr().String())
 
@@ -119,7 +122,7 @@ func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 		data = bytes.Clone(data) // the scanner re-uses bytes, so we have to clone
 		if ent, err := handleLog(data, rip, cfg.ignoreTimestamps, cfg.tag, tg); err != nil {
 			return
-		} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+		} else if err = cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx); err != nil {
 			return
 		}
 	}
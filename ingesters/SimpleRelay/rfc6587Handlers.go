@@ -59,10 +59,13 @@ func findGoodRFC6587Header(b []byte) (number, start, end int) {
 
 func rfc6587ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := addConn(c, cfg.owner)
 	defer cfg.wg.Done()
 	defer delConn(id)
 	defer c.Close()
+	if !cfg.client.identify(c, cfg.name) {
+		return
+	}
 	var rip net.IP
 	debugout("new connection from %v\n", c.RemoteAddr().String())
 
@@ -172,7 +175,7 @@ func rfc6587ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 		data = bytes.Clone(data) // we have to copy due to the scanner reusing its underlying buffer
 		if ent, err := handleLog(data, rip, cfg.ignoreTimestamps, cfg.tag, tg); err != nil {
 			return
-		} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+		} else if err = cfg.proc.ProcessContext(cfg.client.stamp(ent), cfg.ctx); err != nil {
 			return
 		}
 	}
@@ -22,11 +22,13 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 var (
-	connClosers map[int]closer
+	connClosers = map[int]closer{}
+	connOwners  = map[int]string{}
 	connId      int
 	mtx         sync.Mutex
 )
@@ -37,6 +39,7 @@ type closer interface {
 
 type handlerConfig struct {
 	name             string
+	owner            string
 	tag              entry.EntryTag
 	lrt              readerType
 	ignoreTimestamps bool
@@ -49,113 +52,122 @@ type handlerConfig struct {
 	proc             *processors.ProcessorSet
 	ctx              context.Context
 	timeFormats      config.CustomTimeFormat
+	client           clientIdent
 }
 
-func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
-	//short circuit out on empty
-	if len(cfg.Listener) == 0 {
-		return nil
+// startSimpleListener fires up a single Listener block, the listener and every connection it
+// accepts are registered under owner so they can be torn down together
+func startSimpleListener(k string, v *listener, owner string, cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, ctx context.Context) (proc *processors.ProcessorSet, err error) {
+	var src net.IP
+	if v.Source_Override != `` {
+		src = net.ParseIP(v.Source_Override)
+		if src == nil {
+			return nil, fmt.Errorf("Listener %v invalid source override \"%s\"", k, v.Source_Override)
+		}
+	} else if cfg.Source_Override != `` {
+		// global override
+		src = net.ParseIP(cfg.Source_Override)
+		if src == nil {
+			return nil, fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+		}
 	}
-
-	//fire up our simple backends
-	for k, v := range cfg.Listener {
-		var src net.IP
-		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
-				return fmt.Errorf("Listener %v invalid source override \"%s\"", k, v.Source_Override)
-			}
-		} else if cfg.Source_Override != `` {
-			// global override
-			src = net.ParseIP(cfg.Source_Override)
-			if src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
-			}
+	//get the tag for this listener
+	tag, err := igst.GetTag(v.Tag_Name)
+	if err != nil {
+		return nil, fmt.Errorf("Listener %s failed to resolve tag %q: %w", k, v.Tag_Name, err)
+	}
+	tp, str, err := translateBindType(v.Bind_String)
+	if err != nil {
+		return nil, fmt.Errorf("Listener %s invalid bind %q: %w", k, v.Bind_String, err)
+	}
+	lrt, err := translateReaderType(v.Reader_Type)
+	if err != nil {
+		return nil, fmt.Errorf("Listener %s invalid reader type %q: %w", k, v.Reader_Type, err)
+	}
+	hcfg := handlerConfig{
+		name:             k,
+		owner:            owner,
+		tag:              tag,
+		lrt:              lrt,
+		ignoreTimestamps: v.Ignore_Timestamps,
+		setLocalTime:     v.Assume_Local_Timezone,
+		dropPriority:     v.Drop_Priority,
+		timezoneOverride: v.Timezone_Override,
+		src:              src,
+		wg:               wg,
+		formatOverride:   v.Timestamp_Format_Override,
+		ctx:              ctx,
+		timeFormats:      cfg.TimeFormat,
+		client:           newClientIdent(v.baseConfig),
+	}
+	if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		return nil, fmt.Errorf("Listener %s preprocessor error: %w", k, err)
+	}
+	if tp.TCP() || tp.TLS() {
+		var l net.Listener
+		if l, err = listenStream(k, tp, str, v.baseConfig); err != nil {
+			hcfg.proc.Close()
+			return nil, err
 		}
-		//get the tag for this listener
-		tag, err := igst.GetTag(v.Tag_Name)
-		if err != nil {
-			lg.Fatal("failed to resolve tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+		connID := addConn(l, owner)
+		//start the acceptor
+		wg.Add(1)
+		go acceptor(l, connID, igst, hcfg, tp)
+	} else if tp.UDP() {
+		var l *net.UDPConn
+		if l, err = listenPacket(k, tp, str); err != nil {
+			hcfg.proc.Close()
+			return nil, err
 		}
-		tp, str, err := translateBindType(v.Bind_String)
+		connID := addConn(l, owner)
+		wg.Add(1)
+		go acceptorUDP(l, connID, hcfg, igst)
+	}
+	return hcfg.proc, nil
+}
+
+// listenStream opens a TCP or TLS listener for the named config block, TLS listeners pick
+// up certificate changes on disk without a restart
+func listenStream(k string, tp bindType, str string, bc baseConfig) (net.Listener, error) {
+	if tp.TLS() {
+		st, err := utils.NewServerTLS(bc.serverTLS(), lg)
 		if err != nil {
-			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
+			return nil, fmt.Errorf("%s %w", k, err)
 		}
-		lrt, err := translateReaderType(v.Reader_Type)
+		//get the socket
+		addr, err := net.ResolveTCPAddr("tcp", str)
 		if err != nil {
-			lg.FatalCode(0, "invalid reader type", log.KV("readertype", v.Reader_Type), log.KVErr(err))
+			return nil, fmt.Errorf("%s Bind-String \"%s\" is invalid: %w", k, str, err)
 		}
-		hcfg := handlerConfig{
-			name:             k,
-			tag:              tag,
-			lrt:              lrt,
-			ignoreTimestamps: v.Ignore_Timestamps,
-			setLocalTime:     v.Assume_Local_Timezone,
-			dropPriority:     v.Drop_Priority,
-			timezoneOverride: v.Timezone_Override,
-			src:              src,
-			wg:               wg,
-			formatOverride:   v.Timestamp_Format_Override,
-			ctx:              ctx,
-			timeFormats:      cfg.TimeFormat,
-		}
-		if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
-			lg.Fatal("preprocessor error", log.KVErr(err))
+		l, err := tls.Listen("tcp", addr.String(), st.Config())
+		if err != nil {
+			return nil, fmt.Errorf("%s failed to listen via TLS on \"%s\": %w", k, addr, err)
 		}
-		f.Add(hcfg.proc)
-		if tp.TCP() {
-			//get the socket
-			addr, err := net.ResolveTCPAddr(tp.String(), str)
-			if err != nil {
-				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
-			}
-			l, err := net.ListenTCP(tp.String(), addr)
-			if err != nil {
-				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go acceptor(l, connID, igst, hcfg, tp)
-		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
+		return l, nil
+	}
+	//get the socket
+	addr, err := net.ResolveTCPAddr(tp.String(), str)
+	if err != nil {
+		return nil, fmt.Errorf("%s Bind-String \"%s\" is invalid: %w", k, str, err)
+	}
+	l, err := net.ListenTCP(tp.String(), addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s Failed to listen on \"%s\": %w", k, addr, err)
+	}
+	return l, nil
+}
 
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.FatalCode(0, "failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
-			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
-			}
-			l, err := tls.Listen("tcp", addr.String(), config)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			//start the acceptor
-			wg.Add(1)
-			go acceptor(l, connID, igst, hcfg, tp)
-		} else if tp.UDP() {
-			addr, err := net.ResolveUDPAddr(tp.String(), str)
-			if err != nil {
-				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
-			}
-			l, err := net.ListenUDP(tp.String(), addr)
-			if err != nil {
-				lg.FatalCode(0, "failed to listen via udp", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
-			}
-			connID := addConn(l)
-			wg.Add(1)
-			go acceptorUDP(l, connID, hcfg, igst)
-		}
+// listenPacket opens a UDP socket for the named config block
+func listenPacket(k string, tp bindType, str string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr(tp.String(), str)
+	if err != nil {
+		return nil, fmt.Errorf("%s Bind-String \"%s\" is invalid: %w", k, str, err)
+	}
+	l, err := net.ListenUDP(tp.String(), addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to listen via udp on \"%s\": %w", k, addr, err)
 	}
-	debugout("Started %d listeners\n", len(cfg.Listener))
-	return nil
+	return l, nil
 }
 
 func acceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg handlerConfig, tp bindType) {
@@ -187,6 +199,8 @@ func acceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg handlerCon
 			go rfc5424ConnHandlerTCP(conn, cfg)
 		case rfc6587Reader:
 			go rfc6587ConnHandlerTCP(conn, cfg)
+		case relpReader:
+			go relpConnHandlerTCP(conn, cfg)
 		default:
 			lg.Error("invalid reader type", log.KV("readertype", cfg.lrt))
 			return
@@ -238,11 +252,13 @@ func handleLog(b []byte, ip net.IP, ignoreTS bool, tag entry.EntryTag, tg *timeg
 	return
 }
 
-func addConn(c closer) int {
+// addConn tracks a listener or connection, owner is the listener block it belongs to
+func addConn(c closer, owner string) int {
 	mtx.Lock()
 	connId++
 	id := connId
 	connClosers[connId] = c
+	connOwners[connId] = owner
 	mtx.Unlock()
 	return id
 }
@@ -250,6 +266,18 @@ func addConn(c closer) int {
 func delConn(id int) {
 	mtx.Lock()
 	delete(connClosers, id)
+	delete(connOwners, id)
+	mtx.Unlock()
+}
+
+// closeConns closes everything registered by a listener block
+func closeConns(owner string) {
+	mtx.Lock()
+	for id, c := range connClosers {
+		if connOwners[id] == owner {
+			c.Close()
+		}
+	}
 	mtx.Unlock()
 }
 
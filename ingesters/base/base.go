@@ -58,11 +58,15 @@ type IngesterBaseConfig struct {
 
 type IngesterBase struct {
 	IngesterBaseConfig
-	Verbose bool
-	Logger  *log.Logger
-	Cfg     interface{}
-	id      uuid.UUID
-	sm      *utils.StatsManager
+	Verbose  bool
+	Logger   *log.Logger
+	Cfg      interface{}
+	id       uuid.UUID
+	sm       *utils.StatsManager
+	ms       *metricsServer
+	rr       *rateLimitReporter
+	confLoc  string
+	confdLoc string
 }
 
 func Init(ibc IngesterBaseConfig) (ib IngesterBase, err error) {
@@ -100,6 +104,7 @@ func Init(ibc IngesterBaseConfig) (ib IngesterBase, err error) {
 	}
 	ib.Logger.SetAppname(ibc.AppName)
 	ib.Verbose = *verbose
+	ib.confLoc, ib.confdLoc = *confLoc, *confdLoc
 	debug.SetTraceback("all")
 
 	//now try to call getConfig and extract the base ingester configuration
@@ -208,6 +213,11 @@ func (ib *IngesterBase) GetMuxer() (igst *ingest.IngestMuxer, err error) {
 		id = uuid.Nil //set to the zero UUID, we attempt to write one back during init, but if that fails... just use zero
 	}
 	ib.id = id
+	routes, err := cfg.TagRoutes()
+	if err != nil {
+		ib.Logger.FatalCode(0, "failed to get tag routes from configuration", log.KVErr(err))
+		return
+	}
 	igCfg := ingest.UniformMuxerConfig{
 		IngestStreamConfig: cfg.IngestStreamConfig,
 		Destinations:       conns,
@@ -224,8 +234,14 @@ func (ib *IngesterBase) GetMuxer() (igst *ingest.IngestMuxer, err error) {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
+		CacheBackend:       cfg.Cache_Backend,
+		CacheSync:          cfg.Cache_Sync,
+		CacheMaxAge:        cfg.MaxCacheAge(),
 		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
 		Attach:             ch.AttachConfig(),
+		Replicate:          cfg.Replicate_Targets,
+		ReplicationFactor:  cfg.Replication_Factor,
+		TagRoutes:          routes,
 	}
 	if igst, err = ingest.NewUniformMuxer(igCfg); err != nil {
 		ib.Logger.Fatal("failed to build our ingest system", log.KVErr(err))
@@ -250,6 +266,15 @@ func (ib *IngesterBase) GetMuxer() (igst *ingest.IngestMuxer, err error) {
 		ib.Logger.FatalCode(0, "failed to set configuration for ingester state messages")
 	}
 
+	if cfg.Metrics_Listen_Address != `` {
+		if err = ib.startMetrics(cfg.Metrics_Listen_Address, igst); err != nil {
+			ib.Logger.FatalCode(0, "failed to start metrics listener", log.KV("address", cfg.Metrics_Listen_Address), log.KVErr(err))
+			return
+		}
+		ib.Debug("Serving metrics on %s%s\n", cfg.Metrics_Listen_Address, metricsPath)
+	}
+	ib.rr = newRateLimitReporter(igst)
+
 	return
 }
 
@@ -382,6 +407,10 @@ func (ib IngesterBase) AnnounceShutdown() {
 	if ib.sm != nil {
 		ib.sm.Stop()
 	}
+	if err := ib.ms.Close(); err != nil {
+		ib.Logger.Warn("failed to stop metrics listener", log.KVErr(err))
+	}
+	ib.rr.Close()
 }
 
 func (ib *IngesterBase) RegisterStat(name string) (*utils.StatsItem, error) {
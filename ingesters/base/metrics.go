@@ -0,0 +1,168 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package base
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/ingesters/version"
+)
+
+const (
+	metricsPath         = `/metrics`
+	metricsPrefix       = `gravwell_ingester_`
+	metricsContentType  = `text/plain; version=0.0.4; charset=utf-8`
+	metricsReadTimeout  = 5 * time.Second
+	metricsCloseTimeout = time.Second
+)
+
+// metricsServer exposes muxer and ingester counters in the Prometheus text format
+type metricsServer struct {
+	srv  *http.Server
+	igst *ingest.IngestMuxer
+	sm   *utils.StatsManager
+	name string
+	uuid string
+}
+
+// startMetrics binds addr and begins serving /metrics in the background
+func (ib *IngesterBase) startMetrics(addr string, igst *ingest.IngestMuxer) (err error) {
+	ms := &metricsServer{
+		igst: igst,
+		sm:   ib.sm,
+		name: ib.IngesterName,
+		uuid: ib.id.String(),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, ms)
+	ms.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadTimeout,
+	}
+	var l net.Listener
+	if l, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+	go func(lgr *log.Logger) {
+		if err := ms.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			lgr.Error("metrics server failed", log.KV("address", addr), log.KVErr(err))
+		}
+	}(ib.Logger)
+	ib.ms = ms
+	return
+}
+
+func (ms *metricsServer) Close() error {
+	if ms == nil {
+		return nil
+	}
+	ctx, cf := context.WithTimeout(context.Background(), metricsCloseTimeout)
+	defer cf()
+	return ms.srv.Shutdown(ctx)
+}
+
+func (ms *metricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	bb := bytes.NewBuffer(nil)
+	ms.write(bb)
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, bb)
+}
+
+// write emits every metric, muxer metrics are skipped if the muxer is not running
+func (ms *metricsServer) write(w io.Writer) {
+	writeMetricHeader(w, `info`, `gauge`, `Ingester identification, the value is always 1`)
+	fmt.Fprintf(w, "%sinfo{name=\"%s\",version=\"%s\",uuid=\"%s\"} 1\n", metricsPrefix,
+		escapeLabel(ms.name), escapeLabel(version.GetVersion()), escapeLabel(ms.uuid))
+
+	if st, err := ms.igst.Stats(); err == nil {
+		writeMetric(w, `uptime_seconds`, `gauge`, `Seconds since the ingest muxer started`, st.Uptime.Seconds())
+		writeMetricHeader(w, `connections`, `gauge`, `Indexer connections by state`)
+		fmt.Fprintf(w, "%sconnections{state=\"hot\"} %d\n", metricsPrefix, st.Hot)
+		fmt.Fprintf(w, "%sconnections{state=\"dead\"} %d\n", metricsPrefix, st.Dead)
+		writeMetric(w, `cache_depth`, `gauge`, `Entries and blocks waiting in the in-memory buffers`, st.CacheDepth)
+		writeMetric(w, `cache_bytes`, `gauge`, `Bytes held in the ingest cache`, st.CacheBytes)
+		writeMetric(w, `entries_total`, `counter`, `Entries handed to the ingest muxer`, st.Entries)
+		writeMetric(w, `bytes_total`, `counter`, `Bytes of entry data handed to the ingest muxer`, st.Bytes)
+	}
+
+	if ts := ms.igst.TagStats(); len(ts) > 0 {
+		writeMetricHeader(w, `tag_entries_total`, `counter`, `Entries handed to the ingest muxer by tag`)
+		for _, v := range ts {
+			fmt.Fprintf(w, "%stag_entries_total{tag=\"%s\"} %d\n", metricsPrefix, escapeLabel(v.Tag), v.Entries)
+		}
+		writeMetricHeader(w, `tag_bytes_total`, `counter`, `Bytes of entry data handed to the ingest muxer by tag`)
+		for _, v := range ts {
+			fmt.Fprintf(w, "%stag_bytes_total{tag=\"%s\"} %d\n", metricsPrefix, escapeLabel(v.Tag), v.Bytes)
+		}
+	}
+
+	writeLabeledCounters(w, `queue_dropped_total`, `queue`,
+		`Entries dropped because a destination queue and its cache were full`, ms.igst.QueueDropped())
+	writeLabeledCounters(w, `preprocessor_dropped_total`, `preprocessor`,
+		`Entries removed by each preprocessor`, processors.DropCounts())
+	if reps := processors.RateLimitReports(); len(reps) > 0 {
+		limited := make(map[string]uint64, len(reps))
+		for _, r := range reps {
+			limited[r.Name] += r.Limited
+		}
+		writeLabeledCounters(w, `preprocessor_ratelimited_total`, `preprocessor`,
+			`Entries over the limit of each ratelimit preprocessor`, limited)
+	}
+	if ms.sm != nil {
+		writeLabeledCounters(w, `stats_total`, `item`, `Cumulative value of each ingester stats item`, ms.sm.Totals())
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+func writeMetric(w io.Writer, name, typ, help string, v interface{}) {
+	writeMetricHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s%s %v\n", metricsPrefix, name, v)
+}
+
+func writeLabeledCounters(w io.Writer, name, label, help string, vals map[string]uint64) {
+	if len(vals) == 0 {
+		return
+	}
+	writeMetricHeader(w, name, `counter`, help)
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s{%s=\"%s\"} %d\n", metricsPrefix, name, label, escapeLabel(k), vals[k])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value per the Prometheus text exposition format
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
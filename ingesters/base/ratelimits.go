@@ -0,0 +1,84 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	rateLimitReportInterval = 30 * time.Second
+	rateLimitMetadataKey    = `RateLimits` // ingester state metadata key holding the reports
+)
+
+// rateLimitReporter periodically publishes rate limiter reports through the muxer so
+// that the UI can show which keys the ratelimit preprocessors are shedding, the metadata
+// is only touched when the reports change.  It runs for every ingester, ingesters that
+// never open a ratelimit preprocessor have no reports and never publish anything.
+type rateLimitReporter struct {
+	igst *ingest.IngestMuxer
+	last []byte
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newRateLimitReporter(igst *ingest.IngestMuxer) *rateLimitReporter {
+	rr := &rateLimitReporter{
+		igst: igst,
+		done: make(chan struct{}),
+	}
+	rr.wg.Add(1)
+	go rr.routine()
+	return rr
+}
+
+func (rr *rateLimitReporter) routine() {
+	defer rr.wg.Done()
+	tckr := time.NewTicker(rateLimitReportInterval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-rr.done:
+			return
+		case <-tckr.C:
+			rr.publish()
+		}
+	}
+}
+
+func (rr *rateLimitReporter) publish() {
+	reps := processors.RateLimitReports()
+	if len(reps) == 0 {
+		if rr.last != nil && rr.igst.SetMetadataKey(rateLimitMetadataKey, nil) == nil {
+			rr.last = nil
+		}
+		return
+	}
+	msg, err := json.Marshal(reps)
+	if err != nil || bytes.Equal(msg, rr.last) {
+		return
+	}
+	if err = rr.igst.SetMetadataKey(rateLimitMetadataKey, json.RawMessage(msg)); err == nil {
+		rr.last = msg
+	}
+}
+
+func (rr *rateLimitReporter) Close() {
+	if rr == nil {
+		return
+	}
+	close(rr.done)
+	rr.wg.Wait()
+}
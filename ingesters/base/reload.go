@@ -0,0 +1,118 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package base
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+// ReloadFunc applies a freshly loaded and verified configuration to a running ingester.
+// Both values are the native config type produced by GetConfigFunc.  If the function
+// returns an error the ingester must still be running on oldCfg.
+type ReloadFunc func(oldCfg, newCfg interface{}) error
+
+// Reload re-reads the configuration file and overlays and verifies the result.  Any new
+// tags are negotiated on igst before fn is called to apply the new configuration, on
+// success IngesterBase.Cfg is swapped out for the new configuration.  If anything fails
+// the previous configuration stays in place and the new tags are released.
+//
+// Global settings such as indexer targets, secrets, and the cache cannot be changed
+// while the muxer is running, changes to them are logged and ignored until a restart.
+func (ib *IngesterBase) Reload(igst *ingest.IngestMuxer, fn ReloadFunc) (err error) {
+	if ib == nil || ib.Cfg == nil || igst == nil || fn == nil {
+		return ErrNotReady
+	}
+	oldCh, ok := ib.Cfg.(cfgHelper)
+	if !ok {
+		return fmt.Errorf("Config type %T does not implement the helper interface", ib.Cfg)
+	}
+	obj, ch, err := ib.getConfig(ib.confLoc, ib.confdLoc)
+	if err != nil {
+		return
+	} else if err = verifyConfig(obj); err != nil {
+		return
+	} else if reflect.TypeOf(obj) != reflect.TypeOf(ib.Cfg) {
+		return fmt.Errorf("Type Mismatch: %T != %T", obj, ib.Cfg)
+	}
+	oldBC, newBC := oldCh.IngestBaseConfig(), ch.IngestBaseConfig()
+	missingUUID := newBC.Ingester_UUID == `` && ib.id != uuid.Nil
+	if missingUUID {
+		newBC.Ingester_UUID = oldBC.Ingester_UUID
+	}
+	if !reflect.DeepEqual(oldBC, newBC) {
+		ib.Logger.Warn("global configuration changed, a restart is required to apply it")
+	}
+
+	tags, err := ch.Tags()
+	if err != nil {
+		return fmt.Errorf("Failed to get tags %w", err)
+	}
+	known := make(map[string]bool)
+	for _, tag := range igst.KnownTags() {
+		known[tag] = true
+	}
+	var added []string
+	for _, tag := range tags {
+		if _, err = igst.NegotiateTag(tag); err != nil {
+			igst.ReleaseTags(added)
+			return fmt.Errorf("Failed to negotiate tag %q %w", tag, err)
+		} else if !known[tag] {
+			added = append(added, tag)
+		}
+	}
+
+	if err = fn(ib.Cfg, obj); err != nil {
+		igst.ReleaseTags(added)
+		return
+	}
+	ib.Cfg = obj
+	if missingUUID {
+		//the UUID went missing from the file, keep using the one we started with
+		if lerr := ib.writebackUUID(ib.id); lerr != nil {
+			ib.Logger.Warn("failed to write ingester UUID back to configuration", log.KVErr(lerr))
+		}
+	}
+	if lerr := igst.SetRawConfiguration(obj); lerr != nil {
+		ib.Logger.Warn("failed to update configuration for ingester state messages", log.KVErr(lerr))
+	}
+	return
+}
+
+// WaitForQuitOrReload blocks until the ingester is asked to exit and returns the signal.
+// A SIGHUP reloads the configuration via Reload instead, a failed reload is logged and
+// the ingester keeps running with the configuration it already had.
+//
+// Only ingesters that call WaitForQuitOrReload with a ReloadFunc reload on SIGHUP, every
+// other ingester (and a nil fn) keeps treating SIGHUP as a request to exit.
+func (ib *IngesterBase) WaitForQuitOrReload(igst *ingest.IngestMuxer, fn ReloadFunc) os.Signal {
+	if fn == nil {
+		return utils.WaitForQuit()
+	}
+	quit, reload := utils.GetQuitReloadChannels()
+	for {
+		select {
+		case sig := <-quit:
+			return sig
+		case <-reload:
+			ib.Logger.Info("reloading configuration", log.KV("config", ib.confLoc), log.KV("overlays", ib.confdLoc))
+			if err := ib.Reload(igst, fn); err != nil {
+				ib.Logger.Error("configuration reload failed, keeping current configuration", log.KVErr(err))
+				continue
+			}
+			ib.Logger.Info("configuration reloaded")
+		}
+	}
+}
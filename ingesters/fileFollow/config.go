@@ -35,6 +35,7 @@ const (
 var (
 	ErrInvalidStateStoreLocation         = errors.New("Empty state storage location")
 	ErrTimestampDelimiterMissingOverride = errors.New("Timestamp delimiting requires a defined timestamp override")
+	ErrMultipleEngines                   = errors.New("Only one of Timestamp-Delimited, Regex-Delimiter, JSON-Stream, or Multiline options may be set")
 )
 
 type bindType int
@@ -63,7 +64,13 @@ type follower struct {
 	Timestamp_Delimited       bool
 	Timezone_Override         string
 	Regex_Delimiter           string
-	Preprocessor              []string
+	JSON_Stream               bool // records are concatenated or pretty printed JSON values
+	// multiline records, such as log messages followed by stack traces
+	Multiline_Start_Regex        string
+	Multiline_Continuation_Regex string
+	Multiline_Max_Lines          int
+	Multiline_Timeout            string
+	Preprocessor                 []string
 	// these two must be used together
 	Timestamp_Regex         string
 	Timestamp_Format_String string
@@ -134,6 +141,11 @@ func (c *cfgType) Verify() error {
 		if v.Timestamp_Delimited && v.Timestamp_Format_Override == `` {
 			return ErrTimestampDelimiterMissingOverride
 		}
+		if _, ok, err := v.multiline(); err != nil {
+			return fmt.Errorf("Invalid multiline options in follower %v: %v", k, err)
+		} else if engineCount(v.Timestamp_Delimited, v.Regex_Delimiter != ``, v.JSON_Stream, ok) > 1 {
+			return fmt.Errorf("%v: %w", k, ErrMultipleEngines)
+		}
 		if (v.Timestamp_Regex != `` && v.Timestamp_Format_String == ``) || (v.Timestamp_Regex == `` && v.Timestamp_Format_String != ``) {
 			return errors.New("Timestamp-Regex and Timestamp-Format-String must both be specified, or both left unset")
 		}
@@ -248,6 +260,63 @@ func (f follower) TimestampDelimited() (rex string, ok bool, err error) {
 	return
 }
 
+// multiline builds the multiline engine config, ok is false if no multiline options are set
+func (f follower) multiline() (mc filewatch.MultilineConfig, ok bool, err error) {
+	if f.Multiline_Start_Regex == `` && f.Multiline_Continuation_Regex == `` {
+		if f.Multiline_Max_Lines != 0 || f.Multiline_Timeout != `` {
+			err = filewatch.ErrMultilineNoPattern
+		}
+		return
+	}
+	mc = filewatch.MultilineConfig{
+		StartPattern:        f.Multiline_Start_Regex,
+		ContinuationPattern: f.Multiline_Continuation_Regex,
+		MaxLines:            f.Multiline_Max_Lines,
+	}
+	if f.Multiline_Timeout != `` {
+		if mc.Timeout, err = time.ParseDuration(f.Multiline_Timeout); err != nil {
+			return
+		}
+	}
+	if err = mc.Validate(); err == nil {
+		ok = true
+	}
+	return
+}
+
+// EngineConfig picks the filewatch engine that splits the followed files into entries
+func (f follower) EngineConfig() (ec filewatch.FollowerEngineConfig, err error) {
+	var rex string
+	var ok bool
+	if rex, ok, err = f.TimestampDelimited(); err != nil {
+		return
+	} else if ok {
+		ec.Engine = filewatch.RegexEngine
+		ec.EngineArgs = rex
+	} else if f.Regex_Delimiter != `` {
+		ec.Engine = filewatch.RegexEngine
+		ec.EngineArgs = f.Regex_Delimiter
+	} else if f.JSON_Stream {
+		ec.Engine = filewatch.JSONEngine
+	} else if ec.Multiline, ok, err = f.multiline(); err != nil {
+		return
+	} else if ok {
+		ec.Engine = filewatch.MultilineEngine
+	} else {
+		ec.Engine = filewatch.LineEngine
+	}
+	return
+}
+
+func engineCount(set ...bool) (cnt int) {
+	for _, v := range set {
+		if v {
+			cnt++
+		}
+	}
+	return
+}
+
 func (f follower) TimezoneOverride() string {
 	return f.Timezone_Override
 }
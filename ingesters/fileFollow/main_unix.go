@@ -156,16 +156,8 @@ func main() {
 			Hnd:        lh,
 			Recursive:  val.Recursive,
 		}
-		if rex, ok, err := val.TimestampDelimited(); err != nil {
-			lg.FatalCode(0, "invalid timestamp delimiter", log.KVErr(err))
-		} else if ok {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = rex
-		} else if val.Regex_Delimiter != `` {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = val.Regex_Delimiter
-		} else {
-			c.Engine = filewatch.LineEngine
+		if c.FollowerEngineConfig, err = val.EngineConfig(); err != nil {
+			lg.FatalCode(0, "invalid follower engine options", log.KV("follower", k), log.KVErr(err))
 		}
 		if err := wtcher.Add(c); err != nil {
 			wtcher.Close()
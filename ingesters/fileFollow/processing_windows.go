@@ -368,16 +368,8 @@ func (m *mainService) init(ctx context.Context) error {
 			Hnd:        lh,
 			Recursive:  val.Recursive,
 		}
-		if rex, ok, err := val.TimestampDelimited(); err != nil {
-			errorout("Invalid timestamp delimiter: %v\n", err)
-		} else if ok {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = rex
-		} else if val.Regex_Delimiter != `` {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = val.Regex_Delimiter
-		} else {
-			c.Engine = filewatch.LineEngine
+		if c.FollowerEngineConfig, err = val.EngineConfig(); err != nil {
+			errorout("Invalid follower engine options for %s: %v\n", k, err)
 		}
 
 		if err := m.wtchr.Add(c); err != nil {
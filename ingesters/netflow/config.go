@@ -23,9 +23,11 @@ const (
 	MAX_CONFIG_SIZE int64 = (1024 * 1024 * 2) //2MB, even this is crazy large
 	nfv5Type              = iota
 	ipfixType             = iota
+	sflowType             = iota
 
 	nfv5Name  string = `netflowv5`
 	ipfixName string = `ipfix`
+	sflowName string = `sflowv5`
 )
 
 var ()
@@ -33,12 +35,13 @@ var ()
 type flowType int
 
 type collector struct {
-	Bind_String           string //IP port pair 127.0.0.1:1234
-	Tag_Name              string
-	Assume_Local_Timezone bool
-	Ignore_Timestamps     bool
-	Flow_Type             string
-	Session_Dump_Enabled  bool
+	Bind_String            string //IP port pair 127.0.0.1:1234
+	Tag_Name               string
+	Assume_Local_Timezone  bool
+	Ignore_Timestamps      bool
+	Flow_Type              string
+	Session_Dump_Enabled   bool
+	Extract_Packet_Headers bool //sFlow only, emit sampled packet headers instead of the samples
 }
 
 type cfgReadType struct {
@@ -94,6 +97,11 @@ func (c *cfgType) Verify() error {
 		if ingest.CheckTag(v.Tag_Name) != nil {
 			return errors.New("Invalid characters in the Tag-Name for " + k)
 		}
+		if ft, err := translateFlowType(v.Flow_Type); err != nil {
+			return errors.New("Invalid Flow-Type for " + k)
+		} else if v.Extract_Packet_Headers && ft != sflowType {
+			return errors.New("Extract-Packet-Headers is only valid for sFlow collectors, see " + k)
+		}
 		if n, ok := bindMp[v.Bind_String]; ok {
 			return errors.New("Bind-String for " + k + " already in use by " + n)
 		}
@@ -135,6 +143,8 @@ func (ft flowType) String() string {
 		return "Netflow V5"
 	case ipfixType:
 		return "IPFIX"
+	case sflowType:
+		return "sFlow V5"
 	}
 	return "unknown"
 }
@@ -150,6 +160,10 @@ func translateFlowType(s string) (flowType, error) {
 		return nfv5Type, nil
 	case ipfixName:
 		return ipfixType, nil
+	case `sflow`: //sflowName shortcut
+		fallthrough
+	case sflowName:
+		return sflowType, nil
 	}
 	return -1, errors.New("invalid reader type")
 }
@@ -321,3 +321,134 @@ func (i *IpfixHandler) routine(id int) {
 		i.ch <- e
 	}
 }
+
+type SFlowHandler struct {
+	bindConfig
+	mtx   *sync.Mutex
+	c     *net.UDPConn
+	ready bool
+}
+
+func NewSFlowHandler(c bindConfig) (*SFlowHandler, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &SFlowHandler{
+		bindConfig: c,
+		mtx:        &sync.Mutex{},
+	}, nil
+}
+
+func (s *SFlowHandler) String() string {
+	return `SFlowV5`
+}
+
+func (s *SFlowHandler) Listen(addr string) (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.c != nil {
+		err = ErrAlreadyListening
+		return
+	}
+	var a *net.UDPAddr
+	if a, err = net.ResolveUDPAddr("udp", addr); err != nil {
+		return
+	}
+	if s.c, err = net.ListenUDP("udp", a); err == nil {
+		s.ready = true
+	}
+	return
+}
+
+func (s *SFlowHandler) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s == nil {
+		return ErrAlreadyClosed
+	}
+	s.ready = false
+	return s.c.Close()
+}
+
+func (s *SFlowHandler) Start(id int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.ready || s.c == nil {
+		return ErrNotReady
+	}
+	if id < 0 {
+		return errors.New("invalid id")
+	}
+	go s.routine(id)
+	return nil
+}
+
+func (s *SFlowHandler) routine(id int) {
+	defer s.wg.Done()
+	defer delConn(id)
+	var l int
+	var addr *net.UDPAddr
+	var err error
+	tbuff := make([]byte, 65507) // just go with max UDP packet size
+	for {
+		if l, addr, err = s.c.ReadFromUDP(tbuff); err != nil {
+			debugout("Error in ReadFromUDP: %v\n", err)
+			return
+		}
+		var dg netflow.SFlowDatagram
+		if err = dg.Decode(tbuff[:l]); err != nil {
+			debugout("Rejecting sFlow datagram from %v: %v\n", addr.IP, err)
+			continue //there isn't much we can do about bad packets...
+		}
+		// sFlow carries no wall clock time, the best we can do is when we got it
+		ts := entry.Now()
+		for _, ent := range sflowEntries(&dg, s.extractHeaders) {
+			ent.Tag = s.tag
+			ent.SRC = addr.IP
+			ent.TS = ts
+			s.ch <- ent
+		}
+	}
+}
+
+// sflowEntries splits a datagram into one entry per sample, each entry is a datagram carrying
+// just that sample.  If extracting headers, flow samples are instead broken into one entry
+// per sampled packet header with the sampling details attached as enumerated values.
+func sflowEntries(dg *netflow.SFlowDatagram, extract bool) (ents []*entry.Entry) {
+	for _, smp := range dg.Samples {
+		if extract {
+			if hdrs := smp.RawPacketHeaders(); len(hdrs) > 0 {
+				for _, hdr := range hdrs {
+					ent := &entry.Entry{Data: hdr.Header}
+					ent.AddEnumeratedValueEx(`agent`, dg.Agent)
+					addSampleEVs(ent, smp)
+					ent.AddEnumeratedValueEx(`header_protocol`, hdr.Protocol)
+					ent.AddEnumeratedValueEx(`frame_length`, hdr.FrameLength)
+					ent.AddEnumeratedValueEx(`stripped`, hdr.Stripped)
+					ents = append(ents, ent)
+				}
+				continue
+			}
+		}
+		b, err := dg.EncodeSamples(smp)
+		if err != nil {
+			debugout("Failed to encode sFlow sample: %v\n", err)
+			continue
+		}
+		ent := &entry.Entry{Data: b}
+		if smp.IsFlow() {
+			addSampleEVs(ent, smp)
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+func addSampleEVs(ent *entry.Entry, smp netflow.SFlowSample) {
+	ent.AddEnumeratedValueEx(`sampling_rate`, smp.SamplingRate)
+	ent.AddEnumeratedValueEx(`sample_pool`, smp.SamplePool)
+	ent.AddEnumeratedValueEx(`drops`, smp.Drops)
+	ent.AddEnumeratedValueEx(`input`, smp.Input)
+	ent.AddEnumeratedValueEx(`output`, smp.Output)
+}
@@ -107,6 +107,7 @@ func main() {
 		bc.ignoreTS = v.Ignore_Timestamps
 		bc.localTZ = v.Assume_Local_Timezone
 		bc.sessionDumpEnabled = v.Session_Dump_Enabled
+		bc.extractHeaders = v.Extract_Packet_Headers
 		bc.lastInfoDump = time.Now()
 		var bh BindHandler
 		switch ft {
@@ -120,6 +121,11 @@ func main() {
 				lg.FatalCode(0, "NewIpfixHandler failed", log.KVErr(err))
 				return
 			}
+		case sflowType:
+			if bh, err = NewSFlowHandler(bc); err != nil {
+				lg.FatalCode(0, "NewSFlowHandler failed", log.KVErr(err))
+				return
+			}
 		default:
 			lg.FatalCode(0, "invalid flow type", log.KV("flowtype", ft))
 			return
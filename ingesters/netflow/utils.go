@@ -57,6 +57,7 @@ type bindConfig struct {
 	igst               *ingest.IngestMuxer
 	lastInfoDump       time.Time
 	sessionDumpEnabled bool
+	extractHeaders     bool
 }
 
 type BindHandler interface {
@@ -42,46 +42,64 @@ type v3auth struct {
 
 type global struct {
 	config.IngestConfig
+	MIB_Directory []string // directories of MIB files used to translate OIDs
 }
 
 type cfgReadType struct {
 	Global       global
 	Attach       attach.AttachConfig
 	Listener     map[string]*listener
+	Poller       map[string]*poller
 	Preprocessor processors.ProcessorConfig
 }
 
 type cfgType struct {
 	config.IngestConfig
-	Attach       attach.AttachConfig
-	Listener     map[string]*listener
-	Preprocessor processors.ProcessorConfig
+	MIB_Directory []string
+	Attach        attach.AttachConfig
+	Listener      map[string]*listener
+	Poller        map[string]*poller
+	Preprocessor  processors.ProcessorConfig
 }
 
 func (a *v3auth) validate() error {
-	if a.Auth_Protocol != "" && a.Auth_Protocol != "MD5" && a.Auth_Protocol != "SHA" {
-		return fmt.Errorf("Invalid Auth-Protocol %v. Supported protocols: MD5, SHA")
+	if _, ok := authProtocols[a.Auth_Protocol]; !ok && a.Auth_Protocol != "" {
+		return fmt.Errorf("Invalid Auth-Protocol %v. Supported protocols: MD5, SHA, SHA224, SHA256, SHA384, SHA512", a.Auth_Protocol)
 	}
-	if a.Privacy_Protocol != "" && a.Privacy_Protocol != "DES" {
-		return fmt.Errorf("Invalid Privacy-Protocol %v. Supported protocols: DES")
+	if _, ok := privacyProtocols[a.Privacy_Protocol]; !ok && a.Privacy_Protocol != "" {
+		return fmt.Errorf("Invalid Privacy-Protocol %v. Supported protocols: DES, AES, AES192, AES256, AES192C, AES256C", a.Privacy_Protocol)
 	}
 	return nil
 }
 
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var privacyProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
 func (a *v3auth) getAuthProto() gosnmp.SnmpV3AuthProtocol {
-	switch a.Auth_Protocol {
-	case "MD5":
-		return gosnmp.MD5
-	case "SHA":
-		return gosnmp.SHA
+	if p, ok := authProtocols[a.Auth_Protocol]; ok {
+		return p
 	}
 	return gosnmp.NoAuth
 }
 
 func (a *v3auth) getPrivacyProto() gosnmp.SnmpV3PrivProtocol {
-	switch a.Privacy_Protocol {
-	case "DES":
-		return gosnmp.DES
+	if p, ok := privacyProtocols[a.Privacy_Protocol]; ok {
+		return p
 	}
 	return gosnmp.NoPriv
 }
@@ -105,10 +123,12 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		return nil, err
 	}
 	c := &cfgType{
-		IngestConfig: cr.Global.IngestConfig,
-		Attach:       cr.Attach,
-		Listener:     cr.Listener,
-		Preprocessor: cr.Preprocessor,
+		IngestConfig:  cr.Global.IngestConfig,
+		MIB_Directory: cr.Global.MIB_Directory,
+		Attach:        cr.Attach,
+		Listener:      cr.Listener,
+		Poller:        cr.Poller,
+		Preprocessor:  cr.Preprocessor,
 	}
 
 	if err := c.Verify(); err != nil {
@@ -126,8 +146,8 @@ func (c *cfgType) Verify() error {
 		return err
 	}
 
-	if len(c.Listener) == 0 {
-		return errors.New("No listeners specified")
+	if len(c.Listener) == 0 && len(c.Poller) == 0 {
+		return errors.New("No listeners or pollers specified")
 	}
 
 	if err := c.Preprocessor.Validate(); err != nil {
@@ -162,6 +182,15 @@ func (c *cfgType) Verify() error {
 		}
 	}
 
+	for k, v := range c.Poller {
+		if err := v.validate(k, len(c.MIB_Directory) > 0); err != nil {
+			return err
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("Poller %s preprocessor invalid: %v", k, err)
+		}
+	}
+
 	return nil
 }
 
@@ -178,6 +207,15 @@ func (c *cfgType) Tags() ([]string, error) {
 			tagMp[v.Tag_Name] = true
 		}
 	}
+	for _, v := range c.Poller {
+		if len(v.Tag_Name) == 0 {
+			continue
+		}
+		if _, ok := tagMp[v.Tag_Name]; !ok {
+			tags = append(tags, v.Tag_Name)
+			tagMp[v.Tag_Name] = true
+		}
+	}
 
 	if len(tags) == 0 {
 		return nil, errors.New("No tags specified")
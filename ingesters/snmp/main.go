@@ -43,19 +43,50 @@ type TrapRecord struct {
 	ContextEngineID string `json:",omitempty"`
 	ContextName     string `json:",omitempty"`
 	TrapOID         string `json:",omitempty"`
+	TrapName        string `json:",omitempty"`
 	Variables       []SnmpVariable
 }
 
 // SnmpVariable represents one OID->value mapping
-// from the trap. A trap may contain multiple
-// variables.
+// from the trap or poll. A trap may contain multiple
+// variables.  Name and ValueName are only populated
+// when the OID or value resolves through the loaded MIBs.
 type SnmpVariable struct {
 	OID        string
+	Name       string `json:",omitempty"`
 	Value      interface{}
+	ValueName  string `json:",omitempty"`
 	Type       gosnmp.Asn1BER
 	TypeString string
 }
 
+// newSnmpVariable converts a PDU, translating the OID and any enumerated or OID
+// values when MIBs are available
+func newSnmpVariable(pdu gosnmp.SnmpPDU, mibs *mibTree) (v SnmpVariable) {
+	v = SnmpVariable{
+		OID:        pdu.Name,
+		Value:      pdu.Value,
+		Type:       pdu.Type,
+		TypeString: pdu.Type.String(),
+	}
+	name, n, ok := mibs.Translate(pdu.Name)
+	if !ok {
+		return
+	}
+	v.Name = name
+	switch pdu.Type {
+	case gosnmp.Integer:
+		if val := gosnmp.ToBigInt(pdu.Value); val.IsInt64() {
+			v.ValueName, _ = n.Enum(val.Int64())
+		}
+	case gosnmp.ObjectIdentifier:
+		if oid, ok := pdu.Value.(string); ok {
+			v.ValueName, _, _ = mibs.Translate(oid)
+		}
+	}
+	return
+}
+
 func main() {
 	go debug.HandleDebugSignals(ingesterName)
 	var wg sync.WaitGroup
@@ -84,6 +115,15 @@ func main() {
 	defer igst.Close()
 	ib.AnnounceStartup()
 
+	var mibs *mibTree
+	if len(cfg.MIB_Directory) > 0 {
+		if mibs, err = LoadMIBs(cfg.MIB_Directory); err != nil {
+			ib.Logger.FatalCode(0, "failed to load MIBs", log.KVErr(err))
+			return
+		}
+		ib.Logger.Info("loaded MIBs", log.KV("modules", mibs.modules), log.KV("objects", len(mibs.nodes)))
+	}
+
 	exitCtx, exitFn := context.WithCancel(context.Background())
 
 	var traps []*gosnmp.TrapListener
@@ -154,14 +194,9 @@ func main() {
 			for i := range s.Variables {
 				if s.Variables[i].Name == ".1.3.6.1.6.3.1.1.4.1.0" {
 					r.TrapOID, _ = s.Variables[i].Value.(string)
+					r.TrapName, _, _ = mibs.Translate(r.TrapOID)
 				}
-				v := SnmpVariable{
-					OID:        s.Variables[i].Name,
-					Value:      s.Variables[i].Value,
-					Type:       s.Variables[i].Type,
-					TypeString: s.Variables[i].Type.String(),
-				}
-				r.Variables = append(r.Variables, v)
+				r.Variables = append(r.Variables, newSnmpVariable(s.Variables[i], mibs))
 			}
 			var err error
 			if ent.Data, err = json.Marshal(r); err != nil {
@@ -179,6 +214,10 @@ func main() {
 		}(lcfg.Bind_String)
 	}
 
+	if err = startPollers(exitCtx, &wg, cfg, igst, mibs, ib.Logger); err != nil {
+		ib.Logger.FatalCode(0, "failed to start pollers", log.KVErr(err))
+	}
+
 	ib.Debug("Running\n")
 
 	//listen for signals so we can close gracefully
@@ -0,0 +1,603 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxMIBFileSize = 16 * 1024 * 1024
+	maxTypeDepth   = 8
+)
+
+// macros whose value is an OID assignment, see RFC 2578 and RFC 2580
+var mibOIDMacros = map[string]bool{
+	`OBJECT-TYPE`:        true,
+	`MODULE-IDENTITY`:    true,
+	`OBJECT-IDENTITY`:    true,
+	`NOTIFICATION-TYPE`:  true,
+	`OBJECT-GROUP`:       true,
+	`NOTIFICATION-GROUP`: true,
+	`MODULE-COMPLIANCE`:  true,
+	`AGENT-CAPABILITIES`: true,
+}
+
+// mibNode is a resolved OID with its symbolic name and any enumerated integer values
+type mibNode struct {
+	name   string
+	module string
+	enums  map[int64]string
+}
+
+// mibTree translates numeric OIDs to symbolic names and back using MIB modules loaded from
+// disk.  The parser is deliberately forgiving, it only pulls out OID assignments and integer
+// enumerations and ignores everything else, so vendor MIBs with minor syntax errors still
+// contribute whatever names they define.
+type mibTree struct {
+	nodes   map[string]*mibNode // numeric OID without the leading dot to node
+	names   map[string]string   // name and MODULE::name to numeric OID
+	modules int
+}
+
+// mibDef is an unresolved OID assignment, the OID is parent followed by subids
+type mibDef struct {
+	name   string
+	module string
+	parent string
+	subids []string
+	syntax mibSyntax
+}
+
+type mibSyntax struct {
+	typ   string
+	enums map[int64]string
+}
+
+// mibParser accumulates definitions from any number of modules before they are resolved
+type mibParser struct {
+	defs    []mibDef
+	types   map[string]mibSyntax
+	modules map[string]bool
+}
+
+// LoadMIBs reads every MIB module found under the given directories
+func LoadMIBs(dirs []string) (t *mibTree, err error) {
+	p := newMIBParser()
+	for _, dir := range dirs {
+		err = filepath.WalkDir(dir, func(pth string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			} else if strings.HasPrefix(d.Name(), `.`) && pth != dir {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			} else if !d.Type().IsRegular() {
+				return nil
+			}
+			if fi, err := d.Info(); err != nil {
+				return err
+			} else if fi.Size() > maxMIBFileSize {
+				return nil
+			}
+			bts, err := os.ReadFile(pth)
+			if err != nil {
+				return err
+			}
+			p.parse(bts)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MIBs from %s: %w", dir, err)
+		}
+	}
+	t = p.resolve()
+	return
+}
+
+func newMIBParser() *mibParser {
+	return &mibParser{
+		types:   map[string]mibSyntax{},
+		modules: map[string]bool{},
+	}
+}
+
+// parse pulls definitions out of one file, which may hold several modules
+func (p *mibParser) parse(b []byte) {
+	toks := mibTokens(b)
+	var module string
+	for i := 0; i < len(toks); i++ {
+		next := func(n int) string {
+			if i+n < len(toks) {
+				return toks[i+n]
+			}
+			return ``
+		}
+		switch {
+		case next(1) == `DEFINITIONS`:
+			module = toks[i]
+			p.modules[module] = true
+			i = skipTo(toks, i, `BEGIN`)
+		case toks[i] == `IMPORTS` || toks[i] == `EXPORTS`:
+			i = skipTo(toks, i, `;`)
+		case next(1) == `MACRO`:
+			i = skipTo(toks, i, `END`)
+		case module == ``:
+			//nothing is valid outside of a module
+		case next(1) == `OBJECT` && next(2) == `IDENTIFIER` && next(3) == `::=`:
+			name := toks[i]
+			var d mibDef
+			d, i = p.oidValue(toks, i+4)
+			p.addDef(name, module, d)
+		case mibOIDMacros[next(1)]:
+			i = p.oidMacro(toks, i, module)
+		case next(1) == `TRAP-TYPE`:
+			i = p.trapType(toks, i, module)
+		case next(1) == `::=` && isTypeName(toks[i]):
+			i = p.typeAssignment(toks, i)
+		}
+	}
+}
+
+func (p *mibParser) addDef(name, module string, d mibDef) {
+	if d.parent == `` || !isValueName(name) {
+		return
+	}
+	d.name, d.module = name, module
+	p.defs = append(p.defs, d)
+}
+
+// oidMacro handles NAME MACRO-NAME clauses ::= { parent n }, grabbing the SYNTAX of
+// object types along the way
+func (p *mibParser) oidMacro(toks []string, i int, module string) int {
+	name, macro := toks[i], toks[i+1]
+	var syn mibSyntax
+	var depth int
+	for i += 2; i < len(toks); i++ {
+		switch toks[i] {
+		case `{`:
+			depth++
+		case `}`:
+			depth--
+		case `SYNTAX`:
+			if depth == 0 && macro == `OBJECT-TYPE` && syn.typ == `` {
+				syn, i = parseSyntax(toks, i+1)
+				i--
+			}
+		case `::=`:
+			if depth == 0 {
+				d, ni := p.oidValue(toks, i+1)
+				d.syntax = syn
+				p.addDef(name, module, d)
+				return ni
+			}
+		}
+	}
+	return i
+}
+
+// trapType handles SMIv1 traps, RFC 3584 maps them to enterprise.0.specific
+func (p *mibParser) trapType(toks []string, i int, module string) int {
+	name := toks[i]
+	var enterprise string
+	for i += 2; i < len(toks); i++ {
+		switch toks[i] {
+		case `ENTERPRISE`:
+			if i+1 < len(toks) {
+				enterprise = toks[i+1]
+			}
+		case `::=`:
+			if i+1 < len(toks) && enterprise != `` && isNumber(toks[i+1]) {
+				p.addDef(name, module, mibDef{parent: enterprise, subids: []string{`0`, toks[i+1]}})
+			}
+			return i + 1
+		}
+	}
+	return i
+}
+
+// typeAssignment records textual conventions and plain type assignments so that objects
+// using them pick up their enumerations
+func (p *mibParser) typeAssignment(toks []string, i int) int {
+	name := toks[i]
+	i += 2
+	if i < len(toks) && toks[i] == `TEXTUAL-CONVENTION` {
+		for ; i < len(toks); i++ {
+			if toks[i] == `SYNTAX` {
+				var syn mibSyntax
+				syn, i = parseSyntax(toks, i+1)
+				p.types[name] = syn
+				return i - 1
+			} else if toks[i] == `::=` {
+				break
+			}
+		}
+		return i
+	}
+	var syn mibSyntax
+	syn, i = parseSyntax(toks, i)
+	p.types[name] = syn
+	return i - 1
+}
+
+// oidValue parses { parent name(n) n ... }, named components define their own nodes.  The
+// returned index is that of the closing brace.
+func (p *mibParser) oidValue(toks []string, i int) (d mibDef, ni int) {
+	if i >= len(toks) || toks[i] != `{` {
+		return d, i
+	}
+	var comps []string
+	for i++; i < len(toks) && toks[i] != `}`; i++ {
+		if i+3 < len(toks) && toks[i+1] == `(` && isNumber(toks[i+2]) && toks[i+3] == `)` {
+			name, num := toks[i], toks[i+2]
+			i += 3
+			if len(comps) == 0 {
+				//a leading name(n) such as iso(1) is a reference to that node
+				comps = append(comps, name)
+				continue
+			}
+			p.defs = append(p.defs, mibDef{
+				name:   name,
+				parent: comps[0],
+				subids: append(append([]string{}, comps[1:]...), num),
+			})
+			comps = append(comps, num)
+			continue
+		}
+		comps = append(comps, toks[i])
+	}
+	if len(comps) > 0 {
+		//numeric parents such as { 1 3 6 1 } resolve to themselves
+		d.parent, d.subids = comps[0], comps[1:]
+	}
+	return d, i
+}
+
+var mibRoots = map[string]string{
+	`ccitt`:           `0`,
+	`iso`:             `1`,
+	`joint-iso-ccitt`: `2`,
+}
+
+// parseSyntax reads a type reference such as INTEGER { up(1), down(2) }, Counter32,
+// OCTET STRING (SIZE (0..255)), or DisplayString and returns the index past it
+func parseSyntax(toks []string, i int) (syn mibSyntax, ni int) {
+	for i < len(toks) && (toks[i] == `[` || toks[i] == `IMPLICIT`) {
+		if toks[i] == `[` {
+			i = skipTo(toks, i, `]`)
+		}
+		i++
+	}
+	if i >= len(toks) {
+		return syn, i
+	}
+	switch toks[i] {
+	case `SEQUENCE`:
+		if i+1 < len(toks) && toks[i+1] == `OF` {
+			return mibSyntax{typ: `SEQUENCE`}, i + 3
+		}
+		return mibSyntax{typ: `SEQUENCE`}, skipBalanced(toks, i+1)
+	case `OCTET`, `OBJECT`:
+		if i+1 < len(toks) {
+			syn.typ = toks[i] + ` ` + toks[i+1]
+		}
+		i += 2
+	default:
+		syn.typ = toks[i]
+		i++
+	}
+	if i < len(toks) && toks[i] == `{` {
+		end := skipBalanced(toks, i)
+		if syn.typ != `BITS` {
+			syn.enums = parseEnums(toks[i+1 : end-1])
+		}
+		i = end
+	}
+	if i < len(toks) && toks[i] == `(` {
+		i = skipBalanced(toks, i)
+	}
+	return syn, i
+}
+
+// parseEnums reads name(n), name(n), ...
+func parseEnums(toks []string) (r map[int64]string) {
+	for i := 0; i+3 < len(toks); i++ {
+		if toks[i+1] == `(` && toks[i+3] == `)` {
+			if v, err := strconv.ParseInt(toks[i+2], 10, 64); err == nil {
+				if r == nil {
+					r = map[int64]string{}
+				}
+				r[v] = toks[i]
+			}
+			i += 3
+		}
+	}
+	return
+}
+
+// skipBalanced returns the index after the bracketed group starting at i
+func skipBalanced(toks []string, i int) int {
+	if i >= len(toks) {
+		return i
+	}
+	open := toks[i]
+	var close string
+	switch open {
+	case `{`:
+		close = `}`
+	case `(`:
+		close = `)`
+	default:
+		return i
+	}
+	var depth int
+	for ; i < len(toks); i++ {
+		if toks[i] == open {
+			depth++
+		} else if toks[i] == close {
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+func skipTo(toks []string, i int, tok string) int {
+	for ; i < len(toks); i++ {
+		if toks[i] == tok {
+			return i
+		}
+	}
+	return i
+}
+
+// resolve turns the definitions into numeric OIDs, definitions whose parents never show up
+// are dropped
+func (p *mibParser) resolve() *mibTree {
+	t := &mibTree{
+		nodes:   map[string]*mibNode{},
+		names:   map[string]string{},
+		modules: len(p.modules),
+	}
+	byName := make(map[string]*mibDef, len(p.defs))
+	for i := range p.defs {
+		if _, ok := byName[p.defs[i].name]; !ok {
+			byName[p.defs[i].name] = &p.defs[i]
+		}
+	}
+	resolved := map[string]string{}
+	for k, v := range mibRoots {
+		resolved[k] = v
+	}
+	var oidOf func(name string, depth int) (string, bool)
+	oidOf = func(name string, depth int) (string, bool) {
+		if v, ok := resolved[name]; ok {
+			return v, true
+		} else if isNumber(name) {
+			return name, true
+		}
+		d, ok := byName[name]
+		if !ok || depth > 128 {
+			return ``, false
+		}
+		parent, ok := oidOf(d.parent, depth+1)
+		if !ok {
+			return ``, false
+		}
+		oid := parent
+		for _, s := range d.subids {
+			if !isNumber(s) {
+				return ``, false
+			}
+			oid += `.` + s
+		}
+		resolved[name] = oid
+		return oid, true
+	}
+	for k, v := range mibRoots {
+		t.nodes[v] = &mibNode{name: k}
+		t.names[k] = v
+	}
+	for _, d := range p.defs {
+		oid, ok := oidOf(d.name, 0)
+		if !ok {
+			continue
+		}
+		n, ok := t.nodes[oid]
+		if !ok {
+			n = &mibNode{name: d.name, module: d.module}
+			t.nodes[oid] = n
+		} else if n.module == `` && d.module != `` {
+			//prefer the full definition over a name(n) component
+			n.name, n.module = d.name, d.module
+		}
+		if n.enums == nil {
+			n.enums = p.enums(d.syntax, 0)
+		}
+		if _, ok := t.names[d.name]; !ok {
+			t.names[d.name] = oid
+		}
+		if d.module != `` {
+			t.names[d.module+`::`+d.name] = oid
+		}
+	}
+	return t
+}
+
+// enums follows textual conventions until it finds an enumeration
+func (p *mibParser) enums(syn mibSyntax, depth int) map[int64]string {
+	if syn.enums != nil {
+		return syn.enums
+	} else if depth > maxTypeDepth {
+		return nil
+	}
+	if tc, ok := p.types[syn.typ]; ok {
+		return p.enums(tc, depth+1)
+	}
+	return nil
+}
+
+// Translate returns the symbolic form of a numeric OID, e.g. .1.3.6.1.2.1.2.2.1.8.3
+// becomes ifOperStatus.3.  The node is returned for enumeration lookups.
+func (t *mibTree) Translate(oid string) (name string, n *mibNode, ok bool) {
+	if t == nil {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(oid, `.`), `.`)
+	for l := len(parts); l > 0; l-- {
+		if n, ok = t.nodes[strings.Join(parts[:l], `.`)]; ok {
+			name = n.name
+			if l < len(parts) {
+				name += `.` + strings.Join(parts[l:], `.`)
+			}
+			return
+		}
+	}
+	return
+}
+
+// Enum returns the label for an integer value of the node
+func (n *mibNode) Enum(v int64) (s string, ok bool) {
+	if n != nil && n.enums != nil {
+		s, ok = n.enums[v]
+	}
+	return
+}
+
+// Lookup resolves a numeric or symbolic OID, symbolic OIDs may be module qualified and
+// carry a numeric suffix, e.g. IF-MIB::ifDescr.1.  The result has a leading dot.
+func (t *mibTree) Lookup(s string) (oid string, err error) {
+	s = strings.TrimSpace(s)
+	if isNumericOID(s) {
+		return `.` + strings.TrimPrefix(s, `.`), nil
+	}
+	name, suffix := s, ``
+	if idx := strings.IndexByte(s, '.'); idx > 0 {
+		name, suffix = s[:idx], s[idx:]
+		if !isNumericOID(suffix) {
+			return ``, fmt.Errorf("invalid OID %q", s)
+		}
+	}
+	if t != nil {
+		if base, ok := t.names[name]; ok {
+			return `.` + base + suffix, nil
+		}
+	}
+	return ``, fmt.Errorf("unknown OID name %q", name)
+}
+
+// isNumericOID checks for OIDs in the form .1.3.6 or 1.3.6
+func isNumericOID(s string) bool {
+	s = strings.TrimPrefix(s, `.`)
+	if s == `` {
+		return false
+	}
+	for _, p := range strings.Split(s, `.`) {
+		if !isNumber(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(s string) bool {
+	if s == `` {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// value names start lower case, type names upper case
+func isValueName(s string) bool {
+	return s != `` && unicode.IsLower(rune(s[0]))
+}
+
+func isTypeName(s string) bool {
+	return s != `` && unicode.IsUpper(rune(s[0])) && isMIBIdent(s)
+}
+
+func isMIBIdent(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// mibTokens splits ASN.1 source into tokens, dropping comments.  Quoted strings collapse to
+// a single "" token since we never need their contents.
+func mibTokens(b []byte) (toks []string) {
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f':
+			i++
+		case c == '-' && i+1 < len(b) && b[i+1] == '-':
+			//comments run to the end of the line or the next --
+			for i += 2; i < len(b) && b[i] != '\n' && b[i] != '\r'; i++ {
+				if b[i] == '-' && i+1 < len(b) && b[i+1] == '-' {
+					i += 2
+					break
+				}
+			}
+		case c == '"':
+			for i++; i < len(b) && b[i] != '"'; i++ {
+			}
+			i++
+			toks = append(toks, `""`)
+		case c == '\'':
+			//hex and binary strings, 'ff'H
+			j := i + 1
+			for ; j < len(b) && b[j] != '\''; j++ {
+			}
+			if j++; j < len(b) && (b[j] == 'H' || b[j] == 'h' || b[j] == 'B' || b[j] == 'b') {
+				j++
+			}
+			toks = append(toks, string(b[i:min(j, len(b))]))
+			i = j
+		case c == ':' && i+2 < len(b) && b[i+1] == ':' && b[i+2] == '=':
+			toks = append(toks, `::=`)
+			i += 3
+		case c == '.' && i+1 < len(b) && b[i+1] == '.':
+			toks = append(toks, `..`)
+			i += 2
+		case isMIBIdentByte(c) || (c == '-' && i+1 < len(b) && b[i+1] >= '0' && b[i+1] <= '9'):
+			j := i + 1
+			for ; j < len(b) && isMIBIdentByte(b[j]); j++ {
+				if b[j] == '-' && j+1 < len(b) && b[j+1] == '-' {
+					break
+				}
+			}
+			toks = append(toks, string(b[i:j]))
+			i = j
+		default:
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return
+}
+
+func isMIBIdentByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
+}
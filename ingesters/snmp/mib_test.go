@@ -0,0 +1,309 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const testSMI = `
+TEST-SMI DEFINITIONS ::= BEGIN
+-- the bits of SNMPv2-SMI the test module needs
+org         OBJECT IDENTIFIER ::= { iso 3 }
+dod         OBJECT IDENTIFIER ::= { org 6 }
+internet    OBJECT IDENTIFIER ::= { dod 1 }
+mgmt        OBJECT IDENTIFIER ::= { internet 2 }
+mib-2       OBJECT IDENTIFIER ::= { mgmt 1 }
+enterprises OBJECT IDENTIFIER ::= { iso(1) org(3) dod(6) internet(1) private(4) 1 }
+END
+`
+
+const testMIB = `
+TEST-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+	MODULE-IDENTITY, OBJECT-TYPE, NOTIFICATION-TYPE, Integer32,
+	mib-2, enterprises
+		FROM TEST-SMI
+	TEXTUAL-CONVENTION, DisplayString
+		FROM SNMPv2-TC;
+
+testMIB MODULE-IDENTITY
+	LAST-UPDATED "202401010000Z"
+	ORGANIZATION "Gravwell"
+	CONTACT-INFO "-- not a comment { 1 }"
+	DESCRIPTION  "test module"
+	::= { enterprises 99999 }
+
+TestStatus ::= TEXTUAL-CONVENTION
+	STATUS      current
+	DESCRIPTION "interface state"
+	SYNTAX      INTEGER { up(1), down(2), testing(3) }
+
+interfaces OBJECT IDENTIFIER ::= { mib-2 2 }
+
+ifTable OBJECT-TYPE
+	SYNTAX      SEQUENCE OF IfEntry
+	MAX-ACCESS  not-accessible
+	STATUS      current
+	DESCRIPTION "interfaces"
+	::= { interfaces 2 }
+
+ifEntry OBJECT-TYPE
+	SYNTAX      IfEntry
+	MAX-ACCESS  not-accessible
+	STATUS      current
+	DESCRIPTION "an interface"
+	INDEX       { ifIndex }
+	::= { ifTable 1 }
+
+IfEntry ::= SEQUENCE {
+	ifIndex      Integer32,
+	ifDescr      DisplayString,
+	ifOperStatus TestStatus
+}
+
+ifIndex OBJECT-TYPE
+	SYNTAX      Integer32 (1..2147483647)
+	MAX-ACCESS  read-only
+	STATUS      current
+	DESCRIPTION "index"
+	::= { ifEntry 1 }
+
+ifDescr OBJECT-TYPE
+	SYNTAX      DisplayString (SIZE (0..255))
+	MAX-ACCESS  read-only
+	STATUS      current
+	DESCRIPTION "description"
+	::= { ifEntry 2 }
+
+ifAdminStatus OBJECT-TYPE
+	SYNTAX      INTEGER { up(1), down(2) }
+	MAX-ACCESS  read-write
+	STATUS      current
+	DESCRIPTION "desired state"
+	::= { ifEntry 7 }
+
+ifOperStatus OBJECT-TYPE
+	SYNTAX      TestStatus
+	MAX-ACCESS  read-only
+	STATUS      current
+	DESCRIPTION "current state" -- trailing comment
+	::= { ifEntry 8 }
+
+testProducts OBJECT IDENTIFIER ::= { testMIB 1 }
+testRouter   OBJECT IDENTIFIER ::= { testProducts 7 }
+
+testLinkDown NOTIFICATION-TYPE
+	OBJECTS     { ifIndex, ifOperStatus }
+	STATUS      current
+	DESCRIPTION "link down"
+	::= { testMIB 0 1 }
+
+testColdStart TRAP-TYPE
+	ENTERPRISE  testProducts
+	VARIABLES   { ifIndex }
+	DESCRIPTION "cold start"
+	::= 4
+
+orphan OBJECT IDENTIFIER ::= { nowhere 1 }
+
+END
+`
+
+const testHiddenMIB = `
+HIDDEN-MIB DEFINITIONS ::= BEGIN
+hiddenObj OBJECT IDENTIFIER ::= { iso 9 }
+END
+`
+
+func loadTestMIBs(t *testing.T) *mibTree {
+	dir := t.TempDir()
+	files := map[string]string{
+		`TEST-SMI.txt`:       testSMI,
+		`sub/TEST-MIB.mib`:   testMIB,
+		`.hidden.mib`:        testHiddenMIB,
+		`.git/HIDDEN-MIB`:    testHiddenMIB,
+		`garbage.bin`:        "\x00\xff{{ ::= }\"",
+		`sub/not-a-module.c`: `int x ::= { iso 7 };`,
+	}
+	for name, body := range files {
+		pth := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+			t.Fatal(err)
+		} else if err = os.WriteFile(pth, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mibs, err := LoadMIBs([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mibs
+}
+
+func TestLoadMIBs(t *testing.T) {
+	mibs := loadTestMIBs(t)
+	if mibs.modules != 2 {
+		t.Fatalf("bad module count %d", mibs.modules)
+	}
+	if _, err := LoadMIBs([]string{filepath.Join(t.TempDir(), `missing`)}); err == nil {
+		t.Fatal("loaded a missing directory")
+	}
+}
+
+func TestMIBLookup(t *testing.T) {
+	mibs := loadTestMIBs(t)
+	tests := map[string]string{
+		`mib-2`:                 `.1.3.6.1.2.1`,
+		`private`:               `.1.3.6.1.4`,
+		`enterprises`:           `.1.3.6.1.4.1`,
+		`ifDescr`:               `.1.3.6.1.2.1.2.2.1.2`,
+		` ifOperStatus `:        `.1.3.6.1.2.1.2.2.1.8`,
+		`TEST-MIB::ifDescr.7`:   `.1.3.6.1.2.1.2.2.1.2.7`,
+		`TEST-SMI::enterprises`: `.1.3.6.1.4.1`,
+		`testRouter.1.2`:        `.1.3.6.1.4.1.99999.1.7.1.2`,
+		`testLinkDown`:          `.1.3.6.1.4.1.99999.0.1`,
+		`testColdStart`:         `.1.3.6.1.4.1.99999.1.0.4`,
+		`1.3.6`:                 `.1.3.6`,
+		`.1.3.6`:                `.1.3.6`,
+	}
+	for in, want := range tests {
+		if got, err := mibs.Lookup(in); err != nil {
+			t.Fatalf("%q: %v", in, err)
+		} else if got != want {
+			t.Fatalf("%q: %s != %s", in, got, want)
+		}
+	}
+
+	for _, bad := range []string{
+		``,
+		`bogus`,
+		`orphan`,
+		`hiddenObj`,
+		`TEST-SMI::ifDescr`,
+		`ifDescr.x`,
+		`ifDescr.1..2`,
+	} {
+		if oid, err := mibs.Lookup(bad); err == nil {
+			t.Fatalf("%q resolved to %s", bad, oid)
+		}
+	}
+
+	var empty *mibTree
+	if oid, err := empty.Lookup(`1.3.6.1`); err != nil || oid != `.1.3.6.1` {
+		t.Fatalf("numeric lookup without MIBs failed: %s %v", oid, err)
+	} else if _, err = empty.Lookup(`ifDescr`); err == nil {
+		t.Fatal("symbolic lookup without MIBs succeeded")
+	}
+}
+
+func TestMIBTranslate(t *testing.T) {
+	mibs := loadTestMIBs(t)
+	tests := map[string]string{
+		`.1.3.6.1.2.1.2.2.1.7`:       `ifAdminStatus`,
+		`1.3.6.1.2.1.2.2.1.8.3`:      `ifOperStatus.3`,
+		`.1.3.6.1.2.1.2.2.1.80`:      `ifEntry.80`, //prefixes match whole subids
+		`.1.3.6.1.4.1.99999.1.7.5.2`: `testRouter.5.2`,
+		`.1.3.6.1.4.1.99999.1.8`:     `testProducts.8`,
+		`.1.3.6.1.4.1.12345.1`:       `enterprises.12345.1`,
+		`.1.3.6.1.4.1.99999.1.0.4`:   `testColdStart`,
+		`.1.3.6.1.4.1.99999.0.1`:     `testLinkDown`,
+		`.2.5`:                       `joint-iso-ccitt.5`,
+		`.1.9`:                       `iso.9`,
+	}
+	for in, want := range tests {
+		if got, _, ok := mibs.Translate(in); !ok || got != want {
+			t.Fatalf("%s: %q %v != %s", in, got, ok, want)
+		}
+	}
+	for _, bad := range []string{``, `.`, `.7.1`, `foo`} {
+		if got, _, ok := mibs.Translate(bad); ok {
+			t.Fatalf("%q translated to %s", bad, got)
+		}
+	}
+	var empty *mibTree
+	if _, _, ok := empty.Translate(`.1.3.6`); ok {
+		t.Fatal("translated without MIBs")
+	}
+}
+
+func TestMIBEnum(t *testing.T) {
+	mibs := loadTestMIBs(t)
+	tests := []struct {
+		oid  string
+		v    int64
+		want string
+	}{
+		{`.1.3.6.1.2.1.2.2.1.7.1`, 2, `down`},    //inline enumeration
+		{`.1.3.6.1.2.1.2.2.1.8.1`, 3, `testing`}, //through a textual convention
+	}
+	for _, tt := range tests {
+		_, n, ok := mibs.Translate(tt.oid)
+		if !ok {
+			t.Fatalf("%s did not translate", tt.oid)
+		} else if s, ok := n.Enum(tt.v); !ok || s != tt.want {
+			t.Fatalf("%s: enum %d %q != %s", tt.oid, tt.v, s, tt.want)
+		}
+	}
+	if _, n, _ := mibs.Translate(`.1.3.6.1.2.1.2.2.1.7`); n == nil {
+		t.Fatal("missing node")
+	} else if s, ok := n.Enum(9); ok {
+		t.Fatalf("unknown value got label %s", s)
+	}
+	if _, n, _ := mibs.Translate(`.1.3.6.1.2.1.2.2.1.2`); n == nil {
+		t.Fatal("missing node")
+	} else if s, ok := n.Enum(1); ok {
+		t.Fatalf("DisplayString got label %s", s)
+	}
+	var n *mibNode
+	if _, ok := n.Enum(1); ok {
+		t.Fatal("nil node returned a label")
+	}
+}
+
+func TestIsNumericOID(t *testing.T) {
+	tests := map[string]bool{
+		`1.3.6.1`:   true,
+		`.1.3.6.1`:  true,
+		`1`:         true,
+		``:          false,
+		`.`:         false,
+		`1..3`:      false,
+		`1.3.`:      false,
+		`1.3.x`:     false,
+		`-1.3`:      false,
+		`ifDescr.1`: false,
+	}
+	for in, want := range tests {
+		if got := isNumericOID(in); got != want {
+			t.Fatalf("%q: %v != %v", in, got, want)
+		}
+	}
+}
+
+func TestNewSnmpVariable(t *testing.T) {
+	mibs := loadTestMIBs(t)
+	v := newSnmpVariable(gosnmp.SnmpPDU{Name: `.1.3.6.1.2.1.2.2.1.8.4`, Type: gosnmp.Integer, Value: 2}, mibs)
+	if v.Name != `ifOperStatus.4` || v.ValueName != `down` || v.OID != `.1.3.6.1.2.1.2.2.1.8.4` {
+		t.Fatalf("bad integer variable %+v", v)
+	}
+	v = newSnmpVariable(gosnmp.SnmpPDU{Name: `.1.3.6.1.2.1.1.2.0`, Type: gosnmp.ObjectIdentifier, Value: `.1.3.6.1.4.1.99999.1.7`}, mibs)
+	if v.Name != `mib-2.1.2.0` || v.ValueName != `testRouter` {
+		t.Fatalf("bad OID variable %+v", v)
+	}
+	v = newSnmpVariable(gosnmp.SnmpPDU{Name: `.1.3.6.1.2.1.2.2.1.8.4`, Type: gosnmp.Integer, Value: 2}, nil)
+	if v.Name != `` || v.ValueName != `` || v.TypeString != gosnmp.Integer.String() {
+		t.Fatalf("translated without MIBs %+v", v)
+	}
+}
@@ -0,0 +1,385 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	defaultPollPort     = 161
+	defaultPollInterval = time.Minute
+	defaultPollTimeout  = 5 * time.Second
+	defaultPollRetries  = 2
+)
+
+// poller periodically GETs and WALKs a set of OIDs on every target, OIDs may be numeric or
+// symbolic names resolved through the MIBs in MIB-Directory
+type poller struct {
+	v3auth
+	Tag_Name        string
+	Target          []string // devices to poll, host or host:port
+	Version         string   // SNMP version: 1, 2c, 3
+	Community       string   // for SNMP v1 and v2
+	Interval        string   // time between polls, defaults to 1m
+	Timeout         string   // per request timeout, defaults to 5s
+	Retries         int
+	Max_Repetitions int      // GETBULK max-repetitions used for walks with v2c and v3
+	Get             []string // OIDs to GET each interval
+	Walk            []string // subtrees to WALK each interval
+	Source_Override string
+	Preprocessor    []string
+}
+
+// PollRecord gets built up from the results of polling a single device,
+// then encoded to JSON for ingest.
+type PollRecord struct {
+	Poller    string
+	Device    string
+	Variables []SnmpVariable
+}
+
+func (p *poller) validate(name string, mibs bool) (err error) {
+	if len(p.Tag_Name) == 0 {
+		p.Tag_Name = entry.DefaultTagName
+	}
+	if ingest.CheckTag(p.Tag_Name) != nil {
+		return errors.New("Invalid characters in the Tag-Name for " + name)
+	}
+	if p.Source_Override != `` {
+		if net.ParseIP(p.Source_Override) == nil {
+			return fmt.Errorf("Source-Override %s is not a valid IP address", p.Source_Override)
+		}
+	}
+	switch p.Version {
+	case "1":
+	case "2c":
+	case "3":
+	default:
+		return fmt.Errorf("Poller %v Invalid SNMP version %v, supported versions: 1, 2c, 3", name, p.Version)
+	}
+	if err = p.v3auth.validate(); err != nil {
+		return fmt.Errorf("Poller %s SNMP v3 security config is invalid: %v", name, err)
+	}
+	if len(p.Target) == 0 {
+		return fmt.Errorf("Poller %s has no Target", name)
+	}
+	for _, t := range p.Target {
+		if _, _, err = splitTarget(t); err != nil {
+			return fmt.Errorf("Poller %s has an invalid Target %q: %v", name, t, err)
+		}
+	}
+	if len(p.Get) == 0 && len(p.Walk) == 0 {
+		return fmt.Errorf("Poller %s has no Get or Walk OIDs", name)
+	}
+	for _, oid := range append(append([]string{}, p.Get...), p.Walk...) {
+		if !isNumericOID(oid) && !mibs {
+			return fmt.Errorf("Poller %s OID %q is not numeric, symbolic OIDs require a MIB-Directory", name, oid)
+		}
+	}
+	if _, err = p.interval(); err != nil {
+		return fmt.Errorf("Poller %s has an invalid Interval: %v", name, err)
+	} else if _, err = p.timeout(); err != nil {
+		return fmt.Errorf("Poller %s has an invalid Timeout: %v", name, err)
+	} else if p.Retries < 0 {
+		return fmt.Errorf("Poller %s Retries cannot be negative", name)
+	} else if p.Max_Repetitions < 0 {
+		return fmt.Errorf("Poller %s Max-Repetitions cannot be negative", name)
+	}
+	return nil
+}
+
+func (p *poller) interval() (time.Duration, error) {
+	return parsePositiveDuration(p.Interval, defaultPollInterval)
+}
+
+func (p *poller) timeout() (time.Duration, error) {
+	return parsePositiveDuration(p.Timeout, defaultPollTimeout)
+}
+
+func parsePositiveDuration(s string, def time.Duration) (d time.Duration, err error) {
+	if s = strings.TrimSpace(s); s == `` {
+		return def, nil
+	} else if d, err = time.ParseDuration(s); err == nil && d <= 0 {
+		err = errors.New("must be greater than zero")
+	}
+	return
+}
+
+func (p *poller) getSnmpVersion() gosnmp.SnmpVersion {
+	switch p.Version {
+	case "1":
+		return gosnmp.Version1
+	case "3":
+		return gosnmp.Version3
+	}
+	return gosnmp.Version2c
+}
+
+// splitTarget splits host or host:port, the port defaults to 161
+func splitTarget(t string) (host string, port uint16, err error) {
+	host, port = strings.TrimSpace(t), defaultPollPort
+	if h, ps, lerr := net.SplitHostPort(host); lerr == nil {
+		var v uint64
+		if v, err = strconv.ParseUint(ps, 10, 16); err != nil || v == 0 {
+			err = fmt.Errorf("invalid port %q", ps)
+			return
+		}
+		host, port = h, uint16(v)
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, `[`), `]`) //bare IPv6 address
+	}
+	if host == `` {
+		err = errors.New("missing host")
+	}
+	return
+}
+
+// client builds a GoSNMP handle for one target
+func (p *poller) client(target string, ctx context.Context) (x *gosnmp.GoSNMP, err error) {
+	var host string
+	var port uint16
+	var to time.Duration
+	if host, port, err = splitTarget(target); err != nil {
+		return
+	} else if to, err = p.timeout(); err != nil {
+		return
+	}
+	x = &gosnmp.GoSNMP{
+		Context:            ctx,
+		Target:             host,
+		Port:               port,
+		Transport:          "udp",
+		Version:            p.getSnmpVersion(),
+		Community:          p.Community,
+		Timeout:            to,
+		Retries:            p.Retries,
+		ExponentialTimeout: true,
+		MaxOids:            gosnmp.MaxOids,
+		MaxRepetitions:     uint32(p.Max_Repetitions),
+	}
+	if p.Retries == 0 {
+		x.Retries = defaultPollRetries
+	}
+	if x.Version == gosnmp.Version3 {
+		x.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 p.Username,
+			AuthenticationProtocol:   p.getAuthProto(),
+			AuthenticationPassphrase: p.Auth_Passphrase,
+			PrivacyProtocol:          p.getPrivacyProto(),
+			PrivacyPassphrase:        p.Privacy_Passphrase,
+		}
+		x.MsgFlags = p.getMsgFlags()
+		x.SecurityModel = gosnmp.UserSecurityModel
+	}
+	return
+}
+
+// resolveOIDs translates configured OIDs to numeric form
+func resolveOIDs(oids []string, mibs *mibTree) (r []string, err error) {
+	for _, s := range oids {
+		var oid string
+		if oid, err = mibs.Lookup(s); err != nil {
+			return
+		}
+		r = append(r, oid)
+	}
+	return
+}
+
+// snmpClient is the part of gosnmp.GoSNMP used while polling
+type snmpClient interface {
+	Connect() error
+	Close() error
+	RemoteAddr() net.Addr
+	Get(oids []string) (*gosnmp.SnmpPacket, error)
+	WalkAll(root string) ([]gosnmp.SnmpPDU, error)
+	BulkWalkAll(root string) ([]gosnmp.SnmpPDU, error)
+}
+
+// goSNMPClient adapts a GoSNMP handle to snmpClient
+type goSNMPClient struct {
+	*gosnmp.GoSNMP
+}
+
+func (c goSNMPClient) Close() error {
+	return c.Conn.Close()
+}
+
+func (c goSNMPClient) RemoteAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// pollTarget polls a single device on behalf of a poller
+type pollTarget struct {
+	poller   string
+	device   string
+	tag      entry.EntryTag
+	src      net.IP
+	proc     *processors.ProcessorSet
+	x        snmpClient
+	bulk     bool // walk with GETBULK, not available in v1
+	get      []string
+	walk     []string
+	interval time.Duration
+	mibs     *mibTree
+	lg       *log.Logger
+}
+
+func (pt *pollTarget) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if err := pt.x.Connect(); err != nil {
+		pt.lg.Error("failed to connect to SNMP device", log.KV("poller", pt.poller), log.KV("device", pt.device), log.KVErr(err))
+		return
+	}
+	defer pt.x.Close()
+	if pt.src == nil {
+		if ua, ok := pt.x.RemoteAddr().(*net.UDPAddr); ok {
+			pt.src = ua.IP
+		}
+	}
+	tkr := time.NewTicker(pt.interval)
+	defer tkr.Stop()
+	for {
+		pt.pollOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tkr.C:
+		}
+	}
+}
+
+func (pt *pollTarget) pollOnce(ctx context.Context) {
+	ts := entry.Now()
+	r := pt.poll(ctx)
+	if len(r.Variables) == 0 {
+		return
+	}
+	ent := entry.Entry{
+		TS:  ts,
+		SRC: pt.src,
+		Tag: pt.tag,
+	}
+	var err error
+	if ent.Data, err = json.Marshal(r); err != nil {
+		pt.lg.Error("failed to encode poll results", log.KV("poller", pt.poller), log.KV("device", pt.device), log.KVErr(err))
+		return
+	}
+	if err = pt.proc.ProcessContext(&ent, ctx); err != nil && ctx.Err() == nil {
+		pt.lg.Error("failed to send poll results", log.KV("poller", pt.poller), log.KV("device", pt.device), log.KVErr(err))
+	}
+}
+
+// poll runs every GET and WALK, failures are logged and whatever did succeed is returned
+func (pt *pollTarget) poll(ctx context.Context) (r PollRecord) {
+	r = PollRecord{
+		Poller: pt.poller,
+		Device: pt.device,
+	}
+	for i := 0; i < len(pt.get) && ctx.Err() == nil; i += gosnmp.MaxOids {
+		chunk := pt.get[i:min(i+gosnmp.MaxOids, len(pt.get))]
+		pkt, err := pt.x.Get(chunk)
+		if err != nil {
+			pt.lg.Warn("SNMP get failed", log.KV("poller", pt.poller), log.KV("device", pt.device), log.KVErr(err))
+			continue
+		} else if pkt.Error != gosnmp.NoError {
+			pt.lg.Warn("SNMP get returned an error", log.KV("poller", pt.poller), log.KV("device", pt.device),
+				log.KV("error", pkt.Error.String()), log.KV("index", pkt.ErrorIndex))
+			continue
+		}
+		r.Variables = pt.appendVariables(r.Variables, pkt.Variables)
+	}
+	for _, root := range pt.walk {
+		if ctx.Err() != nil {
+			break
+		}
+		var pdus []gosnmp.SnmpPDU
+		var err error
+		if pt.bulk {
+			pdus, err = pt.x.BulkWalkAll(root)
+		} else {
+			pdus, err = pt.x.WalkAll(root)
+		}
+		if err != nil {
+			pt.lg.Warn("SNMP walk failed", log.KV("poller", pt.poller), log.KV("device", pt.device), log.KV("oid", root), log.KVErr(err))
+			continue
+		}
+		r.Variables = pt.appendVariables(r.Variables, pdus)
+	}
+	return
+}
+
+func (pt *pollTarget) appendVariables(vars []SnmpVariable, pdus []gosnmp.SnmpPDU) []SnmpVariable {
+	for _, pdu := range pdus {
+		switch pdu.Type {
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+			continue
+		}
+		vars = append(vars, newSnmpVariable(pdu, pt.mibs))
+	}
+	return vars
+}
+
+// startPollers fires up a routine per poller target, they exit when ctx is cancelled
+func startPollers(ctx context.Context, wg *sync.WaitGroup, cfg *cfgType, igst *ingest.IngestMuxer, mibs *mibTree, lg *log.Logger) (err error) {
+	for name, pcfg := range cfg.Poller {
+		var tag entry.EntryTag
+		var proc *processors.ProcessorSet
+		var get, walk []string
+		var interval time.Duration
+		if tag, err = igst.GetTag(pcfg.Tag_Name); err != nil {
+			return fmt.Errorf("failed to get established tag %s for poller %s: %w", pcfg.Tag_Name, name, err)
+		} else if proc, err = cfg.Preprocessor.ProcessorSet(igst, pcfg.Preprocessor); err != nil {
+			return fmt.Errorf("poller %s preprocessor failure: %w", name, err)
+		} else if get, err = resolveOIDs(pcfg.Get, mibs); err != nil {
+			return fmt.Errorf("poller %s: %w", name, err)
+		} else if walk, err = resolveOIDs(pcfg.Walk, mibs); err != nil {
+			return fmt.Errorf("poller %s: %w", name, err)
+		} else if interval, err = pcfg.interval(); err != nil {
+			return fmt.Errorf("poller %s: %w", name, err)
+		}
+		for _, target := range pcfg.Target {
+			pt := &pollTarget{
+				poller:   name,
+				device:   target,
+				tag:      tag,
+				src:      net.ParseIP(pcfg.Source_Override),
+				proc:     proc,
+				get:      get,
+				walk:     walk,
+				interval: interval,
+				mibs:     mibs,
+				lg:       lg,
+			}
+			var x *gosnmp.GoSNMP
+			if x, err = pcfg.client(target, ctx); err != nil {
+				return fmt.Errorf("poller %s target %s: %w", name, target, err)
+			}
+			pt.x, pt.bulk = goSNMPClient{x}, x.Version != gosnmp.Version1
+			wg.Add(1)
+			go pt.run(ctx, wg)
+		}
+	}
+	return
+}
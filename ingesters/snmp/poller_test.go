@@ -0,0 +1,437 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+var errTestTimeout = errors.New("request timeout (after 2 retries)")
+
+// fakeClient stands in for a device, every GET answers with the OID index as the value
+type fakeClient struct {
+	sync.Mutex
+	connectErr error
+	get        func(oids []string) (*gosnmp.SnmpPacket, error)
+	walks      map[string][]gosnmp.SnmpPDU
+	walkErrs   map[string]error
+	gets       int
+	walked     []string
+	bulkWalked []string
+	closed     bool
+}
+
+func (fc *fakeClient) Connect() error {
+	return fc.connectErr
+}
+
+func (fc *fakeClient) Close() error {
+	fc.Lock()
+	fc.closed = true
+	fc.Unlock()
+	return nil
+}
+
+func (fc *fakeClient) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 161}
+}
+
+func (fc *fakeClient) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+	fc.Lock()
+	fc.gets++
+	fc.Unlock()
+	if fc.get != nil {
+		return fc.get(oids)
+	}
+	return answer(oids), nil
+}
+
+func (fc *fakeClient) WalkAll(root string) ([]gosnmp.SnmpPDU, error) {
+	fc.Lock()
+	fc.walked = append(fc.walked, root)
+	fc.Unlock()
+	return fc.walks[root], fc.walkErrs[root]
+}
+
+func (fc *fakeClient) BulkWalkAll(root string) ([]gosnmp.SnmpPDU, error) {
+	fc.Lock()
+	fc.bulkWalked = append(fc.bulkWalked, root)
+	fc.Unlock()
+	return fc.walks[root], fc.walkErrs[root]
+}
+
+func (fc *fakeClient) getCount() int {
+	fc.Lock()
+	defer fc.Unlock()
+	return fc.gets
+}
+
+func answer(oids []string) *gosnmp.SnmpPacket {
+	pkt := &gosnmp.SnmpPacket{}
+	for i, oid := range oids {
+		pkt.Variables = append(pkt.Variables, gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Integer, Value: i})
+	}
+	return pkt
+}
+
+// pollWriter collects what the pollers send
+type pollWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+}
+
+func (pw *pollWriter) WriteEntry(ent *entry.Entry) error {
+	pw.Lock()
+	pw.ents = append(pw.ents, ent)
+	pw.Unlock()
+	return nil
+}
+
+func (pw *pollWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return pw.WriteEntry(ent)
+}
+
+func (pw *pollWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		pw.WriteEntry(ent)
+	}
+	return nil
+}
+
+func (pw *pollWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return pw.WriteBatch(ents)
+}
+
+func (pw *pollWriter) count() int {
+	pw.Lock()
+	defer pw.Unlock()
+	return len(pw.ents)
+}
+
+func (pw *pollWriter) records(t *testing.T) (r []PollRecord) {
+	pw.Lock()
+	defer pw.Unlock()
+	for _, ent := range pw.ents {
+		var pr PollRecord
+		if err := json.Unmarshal(ent.Data, &pr); err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, pr)
+	}
+	return
+}
+
+func newTestPollTarget(fc *fakeClient, interval time.Duration) (*pollTarget, *pollWriter) {
+	pw := &pollWriter{}
+	return &pollTarget{
+		poller:   `test`,
+		device:   `router:161`,
+		tag:      7,
+		proc:     processors.NewProcessorSet(pw),
+		x:        fc,
+		bulk:     true,
+		get:      []string{`.1.3.6.1.2.1.1.3.0`},
+		interval: interval,
+		lg:       log.NewDiscardLogger(),
+	}, pw
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	for end := time.Now().Add(5 * time.Second); !f(); time.Sleep(time.Millisecond) {
+		if time.Now().After(end) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// runTarget starts pt and returns a function that stops it and waits for it to exit
+func runTarget(t *testing.T, pt *pollTarget) (stop func()) {
+	ctx, cf := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go pt.run(ctx, &wg)
+	return func() {
+		cf()
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("poller did not exit")
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	var get []string
+	for i := 0; i < gosnmp.MaxOids+5; i++ {
+		get = append(get, fmt.Sprintf(".1.3.6.1.4.1.99999.%d", i))
+	}
+	fc := &fakeClient{
+		walks: map[string][]gosnmp.SnmpPDU{
+			`.1.3.6.1.2.1.2.2.1.2`: {
+				{Name: `.1.3.6.1.2.1.2.2.1.2.1`, Type: gosnmp.OctetString, Value: []byte(`eth0`)},
+				{Name: `.1.3.6.1.2.1.2.2.1.2.2`, Type: gosnmp.NoSuchInstance},
+				{Name: `.1.3.6.1.2.1.2.2.1.2.3`, Type: gosnmp.EndOfMibView},
+			},
+		},
+		walkErrs: map[string]error{`.1.3.6.1.2.1.31`: errTestTimeout},
+	}
+	pt, _ := newTestPollTarget(fc, time.Minute)
+	pt.get = get
+	pt.walk = []string{`.1.3.6.1.2.1.31`, `.1.3.6.1.2.1.2.2.1.2`}
+
+	//GETs go out in chunks the agent will accept, empty results are dropped and a failed walk
+	//does not stop the next one
+	r := pt.poll(context.Background())
+	if fc.gets != 2 {
+		t.Fatalf("bad GET count %d", fc.gets)
+	} else if len(fc.walked) != 0 || len(fc.bulkWalked) != 2 {
+		t.Fatalf("bad walks %v %v", fc.walked, fc.bulkWalked)
+	} else if r.Poller != `test` || r.Device != `router:161` {
+		t.Fatalf("bad record %+v", r)
+	} else if len(r.Variables) != len(get)+1 {
+		t.Fatalf("bad variable count %d", len(r.Variables))
+	} else if r.Variables[len(get)].OID != `.1.3.6.1.2.1.2.2.1.2.1` {
+		t.Fatalf("bad walk variable %+v", r.Variables[len(get)])
+	}
+
+	//v1 walks without GETBULK
+	fc.bulkWalked = nil
+	pt.bulk = false
+	pt.poll(context.Background())
+	if len(fc.walked) != 2 || len(fc.bulkWalked) != 0 {
+		t.Fatalf("bad v1 walks %v %v", fc.walked, fc.bulkWalked)
+	}
+}
+
+func TestPollErrors(t *testing.T) {
+	fc := &fakeClient{}
+	pt, _ := newTestPollTarget(fc, time.Minute)
+	pt.get = []string{`.1.3.6.1.2.1.1.3.0`, `.1.3.6.1.2.1.1.5.0`}
+
+	//a timed out request and an error status both produce nothing
+	fc.get = func(oids []string) (*gosnmp.SnmpPacket, error) {
+		return nil, errTestTimeout
+	}
+	if r := pt.poll(context.Background()); len(r.Variables) != 0 {
+		t.Fatalf("timed out GET produced %+v", r.Variables)
+	}
+	fc.get = func(oids []string) (*gosnmp.SnmpPacket, error) {
+		pkt := answer(oids)
+		pkt.Error, pkt.ErrorIndex = gosnmp.NoSuchName, 2
+		return pkt, nil
+	}
+	if r := pt.poll(context.Background()); len(r.Variables) != 0 {
+		t.Fatalf("failed GET produced %+v", r.Variables)
+	}
+
+	//nothing goes out once the context is done
+	ctx, cf := context.WithCancel(context.Background())
+	cf()
+	fc.gets = 0
+	pt.walk = []string{`.1.3.6.1.2.1.2`}
+	pt.poll(ctx)
+	if fc.gets != 0 || len(fc.bulkWalked) != 0 {
+		t.Fatalf("polled after cancel %d %v", fc.gets, fc.bulkWalked)
+	}
+}
+
+func TestPollTargetSchedule(t *testing.T) {
+	fc := &fakeClient{}
+	pt, pw := newTestPollTarget(fc, 10*time.Millisecond)
+	stop := runTarget(t, pt)
+	waitFor(t, "polls", func() bool { return pw.count() >= 3 })
+	stop()
+
+	fc.Lock()
+	closed := fc.closed
+	fc.Unlock()
+	if !closed {
+		t.Fatal("connection was not closed")
+	}
+	pw.Lock()
+	for i, ent := range pw.ents {
+		if ent.Tag != 7 || !ent.SRC.Equal(net.IPv4(192, 168, 1, 1)) {
+			t.Fatalf("entry %d bad tag or source %d %v", i, ent.Tag, ent.SRC)
+		} else if i > 0 && ent.TS.Before(pw.ents[i-1].TS) {
+			t.Fatalf("entry %d timestamp went backwards", i)
+		}
+	}
+	pw.Unlock()
+	for i, r := range pw.records(t) {
+		if r.Poller != `test` || len(r.Variables) != 1 || r.Variables[0].OID != `.1.3.6.1.2.1.1.3.0` {
+			t.Fatalf("record %d bad %+v", i, r)
+		}
+	}
+
+	//Source-Override wins over the device address
+	fc = &fakeClient{}
+	pt, pw = newTestPollTarget(fc, time.Minute)
+	pt.src = net.ParseIP(`10.0.0.1`)
+	stop = runTarget(t, pt)
+	waitFor(t, "the first poll", func() bool { return pw.count() == 1 })
+	stop()
+	if src := pw.ents[0].SRC; !src.Equal(pt.src) {
+		t.Fatalf("bad source %v", src)
+	}
+}
+
+func TestPollTargetTimeout(t *testing.T) {
+	//the device stops answering for a couple of intervals, the poller keeps its schedule
+	//and picks back up when it returns
+	var calls int
+	fc := &fakeClient{}
+	fc.get = func(oids []string) (*gosnmp.SnmpPacket, error) {
+		if calls++; calls <= 2 {
+			time.Sleep(5 * time.Millisecond)
+			return nil, errTestTimeout
+		}
+		return answer(oids), nil
+	}
+	pt, pw := newTestPollTarget(fc, 10*time.Millisecond)
+	stop := runTarget(t, pt)
+	waitFor(t, "a poll after the timeouts", func() bool { return pw.count() >= 1 })
+	stop()
+	if n := fc.getCount(); n < 3 {
+		t.Fatalf("only %d GETs", n)
+	} else if n-pw.count() != 2 {
+		t.Fatalf("timed out polls were sent: %d GETs %d entries", n, pw.count())
+	}
+
+	//a request stuck waiting on the device is abandoned on shutdown without sending
+	//anything, gosnmp gives up when its context is cancelled
+	ctx, cf := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	fc = &fakeClient{
+		get: func(oids []string) (*gosnmp.SnmpPacket, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	pt, pw = newTestPollTarget(fc, time.Minute)
+	pt.walk = []string{`.1.3.6.1.2.1.2`}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go pt.run(ctx, &wg)
+	<-started
+	cf()
+	wg.Wait()
+	if pw.count() != 0 || len(fc.bulkWalked) != 0 {
+		t.Fatalf("polling continued after cancel %d %v", pw.count(), fc.bulkWalked)
+	}
+}
+
+func TestPollTargetConnectFail(t *testing.T) {
+	fc := &fakeClient{connectErr: errors.New("no route to host")}
+	pt, pw := newTestPollTarget(fc, time.Millisecond)
+	stop := runTarget(t, pt)
+	stop()
+	if fc.getCount() != 0 || pw.count() != 0 || fc.closed {
+		t.Fatalf("polled without a connection %d %d %v", fc.getCount(), pw.count(), fc.closed)
+	}
+}
+
+func TestPollerClient(t *testing.T) {
+	p := poller{
+		Version:         `2c`,
+		Community:       `public`,
+		Timeout:         `750ms`,
+		Max_Repetitions: 20,
+	}
+	x, err := p.client(`[fe80::1]:1161`, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if x.Target != `fe80::1` || x.Port != 1161 || x.Community != `public` {
+		t.Fatalf("bad target %s %d %s", x.Target, x.Port, x.Community)
+	} else if x.Timeout != 750*time.Millisecond || x.Retries != defaultPollRetries || x.MaxRepetitions != 20 {
+		t.Fatalf("bad timeouts %v %d %d", x.Timeout, x.Retries, x.MaxRepetitions)
+	} else if x.Version != gosnmp.Version2c || x.SecurityParameters != nil {
+		t.Fatalf("bad version %v", x.Version)
+	}
+
+	p = poller{
+		v3auth: v3auth{
+			Username:        `admin`,
+			Auth_Protocol:   `SHA`,
+			Auth_Passphrase: `authpassword`,
+		},
+		Version: `3`,
+		Retries: 5,
+	}
+	if x, err = p.client(`router`, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if x.Port != defaultPollPort || x.Timeout != defaultPollTimeout || x.Retries != 5 {
+		t.Fatalf("bad defaults %d %v %d", x.Port, x.Timeout, x.Retries)
+	} else if usm, ok := x.SecurityParameters.(*gosnmp.UsmSecurityParameters); !ok || usm.UserName != `admin` {
+		t.Fatalf("bad v3 security parameters %+v", x.SecurityParameters)
+	}
+
+	for _, bad := range []string{`0s`, `-1s`, `soon`} {
+		p.Timeout = bad
+		if _, err = p.client(`router`, context.Background()); err == nil {
+			t.Fatalf("accepted timeout %s", bad)
+		}
+	}
+}
+
+func TestPollerValidate(t *testing.T) {
+	base := func() poller {
+		return poller{
+			Version: `2c`,
+			Target:  []string{`router`, `10.0.0.1:1161`},
+			Get:     []string{`.1.3.6.1.2.1.1.3.0`},
+		}
+	}
+	p := base()
+	if err := p.validate(`test`, false); err != nil {
+		t.Fatal(err)
+	} else if d, _ := p.interval(); d != defaultPollInterval {
+		t.Fatalf("bad default interval %v", d)
+	}
+	tests := map[string]func(*poller){
+		`interval`:      func(p *poller) { p.Interval = `0s` },
+		`timeout`:       func(p *poller) { p.Timeout = `-5s` },
+		`retries`:       func(p *poller) { p.Retries = -1 },
+		`repetitions`:   func(p *poller) { p.Max_Repetitions = -1 },
+		`version`:       func(p *poller) { p.Version = `2` },
+		`no target`:     func(p *poller) { p.Target = nil },
+		`bad port`:      func(p *poller) { p.Target = []string{`router:0`} },
+		`no oids`:       func(p *poller) { p.Get = nil },
+		`symbolic oids`: func(p *poller) { p.Walk = []string{`ifTable`} },
+	}
+	for name, f := range tests {
+		p := base()
+		f(&p)
+		if err := p.validate(`test`, false); err == nil {
+			t.Fatalf("%s: accepted bad config", name)
+		}
+	}
+	p = base()
+	p.Walk = []string{`ifTable`}
+	if err := p.validate(`test`, true); err != nil {
+		t.Fatalf("symbolic OIDs with MIBs: %v", err)
+	}
+}
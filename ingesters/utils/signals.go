@@ -34,3 +34,15 @@ func GetQuitChannel() chan os.Signal {
 	signal.Notify(quitSig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM)
 	return quitSig
 }
+
+// GetQuitReloadChannels registers and returns a channel that will be notified upon receipt of SIGINT,
+// SIGQUIT, or SIGTERM and a second channel that will be notified upon receipt of SIGHUP.
+// Ingesters that can reload their configuration should use this instead of GetQuitChannel,
+// WaitForQuit and GetQuitChannel still treat SIGHUP as a quit signal.
+func GetQuitReloadChannels() (quit, reload chan os.Signal) {
+	quit = make(chan os.Signal, 1)
+	reload = make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM)
+	signal.Notify(reload, syscall.SIGHUP)
+	return
+}
@@ -26,9 +26,10 @@ const (
 )
 
 type StatsItem struct {
-	name string
-	last uint64
-	curr uint64
+	name  string
+	last  uint64
+	curr  uint64
+	total uint64 // never reset, used for exporting monotonic counters
 }
 
 type StatsManager struct {
@@ -153,9 +154,29 @@ func (sm *StatsManager) doTick(dur time.Duration) {
 func (si *StatsItem) Add(v uint64) {
 	if si != nil {
 		atomic.AddUint64(&si.curr, v)
+		atomic.AddUint64(&si.total, v)
 	}
 }
 
+// Total returns everything ever added to the item, it is not affected by the sample interval
+func (si *StatsItem) Total() (v uint64) {
+	if si != nil {
+		v = atomic.LoadUint64(&si.total)
+	}
+	return
+}
+
+// Totals returns the cumulative value of every registered StatsItem keyed by name
+func (sm *StatsManager) Totals() map[string]uint64 {
+	sm.Lock()
+	defer sm.Unlock()
+	r := make(map[string]uint64, len(sm.items))
+	for _, v := range sm.items {
+		r[v.name] = v.Total()
+	}
+	return r
+}
+
 func (si *StatsItem) reset() (curr uint64) {
 	if si != nil {
 		//reset and
@@ -0,0 +1,248 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	DefaultClientCNEnumeratedValue = `client_cn`
+
+	tlsReloadInterval = 5 * time.Second
+)
+
+var (
+	ErrMissingCertificate = errors.New("missing certificate file")
+	ErrMissingKey         = errors.New("missing key file")
+	ErrNoClientCAs        = errors.New("no certificates found in client CA file")
+	ErrClientNotAllowed   = errors.New("client certificate is not in the allowed name list")
+)
+
+// ServerTLSConfig describes the certificate and optional client verification for a TLS
+// listener.  If ClientCAFile is set clients must present a certificate signed by one of
+// the CAs in the bundle, if ClientAllow is also set the certificate must carry a subject
+// common name or SAN matching one of the entries.  Allow entries may use shell style
+// wildcards, e.g. *.example.com.
+type ServerTLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAllow  []string
+}
+
+// Validate loads everything referenced by the config to make sure it is usable
+func (c ServerTLSConfig) Validate() (err error) {
+	_, err = c.load()
+	return
+}
+
+// ClientAuth reports if client certificates are required
+func (c ServerTLSConfig) ClientAuth() bool {
+	return c.ClientCAFile != ``
+}
+
+func (c ServerTLSConfig) load() (tc *tls.Config, err error) {
+	if c.CertFile == `` {
+		return nil, ErrMissingCertificate
+	} else if c.KeyFile == `` {
+		return nil, ErrMissingKey
+	} else if len(c.ClientAllow) > 0 && c.ClientCAFile == `` {
+		return nil, errors.New("a client CA file is required to restrict client names")
+	}
+	for _, a := range c.ClientAllow {
+		if _, err = path.Match(a, ``); err != nil {
+			return nil, fmt.Errorf("invalid client name pattern %q: %w", a, err)
+		}
+	}
+	tc = &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: make([]tls.Certificate, 1),
+	}
+	if tc.Certificates[0], err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+		return nil, fmt.Errorf("failed to load certificate %q and key %q: %w", c.CertFile, c.KeyFile, err)
+	}
+	if c.ClientCAFile == `` {
+		return
+	}
+	var bts []byte
+	if bts, err = os.ReadFile(c.ClientCAFile); err != nil {
+		return nil, fmt.Errorf("failed to read client CA file %q: %w", c.ClientCAFile, err)
+	}
+	tc.ClientCAs = x509.NewCertPool()
+	if !tc.ClientCAs.AppendCertsFromPEM(bts) {
+		return nil, fmt.Errorf("%q: %w", c.ClientCAFile, ErrNoClientCAs)
+	}
+	tc.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(c.ClientAllow) > 0 {
+		allow := append([]string(nil), c.ClientAllow...)
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 || !clientAllowed(cs.PeerCertificates[0], allow) {
+				return ErrClientNotAllowed
+			}
+			return nil
+		}
+	}
+	return
+}
+
+// clientAllowed checks the subject common name and every DNS, email, and URI SAN
+func clientAllowed(cert *x509.Certificate, allow []string) bool {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, n := range names {
+		if n == `` {
+			continue
+		}
+		for _, a := range allow {
+			if ok, _ := path.Match(a, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClientName returns the common name of the verified client certificate on a connection,
+// if the subject has no common name the first DNS or email SAN is used instead.
+func ClientName(cs tls.ConnectionState) string {
+	if len(cs.VerifiedChains) == 0 || len(cs.PeerCertificates) == 0 {
+		return ``
+	}
+	cert := cs.PeerCertificates[0]
+	if cn := strings.TrimSpace(cert.Subject.CommonName); cn != `` {
+		return cn
+	} else if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	} else if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	return ``
+}
+
+// ServerTLS hands out a TLS configuration for a listener and reloads it when the
+// certificate, key, or client CA files change on disk.  Files are checked at most every
+// few seconds as clients connect, if the new files fail to load (for instance because
+// the certificate was replaced but the key has not been yet) the previous configuration
+// stays in service and the load is retried on the next check.
+type ServerTLS struct {
+	mtx       sync.Mutex
+	cfg       ServerTLSConfig
+	lg        *log.Logger
+	current   *tls.Config
+	stamps    []fileStamp
+	lastCheck time.Time
+	interval  time.Duration
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// NewServerTLS loads the config, lg may be nil
+func NewServerTLS(c ServerTLSConfig, lg *log.Logger) (st *ServerTLS, err error) {
+	st = &ServerTLS{
+		cfg:       c,
+		lg:        lg,
+		lastCheck: time.Now(),
+		interval:  tlsReloadInterval,
+	}
+	st.stamps = st.stat()
+	if st.current, err = c.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Config returns a TLS configuration suitable for tls.NewListener or an http.Server,
+// every handshake picks up the most recently loaded files
+func (st *ServerTLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: st.getConfigForClient,
+		GetCertificate:     st.getCertificate,
+	}
+}
+
+// getCertificate lets servers that look for a certificate on the top level config, like
+// http.Server.ServeTLS, know that one is available
+func (st *ServerTLS) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tc, _ := st.getConfigForClient(hi)
+	return &tc.Certificates[0], nil
+}
+
+func (st *ServerTLS) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if time.Since(st.lastCheck) >= st.interval {
+		st.lastCheck = time.Now()
+		st.reload()
+	}
+	return st.current, nil
+}
+
+// reload caller must hold the lock
+func (st *ServerTLS) reload() {
+	stamps := st.stat()
+	if stampsEqual(stamps, st.stamps) {
+		return
+	}
+	tc, err := st.cfg.load()
+	if err != nil {
+		if st.lg != nil {
+			st.lg.Warn("failed to reload TLS certificates, keeping existing certificates", log.KV("certificate", st.cfg.CertFile), log.KVErr(err))
+		}
+		return
+	}
+	st.current = tc
+	st.stamps = stamps
+	if st.lg != nil {
+		st.lg.Info("reloaded TLS certificates", log.KV("certificate", st.cfg.CertFile))
+	}
+}
+
+func (st *ServerTLS) stat() (r []fileStamp) {
+	for _, p := range []string{st.cfg.CertFile, st.cfg.KeyFile, st.cfg.ClientCAFile} {
+		var fs fileStamp
+		if p != `` {
+			if fi, err := os.Stat(p); err == nil {
+				fs = fileStamp{mod: fi.ModTime(), size: fi.Size()}
+			}
+		}
+		r = append(r, fs)
+	}
+	return
+}
+
+func stampsEqual(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].mod.Equal(b[i].mod) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
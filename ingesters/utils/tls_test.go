@@ -0,0 +1,217 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+var testSerial int64
+var testWrites int
+
+// writeTestFile gives every write a distinct modification time so coarse filesystem
+// timestamps cannot hide a change
+func writeTestFile(t *testing.T, pth string, b []byte) {
+	testWrites++
+	mod := time.Now().Add(time.Duration(testWrites) * time.Second)
+	if err := os.WriteFile(pth, b, 0600); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(pth, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCert(t *testing.T, cn string, dns []string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dns,
+		IPAddresses:  []net.IP{net.ParseIP(`127.0.0.1`)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testCert{key: key, der: der}
+	if tc.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func (tc *testCert) write(t *testing.T, certPath, keyPath string) {
+	writeTestFile(t, certPath, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: tc.der}))
+	if keyPath == `` {
+		return
+	}
+	kb, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, keyPath, pem.EncodeToMemory(&pem.Block{Type: `EC PRIVATE KEY`, Bytes: kb}))
+}
+
+func (tc *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.der}, PrivateKey: tc.key}
+}
+
+// testHandshake runs a single handshake against st, returning the server side state
+func testHandshake(t *testing.T, st *ServerTLS, ca *testCert, client *testCert) (cs tls.ConnectionState, serverCN string, err error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ccfg := &tls.Config{RootCAs: pool, ServerName: `server.example.com`}
+	if client != nil {
+		ccfg.Certificates = []tls.Certificate{client.tlsCert()}
+	}
+	lst, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+	cc, err := net.DialTimeout(`tcp`, lst.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := lst.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	sc.SetDeadline(deadline)
+	cc.SetDeadline(deadline)
+	srv := tls.Server(sc, st.Config())
+	errch := make(chan error, 1)
+	go func() {
+		err := srv.Handshake()
+		if err == nil {
+			//TLS 1.3 clients find out about rejected certificates on their first read
+			srv.Write([]byte("ok"))
+		}
+		sc.Close()
+		errch <- err
+	}()
+	clnt := tls.Client(cc, ccfg)
+	if err = clnt.Handshake(); err == nil {
+		cs = clnt.ConnectionState()
+		_, err = clnt.Read(make([]byte, 2))
+	}
+	cc.Close()
+	if serr := <-errch; serr != nil && err == nil {
+		err = serr
+	}
+	serverCN = ClientName(srv.ConnectionState())
+	return
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, caPath := filepath.Join(dir, `cert.pem`), filepath.Join(dir, `key.pem`), filepath.Join(dir, `ca.pem`)
+	ca := newTestCert(t, `test ca`, nil, nil)
+	ca.write(t, caPath, ``)
+	srvA := newTestCert(t, `server a`, []string{`server.example.com`}, ca)
+	srvA.write(t, certPath, keyPath)
+	client := newTestCert(t, `client1`, []string{`client1.example.com`}, ca)
+	rogue := newTestCert(t, `client2`, nil, ca)
+	otherCA := newTestCert(t, `other ca`, nil, nil)
+	stranger := newTestCert(t, `client1`, nil, otherCA)
+
+	for _, c := range []ServerTLSConfig{
+		{KeyFile: keyPath},
+		{CertFile: certPath},
+		{CertFile: certPath, KeyFile: keyPath, ClientAllow: []string{`client1`}},
+		{CertFile: certPath, KeyFile: keyPath, ClientCAFile: keyPath},
+		{CertFile: certPath, KeyFile: keyPath, ClientCAFile: caPath, ClientAllow: []string{`[`}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+
+	cfg := ServerTLSConfig{
+		CertFile:     certPath,
+		KeyFile:      keyPath,
+		ClientCAFile: caPath,
+		ClientAllow:  []string{`*.example.com`},
+	}
+	st, err := NewServerTLS(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.interval = 0
+	if cs, cn, err := testHandshake(t, st, ca, client); err != nil {
+		t.Fatal(err)
+	} else if cn != `client1` {
+		t.Fatalf("bad client name %q", cn)
+	} else if cs.PeerCertificates[0].Subject.CommonName != `server a` {
+		t.Fatalf("bad server certificate %v", cs.PeerCertificates[0].Subject)
+	}
+	for _, c := range []*testCert{nil, rogue, stranger} {
+		if _, _, err := testHandshake(t, st, ca, c); err == nil {
+			t.Fatal("handshake succeeded with a bad client certificate")
+		}
+	}
+
+	//rotate the server certificate, a half written pair keeps the old certificate
+	srvB := newTestCert(t, `server b`, []string{`server.example.com`}, ca)
+	srvB.write(t, certPath, ``)
+	if cs, _, err := testHandshake(t, st, ca, client); err != nil {
+		t.Fatal(err)
+	} else if cn := cs.PeerCertificates[0].Subject.CommonName; cn != `server a` {
+		t.Fatalf("mismatched certificate and key were loaded: %s", cn)
+	}
+	srvB.write(t, certPath, keyPath)
+	if cs, _, err := testHandshake(t, st, ca, client); err != nil {
+		t.Fatal(err)
+	} else if cn := cs.PeerCertificates[0].Subject.CommonName; cn != `server b` {
+		t.Fatalf("rotated certificate was not loaded: %s", cn)
+	}
+
+	//no client CA means no client certificates are requested
+	if st, err = NewServerTLS(ServerTLSConfig{CertFile: certPath, KeyFile: keyPath}, nil); err != nil {
+		t.Fatal(err)
+	} else if _, cn, err := testHandshake(t, st, ca, nil); err != nil {
+		t.Fatal(err)
+	} else if cn != `` {
+		t.Fatalf("got a client name without client verification: %q", cn)
+	}
+}
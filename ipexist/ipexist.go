@@ -9,6 +9,7 @@
 package ipexist
 
 import (
+	"bytes"
 	"compress/flate"
 	"encoding/binary"
 	"errors"
@@ -26,13 +27,21 @@ const (
 )
 
 var (
+	ErrInvalidIP         = errors.New("Invalid IP Address")
 	ErrInvalidIPv4       = errors.New("Invalid IPv4 Address")
+	ErrInvalidCIDR       = errors.New("Invalid CIDR")
 	ErrInvalidBaseOffset = errors.New("Invalid IPv4 base offset, potential corruption")
 	ErrNotMmapBacked     = errors.New("IPBitMap is not backed by a memory map")
 )
 
+const (
+	formatV1 = 1 //IPv4 bitmaps only
+	formatV2 = 2 //IPv4 bitmaps followed by the IPv6 prefix tree
+)
+
 var (
 	compV1Header = []byte{0x49, 0x50, 0x76, 0x34, 0x46, 0x4c, 0x54, 0x31} //IPv4FLT1
+	compV2Header = []byte{0x49, 0x50, 0x76, 0x36, 0x46, 0x4c, 0x54, 0x32} //IPv6FLT2
 )
 
 type slash16bitmap [1024]uint64
@@ -43,6 +52,7 @@ type IpBitMap struct {
 	bitmaps       []slash16bitmap
 	mmapBacked    bool
 	mm            mmapBacker
+	v6            v6tree
 }
 
 type mmapBacker struct {
@@ -69,6 +79,7 @@ func NewIPBitMapMemoryMapped(p string) (ipm *IpBitMap, err error) {
 		return
 	}
 	ipm.mmapBacked = true
+	ipm.v6.mmapPath = p + v6MmapSuffix
 	return
 }
 
@@ -92,17 +103,28 @@ func (ipbm *IpBitMap) Close() (err error) {
 		err = ipbm.mm.Close()
 		ipbm.mmapBacked = false
 	}
+	if lerr := ipbm.v6.reset(); lerr != nil && err == nil {
+		err = lerr
+	}
 	return
 }
 
+// AddIP adds a single IPv4 or IPv6 address to the set
 func (ipbm *IpBitMap) AddIP(ip net.IP) (err error) {
 	if ip == nil {
-		err = ErrInvalidIPv4
-		return
-	} else if ip = ip.To4(); len(ip) != 4 {
-		err = ErrInvalidIPv4
+		err = ErrInvalidIP
 		return
+	} else if v4 := ip.To4(); v4 != nil {
+		return ipbm.addIPv4(v4)
 	}
+	var k v6key
+	if k, err = newV6Key(ip); err == nil {
+		err = ipbm.v6.insert(k, 128)
+	}
+	return
+}
+
+func (ipbm *IpBitMap) addIPv4(ip net.IP) (err error) {
 	//read the upper 2 octets
 	upper := binary.BigEndian.Uint16(ip[0:2])
 	if upper == 0xffff {
@@ -128,14 +150,23 @@ func (ipbm *IpBitMap) AddIP(ip net.IP) (err error) {
 	return
 }
 
+// RemoveIP removes a single IPv4 or IPv6 address from the set, removing an IPv6 address
+// that was added as part of a CIDR splits the range around it
 func (ipbm *IpBitMap) RemoveIP(ip net.IP) (err error) {
 	if ip == nil {
-		err = ErrInvalidIPv4
-		return
-	} else if ip = ip.To4(); len(ip) != 4 {
-		err = ErrInvalidIPv4
+		err = ErrInvalidIP
 		return
+	} else if v4 := ip.To4(); v4 != nil {
+		return ipbm.removeIPv4(v4)
+	}
+	var k v6key
+	if k, err = newV6Key(ip); err == nil {
+		err = ipbm.v6.remove(k)
 	}
+	return
+}
+
+func (ipbm *IpBitMap) removeIPv4(ip net.IP) (err error) {
 	//read the upper 2 octets
 	upper := binary.BigEndian.Uint16(ip[0:2])
 	if upper == 0xffff {
@@ -154,14 +185,22 @@ func (ipbm *IpBitMap) RemoveIP(ip net.IP) (err error) {
 	return
 }
 
+// IPExists checks if an IPv4 or IPv6 address is in the set
 func (ipbm *IpBitMap) IPExists(ip net.IP) (ok bool, err error) {
 	if ip == nil {
-		err = ErrInvalidIPv4
-		return
-	} else if ip = ip.To4(); len(ip) != 4 {
-		err = ErrInvalidIPv4
+		err = ErrInvalidIP
 		return
+	} else if v4 := ip.To4(); v4 != nil {
+		return ipbm.ipv4Exists(v4)
+	}
+	var k v6key
+	if k, err = newV6Key(ip); err == nil {
+		ok, err = ipbm.v6.contains(k)
 	}
+	return
+}
+
+func (ipbm *IpBitMap) ipv4Exists(ip net.IP) (ok bool, err error) {
 	//read the upper 2 octets
 	upper := binary.BigEndian.Uint16(ip[0:2])
 	if upper == 0xFFFF {
@@ -199,10 +238,82 @@ func (ipbm *IpBitMap) addNewBitmap() (off uint16, err error) {
 	return
 }
 
+// AddCIDR adds every address in an IPv4 or IPv6 network to the set.
+// IPv4 networks mapped into IPv6 (::ffff:0:0/96) are treated as IPv4.
+func (ipbm *IpBitMap) AddCIDR(n *net.IPNet) (err error) {
+	if n == nil {
+		return ErrInvalidCIDR
+	}
+	ones, sz := n.Mask.Size()
+	if sz == 128 && ones >= 96 && len(n.IP) == net.IPv6len && n.IP.To4() != nil {
+		ones, sz = ones-96, 32
+	}
+	switch sz {
+	case 32:
+		v4 := n.IP.To4()
+		if v4 == nil {
+			return ErrInvalidCIDR
+		}
+		return ipbm.addCIDRv4(binary.BigEndian.Uint32(v4), ones)
+	case 128:
+		var k v6key
+		if k, err = newV6Key(n.IP.To16()); err == nil {
+			err = ipbm.v6.insert(k, uint32(ones))
+		}
+		return
+	}
+	return ErrInvalidCIDR
+}
+
+func (ipbm *IpBitMap) addCIDRv4(ip uint32, ones int) (err error) {
+	mask := ^uint32(0)
+	if ones < 32 {
+		mask = ^(^uint32(0) >> ones)
+	}
+	start := ip & mask
+	end := start | ^mask
+	if ones <= 16 {
+		//whole /16s, mark them as full
+		for upper := start >> 16; upper <= end>>16; upper++ {
+			if upper == 0xffff {
+				break // we do not support broadcast
+			}
+			ipbm.bitmapOffsets[upper] = 0xffff
+		}
+		return
+	}
+	upper := uint16(start >> 16)
+	if upper == 0xffff {
+		return // we do not support broadcast
+	}
+	off := ipbm.bitmapOffsets[upper]
+	if off == 0xffff {
+		return //already covered
+	} else if off == 0 {
+		if off, err = ipbm.addNewBitmap(); err != nil {
+			return
+		}
+		ipbm.bitmapOffsets[upper] = off
+	} else if off > ipbm.maxOffset {
+		return ErrInvalidBaseOffset
+	}
+	for lower := start & 0xffff; lower <= end&0xffff; lower++ {
+		ipbm.bitmaps[off-1].set(uint16(lower))
+	}
+	return
+}
+
+// Encode writes the set out, sets without any IPv6 addresses are written in the
+// original IPv4 only format so older readers can still load them
 func (ipbm *IpBitMap) Encode(w io.Writer) (err error) {
 	var fw *flate.Writer
+	nodes := ipbm.v6.compact()
+	hdr := compV1Header
+	if len(nodes) > 0 {
+		hdr = compV2Header
+	}
 	//write the header
-	if err = writeAll(w, compV1Header); err != nil {
+	if err = writeAll(w, hdr); err != nil {
 		return
 	}
 	//write the bitmap slice count
@@ -211,6 +322,13 @@ func (ipbm *IpBitMap) Encode(w io.Writer) (err error) {
 	if err = writeAll(w, x); err != nil {
 		return
 	}
+	if len(nodes) > 0 {
+		//and the IPv6 node count
+		binary.LittleEndian.PutUint64(x, uint64(len(nodes)))
+		if err = writeAll(w, x); err != nil {
+			return
+		}
+	}
 
 	//get a new flate writer
 	if fw, err = flate.NewWriter(w, flateLevel); err != nil {
@@ -227,6 +345,12 @@ func (ipbm *IpBitMap) Encode(w io.Writer) (err error) {
 			return
 		}
 	}
+	//write the IPv6 tree
+	if len(nodes) > 0 {
+		if err = binary.Write(fw, binary.LittleEndian, nodes); err != nil {
+			return
+		}
+	}
 	if err = fw.Flush(); err != nil {
 		return
 	}
@@ -236,8 +360,9 @@ func (ipbm *IpBitMap) Encode(w io.Writer) (err error) {
 
 func CheckDecodeHeader(r io.Reader) (err error) {
 	var cnt uint64
+	var ver int
 	//write the header
-	if err = checkHeader(r); err != nil {
+	if ver, err = checkHeader(r); err != nil {
 		return
 	}
 	//get the slice count
@@ -246,15 +371,24 @@ func CheckDecodeHeader(r io.Reader) (err error) {
 	}
 	if cnt > maxMaps {
 		err = errors.New("file is corrupt")
+		return
+	}
+	if ver == formatV2 {
+		if cnt, err = readUint64(r); err != nil {
+			return
+		} else if cnt > maxV6Nodes {
+			err = errors.New("file is corrupt")
+		}
 	}
 	return
 }
 
 func (ipbm *IpBitMap) Decode(r io.Reader) (err error) {
 	var fr io.ReadCloser
-	var cnt uint64
+	var cnt, v6cnt uint64
+	var ver int
 	//write the header
-	if err = checkHeader(r); err != nil {
+	if ver, err = checkHeader(r); err != nil {
 		return
 	}
 	//get the slice count
@@ -265,6 +399,17 @@ func (ipbm *IpBitMap) Decode(r io.Reader) (err error) {
 		err = errors.New("file is corrupt")
 		return
 	}
+	if ver == formatV2 {
+		if v6cnt, err = readUint64(r); err != nil {
+			return
+		} else if v6cnt > maxV6Nodes {
+			err = errors.New("file is corrupt")
+			return
+		}
+	}
+	if err = ipbm.v6.reset(); err != nil {
+		return
+	}
 	//get a new flate Reader
 	fr = flate.NewReader(r)
 	//read the bitmap offsets
@@ -287,6 +432,17 @@ func (ipbm *IpBitMap) Decode(r io.Reader) (err error) {
 	}
 	ipbm.maxOffset = uint16(len(ipbm.bitmaps))
 
+	//read the IPv6 tree
+	if v6cnt > 0 {
+		if err = ipbm.v6.allocateNodes(v6cnt); err != nil {
+			return
+		} else if err = binary.Read(fr, binary.LittleEndian, ipbm.v6.nodes); err != nil {
+			return
+		} else if err = ipbm.v6.validate(); err != nil {
+			return
+		}
+	}
+
 	if len(ipbm.bitmaps) != int(cnt) {
 		err = errors.New("bitmaps are corrupt")
 	}
@@ -376,19 +532,22 @@ func writeAll(w io.Writer, b []byte) (err error) {
 	return
 }
 
-func checkHeader(r io.Reader) (err error) {
+// checkHeader reads the file header and returns the format version
+func checkHeader(r io.Reader) (ver int, err error) {
 	var n int
 	x := make([]byte, len(compV1Header))
 	if n, err = r.Read(x); err != nil {
 		return
 	} else if n != len(x) {
-		return errors.New("failed header read")
+		err = errors.New("failed header read")
+		return
 	}
-	for i := range x {
-		if x[i] != compV1Header[i] {
-			err = errors.New("Bad header")
-			break
-		}
+	if bytes.Equal(x, compV1Header) {
+		ver = formatV1
+	} else if bytes.Equal(x, compV2Header) {
+		ver = formatV2
+	} else {
+		err = errors.New("Bad header")
 	}
 	return
 }
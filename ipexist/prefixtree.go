@@ -0,0 +1,405 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ipexist
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net"
+	"reflect"
+	"unsafe"
+)
+
+const (
+	v6nodeSize   int64 = 32
+	maxV6Nodes         = 0x7fffffff
+	v6MmapSuffix       = `.v6`
+)
+
+var (
+	ErrInvalidIPv6     = errors.New("Invalid IPv6 Address")
+	ErrNodesExhausted  = errors.New("IPv6 nodes exhausted")
+	ErrCorruptV6Tree   = errors.New("IPv6 tree is corrupt")
+	ErrInvalidV6Prefix = errors.New("Invalid IPv6 prefix length")
+)
+
+// v6node is a node in a path compressed binary prefix tree.  Each node carries the full
+// prefix so a lookup can verify it without looking at the parents.  The layout is fixed
+// so that the node slice can be encoded directly and backed by a memory map.
+type v6node struct {
+	Hi       uint64
+	Lo       uint64
+	Children [2]uint32 // index of each child, zero means none since the root is never a child
+	Bits     uint32    // prefix length
+	Term     uint32    // non-zero if everything under the prefix is in the set
+}
+
+type v6key struct {
+	hi, lo uint64
+}
+
+// v6tree is a sparse set of IPv6 prefixes, the root is always node zero with a zero length prefix
+type v6tree struct {
+	nodes      []v6node
+	mmapPath   string
+	mmapBacked bool
+	mm         mmapBacker
+}
+
+func newV6Key(ip net.IP) (k v6key, err error) {
+	if len(ip) != net.IPv6len {
+		err = ErrInvalidIPv6
+		return
+	}
+	k.hi = binary.BigEndian.Uint64(ip[0:8])
+	k.lo = binary.BigEndian.Uint64(ip[8:16])
+	return
+}
+
+// bit returns the bit at position i, counting from the most significant
+func (k v6key) bit(i uint32) uint32 {
+	if i < 64 {
+		return uint32(k.hi>>(63-i)) & 1
+	}
+	return uint32(k.lo>>(127-i)) & 1
+}
+
+// mask zeros everything past the first n bits
+func (k v6key) mask(n uint32) v6key {
+	switch {
+	case n == 0:
+		return v6key{}
+	case n < 64:
+		return v6key{hi: k.hi &^ (^uint64(0) >> n)}
+	case n == 64:
+		return v6key{hi: k.hi}
+	case n < 128:
+		return v6key{hi: k.hi, lo: k.lo &^ (^uint64(0) >> (n - 64))}
+	}
+	return k
+}
+
+// common returns how many leading bits two keys share
+func (k v6key) common(x v6key) uint32 {
+	if d := k.hi ^ x.hi; d != 0 {
+		return uint32(bits.LeadingZeros64(d))
+	}
+	return 64 + uint32(bits.LeadingZeros64(k.lo^x.lo))
+}
+
+func (n *v6node) key() v6key {
+	return v6key{hi: n.Hi, lo: n.Lo}
+}
+
+// matches checks if a key falls under the node prefix
+func (n *v6node) matches(k v6key) bool {
+	return k.mask(n.Bits) == n.key()
+}
+
+func newV6Node(k v6key, plen uint32, term bool) (n v6node) {
+	k = k.mask(plen)
+	n.Hi, n.Lo = k.hi, k.lo
+	n.Bits = plen
+	if term {
+		n.Term = 1
+	}
+	return
+}
+
+func (t *v6tree) alloc(n v6node) (idx uint32, err error) {
+	l := len(t.nodes)
+	if l >= maxV6Nodes {
+		err = ErrNodesExhausted
+		return
+	}
+	if t.mmapPath == `` || l < cap(t.nodes) {
+		t.nodes = append(t.nodes, n)
+	} else {
+		//grow in chunks so we are not resizing the map on every node
+		c := 2 * l
+		if c < int(pageSize/v6nodeSize) {
+			c = int(pageSize / v6nodeSize)
+		} else if c > maxV6Nodes {
+			c = maxV6Nodes
+		}
+		if err = t.mapNodes(l+1, c); err != nil {
+			return
+		}
+		t.nodes[l] = n
+	}
+	idx = uint32(l)
+	return
+}
+
+// mapNodes sizes the memory map to hold c nodes and points the node slice at it with length l
+func (t *v6tree) mapNodes(l, c int) (err error) {
+	if !t.mmapBacked {
+		if t.mm, err = newMmapBacker(t.mmapPath); err != nil {
+			return
+		}
+		t.mmapBacked = true
+	}
+	if err = t.mm.fm.SetSize(int64(c) * v6nodeSize); err != nil {
+		return
+	}
+	//ok, now use reflection to setup our slice
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&t.nodes))
+	hdr.Len = l
+	hdr.Cap = c
+	hdr.Data = uintptr(unsafe.Pointer(&t.mm.fm.Buff[0]))
+	return
+}
+
+// allocateNodes sets up an empty node slice of length cnt for decoding into
+func (t *v6tree) allocateNodes(cnt uint64) (err error) {
+	if cnt > maxV6Nodes {
+		return ErrCorruptV6Tree
+	} else if t.mmapPath == `` {
+		t.nodes = make([]v6node, cnt)
+		return
+	} else if cnt == 0 {
+		t.nodes = nil
+		return
+	}
+	return t.mapNodes(int(cnt), int(cnt))
+}
+
+func (t *v6tree) reset() (err error) {
+	t.nodes = nil
+	if t.mmapBacked {
+		err = t.mm.Close()
+		t.mmapBacked = false
+	}
+	return
+}
+
+func (t *v6tree) empty() bool {
+	return len(t.nodes) == 0
+}
+
+// insert adds a prefix to the set, a prefix that is already covered is a no-op and a
+// prefix that covers existing entries replaces them
+func (t *v6tree) insert(k v6key, plen uint32) (err error) {
+	if plen > 128 {
+		return ErrInvalidV6Prefix
+	}
+	k = k.mask(plen)
+	if t.empty() {
+		if _, err = t.alloc(newV6Node(v6key{}, 0, false)); err != nil {
+			return
+		}
+	}
+	var n uint32
+	for {
+		nd := &t.nodes[n]
+		if nd.Term != 0 {
+			return //already covered
+		} else if plen == nd.Bits {
+			nd.Term = 1
+			nd.Children = [2]uint32{}
+			return
+		}
+		b := k.bit(nd.Bits)
+		c := nd.Children[b]
+		if c == 0 {
+			var leaf uint32
+			if leaf, err = t.alloc(newV6Node(k, plen, true)); err == nil {
+				t.nodes[n].Children[b] = leaf
+			}
+			return
+		}
+		child := t.nodes[c]
+		cp := k.common(child.key())
+		if cp > plen {
+			cp = plen
+		}
+		if cp > child.Bits {
+			cp = child.Bits
+		}
+		if cp == child.Bits {
+			n = c
+			continue
+		}
+		var leaf uint32
+		if leaf, err = t.alloc(newV6Node(k, plen, true)); err != nil {
+			return
+		}
+		if cp == plen {
+			//the new prefix swallows the child
+			t.nodes[n].Children[b] = leaf
+			return
+		}
+		//split off a node where the new prefix and the child diverge
+		var mid uint32
+		if mid, err = t.alloc(newV6Node(k, cp, false)); err != nil {
+			return
+		}
+		t.nodes[mid].Children[k.bit(cp)] = leaf
+		t.nodes[mid].Children[child.key().bit(cp)] = c
+		t.nodes[n].Children[b] = mid
+		return
+	}
+}
+
+// contains checks if an address falls under any prefix in the set
+func (t *v6tree) contains(k v6key) (ok bool, err error) {
+	if t.empty() {
+		return
+	}
+	var n uint32
+	for i := 0; i <= 128; i++ {
+		nd := &t.nodes[n]
+		if !nd.matches(k) {
+			return
+		} else if nd.Term != 0 {
+			ok = true
+			return
+		} else if nd.Bits >= 128 {
+			return
+		}
+		if n = nd.Children[k.bit(nd.Bits)]; n == 0 {
+			return
+		} else if int(n) >= len(t.nodes) {
+			err = ErrCorruptV6Tree
+			return
+		}
+	}
+	//every step goes at least one bit deeper, this can only be a loop
+	err = ErrCorruptV6Tree
+	return
+}
+
+// remove takes a single address out of the set, if the address is covered by a larger
+// prefix that prefix is split up around it
+func (t *v6tree) remove(k v6key) (err error) {
+	if t.empty() {
+		return
+	}
+	var n uint32
+	for {
+		nd := &t.nodes[n]
+		if !nd.matches(k) {
+			return
+		} else if nd.Term != 0 {
+			break
+		} else if nd.Bits >= 128 {
+			return
+		}
+		if n = nd.Children[k.bit(nd.Bits)]; n == 0 {
+			return
+		}
+	}
+	t.nodes[n].Term = 0
+	for d := t.nodes[n].Bits; d < 128; d++ {
+		b := k.bit(d)
+		//everything on the other side of this bit is still in the set
+		sk := k
+		if d < 64 {
+			sk.hi ^= 1 << (63 - d)
+		} else {
+			sk.lo ^= 1 << (127 - d)
+		}
+		var sib uint32
+		if sib, err = t.alloc(newV6Node(sk, d+1, true)); err != nil {
+			return
+		}
+		t.nodes[n].Children[b^1] = sib
+		if d == 127 {
+			break
+		}
+		var next uint32
+		if next, err = t.alloc(newV6Node(k, d+1, false)); err != nil {
+			return
+		}
+		t.nodes[n].Children[b] = next
+		n = next
+	}
+	return
+}
+
+// live returns the node that should stand in for n in a compacted tree, skipping nodes
+// that only have one populated child.  False means nothing under n is in the set.
+func (t *v6tree) live(n uint32) (uint32, bool) {
+	nd := &t.nodes[n]
+	if nd.Term != 0 {
+		return n, true
+	}
+	var l, r uint32
+	var lok, rok bool
+	if c := nd.Children[0]; c != 0 {
+		l, lok = t.live(c)
+	}
+	if c := nd.Children[1]; c != 0 {
+		r, rok = t.live(c)
+	}
+	switch {
+	case lok && rok:
+		return n, true
+	case lok:
+		return l, true
+	case rok:
+		return r, true
+	}
+	return 0, false
+}
+
+// compact returns a copy of the tree without covered or emptied nodes
+func (t *v6tree) compact() (out []v6node) {
+	if t.empty() {
+		return
+	}
+	var emit func(n uint32) uint32
+	emit = func(n uint32) uint32 {
+		idx := uint32(len(out))
+		nd := t.nodes[n]
+		out = append(out, newV6Node(nd.key(), nd.Bits, nd.Term != 0))
+		if nd.Term != 0 {
+			return idx
+		}
+		for i, c := range nd.Children {
+			if c == 0 {
+				continue
+			}
+			if l, ok := t.live(c); ok {
+				ci := emit(l)
+				out[idx].Children[i] = ci
+			}
+		}
+		return idx
+	}
+	//the root always stays at zero
+	if _, ok := t.live(0); ok {
+		emit(0)
+	}
+	return
+}
+
+// validate makes sure a decoded tree cannot send a lookup off into the weeds
+func (t *v6tree) validate() error {
+	if t.empty() {
+		return nil
+	} else if t.nodes[0].Bits != 0 {
+		return ErrCorruptV6Tree
+	}
+	for i := range t.nodes {
+		nd := &t.nodes[i]
+		if nd.Bits > 128 || nd.key().mask(nd.Bits) != nd.key() {
+			return ErrCorruptV6Tree
+		}
+		for _, c := range nd.Children {
+			if c == 0 {
+				continue
+			} else if int(c) >= len(t.nodes) || t.nodes[c].Bits <= nd.Bits {
+				return ErrCorruptV6Tree
+			}
+		}
+	}
+	return nil
+}
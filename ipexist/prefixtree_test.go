@@ -0,0 +1,284 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ipexist
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+)
+
+func genIPv6(r *rand.Rand) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	r.Read(ip)
+	ip[0] = 0x20 //keep it in global unicast so it never looks like a mapped v4 address
+	return ip
+}
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func checkExists(t *testing.T, bm *IpBitMap, s string, expect bool) {
+	t.Helper()
+	ip := net.ParseIP(s)
+	if ok, err := bm.IPExists(ip); err != nil {
+		t.Fatal(err)
+	} else if ok != expect {
+		t.Fatalf("%s exists = %v, expected %v", s, ok, expect)
+	}
+}
+
+func TestV6AddExists(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bm := NewIPBitMap()
+	var ips []net.IP
+	for i := 0; i < 10000; i++ {
+		ip := genIPv6(r)
+		if err := bm.AddIP(ip); err != nil {
+			t.Fatal(err)
+		}
+		ips = append(ips, ip)
+	}
+	for i, ip := range ips {
+		if ok, err := bm.IPExists(ip); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("IP missed", i, ip)
+		}
+		//flip the last bit, the odds of hitting another random address are nil
+		miss := append(net.IP(nil), ip...)
+		miss[15] ^= 1
+		if ok, err := bm.IPExists(miss); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("false hit", i, miss)
+		}
+	}
+	//v4 and v6 do not bleed into each other
+	checkExists(t, bm, "10.0.0.1", false)
+	if err := bm.AddIP(net.ParseIP("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, bm, "10.0.0.1", true)
+	checkExists(t, bm, "::ffff:10.0.0.1", true)
+	checkExists(t, bm, "::a00:1", false)
+	if err := bm.AddIP(net.IP{1, 2, 3}); err != ErrInvalidIPv6 {
+		t.Fatalf("bad error on a garbage address: %v", err)
+	}
+}
+
+func TestCIDR(t *testing.T) {
+	bm := NewIPBitMap()
+	for _, s := range []string{
+		`2001:db8:1:2::/64`,
+		`2001:db8:ff00::/40`,
+		`2001:db8:ff00:1234::/64`, //already covered
+		`2001:db8:aaaa::1/128`,
+		`192.168.0.0/24`,
+		`172.16.0.0/12`,
+		`::ffff:10.1.1.0/120`,
+	} {
+		if err := bm.AddCIDR(mustCIDR(t, s)); err != nil {
+			t.Fatal(s, err)
+		}
+	}
+	for s, expect := range map[string]bool{
+		`2001:db8:1:2::`:                       true,
+		`2001:db8:1:2:ffff:ffff:ffff:ffff`:     true,
+		`2001:db8:1:3::`:                       false,
+		`2001:db8:1:1:ffff:ffff:ffff:ffff`:     false,
+		`2001:db8:ff00::1`:                     true,
+		`2001:db8:ffff:ffff::1`:                true,
+		`2001:db8:fe00::1`:                     false,
+		`2001:db8:aaaa::1`:                     true,
+		`2001:db8:aaaa::2`:                     false,
+		`192.168.0.0`:                          true,
+		`192.168.0.255`:                        true,
+		`192.168.1.0`:                          false,
+		`172.16.0.1`:                           true,
+		`172.31.255.254`:                       true,
+		`172.32.0.1`:                           false,
+		`10.1.1.77`:                            true,
+		`10.1.2.77`:                            false,
+		`2001:db9::`:                           false,
+		`fe80::1`:                              false,
+		`2001:0db8:0001:0002:0000:0000:0000:1`: true,
+	} {
+		checkExists(t, bm, s, expect)
+	}
+
+	//a wider prefix swallows the narrower ones
+	if err := bm.AddCIDR(mustCIDR(t, `2001:db8::/32`)); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, bm, `2001:db8:1234::1`, true)
+	if nodes := bm.v6.compact(); len(nodes) != 2 {
+		t.Fatalf("compacted tree has %d nodes, expected a root and one prefix", len(nodes))
+	}
+	if err := bm.AddCIDR(mustCIDR(t, `::/0`)); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, bm, `fe80::1`, true)
+	checkExists(t, bm, `1.1.1.1`, false)
+}
+
+func TestV6Remove(t *testing.T) {
+	bm := NewIPBitMap()
+	if err := bm.AddCIDR(mustCIDR(t, `2001:db8::/64`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.AddIP(net.ParseIP(`2001:db8:1::1`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.RemoveIP(net.ParseIP(`2001:db8::1234`)); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, bm, `2001:db8::1234`, false)
+	for _, s := range []string{`2001:db8::`, `2001:db8::1235`, `2001:db8::1233`, `2001:db8::ffff:ffff:ffff:ffff`, `2001:db8:1::1`} {
+		checkExists(t, bm, s, true)
+	}
+	if err := bm.RemoveIP(net.ParseIP(`2001:db8:1::1`)); err != nil {
+		t.Fatal(err)
+	}
+	checkExists(t, bm, `2001:db8:1::1`, false)
+	//removing something that is not there is fine
+	if err := bm.RemoveIP(net.ParseIP(`fe80::1`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestV6EncodeDecode(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	bm := NewIPBitMap()
+
+	//no v6 content means the old format
+	var bb bytes.Buffer
+	if err := bm.AddIP(net.ParseIP("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	} else if err = bm.Encode(&bb); err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasPrefix(bb.Bytes(), compV1Header) {
+		t.Fatal("IPv4 only set did not encode as V1")
+	}
+
+	var ips []net.IP
+	for i := 0; i < 1000; i++ {
+		ip := genIPv6(r)
+		if err := bm.AddIP(ip); err != nil {
+			t.Fatal(err)
+		}
+		ips = append(ips, ip)
+	}
+	if err := bm.AddCIDR(mustCIDR(t, `2001:db8::/48`)); err != nil {
+		t.Fatal(err)
+	}
+	bb.Reset()
+	if err := bm.Encode(&bb); err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasPrefix(bb.Bytes(), compV2Header) {
+		t.Fatal("IPv6 set did not encode as V2")
+	}
+	if err := CheckDecodeHeader(bytes.NewReader(bb.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	mmn, err := getTempFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mapped := range []bool{false, true} {
+		var nbm *IpBitMap
+		if mapped {
+			nbm, err = LoadIPBitMapMemoryMapped(bytes.NewReader(bb.Bytes()), mmn)
+		} else {
+			nbm, err = LoadIPBitMap(bytes.NewReader(bb.Bytes()))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, ip := range ips {
+			if ok, err := nbm.IPExists(ip); err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				t.Fatal("IP missed after decode", i, ip, mapped)
+			}
+		}
+		checkExists(t, nbm, `2001:db8:0:ffff::1`, true)
+		checkExists(t, nbm, `2001:db8:1::1`, false)
+		checkExists(t, nbm, `10.0.0.1`, true)
+
+		//keep adding to the decoded set
+		if err = nbm.AddCIDR(mustCIDR(t, `2001:db8:1::/48`)); err != nil {
+			t.Fatal(err)
+		}
+		checkExists(t, nbm, `2001:db8:1::1`, true)
+		if err = nbm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = os.Stat(mmn + v6MmapSuffix); !os.IsNotExist(err) {
+		t.Fatal("IPv6 backing file was not cleaned up", err)
+	}
+}
+
+func TestV6AddMemoryMapped(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	mmn, err := getTempFileName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm, err := NewIPBitMapMemoryMapped(mmn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bm.Close()
+	var ips []net.IP
+	for i := 0; i < 10000; i++ {
+		ip := genIPv6(r)
+		if err := bm.AddIP(ip); err != nil {
+			t.Fatal(err)
+		}
+		ips = append(ips, ip)
+	}
+	if !bm.v6.mmapBacked {
+		t.Fatal("IPv6 tree is not memory mapped")
+	}
+	for i, ip := range ips {
+		if ok, err := bm.IPExists(ip); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("IP missed", i, ip)
+		}
+	}
+}
+
+func TestV6CorruptTree(t *testing.T) {
+	bm := NewIPBitMap()
+	if err := bm.AddCIDR(mustCIDR(t, `2001:db8::/32`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.AddCIDR(mustCIDR(t, `2001:db9::/32`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.v6.validate(); err != nil {
+		t.Fatal(err)
+	}
+	//point the root past the end of the nodes
+	bm.v6.nodes[0].Children[0] = uint32(len(bm.v6.nodes))
+	if err := bm.v6.validate(); err != ErrCorruptV6Tree {
+		t.Fatalf("failed to catch a corrupt tree: %v", err)
+	}
+}
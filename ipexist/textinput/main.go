@@ -63,17 +63,22 @@ func main() {
 			break
 		}
 		s = strings.TrimSpace(strings.Trim(s, "\n\r\"'"))
-		if ip := net.ParseIP(s); ip != nil {
-			if ip = ip.To4(); ip != nil {
-				if err := ipb.AddIP(ip); err != nil {
-					log.Fatalf("Failed to add %s: %v\n", ip, err)
+		if strings.Contains(s, "/") {
+			if _, n, err := net.ParseCIDR(s); err == nil {
+				if err := ipb.AddCIDR(n); err != nil {
+					log.Fatalf("Failed to add %s: %v\n", n, err)
 				}
 				cnt++
 			}
+		} else if ip := net.ParseIP(s); ip != nil {
+			if err := ipb.AddIP(ip); err != nil {
+				log.Fatalf("Failed to add %s: %v\n", ip, err)
+			}
+			cnt++
 		}
 	}
 	if err = ipb.Encode(fout); err != nil {
 		log.Fatalf("Failied to encode output file: %v\n", err)
 	}
-	log.Printf("Processed %d IPs\n", cnt)
+	log.Printf("Processed %d IPs and networks\n", cnt)
 }
@@ -0,0 +1,525 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	NFv9HeaderSize int = 20
+
+	nfv9Version                  uint16 = 9
+	nfv9FlowSetHeaderSize        int    = 4
+	nfv9TemplateFlowSetID        uint16 = 0
+	nfv9OptionsTemplateFlowSetID uint16 = 1
+	nfv9MinDataFlowSetID         uint16 = 256
+
+	// DefaultTemplateTimeout is how long a template is kept without being refreshed by the exporter
+	DefaultTemplateTimeout = 30 * time.Minute
+)
+
+var (
+	ErrNFv9HeaderTooShort = errors.New("Buffer to small for Netflow V9 header")
+	ErrInvalidNFv9        = errors.New("Not a valid Netflow V9 packet")
+	ErrInvalidFlowSet     = errors.New("Netflow V9 flowset is invalid")
+	ErrInvalidTemplate    = errors.New("Netflow V9 template is invalid")
+	ErrInvalidFieldValue  = errors.New("Netflow V9 field value does not match the template")
+)
+
+// NFv9Header is the header of a Netflow V9 export packet as described in RFC 3954
+type NFv9Header struct {
+	Version  uint16
+	Count    uint16 // template and data records in the packet
+	Uptime   uint32 // milliseconds since the exporter booted
+	Sec      uint32
+	Sequence uint32
+	SourceID uint32
+}
+
+// NFv9TemplateField is the type and length of a single field in a template
+type NFv9TemplateField struct {
+	Type   uint16
+	Length uint16
+}
+
+// NFv9Template describes the layout of data records.  Options templates carry scope
+// fields that describe what the option values apply to.
+type NFv9Template struct {
+	ID      uint16
+	Options bool
+	Scopes  []NFv9TemplateField
+	Fields  []NFv9TemplateField
+}
+
+// NFv9Field is a single decoded value, the value is a copy and does not reference the packet
+type NFv9Field struct {
+	Type  uint16
+	Scope bool
+	Value []byte
+}
+
+// NFv9Record is a data record decoded with its template
+type NFv9Record struct {
+	TemplateID uint16
+	Options    bool
+	Fields     []NFv9Field
+}
+
+// NFv9 is a decoded Netflow V9 packet.  Templates holds the templates that were carried in
+// the packet and Missing counts the data flowsets we could not decode for lack of a template.
+type NFv9 struct {
+	NFv9Header
+	Templates []NFv9Template
+	Records   []NFv9Record
+	Missing   int
+}
+
+// Decode pulls the header out of a buffer
+func (h *NFv9Header) Decode(b []byte) error {
+	if len(b) < NFv9HeaderSize {
+		return ErrNFv9HeaderTooShort
+	}
+	h.Version = binary.BigEndian.Uint16(b)
+	h.Count = binary.BigEndian.Uint16(b[2:4])
+	h.Uptime = binary.BigEndian.Uint32(b[4:8])
+	h.Sec = binary.BigEndian.Uint32(b[8:12])
+	h.Sequence = binary.BigEndian.Uint32(b[12:16])
+	h.SourceID = binary.BigEndian.Uint32(b[16:20])
+	return nil
+}
+
+func (h *NFv9Header) encode(b []byte) error {
+	if len(b) < NFv9HeaderSize {
+		return ErrNFv9HeaderTooShort
+	}
+	binary.BigEndian.PutUint16(b[0:2], h.Version)
+	binary.BigEndian.PutUint16(b[2:4], h.Count)
+	binary.BigEndian.PutUint32(b[4:8], h.Uptime)
+	binary.BigEndian.PutUint32(b[8:12], h.Sec)
+	binary.BigEndian.PutUint32(b[12:16], h.Sequence)
+	binary.BigEndian.PutUint32(b[16:20], h.SourceID)
+	return nil
+}
+
+// Timestamp returns the export time of the packet
+func (h *NFv9Header) Timestamp() time.Time {
+	return time.Unix(int64(h.Sec), 0)
+}
+
+// RecordSize is the number of bytes a data record described by the template occupies
+func (t *NFv9Template) RecordSize() (n int) {
+	for _, f := range t.Scopes {
+		n += int(f.Length)
+	}
+	for _, f := range t.Fields {
+		n += int(f.Length)
+	}
+	return
+}
+
+func (t *NFv9Template) decodeRecord(b []byte) (r NFv9Record) {
+	r.TemplateID = t.ID
+	r.Options = t.Options
+	r.Fields = make([]NFv9Field, 0, len(t.Scopes)+len(t.Fields))
+	for _, f := range t.Scopes {
+		r.Fields = append(r.Fields, NFv9Field{
+			Type:  f.Type,
+			Scope: true,
+			Value: append([]byte(nil), b[:f.Length]...),
+		})
+		b = b[f.Length:]
+	}
+	for _, f := range t.Fields {
+		r.Fields = append(r.Fields, NFv9Field{
+			Type:  f.Type,
+			Value: append([]byte(nil), b[:f.Length]...),
+		})
+		b = b[f.Length:]
+	}
+	return
+}
+
+func (t *NFv9Template) equal(x *NFv9Template) bool {
+	if t.ID != x.ID || t.Options != x.Options || len(t.Scopes) != len(x.Scopes) || len(t.Fields) != len(x.Fields) {
+		return false
+	}
+	for i := range t.Scopes {
+		if t.Scopes[i] != x.Scopes[i] {
+			return false
+		}
+	}
+	for i := range t.Fields {
+		if t.Fields[i] != x.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type nfv9TemplateKey struct {
+	exporter [16]byte
+	source   uint32
+	id       uint16
+}
+
+type nfv9CachedTemplate struct {
+	NFv9Template
+	seen time.Time
+}
+
+// NFv9Decoder decodes Netflow V9 packets.  Templates are cached per exporter address and
+// source ID, a template that is not refreshed within the timeout is dropped.  A decoder is
+// safe for concurrent use.
+type NFv9Decoder struct {
+	mtx       sync.Mutex
+	timeout   time.Duration
+	templates map[nfv9TemplateKey]*nfv9CachedTemplate
+}
+
+// NewNFv9Decoder creates a decoder, a timeout of zero uses the DefaultTemplateTimeout
+func NewNFv9Decoder(timeout time.Duration) *NFv9Decoder {
+	if timeout <= 0 {
+		timeout = DefaultTemplateTimeout
+	}
+	return &NFv9Decoder{
+		timeout:   timeout,
+		templates: map[nfv9TemplateKey]*nfv9CachedTemplate{},
+	}
+}
+
+func newTemplateKey(exporter net.IP, source uint32, id uint16) (k nfv9TemplateKey) {
+	if v4 := exporter.To4(); v4 != nil {
+		copy(k.exporter[:], v4)
+	} else {
+		copy(k.exporter[:], exporter.To16())
+	}
+	k.source = source
+	k.id = id
+	return
+}
+
+// Templates returns the number of cached templates
+func (d *NFv9Decoder) Templates() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return len(d.templates)
+}
+
+// Expire drops templates that have not been seen within the timeout and returns how many were removed
+func (d *NFv9Decoder) Expire() (n int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	now := time.Now()
+	for k, v := range d.templates {
+		if now.Sub(v.seen) > d.timeout {
+			delete(d.templates, k)
+			n++
+		}
+	}
+	return
+}
+
+// AddTemplate installs a template for an exporter as if it had been received
+func (d *NFv9Decoder) AddTemplate(exporter net.IP, source uint32, t NFv9Template) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.addTemplate(newTemplateKey(exporter, source, t.ID), t, time.Now())
+}
+
+func (d *NFv9Decoder) addTemplate(k nfv9TemplateKey, t NFv9Template, now time.Time) {
+	if ct, ok := d.templates[k]; ok && ct.equal(&t) {
+		ct.seen = now
+		return
+	}
+	d.templates[k] = &nfv9CachedTemplate{NFv9Template: t, seen: now}
+}
+
+func (d *NFv9Decoder) template(k nfv9TemplateKey, now time.Time) (*NFv9Template, bool) {
+	ct, ok := d.templates[k]
+	if !ok {
+		return nil, false
+	} else if now.Sub(ct.seen) > d.timeout {
+		delete(d.templates, k)
+		return nil, false
+	}
+	return &ct.NFv9Template, true
+}
+
+// Decode decodes a packet sent by exporter into nf.  Templates in the packet are added to
+// the cache before the data flowsets that follow them are decoded.  Data flowsets that
+// reference templates we have not seen are skipped and counted in nf.Missing.
+func (d *NFv9Decoder) Decode(exporter net.IP, b []byte, nf *NFv9) (err error) {
+	if err = nf.NFv9Header.Decode(b); err != nil {
+		return
+	} else if nf.Version != nfv9Version {
+		return ErrInvalidNFv9
+	}
+	nf.Templates = nf.Templates[:0]
+	nf.Records = nf.Records[:0]
+	nf.Missing = 0
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	now := time.Now()
+	b = b[NFv9HeaderSize:]
+	for len(b) >= nfv9FlowSetHeaderSize {
+		id := binary.BigEndian.Uint16(b)
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		if l < nfv9FlowSetHeaderSize || l > len(b) {
+			return fmt.Errorf("%w: length %d with %d bytes remaining", ErrInvalidFlowSet, l, len(b))
+		}
+		body := b[nfv9FlowSetHeaderSize:l]
+		b = b[l:]
+		switch {
+		case id == nfv9TemplateFlowSetID:
+			err = decodeTemplates(body, func(t NFv9Template) {
+				d.addTemplate(newTemplateKey(exporter, nf.SourceID, t.ID), t, now)
+				nf.Templates = append(nf.Templates, t)
+			})
+		case id == nfv9OptionsTemplateFlowSetID:
+			err = decodeOptionsTemplates(body, func(t NFv9Template) {
+				d.addTemplate(newTemplateKey(exporter, nf.SourceID, t.ID), t, now)
+				nf.Templates = append(nf.Templates, t)
+			})
+		case id >= nfv9MinDataFlowSetID:
+			t, ok := d.template(newTemplateKey(exporter, nf.SourceID, id), now)
+			if !ok {
+				nf.Missing++
+				continue
+			}
+			sz := t.RecordSize()
+			if sz == 0 {
+				continue
+			}
+			// anything left over that is smaller than a record is padding
+			for len(body) >= sz {
+				nf.Records = append(nf.Records, t.decodeRecord(body))
+				body = body[sz:]
+			}
+		default:
+			// flowset IDs 2-255 are reserved, skip them
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func decodeTemplates(b []byte, fn func(NFv9Template)) error {
+	for len(b) >= 4 {
+		var t NFv9Template
+		t.ID = binary.BigEndian.Uint16(b)
+		cnt := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		if t.ID == 0 && cnt == 0 {
+			// padding
+			break
+		} else if t.ID < nfv9MinDataFlowSetID || cnt*4 > len(b) {
+			return ErrInvalidTemplate
+		}
+		t.Fields, b = decodeTemplateFields(b, cnt)
+		fn(t)
+	}
+	return nil
+}
+
+func decodeOptionsTemplates(b []byte, fn func(NFv9Template)) error {
+	for len(b) >= 6 {
+		t := NFv9Template{Options: true}
+		t.ID = binary.BigEndian.Uint16(b)
+		scopeLen := int(binary.BigEndian.Uint16(b[2:4]))
+		optLen := int(binary.BigEndian.Uint16(b[4:6]))
+		b = b[6:]
+		if t.ID == 0 && scopeLen == 0 && optLen == 0 {
+			break
+		} else if t.ID < nfv9MinDataFlowSetID || scopeLen%4 != 0 || optLen%4 != 0 || scopeLen+optLen > len(b) {
+			return ErrInvalidTemplate
+		}
+		t.Scopes, b = decodeTemplateFields(b, scopeLen/4)
+		t.Fields, b = decodeTemplateFields(b, optLen/4)
+		fn(t)
+	}
+	return nil
+}
+
+func decodeTemplateFields(b []byte, cnt int) ([]NFv9TemplateField, []byte) {
+	flds := make([]NFv9TemplateField, cnt)
+	for i := range flds {
+		flds[i].Type = binary.BigEndian.Uint16(b)
+		flds[i].Length = binary.BigEndian.Uint16(b[2:4])
+		b = b[4:]
+	}
+	return flds, b
+}
+
+// Encode builds a packet from the header, templates, and records.  Templates go out first
+// so the packet can be decoded on its own, records are grouped into data flowsets by
+// template and each record must match the layout of a template in nf.Templates.  The
+// Count in the header is filled in.
+func (nf *NFv9) Encode() (b []byte, err error) {
+	if nf.Version != nfv9Version {
+		err = ErrInvalidNFv9
+		return
+	}
+	tmap := make(map[uint16]*NFv9Template, len(nf.Templates))
+	for i := range nf.Templates {
+		tmap[nf.Templates[i].ID] = &nf.Templates[i]
+	}
+	b = make([]byte, NFv9HeaderSize)
+
+	//templates first, regular then options
+	var fs []byte
+	for _, t := range nf.Templates {
+		if t.Options {
+			continue
+		}
+		fs = binary.BigEndian.AppendUint16(fs, t.ID)
+		fs = binary.BigEndian.AppendUint16(fs, uint16(len(t.Fields)))
+		fs = appendTemplateFields(fs, t.Fields)
+	}
+	b = appendFlowSet(b, nfv9TemplateFlowSetID, fs)
+	fs = nil
+	for _, t := range nf.Templates {
+		if !t.Options {
+			continue
+		}
+		fs = binary.BigEndian.AppendUint16(fs, t.ID)
+		fs = binary.BigEndian.AppendUint16(fs, uint16(len(t.Scopes)*4))
+		fs = binary.BigEndian.AppendUint16(fs, uint16(len(t.Fields)*4))
+		fs = appendTemplateFields(fs, t.Scopes)
+		fs = appendTemplateFields(fs, t.Fields)
+	}
+	b = appendFlowSet(b, nfv9OptionsTemplateFlowSetID, fs)
+
+	//then runs of data records that share a template
+	fs = nil
+	var curr uint16
+	for _, r := range nf.Records {
+		t, ok := tmap[r.TemplateID]
+		if !ok {
+			err = fmt.Errorf("%w: no template %d for record", ErrInvalidTemplate, r.TemplateID)
+			return
+		}
+		if r.TemplateID != curr {
+			b = appendFlowSet(b, curr, fs)
+			fs = nil
+			curr = r.TemplateID
+		}
+		if fs, err = appendRecord(fs, t, r); err != nil {
+			return
+		}
+	}
+	b = appendFlowSet(b, curr, fs)
+
+	nf.Count = uint16(len(nf.Templates) + len(nf.Records))
+	err = nf.NFv9Header.encode(b)
+	return
+}
+
+func appendTemplateFields(b []byte, flds []NFv9TemplateField) []byte {
+	for _, f := range flds {
+		b = binary.BigEndian.AppendUint16(b, f.Type)
+		b = binary.BigEndian.AppendUint16(b, f.Length)
+	}
+	return b
+}
+
+// appendFlowSet wraps a flowset body in its header and pads it out to a 4 byte boundary
+func appendFlowSet(b []byte, id uint16, body []byte) []byte {
+	if len(body) == 0 {
+		return b
+	}
+	pad := (4 - (len(body) % 4)) % 4
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, uint16(nfv9FlowSetHeaderSize+len(body)+pad))
+	b = append(b, body...)
+	return append(b, make([]byte, pad)...)
+}
+
+func appendRecord(b []byte, t *NFv9Template, r NFv9Record) ([]byte, error) {
+	if len(r.Fields) != len(t.Scopes)+len(t.Fields) {
+		return nil, ErrInvalidFieldValue
+	}
+	for i, tf := range append(append([]NFv9TemplateField(nil), t.Scopes...), t.Fields...) {
+		if r.Fields[i].Type != tf.Type || len(r.Fields[i].Value) != int(tf.Length) {
+			return nil, ErrInvalidFieldValue
+		}
+		b = append(b, r.Fields[i].Value...)
+	}
+	return b, nil
+}
+
+// EnumeratedValues renders the record as enumerated values named after the field types,
+// numbers, addresses, and strings are given their natural types
+func (r *NFv9Record) EnumeratedValues() (evs []entry.EnumeratedValue) {
+	evs = make([]entry.EnumeratedValue, 0, len(r.Fields))
+	for _, f := range r.Fields {
+		evs = append(evs, f.EnumeratedValue())
+	}
+	return
+}
+
+// EnumeratedValue renders a single field
+func (f NFv9Field) EnumeratedValue() entry.EnumeratedValue {
+	var name string
+	if f.Scope {
+		name = NFv9ScopeName(f.Type)
+	} else {
+		name = NFv9FieldName(f.Type)
+	}
+	return entry.EnumeratedValue{
+		Name:  name,
+		Value: nfv9EnumeratedData(f),
+	}
+}
+
+func nfv9EnumeratedData(f NFv9Field) entry.EnumeratedData {
+	v := f.Value
+	if !f.Scope {
+		switch nfv9FieldKind(f.Type) {
+		case nfv9KindIP:
+			if len(v) == 4 || len(v) == 16 {
+				return entry.IPEnumData(net.IP(append([]byte(nil), v...)))
+			}
+		case nfv9KindMAC:
+			if len(v) == 6 {
+				return entry.MACEnumData(net.HardwareAddr(append([]byte(nil), v...)))
+			}
+		case nfv9KindString:
+			for len(v) > 0 && v[len(v)-1] == 0 {
+				v = v[:len(v)-1]
+			}
+			return entry.StringEnumData(string(v))
+		case nfv9KindBytes:
+			return entry.SliceEnumData(v)
+		}
+	}
+	switch len(v) {
+	case 1:
+		return entry.ByteEnumData(v[0])
+	case 2:
+		return entry.Uint16EnumData(binary.BigEndian.Uint16(v))
+	case 3:
+		return entry.Uint32EnumData(uint32(v[0])<<16 | uint32(v[1])<<8 | uint32(v[2]))
+	case 4:
+		return entry.Uint32EnumData(binary.BigEndian.Uint32(v))
+	case 8:
+		return entry.Uint64EnumData(binary.BigEndian.Uint64(v))
+	}
+	return entry.SliceEnumData(v)
+}
@@ -0,0 +1,152 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netflow
+
+import "fmt"
+
+type nfv9Kind int
+
+const (
+	nfv9KindNumber nfv9Kind = iota
+	nfv9KindIP
+	nfv9KindMAC
+	nfv9KindString
+	nfv9KindBytes
+)
+
+type nfv9FieldType struct {
+	name string
+	kind nfv9Kind
+}
+
+// field types from RFC 3954 section 8
+var nfv9FieldTypes = map[uint16]nfv9FieldType{
+	1:   {`IN_BYTES`, nfv9KindNumber},
+	2:   {`IN_PKTS`, nfv9KindNumber},
+	3:   {`FLOWS`, nfv9KindNumber},
+	4:   {`PROTOCOL`, nfv9KindNumber},
+	5:   {`SRC_TOS`, nfv9KindNumber},
+	6:   {`TCP_FLAGS`, nfv9KindNumber},
+	7:   {`L4_SRC_PORT`, nfv9KindNumber},
+	8:   {`IPV4_SRC_ADDR`, nfv9KindIP},
+	9:   {`SRC_MASK`, nfv9KindNumber},
+	10:  {`INPUT_SNMP`, nfv9KindNumber},
+	11:  {`L4_DST_PORT`, nfv9KindNumber},
+	12:  {`IPV4_DST_ADDR`, nfv9KindIP},
+	13:  {`DST_MASK`, nfv9KindNumber},
+	14:  {`OUTPUT_SNMP`, nfv9KindNumber},
+	15:  {`IPV4_NEXT_HOP`, nfv9KindIP},
+	16:  {`SRC_AS`, nfv9KindNumber},
+	17:  {`DST_AS`, nfv9KindNumber},
+	18:  {`BGP_IPV4_NEXT_HOP`, nfv9KindIP},
+	19:  {`MUL_DST_PKTS`, nfv9KindNumber},
+	20:  {`MUL_DST_BYTES`, nfv9KindNumber},
+	21:  {`LAST_SWITCHED`, nfv9KindNumber},
+	22:  {`FIRST_SWITCHED`, nfv9KindNumber},
+	23:  {`OUT_BYTES`, nfv9KindNumber},
+	24:  {`OUT_PKTS`, nfv9KindNumber},
+	25:  {`MIN_PKT_LNGTH`, nfv9KindNumber},
+	26:  {`MAX_PKT_LNGTH`, nfv9KindNumber},
+	27:  {`IPV6_SRC_ADDR`, nfv9KindIP},
+	28:  {`IPV6_DST_ADDR`, nfv9KindIP},
+	29:  {`IPV6_SRC_MASK`, nfv9KindNumber},
+	30:  {`IPV6_DST_MASK`, nfv9KindNumber},
+	31:  {`IPV6_FLOW_LABEL`, nfv9KindNumber},
+	32:  {`ICMP_TYPE`, nfv9KindNumber},
+	33:  {`MUL_IGMP_TYPE`, nfv9KindNumber},
+	34:  {`SAMPLING_INTERVAL`, nfv9KindNumber},
+	35:  {`SAMPLING_ALGORITHM`, nfv9KindNumber},
+	36:  {`FLOW_ACTIVE_TIMEOUT`, nfv9KindNumber},
+	37:  {`FLOW_INACTIVE_TIMEOUT`, nfv9KindNumber},
+	38:  {`ENGINE_TYPE`, nfv9KindNumber},
+	39:  {`ENGINE_ID`, nfv9KindNumber},
+	40:  {`TOTAL_BYTES_EXP`, nfv9KindNumber},
+	41:  {`TOTAL_PKTS_EXP`, nfv9KindNumber},
+	42:  {`TOTAL_FLOWS_EXP`, nfv9KindNumber},
+	44:  {`IPV4_SRC_PREFIX`, nfv9KindIP},
+	45:  {`IPV4_DST_PREFIX`, nfv9KindIP},
+	46:  {`MPLS_TOP_LABEL_TYPE`, nfv9KindNumber},
+	47:  {`MPLS_TOP_LABEL_IP_ADDR`, nfv9KindIP},
+	48:  {`FLOW_SAMPLER_ID`, nfv9KindNumber},
+	49:  {`FLOW_SAMPLER_MODE`, nfv9KindNumber},
+	50:  {`FLOW_SAMPLER_RANDOM_INTERVAL`, nfv9KindNumber},
+	52:  {`MIN_TTL`, nfv9KindNumber},
+	53:  {`MAX_TTL`, nfv9KindNumber},
+	54:  {`IPV4_IDENT`, nfv9KindNumber},
+	55:  {`DST_TOS`, nfv9KindNumber},
+	56:  {`IN_SRC_MAC`, nfv9KindMAC},
+	57:  {`OUT_DST_MAC`, nfv9KindMAC},
+	58:  {`SRC_VLAN`, nfv9KindNumber},
+	59:  {`DST_VLAN`, nfv9KindNumber},
+	60:  {`IP_PROTOCOL_VERSION`, nfv9KindNumber},
+	61:  {`DIRECTION`, nfv9KindNumber},
+	62:  {`IPV6_NEXT_HOP`, nfv9KindIP},
+	63:  {`BPG_IPV6_NEXT_HOP`, nfv9KindIP},
+	64:  {`IPV6_OPTION_HEADERS`, nfv9KindNumber},
+	70:  {`MPLS_LABEL_1`, nfv9KindBytes},
+	71:  {`MPLS_LABEL_2`, nfv9KindBytes},
+	72:  {`MPLS_LABEL_3`, nfv9KindBytes},
+	73:  {`MPLS_LABEL_4`, nfv9KindBytes},
+	74:  {`MPLS_LABEL_5`, nfv9KindBytes},
+	75:  {`MPLS_LABEL_6`, nfv9KindBytes},
+	76:  {`MPLS_LABEL_7`, nfv9KindBytes},
+	77:  {`MPLS_LABEL_8`, nfv9KindBytes},
+	78:  {`MPLS_LABEL_9`, nfv9KindBytes},
+	79:  {`MPLS_LABEL_10`, nfv9KindBytes},
+	80:  {`IN_DST_MAC`, nfv9KindMAC},
+	81:  {`OUT_SRC_MAC`, nfv9KindMAC},
+	82:  {`IF_NAME`, nfv9KindString},
+	83:  {`IF_DESC`, nfv9KindString},
+	84:  {`SAMPLER_NAME`, nfv9KindString},
+	85:  {`IN_PERMANENT_BYTES`, nfv9KindNumber},
+	86:  {`IN_PERMANENT_PKTS`, nfv9KindNumber},
+	88:  {`FRAGMENT_OFFSET`, nfv9KindNumber},
+	89:  {`FORWARDING_STATUS`, nfv9KindNumber},
+	90:  {`MPLS_PAL_RD`, nfv9KindBytes},
+	91:  {`MPLS_PREFIX_LEN`, nfv9KindNumber},
+	92:  {`SRC_TRAFFIC_INDEX`, nfv9KindNumber},
+	93:  {`DST_TRAFFIC_INDEX`, nfv9KindNumber},
+	94:  {`APPLICATION_DESCRIPTION`, nfv9KindString},
+	95:  {`APPLICATION_TAG`, nfv9KindBytes},
+	96:  {`APPLICATION_NAME`, nfv9KindString},
+	98:  {`POSTIPDIFFSERVCODEPOINT`, nfv9KindNumber},
+	99:  {`REPLICATION_FACTOR`, nfv9KindNumber},
+	102: {`LAYER2_PACKET_SECTION_OFFSET`, nfv9KindNumber},
+	103: {`LAYER2_PACKET_SECTION_SIZE`, nfv9KindNumber},
+	104: {`LAYER2_PACKET_SECTION_DATA`, nfv9KindBytes},
+}
+
+// scope field types for options templates from RFC 3954 section 6.1
+var nfv9ScopeTypes = map[uint16]string{
+	1: `SCOPE_SYSTEM`,
+	2: `SCOPE_INTERFACE`,
+	3: `SCOPE_LINE_CARD`,
+	4: `SCOPE_CACHE`,
+	5: `SCOPE_TEMPLATE`,
+}
+
+// NFv9FieldName returns the RFC 3954 name for a field type, unknown types are named by number
+func NFv9FieldName(t uint16) string {
+	if ft, ok := nfv9FieldTypes[t]; ok {
+		return ft.name
+	}
+	return fmt.Sprintf("FIELD_%d", t)
+}
+
+// NFv9ScopeName returns the name of an options template scope field type
+func NFv9ScopeName(t uint16) string {
+	if n, ok := nfv9ScopeTypes[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("SCOPE_%d", t)
+}
+
+func nfv9FieldKind(t uint16) nfv9Kind {
+	return nfv9FieldTypes[t].kind
+}
@@ -0,0 +1,275 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netflow
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var (
+	testExporter = net.ParseIP("192.168.1.1")
+
+	v4Template = NFv9Template{
+		ID: 256,
+		Fields: []NFv9TemplateField{
+			{Type: 8, Length: 4},  // IPV4_SRC_ADDR
+			{Type: 12, Length: 4}, // IPV4_DST_ADDR
+			{Type: 7, Length: 2},  // L4_SRC_PORT
+			{Type: 11, Length: 2}, // L4_DST_PORT
+			{Type: 4, Length: 1},  // PROTOCOL
+			{Type: 1, Length: 8},  // IN_BYTES
+			{Type: 56, Length: 6}, // IN_SRC_MAC
+		},
+	}
+	optTemplate = NFv9Template{
+		ID:      257,
+		Options: true,
+		Scopes:  []NFv9TemplateField{{Type: 2, Length: 2}},
+		Fields: []NFv9TemplateField{
+			{Type: 82, Length: 8}, // IF_NAME
+			{Type: 34, Length: 4}, // SAMPLING_INTERVAL
+		},
+	}
+)
+
+func v4Record(src, dst string, sport, dport uint16, proto byte, sz uint64) NFv9Record {
+	return NFv9Record{
+		TemplateID: 256,
+		Fields: []NFv9Field{
+			{Type: 8, Value: net.ParseIP(src).To4()},
+			{Type: 12, Value: net.ParseIP(dst).To4()},
+			{Type: 7, Value: []byte{byte(sport >> 8), byte(sport)}},
+			{Type: 11, Value: []byte{byte(dport >> 8), byte(dport)}},
+			{Type: 4, Value: []byte{proto}},
+			{Type: 1, Value: []byte{0, 0, 0, 0, 0, 0, byte(sz >> 8), byte(sz)}},
+			{Type: 56, Value: []byte{0, 1, 2, 3, 4, 5}},
+		},
+	}
+}
+
+func optRecord(iface uint16, name string, interval uint32) NFv9Record {
+	nm := make([]byte, 8)
+	copy(nm, name)
+	return NFv9Record{
+		TemplateID: 257,
+		Options:    true,
+		Fields: []NFv9Field{
+			{Type: 2, Scope: true, Value: []byte{byte(iface >> 8), byte(iface)}},
+			{Type: 82, Value: nm},
+			{Type: 34, Value: []byte{0, 0, byte(interval >> 8), byte(interval)}},
+		},
+	}
+}
+
+func testPacket() NFv9 {
+	return NFv9{
+		NFv9Header: NFv9Header{
+			Version:  9,
+			Uptime:   1000,
+			Sec:      uint32(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()),
+			Sequence: 99,
+			SourceID: 7,
+		},
+		Templates: []NFv9Template{v4Template, optTemplate},
+		Records: []NFv9Record{
+			v4Record("10.0.0.1", "10.0.0.2", 1234, 53, 17, 100),
+			v4Record("10.0.0.2", "10.0.0.1", 53, 1234, 17, 300),
+			optRecord(3, "eth0", 1000),
+			v4Record("10.0.0.3", "8.8.8.8", 4444, 443, 6, 1500),
+		},
+	}
+}
+
+func TestNFv9RoundTrip(t *testing.T) {
+	orig := testPacket()
+	b, err := orig.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b)%4 != 0 {
+		t.Fatalf("encoded packet is not padded: %d", len(b))
+	}
+	d := NewNFv9Decoder(0)
+	var nf NFv9
+	if err = d.Decode(testExporter, b, &nf); err != nil {
+		t.Fatal(err)
+	}
+	if nf.NFv9Header != orig.NFv9Header || nf.Count != 6 {
+		t.Fatalf("header mismatch: %+v != %+v", nf.NFv9Header, orig.NFv9Header)
+	} else if nf.Missing != 0 || len(nf.Templates) != 2 || d.Templates() != 2 {
+		t.Fatalf("bad template handling: %d missing %d templates", nf.Missing, len(nf.Templates))
+	} else if len(nf.Records) != len(orig.Records) {
+		t.Fatalf("decoded %d records, expected %d", len(nf.Records), len(orig.Records))
+	}
+	for i := range orig.Records {
+		checkRecord(t, nf.Records[i], orig.Records[i])
+	}
+
+	//re-encoding what we decoded must give us the same packet
+	if b2, err := nf.Encode(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, b2) {
+		t.Fatal("re-encoded packet does not match")
+	}
+}
+
+func checkRecord(t *testing.T, r, x NFv9Record) {
+	t.Helper()
+	if r.TemplateID != x.TemplateID || r.Options != x.Options || len(r.Fields) != len(x.Fields) {
+		t.Fatalf("record mismatch: %+v != %+v", r, x)
+	}
+	for i := range r.Fields {
+		if r.Fields[i].Type != x.Fields[i].Type || r.Fields[i].Scope != x.Fields[i].Scope || !bytes.Equal(r.Fields[i].Value, x.Fields[i].Value) {
+			t.Fatalf("field %d mismatch: %+v != %+v", i, r.Fields[i], x.Fields[i])
+		}
+	}
+}
+
+func TestNFv9TemplateCache(t *testing.T) {
+	tp := testPacket()
+	d := NewNFv9Decoder(time.Minute)
+
+	//data without templates is counted as missing
+	b := encodeWithout(t, tp, true)
+	var nf NFv9
+	if err := d.Decode(testExporter, b, &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Records) != 0 || nf.Missing != 3 {
+		t.Fatalf("decoded %d records with %d missing before templates", len(nf.Records), nf.Missing)
+	}
+
+	//templates only, then the data again
+	tmpl := tp
+	tmpl.Records = nil
+	b = encodeWithout(t, tmpl, false)
+	if err := d.Decode(testExporter, b, &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Templates) != 2 || len(nf.Records) != 0 {
+		t.Fatalf("bad template packet: %d templates %d records", len(nf.Templates), len(nf.Records))
+	}
+	b = encodeWithout(t, tp, true)
+	if err := d.Decode(testExporter, b, &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Records) != 4 || nf.Missing != 0 {
+		t.Fatalf("decoded %d records with %d missing after templates", len(nf.Records), nf.Missing)
+	}
+
+	//templates are per exporter and source ID
+	if err := d.Decode(net.ParseIP("192.168.1.2"), b, &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Records) != 0 || nf.Missing != 3 {
+		t.Fatal("templates leaked to another exporter")
+	}
+	other := tp
+	other.SourceID = 8
+	if err := d.Decode(testExporter, encodeWithout(t, other, true), &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Records) != 0 {
+		t.Fatal("templates leaked to another source ID")
+	}
+
+	//age the templates out
+	d.mtx.Lock()
+	for _, v := range d.templates {
+		v.seen = v.seen.Add(-2 * time.Minute)
+	}
+	d.mtx.Unlock()
+	if n := d.Expire(); n != 2 || d.Templates() != 0 {
+		t.Fatalf("expired %d templates, %d left", n, d.Templates())
+	}
+	if err := d.Decode(testExporter, b, &nf); err != nil {
+		t.Fatal(err)
+	} else if len(nf.Records) != 0 {
+		t.Fatal("decoded records with expired templates")
+	}
+}
+
+// encodeWithout encodes a packet and strips out either the template or data flowsets
+func encodeWithout(t *testing.T, nf NFv9, templates bool) []byte {
+	b, err := nf.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte(nil), b[:NFv9HeaderSize]...)
+	for fs := b[NFv9HeaderSize:]; len(fs) > 0; {
+		id := uint16(fs[0])<<8 | uint16(fs[1])
+		l := int(fs[2])<<8 | int(fs[3])
+		if (id < nfv9MinDataFlowSetID) != templates {
+			out = append(out, fs[:l]...)
+		}
+		fs = fs[l:]
+	}
+	return out
+}
+
+func TestNFv9EnumeratedValues(t *testing.T) {
+	r := v4Record("10.0.0.1", "10.0.0.2", 1234, 53, 17, 100)
+	evs := r.EnumeratedValues()
+	if len(evs) != 7 {
+		t.Fatalf("got %d values", len(evs))
+	}
+	expect := []entry.EnumeratedValue{
+		{Name: `IPV4_SRC_ADDR`, Value: entry.IPEnumData(net.ParseIP("10.0.0.1").To4())},
+		{Name: `IPV4_DST_ADDR`, Value: entry.IPEnumData(net.ParseIP("10.0.0.2").To4())},
+		{Name: `L4_SRC_PORT`, Value: entry.Uint16EnumData(1234)},
+		{Name: `L4_DST_PORT`, Value: entry.Uint16EnumData(53)},
+		{Name: `PROTOCOL`, Value: entry.ByteEnumData(17)},
+		{Name: `IN_BYTES`, Value: entry.Uint64EnumData(100)},
+		{Name: `IN_SRC_MAC`, Value: entry.MACEnumData(net.HardwareAddr{0, 1, 2, 3, 4, 5})},
+	}
+	for i, ev := range evs {
+		if ev.Name != expect[i].Name || !reflect.DeepEqual(ev.Value.Interface(), expect[i].Value.Interface()) {
+			t.Fatalf("bad value %d: %s=%v expected %s=%v", i, ev.Name, ev.Value, expect[i].Name, expect[i].Value)
+		}
+	}
+
+	r = optRecord(3, "eth0", 1000)
+	evs = r.EnumeratedValues()
+	if evs[0].Name != `SCOPE_INTERFACE` || evs[0].Value.String() != `3` {
+		t.Fatalf("bad scope value %s=%v", evs[0].Name, evs[0].Value)
+	} else if evs[1].Name != `IF_NAME` || evs[1].Value.String() != `eth0` {
+		t.Fatalf("bad string value %s=%q", evs[1].Name, evs[1].Value)
+	} else if evs[2].Name != `SAMPLING_INTERVAL` || evs[2].Value.String() != `1000` {
+		t.Fatalf("bad number value %s=%v", evs[2].Name, evs[2].Value)
+	}
+	if n := NFv9FieldName(4000); n != `FIELD_4000` {
+		t.Fatalf("bad unknown field name %q", n)
+	}
+}
+
+func TestNFv9Invalid(t *testing.T) {
+	d := NewNFv9Decoder(0)
+	var nf NFv9
+	if err := d.Decode(testExporter, make([]byte, 10), &nf); err != ErrNFv9HeaderTooShort {
+		t.Fatalf("bad error on short packet: %v", err)
+	}
+	tp := testPacket()
+	b, err := tp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[1] = 5
+	if err = d.Decode(testExporter, b, &nf); err != ErrInvalidNFv9 {
+		t.Fatalf("bad error on wrong version: %v", err)
+	}
+	b[1] = 9
+	if err = d.Decode(testExporter, b[:len(b)-4], &nf); err == nil {
+		t.Fatal("failed to catch truncated flowset")
+	}
+	tp.Records[0].Fields[0].Value = []byte{1, 2}
+	if _, err = tp.Encode(); err != ErrInvalidFieldValue {
+		t.Fatalf("bad error on mismatched field: %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netflow
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	SFlowVersion5 uint32 = 5
+
+	SFlowAgentIPv4 uint32 = 1
+	SFlowAgentIPv6 uint32 = 2
+
+	// sample formats, enterprise 0
+	SFlowFlowSample            uint32 = 1
+	SFlowCounterSample         uint32 = 2
+	SFlowExpandedFlowSample    uint32 = 3
+	SFlowExpandedCounterSample uint32 = 4
+
+	// flow record format for the sampled packet header, enterprise 0
+	SFlowRawPacketHeader uint32 = 1
+
+	// header protocols for raw packet header records
+	SFlowHeaderEthernet uint32 = 1
+	SFlowHeaderIPv4     uint32 = 11
+	SFlowHeaderIPv6     uint32 = 12
+)
+
+var (
+	ErrSFlowTooShort      = errors.New("Buffer to small for sFlow datagram")
+	ErrInvalidSFlow       = errors.New("Not a valid sFlow V5 datagram")
+	ErrInvalidSFlowAgent  = errors.New("sFlow agent address type is invalid")
+	ErrInvalidSFlowSample = errors.New("sFlow sample is invalid")
+	ErrInvalidSFlowRecord = errors.New("sFlow record is invalid")
+)
+
+// SFlowHeader is the header of an sFlow V5 datagram
+type SFlowHeader struct {
+	Version    uint32
+	Agent      net.IP
+	SubAgentID uint32
+	Sequence   uint32
+	Uptime     uint32 // milliseconds since the agent booted
+}
+
+// SFlowSample is a single flow or counter sample.  Compact and expanded samples are decoded
+// into the same structure, the interface formats are only meaningful for flow samples.
+// Samples with formats we do not understand are kept as-is in Data.
+type SFlowSample struct {
+	Enterprise    uint32
+	Format        uint32
+	Sequence      uint32
+	SourceIDType  uint32
+	SourceIDIndex uint32
+	SamplingRate  uint32
+	SamplePool    uint32
+	Drops         uint32
+	InputFormat   uint32
+	Input         uint32
+	OutputFormat  uint32
+	Output        uint32
+	Records       []SFlowRecord
+	Data          []byte
+}
+
+// SFlowRecord is a flow or counter record inside a sample, the data is left encoded
+type SFlowRecord struct {
+	Enterprise uint32
+	Format     uint32
+	Data       []byte
+}
+
+// SFlowRawHeader is a sampled packet header record
+type SFlowRawHeader struct {
+	Protocol    uint32
+	FrameLength uint32
+	Stripped    uint32
+	Header      []byte
+}
+
+// SFlowDatagram is a decoded sFlow V5 datagram
+type SFlowDatagram struct {
+	SFlowHeader
+	Samples []SFlowSample
+}
+
+// xdrReader walks a buffer of big endian 32bit words, any read past the end marks it bad
+type xdrReader struct {
+	b   []byte
+	bad bool
+}
+
+func (x *xdrReader) uint32() (v uint32) {
+	if len(x.b) < 4 {
+		x.bad = true
+		x.b = nil
+		return
+	}
+	v = binary.BigEndian.Uint32(x.b)
+	x.b = x.b[4:]
+	return
+}
+
+// opaque pulls n bytes plus padding to the next word
+func (x *xdrReader) opaque(n uint32) (v []byte) {
+	padded := (uint64(n) + 3) &^ 3
+	if uint64(len(x.b)) < padded {
+		x.bad = true
+		x.b = nil
+		return
+	}
+	v = append([]byte(nil), x.b[:n]...)
+	x.b = x.b[padded:]
+	return
+}
+
+func appendOpaque(b, v []byte) []byte {
+	b = append(b, v...)
+	return append(b, make([]byte, (4-len(v)%4)%4)...)
+}
+
+func splitFormat(v uint32) (enterprise, format uint32) {
+	return v >> 12, v & 0xfff
+}
+
+func joinFormat(enterprise, format uint32) uint32 {
+	return enterprise<<12 | (format & 0xfff)
+}
+
+// IsFlow returns true if the sample is a compact or expanded flow sample
+func (s *SFlowSample) IsFlow() bool {
+	return s.Enterprise == 0 && (s.Format == SFlowFlowSample || s.Format == SFlowExpandedFlowSample)
+}
+
+// IsCounter returns true if the sample is a compact or expanded counter sample
+func (s *SFlowSample) IsCounter() bool {
+	return s.Enterprise == 0 && (s.Format == SFlowCounterSample || s.Format == SFlowExpandedCounterSample)
+}
+
+// RawPacketHeaders returns the sampled packet header records in a flow sample
+func (s *SFlowSample) RawPacketHeaders() (hdrs []SFlowRawHeader) {
+	if !s.IsFlow() {
+		return
+	}
+	for _, r := range s.Records {
+		if h, err := r.RawPacketHeader(); err == nil {
+			hdrs = append(hdrs, h)
+		}
+	}
+	return
+}
+
+// RawPacketHeader decodes the record as a sampled packet header
+func (r *SFlowRecord) RawPacketHeader() (h SFlowRawHeader, err error) {
+	if r.Enterprise != 0 || r.Format != SFlowRawPacketHeader {
+		err = ErrInvalidSFlowRecord
+		return
+	}
+	x := xdrReader{b: r.Data}
+	h.Protocol = x.uint32()
+	h.FrameLength = x.uint32()
+	h.Stripped = x.uint32()
+	h.Header = x.opaque(x.uint32())
+	if x.bad {
+		err = ErrInvalidSFlowRecord
+	}
+	return
+}
+
+// Record encodes a raw packet header into a flow record
+func (h *SFlowRawHeader) Record() SFlowRecord {
+	var b []byte
+	b = binary.BigEndian.AppendUint32(b, h.Protocol)
+	b = binary.BigEndian.AppendUint32(b, h.FrameLength)
+	b = binary.BigEndian.AppendUint32(b, h.Stripped)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(h.Header)))
+	return SFlowRecord{
+		Format: SFlowRawPacketHeader,
+		Data:   appendOpaque(b, h.Header),
+	}
+}
+
+// Decode pulls the header out of a datagram and returns the number of bytes it occupied
+func (h *SFlowHeader) Decode(b []byte) (n int, err error) {
+	x := xdrReader{b: b}
+	if h.Version = x.uint32(); x.bad {
+		err = ErrSFlowTooShort
+		return
+	} else if h.Version != SFlowVersion5 {
+		err = ErrInvalidSFlow
+		return
+	}
+	switch x.uint32() {
+	case SFlowAgentIPv4:
+		h.Agent = net.IP(x.opaque(4))
+	case SFlowAgentIPv6:
+		h.Agent = net.IP(x.opaque(16))
+	default:
+		if x.bad {
+			err = ErrSFlowTooShort
+		} else {
+			err = ErrInvalidSFlowAgent
+		}
+		return
+	}
+	h.SubAgentID = x.uint32()
+	h.Sequence = x.uint32()
+	h.Uptime = x.uint32()
+	if x.bad {
+		err = ErrSFlowTooShort
+		return
+	}
+	n = len(b) - len(x.b)
+	return
+}
+
+func (h *SFlowHeader) encode(b []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, h.Version)
+	if v4 := h.Agent.To4(); v4 != nil {
+		b = binary.BigEndian.AppendUint32(b, SFlowAgentIPv4)
+		b = append(b, v4...)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, SFlowAgentIPv6)
+		b = append(b, h.Agent.To16()...)
+	}
+	b = binary.BigEndian.AppendUint32(b, h.SubAgentID)
+	b = binary.BigEndian.AppendUint32(b, h.Sequence)
+	return binary.BigEndian.AppendUint32(b, h.Uptime)
+}
+
+// Decode decodes a datagram, records inside samples are left encoded
+func (d *SFlowDatagram) Decode(b []byte) (err error) {
+	var n int
+	if n, err = d.SFlowHeader.Decode(b); err != nil {
+		return
+	}
+	x := xdrReader{b: b[n:]}
+	cnt := x.uint32()
+	if x.bad || uint64(cnt)*8 > uint64(len(x.b)) {
+		return ErrInvalidSFlowSample
+	}
+	d.Samples = make([]SFlowSample, 0, cnt)
+	for i := uint32(0); i < cnt; i++ {
+		var s SFlowSample
+		s.Enterprise, s.Format = splitFormat(x.uint32())
+		data := x.opaque(x.uint32())
+		if x.bad {
+			return ErrInvalidSFlowSample
+		}
+		if err = s.decode(data); err != nil {
+			return
+		}
+		d.Samples = append(d.Samples, s)
+	}
+	return
+}
+
+func (s *SFlowSample) decode(b []byte) error {
+	if s.Enterprise != 0 {
+		s.Data = b
+		return nil
+	}
+	x := xdrReader{b: b}
+	switch s.Format {
+	case SFlowFlowSample:
+		s.Sequence = x.uint32()
+		s.SourceIDType, s.SourceIDIndex = splitSourceID(x.uint32())
+		s.SamplingRate = x.uint32()
+		s.SamplePool = x.uint32()
+		s.Drops = x.uint32()
+		s.InputFormat, s.Input = splitInterface(x.uint32())
+		s.OutputFormat, s.Output = splitInterface(x.uint32())
+	case SFlowCounterSample:
+		s.Sequence = x.uint32()
+		s.SourceIDType, s.SourceIDIndex = splitSourceID(x.uint32())
+	case SFlowExpandedFlowSample:
+		s.Sequence = x.uint32()
+		s.SourceIDType = x.uint32()
+		s.SourceIDIndex = x.uint32()
+		s.SamplingRate = x.uint32()
+		s.SamplePool = x.uint32()
+		s.Drops = x.uint32()
+		s.InputFormat = x.uint32()
+		s.Input = x.uint32()
+		s.OutputFormat = x.uint32()
+		s.Output = x.uint32()
+	case SFlowExpandedCounterSample:
+		s.Sequence = x.uint32()
+		s.SourceIDType = x.uint32()
+		s.SourceIDIndex = x.uint32()
+	default:
+		s.Data = b
+		return nil
+	}
+	cnt := x.uint32()
+	if x.bad || uint64(cnt)*8 > uint64(len(x.b)) {
+		return ErrInvalidSFlowSample
+	}
+	s.Records = make([]SFlowRecord, 0, cnt)
+	for i := uint32(0); i < cnt; i++ {
+		var r SFlowRecord
+		r.Enterprise, r.Format = splitFormat(x.uint32())
+		r.Data = x.opaque(x.uint32())
+		if x.bad {
+			return ErrInvalidSFlowRecord
+		}
+		s.Records = append(s.Records, r)
+	}
+	return nil
+}
+
+func (s *SFlowSample) encode() (b []byte) {
+	if s.Enterprise != 0 || (!s.IsFlow() && !s.IsCounter()) {
+		return append(b, s.Data...)
+	}
+	b = binary.BigEndian.AppendUint32(b, s.Sequence)
+	switch s.Format {
+	case SFlowFlowSample:
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDType<<24|(s.SourceIDIndex&0xffffff))
+		b = binary.BigEndian.AppendUint32(b, s.SamplingRate)
+		b = binary.BigEndian.AppendUint32(b, s.SamplePool)
+		b = binary.BigEndian.AppendUint32(b, s.Drops)
+		b = binary.BigEndian.AppendUint32(b, s.InputFormat<<30|(s.Input&0x3fffffff))
+		b = binary.BigEndian.AppendUint32(b, s.OutputFormat<<30|(s.Output&0x3fffffff))
+	case SFlowCounterSample:
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDType<<24|(s.SourceIDIndex&0xffffff))
+	case SFlowExpandedFlowSample:
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDType)
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDIndex)
+		b = binary.BigEndian.AppendUint32(b, s.SamplingRate)
+		b = binary.BigEndian.AppendUint32(b, s.SamplePool)
+		b = binary.BigEndian.AppendUint32(b, s.Drops)
+		b = binary.BigEndian.AppendUint32(b, s.InputFormat)
+		b = binary.BigEndian.AppendUint32(b, s.Input)
+		b = binary.BigEndian.AppendUint32(b, s.OutputFormat)
+		b = binary.BigEndian.AppendUint32(b, s.Output)
+	case SFlowExpandedCounterSample:
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDType)
+		b = binary.BigEndian.AppendUint32(b, s.SourceIDIndex)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s.Records)))
+	for _, r := range s.Records {
+		b = binary.BigEndian.AppendUint32(b, joinFormat(r.Enterprise, r.Format))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(r.Data)))
+		b = appendOpaque(b, r.Data)
+	}
+	return
+}
+
+// Encode builds a datagram from the header and samples
+func (d *SFlowDatagram) Encode() ([]byte, error) {
+	return d.SFlowHeader.EncodeSamples(d.Samples...)
+}
+
+// EncodeSamples builds a datagram carrying the header and the given samples.  This is
+// handy for splitting a datagram into one datagram per sample.
+func (h *SFlowHeader) EncodeSamples(samples ...SFlowSample) (b []byte, err error) {
+	if h.Version != SFlowVersion5 {
+		err = ErrInvalidSFlow
+		return
+	} else if len(h.Agent) != net.IPv4len && len(h.Agent) != net.IPv6len {
+		err = ErrInvalidSFlowAgent
+		return
+	}
+	b = h.encode(b)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(samples)))
+	for i := range samples {
+		data := samples[i].encode()
+		b = binary.BigEndian.AppendUint32(b, joinFormat(samples[i].Enterprise, samples[i].Format))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = appendOpaque(b, data)
+	}
+	return
+}
+
+func splitSourceID(v uint32) (uint32, uint32) {
+	return v >> 24, v & 0xffffff
+}
+
+func splitInterface(v uint32) (uint32, uint32) {
+	return v >> 30, v & 0x3fffffff
+}
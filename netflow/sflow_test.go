@@ -0,0 +1,180 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netflow
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	// an ethernet header followed by the start of an IPv4 header, odd length to exercise padding
+	testSampledHeader = []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0x08, 0x00,
+		0x45, 0x00, 0x00, 0x54, 0x12, 0x34, 0x40, 0x00, 0x40, 0x01, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02, 0xff,
+	}
+)
+
+func testSFlowDatagram(agent string) SFlowDatagram {
+	hdr := SFlowRawHeader{
+		Protocol:    SFlowHeaderEthernet,
+		FrameLength: 98,
+		Stripped:    4,
+		Header:      testSampledHeader,
+	}
+	return SFlowDatagram{
+		SFlowHeader: SFlowHeader{
+			Version:    SFlowVersion5,
+			Agent:      net.ParseIP(agent),
+			SubAgentID: 1,
+			Sequence:   1000,
+			Uptime:     123456,
+		},
+		Samples: []SFlowSample{
+			{
+				Format:        SFlowFlowSample,
+				Sequence:      10,
+				SourceIDIndex: 3,
+				SamplingRate:  4096,
+				SamplePool:    409600,
+				Drops:         2,
+				Input:         3,
+				Output:        7,
+				Records: []SFlowRecord{
+					hdr.Record(),
+					{Format: 1001, Data: []byte{0, 0, 0, 1, 0, 0, 0, 2}}, // extended switch, left encoded
+				},
+			},
+			{
+				Format:        SFlowCounterSample,
+				Sequence:      11,
+				SourceIDIndex: 3,
+				Records: []SFlowRecord{
+					{Format: 1, Data: make([]byte, 88)}, // generic interface counters
+				},
+			},
+			{
+				Format:        SFlowExpandedFlowSample,
+				Sequence:      12,
+				SourceIDType:  0,
+				SourceIDIndex: 0x1000000, // too big for a compact sample
+				SamplingRate:  512,
+				SamplePool:    1024,
+				Input:         0x1000001,
+				OutputFormat:  2,
+				Output:        4,
+				Records:       []SFlowRecord{hdr.Record()},
+			},
+			{
+				Enterprise: 4413,
+				Format:     5,
+				Data:       []byte{1, 2, 3, 4, 5},
+			},
+		},
+	}
+}
+
+func TestSFlowRoundTrip(t *testing.T) {
+	for _, agent := range []string{`192.168.1.1`, `fe80::1`} {
+		orig := testSFlowDatagram(agent)
+		b, err := orig.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d SFlowDatagram
+		if err = d.Decode(b); err != nil {
+			t.Fatal(err)
+		}
+		if !d.Agent.Equal(orig.Agent) || d.Sequence != orig.Sequence || d.Uptime != orig.Uptime || d.SubAgentID != orig.SubAgentID {
+			t.Fatalf("header mismatch %+v != %+v", d.SFlowHeader, orig.SFlowHeader)
+		} else if len(d.Samples) != len(orig.Samples) {
+			t.Fatalf("decoded %d samples", len(d.Samples))
+		}
+		// the unknown sample comes back without its padding
+		orig.Samples[3].Data = []byte{1, 2, 3, 4, 5}
+		for i := range orig.Samples {
+			if !reflect.DeepEqual(d.Samples[i], orig.Samples[i]) {
+				t.Fatalf("sample %d mismatch:\n%+v\n%+v", i, d.Samples[i], orig.Samples[i])
+			}
+		}
+		if b2, err := d.Encode(); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(b, b2) {
+			t.Fatal("re-encoded datagram does not match")
+		}
+	}
+}
+
+func TestSFlowSamples(t *testing.T) {
+	orig := testSFlowDatagram(`10.0.0.1`)
+	b, err := orig.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d SFlowDatagram
+	if err = d.Decode(b); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Samples[0].IsFlow() || !d.Samples[2].IsFlow() || !d.Samples[1].IsCounter() {
+		t.Fatal("bad sample types")
+	} else if d.Samples[3].IsFlow() || d.Samples[3].IsCounter() {
+		t.Fatal("enterprise sample identified as standard")
+	}
+	hdrs := d.Samples[0].RawPacketHeaders()
+	if len(hdrs) != 1 {
+		t.Fatalf("got %d packet headers", len(hdrs))
+	} else if hdrs[0].FrameLength != 98 || hdrs[0].Stripped != 4 || hdrs[0].Protocol != SFlowHeaderEthernet {
+		t.Fatalf("bad packet header %+v", hdrs[0])
+	} else if !bytes.Equal(hdrs[0].Header, testSampledHeader) {
+		t.Fatal("bad sampled header")
+	}
+	if hdrs = d.Samples[1].RawPacketHeaders(); len(hdrs) != 0 {
+		t.Fatal("counter sample returned packet headers")
+	}
+
+	// split out a single sample and make sure it decodes on its own
+	if b, err = d.EncodeSamples(d.Samples[2]); err != nil {
+		t.Fatal(err)
+	}
+	var single SFlowDatagram
+	if err = single.Decode(b); err != nil {
+		t.Fatal(err)
+	} else if len(single.Samples) != 1 || single.Samples[0].SamplingRate != 512 || !single.Agent.Equal(d.Agent) {
+		t.Fatalf("bad single sample datagram %+v", single)
+	}
+}
+
+func TestSFlowInvalid(t *testing.T) {
+	var d SFlowDatagram
+	if err := d.Decode([]byte{0, 0}); err != ErrSFlowTooShort {
+		t.Fatalf("bad error on short datagram: %v", err)
+	}
+	orig := testSFlowDatagram(`10.0.0.1`)
+	b, err := orig.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(b); i++ {
+		if err := d.Decode(b[:i]); err == nil {
+			t.Fatalf("failed to catch datagram truncated to %d bytes", i)
+		}
+	}
+	b[3] = 4
+	if err = d.Decode(b); err != ErrInvalidSFlow {
+		t.Fatalf("bad error on wrong version: %v", err)
+	}
+	b[3] = 5
+	b[7] = 3
+	if err = d.Decode(b); err != ErrInvalidSFlowAgent {
+		t.Fatalf("bad error on bad agent type: %v", err)
+	}
+}
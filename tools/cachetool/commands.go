@@ -0,0 +1,324 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	timeFormat     = time.RFC3339Nano
+)
+
+type dirInfo struct {
+	entries int
+	batches int
+	other   int
+	data    int64
+	oldest  entry.Timestamp
+	newest  entry.Timestamp
+	tags    map[string]int
+}
+
+func (di *dirInfo) add(cs cacheSet, ent *entry.Entry) {
+	di.entries++
+	di.data += int64(len(ent.Data))
+	if di.entries == 1 || ent.TS.Before(di.oldest) {
+		di.oldest = ent.TS
+	}
+	if di.entries == 1 || ent.TS.After(di.newest) {
+		di.newest = ent.TS
+	}
+	name, ok := cs.tagName(ent.Tag)
+	if !ok {
+		name = fmt.Sprintf("<unknown tag %d>", ent.Tag)
+	}
+	di.tags[name]++
+}
+
+// info reports what is sitting in each cache directory
+func info(cs cacheSet) error {
+	var total dirInfo
+	total.tags = map[string]int{}
+	for _, d := range cs.dirs {
+		di := dirInfo{tags: map[string]int{}}
+		st, err := chancacher.Walk(filepath.Join(cs.root, d), *salvage, func(v interface{}) error {
+			switch t := v.(type) {
+			case *entry.Entry:
+				di.add(cs, t)
+				total.add(cs, t)
+			case []*entry.Entry:
+				di.batches++
+				for _, ent := range t {
+					if ent != nil {
+						di.add(cs, ent)
+						total.add(cs, ent)
+					}
+				}
+			default:
+				di.other++
+			}
+			return nil
+		})
+		inUse, _ := chancacher.InUse(filepath.Join(cs.root, d))
+		fmt.Printf("%s\n", filepath.Join(cs.root, d))
+		fmt.Printf("\tIn use:   %v\n", inUse)
+		fmt.Printf("\tFiles:    %d\n", st.Files)
+		fmt.Printf("\tSize:     %s\n", humanSize(st.Bytes))
+		di.print()
+		if st.Damaged > 0 {
+			fmt.Printf("\tDamaged:  %d regions, %s skipped\n", st.Damaged, humanSize(st.Skipped))
+		}
+		if err != nil {
+			fmt.Printf("\tError:    %v\n", err)
+			if errors.Is(err, chancacher.ErrCacheCorrupted) && !*salvage {
+				fmt.Printf("\t          run with -salvage to read past the damage\n")
+			}
+		}
+		fmt.Println()
+	}
+	if len(cs.dirs) > 1 {
+		fmt.Printf("Total\n")
+		total.print()
+	}
+	return nil
+}
+
+func (di dirInfo) print() {
+	fmt.Printf("\tEntries:  %d\n", di.entries)
+	if di.batches > 0 {
+		fmt.Printf("\tBatches:  %d\n", di.batches)
+	}
+	if di.other > 0 {
+		fmt.Printf("\tUnknown:  %d values that are not entries\n", di.other)
+	}
+	fmt.Printf("\tData:     %s\n", humanSize(di.data))
+	if di.entries == 0 {
+		return
+	}
+	fmt.Printf("\tOldest:   %s\n", di.oldest.StandardTime().Format(timeFormat))
+	fmt.Printf("\tNewest:   %s\n", di.newest.StandardTime().Format(timeFormat))
+	names := make([]string, 0, len(di.tags))
+	for k := range di.tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	fmt.Printf("\tTags:\n")
+	for _, n := range names {
+		fmt.Printf("\t\t%-24s %d\n", n, di.tags[n])
+	}
+}
+
+// export writes every cached entry to a file in a format that the reimport tools understand
+func export(cs cacheSet) (err error) {
+	var out io.Writer = os.Stdout
+	if *output != `` {
+		var fout *os.File
+		if fout, err = os.Create(*output); err != nil {
+			return
+		}
+		defer fout.Close()
+		out = fout
+	}
+	bw := bufio.NewWriter(out)
+
+	var enc func(*entry.Entry) error
+	switch strings.ToLower(*format) {
+	case `json`:
+		je := json.NewEncoder(bw)
+		enc = func(ent *entry.Entry) error {
+			return je.Encode(cs.exportEntry(ent))
+		}
+	case `raw`:
+		enc = func(ent *entry.Entry) (err error) {
+			if _, err = bw.Write(ent.Data); err == nil {
+				err = bw.WriteByte('\n')
+			}
+			return
+		}
+	default:
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	var cnt int
+	for _, d := range cs.replayDirs() {
+		st, err := cs.walk(d, *salvage, func(ent *entry.Entry) error {
+			cnt++
+			return enc(ent)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", d, err)
+		} else if st.Damaged > 0 {
+			fmt.Fprintf(os.Stderr, "%s: skipped %d damaged regions (%s)\n", d, st.Damaged, humanSize(st.Skipped))
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d entries\n", cnt)
+	return
+}
+
+func (cs cacheSet) exportEntry(ent *entry.Entry) types.StringTagEntry {
+	name, ok := cs.tagName(ent.Tag)
+	if !ok {
+		name = *defaultTag
+	}
+	ste := types.StringTagEntry{
+		TS:   ent.TS.StandardTime(),
+		Tag:  name,
+		SRC:  ent.SRC,
+		Data: ent.Data,
+	}
+	for _, ev := range ent.EnumeratedValues() {
+		ste.Enumerated = append(ste.Enumerated, types.EnumeratedPair{
+			Name:  ev.Name,
+			Value: ev.Value.String(),
+		})
+	}
+	return ste
+}
+
+// salvageCache copies every readable value into a fresh cache with the same layout, which can
+// be dropped in place of the damaged cache and drained by the ingester as usual.
+func salvageCache(cs cacheSet) error {
+	if *output == `` {
+		return errors.New("salvage requires an output directory (-o)")
+	}
+	outRoot := filepath.Clean(*output)
+	if ents, err := os.ReadDir(outRoot); err == nil && len(ents) > 0 {
+		return fmt.Errorf("output directory %q is not empty", outRoot)
+	}
+	for _, d := range cs.dirs {
+		odir := filepath.Join(outRoot, d)
+		if err := os.MkdirAll(odir, 0750); err != nil {
+			return err
+		}
+		fout, err := os.OpenFile(filepath.Join(odir, "cache_a"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+		if err != nil {
+			return err
+		}
+		genc := gob.NewEncoder(fout)
+		st, err := chancacher.Walk(filepath.Join(cs.root, d), true, func(v interface{}) error {
+			return genc.Encode(&v)
+		})
+		if cerr := fout.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", d, err)
+		}
+		fmt.Printf("%s: recovered %d values, %d damaged regions, %s lost\n", d, st.Values, st.Damaged, humanSize(st.Skipped))
+	}
+	// carry the tag cache along so local tag IDs keep their meaning
+	if bts, err := os.ReadFile(filepath.Join(cs.root, tagCacheName)); err == nil {
+		if err = os.WriteFile(filepath.Join(outRoot, tagCacheName), bts, 0640); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// push replays the cache to indexers through an ingest muxer.  The cache itself is left
+// alone, remove it once the data is confirmed to be on the indexers.
+func push(cs cacheSet) error {
+	if *targets == `` {
+		return errors.New("push requires -targets")
+	} else if *secret == `` {
+		return errors.New("push requires -secret")
+	}
+	if err := cs.checkInUse(); err != nil {
+		return err
+	}
+	tags := []string{*defaultTag}
+	for _, name := range cs.tags {
+		if name != entry.GravwellTagName && name != *defaultTag {
+			tags = append(tags, name)
+		}
+	}
+	var dests []string
+	for _, t := range strings.Split(*targets, ",") {
+		if t = strings.TrimSpace(t); t != `` {
+			dests = append(dests, t)
+		}
+	}
+	im, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations:    dests,
+		Tags:            tags,
+		Auth:            *secret,
+		VerifyCert:      !*insecure,
+		IngesterName:    `cachetool`,
+		IngesterVersion: `1.0`,
+	})
+	if err != nil {
+		return err
+	}
+	if err = im.Start(); err != nil {
+		return err
+	}
+	defer im.Close()
+	if err = im.WaitForHot(*timeoutFlag); err != nil {
+		return err
+	}
+
+	// the muxer negotiated its own tag IDs, map the cached IDs onto them
+	xlate := map[entry.EntryTag]entry.EntryTag{}
+	fallback, err := im.GetTag(*defaultTag)
+	if err != nil {
+		return err
+	}
+	for id, name := range cs.tags {
+		if tg, err := im.GetTag(name); err == nil {
+			xlate[id] = tg
+		}
+	}
+	var cnt int
+	dirs := cs.replayDirs()
+	for _, d := range dirs {
+		st, err := cs.walk(d, *salvage, func(ent *entry.Entry) error {
+			if tg, ok := xlate[ent.Tag]; ok {
+				ent.Tag = tg
+			} else {
+				ent.Tag = fallback
+			}
+			cnt++
+			return im.WriteEntry(ent)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", d, err)
+		} else if st.Damaged > 0 {
+			fmt.Printf("%s: skipped %d damaged regions (%s)\n", d, st.Damaged, humanSize(st.Skipped))
+		}
+	}
+	if err = im.Sync(*timeoutFlag); err != nil {
+		return fmt.Errorf("failed to sync %d entries: %w", cnt, err)
+	}
+	if len(dirs) < len(cs.dirs) {
+		fmt.Printf("Pushed %d entries, the replica and tag route queue caches under %s were not pushed\n", cnt, cs.root)
+		return nil
+	}
+	fmt.Printf("Pushed %d entries, the cache at %s can now be removed\n", cnt, cs.root)
+	return nil
+}
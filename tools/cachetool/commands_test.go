@@ -0,0 +1,253 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/ingesttest"
+)
+
+const (
+	testSecret  = `cachetoolsecret`
+	testTimeout = 10 * time.Second
+	unknownTag  = entry.EntryTag(9) // not in the tag cache, pushed and exported as the default tag
+)
+
+var (
+	replicaDir = filepath.Join(`replicas`, `10.0.0.1_4023`)
+	routeDir   = filepath.Join(`routes`, `5f3a`)
+)
+
+// setFlag overrides one of the command line flags for the duration of a test
+func setFlag[T any](t *testing.T, p *T, v T) {
+	orig := *p
+	*p = v
+	t.Cleanup(func() { *p = orig })
+}
+
+// writeTestCacheDir writes entries as a gob cache file the way salvage does
+func writeTestCacheDir(t *testing.T, dir string, ents []*entry.Entry) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	fout, err := os.Create(filepath.Join(dir, `cache_a`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	enc := gob.NewEncoder(fout)
+	for _, ent := range ents {
+		var v interface{} = ent
+		if err = enc.Encode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testEntries(prefix string, tags ...entry.EntryTag) (r []*entry.Entry) {
+	for i, tg := range tags {
+		r = append(r, &entry.Entry{
+			TS:   entry.Now(),
+			Tag:  tg,
+			Data: []byte(fmt.Sprintf("%s %d", prefix, i)),
+		})
+	}
+	return
+}
+
+// newTestCache lays out an ingest cache path with a main cache, a replica queue, and a
+// route group queue
+func newTestCache(t *testing.T) string {
+	root := t.TempDir()
+	writeTestCacheDir(t, filepath.Join(root, `e`), testEntries(`main`, 1, 1, unknownTag))
+	writeTestCacheDir(t, filepath.Join(root, replicaDir), testEntries(`replica`, 1, 2))
+	writeTestCacheDir(t, filepath.Join(root, routeDir), testEntries(`route`, 2, 2))
+	fout, err := os.Create(filepath.Join(root, tagCacheName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	if err = gob.NewEncoder(fout).Encode(map[string]entry.EntryTag{`foo`: 1, `bar`: 2}); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestOpenCache(t *testing.T) {
+	root := newTestCache(t)
+	cs, err := openCache(root)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []string{`e`, replicaDir, routeDir}; !reflect.DeepEqual(cs.dirs, want) {
+		t.Fatalf("bad cache dirs %v != %v", cs.dirs, want)
+	} else if name, ok := cs.tagName(2); !ok || name != `bar` {
+		t.Fatalf("bad tag name %q", name)
+	}
+
+	// the tag cache is found above a cache directory
+	if cs, err = openCache(filepath.Join(root, replicaDir)); err != nil {
+		t.Fatal(err)
+	} else if len(cs.dirs) != 1 || cs.dirs[0] != `.` {
+		t.Fatalf("bad cache dirs %v", cs.dirs)
+	} else if name, ok := cs.tagName(1); !ok || name != `foo` {
+		t.Fatalf("bad tag name %q", name)
+	}
+
+	if _, err = openCache(t.TempDir()); err == nil {
+		t.Fatal("opened an empty directory")
+	}
+}
+
+func TestReplayDirs(t *testing.T) {
+	cs, err := openCache(newTestCache(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirs := cs.replayDirs(); !reflect.DeepEqual(dirs, []string{`e`}) {
+		t.Fatalf("queue caches were not skipped %v", dirs)
+	}
+	setFlag(t, queues, true)
+	if dirs := cs.replayDirs(); len(dirs) != 3 {
+		t.Fatalf("queue caches were not included %v", dirs)
+	}
+	for in, want := range map[string]bool{
+		`e`:                          false,
+		`.`:                          false,
+		`replicas`:                   true,
+		replicaDir:                   true,
+		routeDir:                     true,
+		filepath.Join(`e`, `routes`): false,
+	} {
+		if got := isQueueDir(in); got != want {
+			t.Fatalf("%q: %v != %v", in, got, want)
+		}
+	}
+}
+
+func exportEntries(t *testing.T, root string) (r []types.StringTagEntry) {
+	cs, err := openCache(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), `export.json`)
+	setFlag(t, output, out)
+	setFlag(t, format, `json`)
+	if err = export(cs); err != nil {
+		t.Fatal(err)
+	}
+	fin, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	sc := bufio.NewScanner(fin)
+	for sc.Scan() {
+		var ste types.StringTagEntry
+		if err = json.Unmarshal(sc.Bytes(), &ste); err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, ste)
+	}
+	if err = sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestExport(t *testing.T) {
+	root := newTestCache(t)
+	ents := exportEntries(t, root)
+	if len(ents) != 3 {
+		t.Fatalf("bad export count %d", len(ents))
+	}
+	for i, want := range []string{`foo`, `foo`, `default`} {
+		if ents[i].Tag != want || string(ents[i].Data) != fmt.Sprintf("main %d", i) {
+			t.Fatalf("bad exported entry %d: %s %q", i, ents[i].Tag, ents[i].Data)
+		}
+	}
+
+	setFlag(t, queues, true)
+	if ents = exportEntries(t, root); len(ents) != 7 {
+		t.Fatalf("bad export count with queues %d", len(ents))
+	}
+
+	// pointing at a queue cache exports it
+	setFlag(t, queues, false)
+	if ents = exportEntries(t, filepath.Join(root, routeDir)); len(ents) != 2 || ents[0].Tag != `bar` {
+		t.Fatalf("bad route queue export %+v", ents)
+	}
+}
+
+func TestPush(t *testing.T) {
+	s, err := ingesttest.NewServer(ingesttest.Config{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	tgt, err := s.ListenTCP(`127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, targets, tgt)
+	setFlag(t, secret, testSecret)
+	setFlag(t, timeoutFlag, testTimeout)
+
+	cs, err := openCache(newTestCache(t))
+	if err != nil {
+		t.Fatal(err)
+	} else if err = push(cs); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WaitForEntries(3, testTimeout); err != nil {
+		t.Fatal(err)
+	} else if n := s.Count(); n != 3 {
+		t.Fatalf("queue caches were pushed, got %d entries", n)
+	} else if n := len(s.TagEntries(`foo`)); n != 2 {
+		t.Fatalf("bad foo entry count %d", n)
+	} else if n := len(s.TagEntries(`default`)); n != 1 {
+		t.Fatalf("unknown tag was not pushed as the default tag: %d", n)
+	}
+
+	s.Reset()
+	setFlag(t, queues, true)
+	if err = push(cs); err != nil {
+		t.Fatal(err)
+	} else if err = s.WaitForEntries(7, testTimeout); err != nil {
+		t.Fatal(err)
+	} else if n := len(s.TagEntries(`bar`)); n != 3 {
+		t.Fatalf("bad bar entry count %d", n)
+	}
+}
+
+func TestPushRequiresTargets(t *testing.T) {
+	cs, err := openCache(newTestCache(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, targets, ``)
+	if err = push(cs); err == nil {
+		t.Fatal("pushed without targets")
+	}
+	setFlag(t, targets, `tcp://127.0.0.1:4023`)
+	setFlag(t, secret, ``)
+	if err = push(cs); err == nil {
+		t.Fatal("pushed without a secret")
+	}
+}
@@ -0,0 +1,228 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// cachetool inspects, exports, salvages, and replays ingest cache directories
+// left behind by ingesters, without starting the ingester.
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const tagCacheName = `tagcache`
+
+// queueDirs hold the per destination caches a muxer keeps when replicating or routing tags,
+// replicas hold a copy of every entry for each destination and routes hold the entries
+// bound for one group of indexers, so replaying them alongside everything else duplicates
+// entries or ignores the routing
+var queueDirs = []string{`replicas`, `routes`}
+
+var (
+	salvage     = flag.Bool("salvage", false, "Skip over damaged regions of the cache instead of stopping")
+	output      = flag.String("o", "", "Output file for export, output directory for salvage")
+	format      = flag.String("format", "json", "Export format, json or raw")
+	targets     = flag.String("targets", "", "Comma separated list of indexers for push, e.g. tcp://10.0.0.1:4023,tls://10.0.0.2:4024")
+	secret      = flag.String("secret", "", "Ingest secret for push")
+	insecure    = flag.Bool("insecure", false, "Do not verify indexer certificates on push")
+	defaultTag  = flag.String("default-tag", "default", "Tag used for entries whose tag is not in the tag cache")
+	force       = flag.Bool("force", false, "Operate on a cache that is currently held by a running ingester")
+	timeoutFlag = flag.Duration("timeout", defaultTimeout, "Connection and sync timeout for push")
+	queues      = flag.Bool("queues", false, "Include the replica and tag route queue caches in export and push")
+
+	errInUse = errors.New("cache is held by a running ingester, stop it first or use -force")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <info|export|salvage|push> <cache path>\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "The cache path is the Ingest-Cache-Path of an ingester or any cache directory beneath it.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	cmd, root := flag.Arg(0), filepath.Clean(flag.Arg(1))
+	cache, err := openCache(root)
+	if err != nil {
+		log.Fatalf("Failed to open cache %q: %v\n", root, err)
+	}
+
+	switch strings.ToLower(cmd) {
+	case `info`:
+		err = info(cache)
+	case `export`:
+		err = export(cache)
+	case `salvage`:
+		err = salvageCache(cache)
+	case `push`:
+		err = push(cache)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v\n", cmd, err)
+	}
+}
+
+// cacheSet is every ChanCacher directory underneath an ingest cache path along with the
+// tag map the muxer wrote, the entries carry local tag IDs that only mean something with it.
+type cacheSet struct {
+	root string
+	dirs []string // relative to root
+	tags map[entry.EntryTag]string
+}
+
+func openCache(root string) (cs cacheSet, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(root); err != nil {
+		return
+	} else if !fi.IsDir() {
+		err = fmt.Errorf("%q is not a directory", root)
+		return
+	}
+	cs.root = root
+	err = filepath.WalkDir(root, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && chancacher.IsCacheDir(pth) {
+			rel, err := filepath.Rel(root, pth)
+			if err != nil {
+				return err
+			}
+			cs.dirs = append(cs.dirs, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	} else if len(cs.dirs) == 0 {
+		err = errors.New("no cache data found")
+		return
+	}
+	sort.Strings(cs.dirs)
+
+	// the tag cache sits at the top of the ingest cache path, look upward in case we were
+	// pointed at one of the cache directories underneath it
+	cs.tags = map[entry.EntryTag]string{
+		entry.GravwellTagId: entry.GravwellTagName,
+	}
+	for dir := root; ; dir = filepath.Dir(dir) {
+		if m, lerr := readTagCache(filepath.Join(dir, tagCacheName)); lerr == nil {
+			for k, v := range m {
+				cs.tags[v] = k
+			}
+			break
+		} else if !os.IsNotExist(lerr) {
+			err = fmt.Errorf("failed to read tag cache %w", lerr)
+			return
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return
+}
+
+func readTagCache(pth string) (m map[string]entry.EntryTag, err error) {
+	var f *os.File
+	if f, err = os.Open(pth); err != nil {
+		return
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&m)
+	return
+}
+
+func (cs cacheSet) tagName(tag entry.EntryTag) (string, bool) {
+	name, ok := cs.tags[tag]
+	return name, ok
+}
+
+func (cs cacheSet) checkInUse() error {
+	for _, d := range cs.dirs {
+		if inUse, err := chancacher.InUse(filepath.Join(cs.root, d)); err != nil {
+			return err
+		} else if inUse && !*force {
+			return fmt.Errorf("%s %w", d, errInUse)
+		}
+	}
+	return nil
+}
+
+// isQueueDir reports if a cache directory, relative to the cache root, is a replica or
+// tag route queue cache
+func isQueueDir(rel string) bool {
+	first := strings.SplitN(filepath.ToSlash(rel), `/`, 2)[0]
+	for _, q := range queueDirs {
+		if first == q {
+			return true
+		}
+	}
+	return false
+}
+
+// replayDirs returns the cache directories that export and push replay, the queue caches
+// are skipped unless -queues is given.  Pointing the tool directly at a queue cache
+// replays it.
+func (cs cacheSet) replayDirs() (r []string) {
+	var skipped int
+	for _, d := range cs.dirs {
+		if !*queues && isQueueDir(d) {
+			skipped++
+			continue
+		}
+		r = append(r, d)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipping %d replica and tag route queue caches, use -queues to include them\n", skipped)
+	}
+	return
+}
+
+// walk hands every cached entry to fn, batches are flattened into individual entries
+func (cs cacheSet) walk(dir string, salvage bool, fn func(ent *entry.Entry) error) (chancacher.WalkStats, error) {
+	return chancacher.Walk(filepath.Join(cs.root, dir), salvage, func(v interface{}) error {
+		switch t := v.(type) {
+		case *entry.Entry:
+			return fn(t)
+		case []*entry.Entry:
+			for _, ent := range t {
+				if ent == nil {
+					continue
+				}
+				if err := fn(ent); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func humanSize(b int64) string {
+	return ingest.HumanSize(uint64(b))
+}